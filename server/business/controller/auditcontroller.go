@@ -0,0 +1,131 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 审计日志接口
+
+package controller
+
+import (
+	"fileservice/business/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/serviceutil"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// AuditCtrl 审计日志查询
+type AuditCtrl struct {
+	um service.UserAuth4Rpc `@autowired:"User4RPC"`
+	al service.AuditLog     `@autowired:"AuditLog"`
+}
+
+// AsController 实现 AsController 接口
+func (ctl *AuditCtrl) AsController() ipakku.ControllerConfig {
+	return ipakku.ControllerConfig{
+		RequestMapping: "/audit/v1",
+		RouterConfig: ipakku.RouterConfig{
+			ToLowerCase: true,
+			HandlerFunc: [][]interface{}{
+				{http.MethodGet, ctl.ListAudits},
+				{http.MethodPost, ctl.CleanupAudits},
+			},
+		},
+		FilterConfig: ipakku.FilterConfig{
+			FilterFunc: [][]interface{}{
+				{`/:[\s\S]*`, ctl.um.GetAuthFilterFunc()},
+			},
+		},
+	}
+}
+
+// checkPermission 检查是否是管理员
+func (ctl *AuditCtrl) checkPermission(w http.ResponseWriter, r *http.Request) bool {
+	if accesskey := ctl.um.GetAccessKey4Request(r); len(accesskey) > 0 {
+		if ack, err := ctl.um.GetUserAccess(accesskey); nil == err && ack.UserType == service.UserType_Admin {
+			return true
+		}
+	}
+	w.WriteHeader(http.StatusForbidden)
+	return false
+}
+
+// ListAudits 按用户、路径、动作、时间范围(毫秒)查询日志, 按时间倒序
+func (ctl *AuditCtrl) ListAudits(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	query := service.AuditQuery{
+		UserID: r.FormValue("userid"),
+		Action: r.FormValue("action"),
+	}
+	if qPath := r.FormValue("path"); len(qPath) > 0 {
+		query.Path = strutil.Parse2UnixPath(qPath)
+	}
+	var err error
+	if query.Start, err = ctl.parseInt64(r.FormValue("start")); nil != err {
+		serviceutil.SendBadRequest(w, "start is not a valid number")
+		return
+	}
+	if query.End, err = ctl.parseInt64(r.FormValue("end")); nil != err {
+		serviceutil.SendBadRequest(w, "end is not a valid number")
+		return
+	}
+	if limit, err := ctl.parseInt64(r.FormValue("limit")); nil != err {
+		serviceutil.SendBadRequest(w, "limit is not a valid number")
+		return
+	} else {
+		query.Limit = int(limit)
+	}
+	if offset, err := ctl.parseInt64(r.FormValue("offset")); nil != err {
+		serviceutil.SendBadRequest(w, "offset is not a valid number")
+		return
+	} else {
+		query.Offset = int(offset)
+	}
+	if audits, err := ctl.al.QueryAudits(query); nil == err {
+		auditdto := make([]*service.AuditInfoDto, len(audits))
+		for i := 0; i < len(audits); i++ {
+			auditdto[i] = audits[i].ToDto()
+		}
+		serviceutil.SendSuccess(w, auditdto)
+	} else if err == service.ErrorAuditQueryRange {
+		serviceutil.SendBadRequest(w, err.Error())
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// CleanupAudits 删除多少天以前的日志, 返回删除的条数
+func (ctl *AuditCtrl) CleanupAudits(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	days, err := strconv.ParseInt(r.FormValue("days"), 10, 64)
+	if nil != err || days < 0 {
+		serviceutil.SendBadRequest(w, "days is not a valid number")
+		return
+	}
+	before := time.Now().Add(-time.Duration(days) * 24 * time.Hour).UnixMilli()
+	if count, err := ctl.al.Cleanup(before); nil == err {
+		serviceutil.SendSuccess(w, count)
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// parseInt64 空字符串返回0
+func (ctl *AuditCtrl) parseInt64(str string) (int64, error) {
+	if len(str) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
@@ -59,6 +59,9 @@ var ErrorParentFolderNotExist = errors.New("parent folder does not exist")
 // ErrorNewNameIsEmpty ErrorNewNameIsEmpty
 var ErrorNewNameIsEmpty = errors.New("new name cannot be empty")
 
+// ErrorPreconditionFailed 条件请求不满足
+var ErrorPreconditionFailed = errors.New("precondition failed")
+
 // ErrorPermissionInsufficient 权限不足
 var ErrorPermissionInsufficient = errors.New("权限不足")
 
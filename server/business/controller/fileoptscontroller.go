@@ -17,6 +17,7 @@ import (
 	"fileservice/business/service"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/wup364/pakku/ipakku"
@@ -29,6 +30,9 @@ type FileOptsCtrl struct {
 	fm  service.FileDatas           `@autowired:"FileDatas"`
 	um  service.UserAuth4Rpc        `@autowired:"User4RPC"`
 	pms service.FilePermissionCheck `@autowired:"FilePermission"`
+	qc  service.FileQuotaCheck      `@autowired:"FileQuota"`
+	al  service.AuditLog            `@autowired:"AuditLog"`
+	cj  service.ChangeJournal       `@autowired:"ChangeJournal"`
 }
 
 // AsController 实现 AsController 接口
@@ -43,6 +47,8 @@ func (ctl *FileOptsCtrl) AsController() ipakku.ControllerConfig {
 				{http.MethodDelete, ctl.Del},
 				{http.MethodPost, ctl.ReName},
 				{http.MethodPost, ctl.NewFolder},
+				{http.MethodGet, ctl.Changes},
+				{http.MethodGet, ctl.Checksum},
 			},
 		},
 		FilterConfig: ipakku.FilterConfig{
@@ -89,6 +95,25 @@ func (ctl *FileOptsCtrl) Info(w http.ResponseWriter, r *http.Request) {
 	serviceutil.SendSuccess(w, ctl.fm.GetNode(qpath).ToDto())
 }
 
+// Checksum 获取文件信息及内容摘要, 没有摘要缓存的旧文件会即时计算
+func (ctl *FileOptsCtrl) Checksum(w http.ResponseWriter, r *http.Request) {
+	qpath := path.Clean(r.FormValue("path"))
+	if !ctl.checkPermision(ctl.GetUserID4Request(r), qpath, service.FPM_Read) {
+		serviceutil.SendBadRequest(w, ErrorPermissionInsufficient.Error())
+		return
+	}
+	if !ctl.fm.IsFile(qpath) {
+		serviceutil.SendBadRequest(w, qpath+" is not a file")
+		return
+	}
+	node, err := ctl.fm.DoHash(qpath)
+	if nil != err {
+		serviceutil.SendServerError(w, err.Error())
+		return
+	}
+	serviceutil.SendSuccess(w, node.ToDto())
+}
+
 // List 查询路径下的列表以及基本信息
 func (ctl *FileOptsCtrl) List(w http.ResponseWriter, r *http.Request) {
 	qpath := r.FormValue("path")
@@ -143,7 +168,9 @@ func (ctl *FileOptsCtrl) Del(w http.ResponseWriter, r *http.Request) {
 		serviceutil.SendBadRequest(w, "path is empty")
 		return
 	}
-	if !ctl.checkPermision(ctl.GetUserID4Request(r), qpath, service.FPM_Write) {
+	userID := ctl.GetUserID4Request(r)
+	if !ctl.checkPermision(userID, qpath, service.FPM_Write) {
+		ctl.al.Record4Request(r, userID, service.AuditAction_FileDelete, qpath, "", ErrorPermissionInsufficient)
 		serviceutil.SendBadRequest(w, ErrorPermissionInsufficient.Error())
 		return
 	}
@@ -151,7 +178,15 @@ func (ctl *FileOptsCtrl) Del(w http.ResponseWriter, r *http.Request) {
 		serviceutil.SendBadRequest(w, ErrorFileNotExist.Error())
 		return
 	}
-	if err := ctl.fm.DoDelete(qpath); nil == err {
+	// 配置了配额时, 需要在删除前统计释放的空间, 归还给所在路径的配额
+	size := int64(0)
+	if ctl.qc.HasQuotas() {
+		size = ctl.qc.GetPathSize(qpath)
+	}
+	err := ctl.fm.DoDelete(qpath)
+	ctl.al.Record4Request(r, userID, service.AuditAction_FileDelete, qpath, "", err)
+	if nil == err {
+		ctl.qc.AddUsage(qpath, -size)
 		serviceutil.SendSuccess(w, "")
 	} else {
 		serviceutil.SendServerError(w, err.Error())
@@ -166,7 +201,9 @@ func (ctl *FileOptsCtrl) ReName(w http.ResponseWriter, r *http.Request) {
 		serviceutil.SendBadRequest(w, ErrorNewNameIsEmpty.Error())
 		return
 	}
-	if !ctl.checkPermision(ctl.GetUserID4Request(r), qSrcPath, service.FPM_Write) {
+	userID := ctl.GetUserID4Request(r)
+	if !ctl.checkPermision(userID, qSrcPath, service.FPM_Write) {
+		ctl.al.Record4Request(r, userID, service.AuditAction_FileRename, qSrcPath, qName, ErrorPermissionInsufficient)
 		serviceutil.SendBadRequest(w, ErrorPermissionInsufficient.Error())
 		return
 	}
@@ -174,7 +211,9 @@ func (ctl *FileOptsCtrl) ReName(w http.ResponseWriter, r *http.Request) {
 		serviceutil.SendBadRequest(w, ErrorFileNotExist.Error())
 		return
 	}
-	if err := ctl.fm.DoRename(qSrcPath, qName); nil == err {
+	err := ctl.fm.DoRename(qSrcPath, qName)
+	ctl.al.Record4Request(r, userID, service.AuditAction_FileRename, qSrcPath, qName, err)
+	if nil == err {
 		serviceutil.SendSuccess(w, "")
 	} else {
 		serviceutil.SendServerError(w, err.Error())
@@ -185,7 +224,9 @@ func (ctl *FileOptsCtrl) ReName(w http.ResponseWriter, r *http.Request) {
 func (ctl *FileOptsCtrl) NewFolder(w http.ResponseWriter, r *http.Request) {
 	qPath := r.FormValue("path")
 	qPath = strutil.Parse2UnixPath(qPath)
-	if !ctl.checkPermision(ctl.GetUserID4Request(r), qPath, service.FPM_Write) {
+	userID := ctl.GetUserID4Request(r)
+	if !ctl.checkPermision(userID, qPath, service.FPM_Write) {
+		ctl.al.Record4Request(r, userID, service.AuditAction_FileMkdir, qPath, "", ErrorPermissionInsufficient)
 		serviceutil.SendBadRequest(w, ErrorPermissionInsufficient.Error())
 		return
 	}
@@ -193,9 +234,79 @@ func (ctl *FileOptsCtrl) NewFolder(w http.ResponseWriter, r *http.Request) {
 		serviceutil.SendBadRequest(w, ErrorParentFolderNotExist.Error())
 		return
 	}
-	if err := ctl.fm.DoMkDir(qPath); nil == err {
+	err := ctl.fm.DoMkDir(qPath)
+	ctl.al.Record4Request(r, userID, service.AuditAction_FileMkdir, qPath, "", err)
+	if nil == err {
 		serviceutil.SendSuccess(w, "")
 	} else {
 		serviceutil.SendServerError(w, err.Error())
 	}
 }
+
+// Changes 增量拉取since之后的文件变更, 只返回有可见权限的路径
+// 不传since时只返回当前游标, 客户端应在全量同步前获取; resync为true时需要重新全量同步
+func (ctl *FileOptsCtrl) Changes(w http.ResponseWriter, r *http.Request) {
+	qSince := r.FormValue("since")
+	if len(qSince) == 0 {
+		serviceutil.SendSuccess(w, service.ChangeFeedDto{
+			Changes: make([]*service.ChangeInfoDto, 0),
+			Cursor:  ctl.cj.LastSeq(),
+		})
+		return
+	}
+	since, err := strconv.ParseInt(qSince, 10, 64)
+	if nil != err {
+		serviceutil.SendBadRequest(w, "since is not a valid number")
+		return
+	}
+	limit := strutil.String2Int(r.FormValue("limit"), 1000)
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+	changes, resync, err := ctl.cj.ListChanges(since, limit)
+	if nil != err {
+		serviceutil.SendServerError(w, err.Error())
+		return
+	}
+	userID := ctl.GetUserID4Request(r)
+	res := service.ChangeFeedDto{
+		Changes: make([]*service.ChangeInfoDto, 0),
+		Cursor:  since,
+		HasMore: len(changes) == limit,
+		Resync:  resync,
+	}
+	if resync {
+		res.Cursor = ctl.cj.LastSeq()
+	}
+	for i := 0; i < len(changes); i++ {
+		res.Cursor = changes[i].Seq
+		if change := ctl.visibleChange(userID, changes[i]); nil != change {
+			res.Changes = append(res.Changes, change.ToDto())
+		}
+	}
+	serviceutil.SendSuccess(w, res)
+}
+
+// visibleChange 按可见权限裁剪变更, 不可见时返回nil
+// 移动|重命名只有一端可见时, 对用户来说是源路径被删除或目标路径被新建, 不暴露另一端的路径
+func (ctl *FileOptsCtrl) visibleChange(userID string, change service.ChangeInfo) *service.ChangeInfo {
+	srcVisible := ctl.checkPermision(userID, change.Path, service.FPM_Visible)
+	if len(change.Dst) == 0 {
+		if srcVisible {
+			return &change
+		}
+		return nil
+	}
+	dstVisible := ctl.checkPermision(userID, change.Dst, service.FPM_Visible)
+	switch {
+	case srcVisible && dstVisible:
+		return &change
+	case srcVisible:
+		change.Event, change.Dst = service.FileEvent_Deleted, ""
+		return &change
+	case dstVisible:
+		change.Event, change.Path, change.Dst = service.FileEvent_Created, change.Dst, ""
+		return &change
+	}
+	return nil
+}
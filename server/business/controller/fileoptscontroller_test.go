@@ -0,0 +1,50 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controller
+
+import (
+	"fileservice/business/service"
+	"strings"
+	"testing"
+)
+
+// visiblePrefix 只有前缀下的路径可见
+type visiblePrefix string
+
+func (vp visiblePrefix) GetUserPermissionSum(userID, path string) int64 {
+	if vp.HashPermission(userID, path, service.FPM_Visible) {
+		return 1 << service.FPM_Visible
+	}
+	return -1
+}
+
+func (vp visiblePrefix) HashPermission(userID, path string, permission int64) bool {
+	return permission == service.FPM_Visible && strings.HasPrefix(path, string(vp))
+}
+
+func TestVisibleChange(t *testing.T) {
+	ctl := &FileOptsCtrl{pms: visiblePrefix("/a")}
+	for i, c := range []struct {
+		change service.ChangeInfo
+		res    *service.ChangeInfo
+	}{
+		{service.ChangeInfo{Event: service.FileEvent_Created, Path: "/a/1"}, &service.ChangeInfo{Event: service.FileEvent_Created, Path: "/a/1"}},
+		{service.ChangeInfo{Event: service.FileEvent_Created, Path: "/b/1"}, nil},
+		{service.ChangeInfo{Event: service.FileEvent_Moved, Path: "/a/1", Dst: "/a/2"}, &service.ChangeInfo{Event: service.FileEvent_Moved, Path: "/a/1", Dst: "/a/2"}},
+		{service.ChangeInfo{Event: service.FileEvent_Moved, Path: "/a/1", Dst: "/b/1"}, &service.ChangeInfo{Event: service.FileEvent_Deleted, Path: "/a/1"}},
+		{service.ChangeInfo{Event: service.FileEvent_Moved, Path: "/b/1", Dst: "/a/1"}, &service.ChangeInfo{Event: service.FileEvent_Created, Path: "/a/1"}},
+		{service.ChangeInfo{Event: service.FileEvent_Moved, Path: "/b/1", Dst: "/b/2"}, nil},
+	} {
+		res := ctl.visibleChange("user01", c.change)
+		if (nil == res) != (nil == c.res) || (nil != res && *res != *c.res) {
+			t.Fatalf("case %d: expected %v, got %v", i, c.res, res)
+		}
+	}
+}
@@ -26,6 +26,7 @@ import (
 type FilePermissionCtrl struct {
 	um  service.UserAuth4Rpc   `@autowired:"User4RPC"`
 	pms service.FilePermission `@autowired:"FilePermission"`
+	al  service.AuditLog       `@autowired:"AuditLog"`
 }
 
 // AsController 实现 AsController 接口
@@ -165,7 +166,9 @@ func (ctl *FilePermissionCtrl) AddFPermission(w http.ResponseWriter, r *http.Req
 		Permission: int64(permission),
 	}
 
-	if err := ctl.pms.AddFPermission(pmsInfo); nil == err {
+	err = ctl.pms.AddFPermission(pmsInfo)
+	ctl.al.Record4Request(r, "", service.AuditAction_PermissionAdd, path, userID+":"+permissionStr, err)
+	if nil == err {
 		serviceutil.SendSuccess(w, "")
 	} else {
 		serviceutil.SendServerError(w, err.Error())
@@ -193,7 +196,9 @@ func (ctl *FilePermissionCtrl) UpdateFPermission(w http.ResponseWriter, r *http.
 		PermissionID: permissionID,
 		Permission:   int64(permission),
 	}
-	if err := ctl.pms.UpdateFPermission(pmsInfo); nil == err {
+	err = ctl.pms.UpdateFPermission(pmsInfo)
+	ctl.al.Record4Request(r, "", service.AuditAction_PermissionEdit, permissionID, permissionStr, err)
+	if nil == err {
 		serviceutil.SendSuccess(w, "")
 	} else {
 		serviceutil.SendServerError(w, err.Error())
@@ -211,7 +216,9 @@ func (ctl *FilePermissionCtrl) DelFPermission(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if err := ctl.pms.DelFPermission(permissionID); nil == err {
+	err := ctl.pms.DelFPermission(permissionID)
+	ctl.al.Record4Request(r, "", service.AuditAction_PermissionDel, permissionID, "", err)
+	if nil == err {
 		serviceutil.SendSuccess(w, "")
 	} else {
 		serviceutil.SendServerError(w, err.Error())
@@ -59,7 +59,7 @@ func (ctl *Preview) AsController() ipakku.ControllerConfig {
 
 // Status 保持会话用
 func (ctl *Preview) Status(w http.ResponseWriter, r *http.Request) {
-	if token, err := ctl.getSteamToken(strutil.GetPathName(r.URL.Path)); nil != err || nil == token {
+	if token, err := ctl.getSteamToken(r, strutil.GetPathName(r.URL.Path)); nil != err || nil == token {
 		serviceutil.SendBadRequest(w, err.Error())
 	} else if _, err = ctl.tt.RefreshToken(token.Token); nil != err {
 		serviceutil.SendBadRequest(w, err.Error())
@@ -101,7 +101,7 @@ func (ctl *Preview) AskSameDIRToken(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	//
-	if token, err := ctl.getSteamToken(strutil.GetPathName(r.URL.Path)); nil != err || nil == token {
+	if token, err := ctl.getSteamToken(r, strutil.GetPathName(r.URL.Path)); nil != err || nil == token {
 		serviceutil.SendBadRequest(w, err.Error())
 	} else {
 		// 校验 & 获取token
@@ -112,7 +112,7 @@ func (ctl *Preview) AskSameDIRToken(w http.ResponseWriter, r *http.Request) {
 				serviceutil.SendBadRequest(w, ErrorFileNotExist.Error())
 				return
 			} else {
-				if token, err := ctl.tt.AskReadToken(filePath, nil); nil == err {
+				if token, err := ctl.tt.AskReadToken(filePath, ctl.inheritTokenProps(token)); nil == err {
 					token.TokenURL = "/filestream/v1/read/" + token.Token
 					tokenList[nameList[i]] = *token.ToDto()
 				}
@@ -125,7 +125,7 @@ func (ctl *Preview) AskSameDIRToken(w http.ResponseWriter, r *http.Request) {
 // SameDirFiles 同目录文件
 func (ctl *Preview) SameDirFiles(w http.ResponseWriter, r *http.Request) {
 	var prentPath string
-	token, err := ctl.getSteamToken(strutil.GetPathName(r.URL.Path))
+	token, err := ctl.getSteamToken(r, strutil.GetPathName(r.URL.Path))
 	if nil != err || nil == token {
 		serviceutil.SendBadRequest(w, err.Error())
 	} else {
@@ -156,6 +156,17 @@ func (ctl *Preview) SameDirFiles(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// inheritTokenProps 同级目录的token继承原token的使用限制, 避免通过预览绕过
+func (ctl *Preview) inheritTokenProps(token *service.StreamToken) map[string]string {
+	props := make(map[string]string)
+	for _, key := range []string{service.StreamTokenProp_UserID, service.StreamTokenProp_MaxUses, service.StreamTokenProp_BindIP, service.StreamTokenProp_ExpiresAt, service.StreamTokenProp_RateLimit} {
+		if val, ok := token.Props[key]; ok {
+			props[key] = val
+		}
+	}
+	return props
+}
+
 // checkPermision 检查权限
 func (ctl *Preview) checkPermision(userID, path string, permission int64) bool {
 	return ctl.pmc.HashPermission(userID, path, permission)
@@ -171,9 +182,9 @@ func (ctl *Preview) getUserID4Request(r *http.Request) string {
 	return ""
 }
 
-// getSteamToken 获取文件传输Token对象
-func (ctl *Preview) getSteamToken(token string) (*service.StreamToken, error) {
-	if tokenBody, err := ctl.tt.QueryToken(token); nil != err || nil == tokenBody {
+// getSteamToken 获取文件传输Token对象, 校验绑定IP和使用次数, 预览不计次数
+func (ctl *Preview) getSteamToken(r *http.Request, token string) (*service.StreamToken, error) {
+	if tokenBody, err := ctl.tt.UseToken(token, ctl.um.GetClientIP4Request(r), false); nil != err || nil == tokenBody {
 		return nil, ErrorOprationExpires
 	} else {
 		return tokenBody, nil
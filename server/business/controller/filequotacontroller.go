@@ -0,0 +1,197 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 存储配额接口
+
+package controller
+
+import (
+	"fileservice/business/service"
+	"net/http"
+	"strconv"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/serviceutil"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// FileQuotaCtrl 存储配额管理
+type FileQuotaCtrl struct {
+	um service.UserAuth4Rpc `@autowired:"User4RPC"`
+	fq service.FileQuota    `@autowired:"FileQuota"`
+	al service.AuditLog     `@autowired:"AuditLog"`
+}
+
+// AsController 实现 AsController 接口
+func (ctl *FileQuotaCtrl) AsController() ipakku.ControllerConfig {
+	return ipakku.ControllerConfig{
+		RequestMapping: "/filequota/v1",
+		RouterConfig: ipakku.RouterConfig{
+			ToLowerCase: true,
+			HandlerFunc: [][]interface{}{
+				{http.MethodGet, ctl.Usage},
+				{http.MethodGet, ctl.ListQuotas},
+				{http.MethodPost, ctl.AddQuota},
+				{http.MethodPost, ctl.UpdateQuota},
+				{http.MethodDelete, ctl.DelQuota},
+				{http.MethodPost, ctl.RecountQuota},
+			},
+		},
+		FilterConfig: ipakku.FilterConfig{
+			FilterFunc: [][]interface{}{
+				{`/:[\s\S]*`, ctl.um.GetAuthFilterFunc()},
+			},
+		},
+	}
+}
+
+// checkPermission 检查是否是管理员
+func (ctl *FileQuotaCtrl) checkPermission(w http.ResponseWriter, r *http.Request) bool {
+	if accesskey := ctl.um.GetAccessKey4Request(r); len(accesskey) > 0 {
+		if ack, err := ctl.um.GetUserAccess(accesskey); nil == err && ack.UserType == service.UserType_Admin {
+			return true
+		}
+	}
+	w.WriteHeader(http.StatusForbidden)
+	return false
+}
+
+// Usage 查询路径下的配额使用情况
+func (ctl *FileQuotaCtrl) Usage(w http.ResponseWriter, r *http.Request) {
+	// 未指定路径时查询用户主目录
+	qPath := strutil.Parse2UnixPath(r.FormValue("path"))
+	if len(qPath) == 0 {
+		if ack, err := ctl.um.GetUserAccess(ctl.um.GetAccessKey4Request(r)); nil == err {
+			qPath = ctl.um.GetHomePath(ack.UserID)
+		}
+		if len(qPath) == 0 {
+			qPath = "/"
+		}
+	}
+	quotas := ctl.fq.ListUsage(qPath)
+	usage := service.QuotaUsageDto{
+		Remaining: ctl.fq.GetRemaining(qPath),
+		Quotas:    make([]*service.QuotaInfoDto, len(quotas)),
+	}
+	for i := 0; i < len(quotas); i++ {
+		usage.Quotas[i] = quotas[i].ToDto()
+	}
+	serviceutil.SendSuccess(w, usage)
+}
+
+// ListQuotas 列出所有配额, 无分页
+func (ctl *FileQuotaCtrl) ListQuotas(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	if quotas, err := ctl.fq.ListQuotas(); nil == err {
+		quotadto := make([]*service.QuotaInfoDto, len(quotas))
+		for i := 0; i < len(quotas); i++ {
+			quotadto[i] = quotas[i].ToDto()
+		}
+		serviceutil.SendSuccess(w, quotadto)
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// AddQuota 添加配额, quotatype: user|path
+func (ctl *FileQuotaCtrl) AddQuota(w http.ResponseWriter, r *http.Request) {
+	quotaType := r.FormValue("quotatype")
+	target := r.FormValue("target")
+	if len(target) == 0 {
+		serviceutil.SendBadRequest(w, service.ErrorQuotaTargetIsNil.Error())
+		return
+	}
+	maxSize, err := strconv.ParseInt(r.FormValue("maxsize"), 10, 64)
+	if nil != err || maxSize < 0 {
+		serviceutil.SendBadRequest(w, "maxsize is not a valid number")
+		return
+	}
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	err = ctl.fq.AddQuota(service.QuotaInfo{
+		QuotaType: quotaType,
+		Target:    target,
+		MaxSize:   maxSize,
+	})
+	ctl.al.Record4Request(r, "", service.AuditAction_QuotaAdd, quotaType+":"+target, strconv.FormatInt(maxSize, 10), err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
+	} else if err == service.ErrorQuotaTypeNotSupport {
+		serviceutil.SendBadRequest(w, err.Error())
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// UpdateQuota 修改配额上限
+func (ctl *FileQuotaCtrl) UpdateQuota(w http.ResponseWriter, r *http.Request) {
+	quotaID := r.FormValue("quotaid")
+	if len(quotaID) == 0 {
+		serviceutil.SendBadRequest(w, service.ErrorQuotaIDIsNil.Error())
+		return
+	}
+	maxSize, err := strconv.ParseInt(r.FormValue("maxsize"), 10, 64)
+	if nil != err || maxSize < 0 {
+		serviceutil.SendBadRequest(w, "maxsize is not a valid number")
+		return
+	}
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	err = ctl.fq.UpdateQuota(service.QuotaInfo{QuotaID: quotaID, MaxSize: maxSize})
+	ctl.al.Record4Request(r, "", service.AuditAction_QuotaEdit, quotaID, strconv.FormatInt(maxSize, 10), err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// DelQuota 删除配额
+func (ctl *FileQuotaCtrl) DelQuota(w http.ResponseWriter, r *http.Request) {
+	quotaID := r.FormValue("quotaid")
+	if len(quotaID) == 0 {
+		serviceutil.SendBadRequest(w, service.ErrorQuotaIDIsNil.Error())
+		return
+	}
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	err := ctl.fq.DelQuota(quotaID)
+	ctl.al.Record4Request(r, "", service.AuditAction_QuotaDel, quotaID, "", err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// RecountQuota 重新统计路径配额的已用空间
+func (ctl *FileQuotaCtrl) RecountQuota(w http.ResponseWriter, r *http.Request) {
+	quotaID := r.FormValue("quotaid")
+	if len(quotaID) == 0 {
+		serviceutil.SendBadRequest(w, service.ErrorQuotaIDIsNil.Error())
+		return
+	}
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	err := ctl.fq.RecountQuota(quotaID)
+	ctl.al.Record4Request(r, "", service.AuditAction_QuotaRecount, quotaID, "", err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
+	} else if err == service.ErrorQuotaTypeNotSupport || err == service.ErrorQuotaNotExist {
+		serviceutil.SendBadRequest(w, err.Error())
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
@@ -12,8 +12,12 @@
 package controller
 
 import (
+	"encoding/json"
 	"fileservice/business/service"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/wup364/pakku/ipakku"
 	"github.com/wup364/pakku/utils/serviceutil"
@@ -23,6 +27,7 @@ import (
 type AsyncTaskCtrl struct {
 	um  service.UserAuth4Rpc `@autowired:"User4RPC"`
 	ast service.AsyncTask    `@autowired:"AsyncTask"`
+	al  service.AuditLog     `@autowired:"AuditLog"`
 }
 
 // AsController 实现 AsController 接口
@@ -34,6 +39,10 @@ func (ctl *AsyncTaskCtrl) AsController() ipakku.ControllerConfig {
 			HandlerFunc: [][]interface{}{
 				{http.MethodPost, ctl.AsyncExec},
 				{http.MethodPost, ctl.AsyncExecToken},
+				{http.MethodGet, ctl.AsyncExecEvents},
+				{http.MethodGet, ctl.ListTasks},
+				{http.MethodGet, ctl.QueryTask},
+				{http.MethodPost, ctl.ClearTasks},
 			},
 		},
 		FilterConfig: ipakku.FilterConfig{
@@ -46,10 +55,24 @@ func (ctl *AsyncTaskCtrl) AsController() ipakku.ControllerConfig {
 
 // AsyncExec 发起一个异步操作, 返回一个可以查询的tooken
 func (ctl *AsyncTaskCtrl) AsyncExec(w http.ResponseWriter, r *http.Request) {
-	if executor, err := ctl.ast.GetTaskObject(r.FormValue("func")); nil != err {
+	qFunc := r.FormValue("func")
+	if executor, err := ctl.ast.GetTaskObject(qFunc); nil != err {
 		serviceutil.SendServerError(w, err.Error())
 	} else {
-		if token, err := executor.Execute(r); nil != err {
+		token, err := executor.Execute(r)
+		// 删除等多路径任务使用path参数, 批量操作使用paths参数, 下载远程文件使用url参数
+		qSrcPath := r.FormValue("srcPath")
+		if len(qSrcPath) == 0 {
+			qSrcPath = strings.Join(r.Form["path"], ",")
+		}
+		if len(qSrcPath) == 0 {
+			qSrcPath = r.FormValue("paths")
+		}
+		if len(qSrcPath) == 0 {
+			qSrcPath = r.FormValue("url")
+		}
+		ctl.al.Record4Request(r, "", ctl.getAuditAction(qFunc, r.FormValue("op")), qSrcPath, r.FormValue("dstPath"), err)
+		if nil != err {
 			serviceutil.SendServerError(w, err.Error())
 		} else {
 			serviceutil.SendSuccess(w, token)
@@ -62,6 +85,165 @@ func (ctl *AsyncTaskCtrl) AsyncExecToken(w http.ResponseWriter, r *http.Request)
 	if executor, err := ctl.ast.GetTaskObject(r.FormValue("func")); nil != err {
 		serviceutil.SendServerError(w, err.Error())
 	} else {
+		if qOperation := r.FormValue("operation"); len(qOperation) > 0 {
+			ctl.al.Record4Request(r, "", service.AuditAction_TaskOperation, r.FormValue("token"), qOperation, nil)
+		}
 		executor.Status(w, r)
 	}
 }
+
+// AsyncExecEvents 以 Server-Sent Events 推送token的进度和冲突提示, 任务结束后关闭连接
+func (ctl *AsyncTaskCtrl) AsyncExecEvents(w http.ResponseWriter, r *http.Request) {
+	executor, err := ctl.ast.GetTaskObject(r.FormValue("func"))
+	if nil != err {
+		serviceutil.SendServerError(w, err.Error())
+		return
+	}
+	watcher, ok := executor.(service.AsyncTaskWatchI)
+	if !ok {
+		serviceutil.SendBadRequest(w, r.FormValue("func")+" does not support events")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		serviceutil.SendServerError(w, "streaming unsupported")
+		return
+	}
+	qToken := r.FormValue("token")
+	if _, _, err := watcher.Snapshot(qToken); nil != err {
+		serviceutil.SendBadRequest(w, err.Error())
+		return
+	}
+	notify, cancel := ctl.ast.Subscribe(qToken)
+	defer cancel()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// 状态没有变化时不重复推送, 每隔一段时间发送注释行保持连接
+	last, idle := "", 0
+	for {
+		event, data, err := watcher.Snapshot(qToken)
+		if nil != err {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+			flusher.Flush()
+			return
+		}
+		if bt, err := json.Marshal(data); nil == err && string(bt) != last {
+			last, idle = string(bt), 0
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, bt)
+			flusher.Flush()
+		} else if idle++; idle >= 30 {
+			idle = 0
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+		if event == service.TaskEvent_Complete {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListTasks 列出当前用户的任务, 管理员可以通过 userid 查看其他用户
+func (ctl *AsyncTaskCtrl) ListTasks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ctl.getTaskUserID(w, r)
+	if !ok {
+		return
+	}
+	if tasks, err := ctl.ast.ListTasks(userID); nil == err {
+		taskdto := make([]*service.TaskInfoDto, len(tasks))
+		for i := 0; i < len(tasks); i++ {
+			taskdto[i] = tasks[i].ToDto()
+		}
+		serviceutil.SendSuccess(w, taskdto)
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// QueryTask 查询单个任务, 只能查询自己的任务, 管理员不受限制
+func (ctl *AsyncTaskCtrl) QueryTask(w http.ResponseWriter, r *http.Request) {
+	ack, err := ctl.um.GetUserAccess(ctl.um.GetAccessKey4Request(r))
+	if nil != err {
+		serviceutil.SendServerError(w, err.Error())
+		return
+	}
+	if task, err := ctl.ast.QueryTask(r.FormValue("taskid")); nil != err {
+		if err == service.ErrorTaskNotExist {
+			serviceutil.SendBadRequest(w, err.Error())
+		} else {
+			serviceutil.SendServerError(w, err.Error())
+		}
+	} else if task.UserID != ack.UserID && ack.UserType != service.UserType_Admin {
+		serviceutil.SendBadRequest(w, service.ErrorTaskNotExist.Error())
+	} else {
+		serviceutil.SendSuccess(w, task.ToDto())
+	}
+}
+
+// ClearTasks 清除当前用户已结束的任务, 返回清除条数
+func (ctl *AsyncTaskCtrl) ClearTasks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ctl.getTaskUserID(w, r)
+	if !ok {
+		return
+	}
+	if count, err := ctl.ast.ClearTasks(userID); nil == err {
+		serviceutil.SendSuccess(w, count)
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// getTaskUserID 获取要操作的任务所属用户, 非管理员只能操作自己的任务
+func (ctl *AsyncTaskCtrl) getTaskUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ack, err := ctl.um.GetUserAccess(ctl.um.GetAccessKey4Request(r))
+	if nil != err {
+		serviceutil.SendServerError(w, err.Error())
+		return "", false
+	}
+	if qUserID := r.FormValue("userid"); len(qUserID) > 0 && qUserID != ack.UserID {
+		if ack.UserType != service.UserType_Admin {
+			w.WriteHeader(http.StatusForbidden)
+			return "", false
+		}
+		return qUserID, true
+	}
+	return ack.UserID, true
+}
+
+// getAuditAction 复制、移动、删除和批量操作按文件操作记录, 其余按任务记录
+func (ctl *AsyncTaskCtrl) getAuditAction(taskName, op string) string {
+	switch taskName {
+	case "CopyFile":
+		return service.AuditAction_FileCopy
+	case "MoveFile":
+		return service.AuditAction_FileMove
+	case "DeleteFile":
+		return service.AuditAction_FileDelete
+	case "FetchURL":
+		return service.AuditAction_FileFetch
+	case "BatchFile":
+		switch op {
+		case service.BatchOp_Delete:
+			return service.AuditAction_FileDelete
+		case service.BatchOp_Rename:
+			return service.AuditAction_FileRename
+		case service.BatchOp_Copy:
+			return service.AuditAction_FileCopy
+		case service.BatchOp_Move:
+			return service.AuditAction_FileMove
+		}
+		return service.AuditAction_TaskExec
+	default:
+		return service.AuditAction_TaskExec
+	}
+}
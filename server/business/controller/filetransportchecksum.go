@@ -0,0 +1,120 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 文件上传的完整性校验, 支持 Content-MD5, Digest 头和 checksum 参数
+
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fileservice/business/modules/filetransport"
+	"fileservice/business/service"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/wup364/pakku/utils/serviceutil"
+)
+
+// formNameChecksum 校验值参数名, 格式: md5:十六进制 或 sha256:十六进制
+const formNameChecksum = "checksum"
+
+// ErrorChecksumInvalid 校验值格式错误
+var ErrorChecksumInvalid = errors.New("invalid checksum")
+
+// checksumExpect 期望的摘要, nil表示不校验
+type checksumExpect struct {
+	md5    []byte
+	sha256 []byte
+}
+
+// parseChecksum 从 Content-MD5(base64), Digest(MD5=base64, SHA-256=base64) 头和url中的 checksum 参数读取期望的摘要
+// 不读取body中的参数, 避免消耗上传内容
+func (ctl *TransportCtrl) parseChecksum(r *http.Request) (expect checksumExpect, err error) {
+	if val := r.Header.Get("Content-MD5"); len(val) > 0 {
+		if err = ctl.setChecksum(&expect, "md5", val, base64.StdEncoding.DecodeString); nil != err {
+			return expect, err
+		}
+	}
+	for _, digest := range strings.Split(r.Header.Get("Digest"), ",") {
+		if index := strings.Index(digest, "="); index > 0 {
+			algo := strings.ToLower(strings.TrimSpace(digest[:index]))
+			if algo == "md5" || algo == "sha-256" {
+				if err = ctl.setChecksum(&expect, algo, strings.TrimSpace(digest[index+1:]), base64.StdEncoding.DecodeString); nil != err {
+					return expect, err
+				}
+			}
+		}
+	}
+	if val := r.URL.Query().Get(formNameChecksum); len(val) > 0 {
+		err = ctl.parseChecksumField(&expect, val)
+	}
+	return expect, err
+}
+
+// parseChecksumField 解析 checksum 参数, 格式: md5:十六进制 或 sha256:十六进制
+func (ctl *TransportCtrl) parseChecksumField(expect *checksumExpect, val string) error {
+	index := strings.Index(val, ":")
+	if index < 0 {
+		return ErrorChecksumInvalid
+	}
+	return ctl.setChecksum(expect, strings.ToLower(strings.TrimSpace(val[:index])), strings.TrimSpace(val[index+1:]), hex.DecodeString)
+}
+
+// setChecksum 解码并设置期望的摘要, 同一算法的多个来源必须一致
+func (ctl *TransportCtrl) setChecksum(expect *checksumExpect, algo, val string, decode func(string) ([]byte, error)) error {
+	sum, err := decode(val)
+	if nil != err {
+		return ErrorChecksumInvalid
+	}
+	var target *[]byte
+	switch algo {
+	case "md5":
+		if len(sum) != 16 {
+			return ErrorChecksumInvalid
+		}
+		target = &expect.md5
+	case "sha256", "sha-256":
+		if len(sum) != 32 {
+			return ErrorChecksumInvalid
+		}
+		target = &expect.sha256
+	default:
+		return ErrorChecksumInvalid
+	}
+	if nil != *target && string(*target) != string(sum) {
+		return ErrorChecksumInvalid
+	}
+	*target = sum
+	return nil
+}
+
+// readChecksumField 读取表单中的 checksum 参数
+func (ctl *TransportCtrl) readChecksumField(expect *checksumExpect, r io.Reader) error {
+	val, err := io.ReadAll(io.LimitReader(r, 256))
+	if nil != err {
+		return err
+	}
+	return ctl.parseChecksumField(expect, string(val))
+}
+
+// sendWriteSuccess 返回新的 ETag 和上传内容的摘要
+func (ctl *TransportCtrl) sendWriteSuccess(w http.ResponseWriter, dst string, cr *filetransport.ChecksumReader) {
+	ctl.setETag(w, dst)
+	w.Header().Set("Digest", "md5="+base64.StdEncoding.EncodeToString(cr.SumMD5())+", sha-256="+base64.StdEncoding.EncodeToString(cr.SumSHA256()))
+	serviceutil.SendSuccess(w, service.WriteResultDto{
+		Path: dst,
+		ChecksumDto: service.ChecksumDto{
+			MD5:    hex.EncodeToString(cr.SumMD5()),
+			SHA256: hex.EncodeToString(cr.SumSHA256()),
+		},
+	})
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 文件传输的条件请求, ETag 由修改时间和大小生成
+
+package controller
+
+import (
+	"fileservice/business/service"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getETag 由修改时间(毫秒)和大小生成强校验值
+func (ctl *TransportCtrl) getETag(node *service.FNode) string {
+	return `"` + strconv.FormatInt(node.Mtime, 36) + "-" + strconv.FormatInt(node.Size, 36) + `"`
+}
+
+// setValidators 输出 ETag, Last-Modified
+func (ctl *TransportCtrl) setValidators(w http.ResponseWriter, node *service.FNode) {
+	w.Header().Set("ETag", ctl.getETag(node))
+	w.Header().Set("Last-Modified", time.UnixMilli(node.Mtime).UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+}
+
+// setETag 写入成功后返回新的校验值, 供下次 If-Match 使用
+func (ctl *TransportCtrl) setETag(w http.ResponseWriter, path string) {
+	if node := ctl.fm.GetNode(path); nil != node {
+		w.Header().Set("ETag", ctl.getETag(node))
+	}
+}
+
+// isNotModified 读取时的条件判断, If-None-Match 存在时忽略 If-Modified-Since, 返回true时应答304
+func (ctl *TransportCtrl) isNotModified(r *http.Request, node *service.FNode) bool {
+	if inm := r.Header.Get("If-None-Match"); len(inm) > 0 {
+		return ctl.matchETag(inm, ctl.getETag(node), false)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); len(ims) > 0 {
+		if t, err := http.ParseTime(ims); nil == err {
+			return node.Mtime/1000 <= t.Unix()
+		}
+	}
+	return false
+}
+
+// isRangeValid If-Range 判断, 不匹配时应忽略 Range 返回完整内容
+func (ctl *TransportCtrl) isRangeValid(r *http.Request, node *service.FNode) bool {
+	ir := r.Header.Get("If-Range")
+	if len(ir) == 0 {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return ctl.matchETag(ir, ctl.getETag(node), true)
+	}
+	// 时间只能精确到秒, 需完全相等
+	if t, err := http.ParseTime(ir); nil == err {
+		return node.Mtime/1000 == t.Unix()
+	}
+	return false
+}
+
+// isPreconditionFailed 写入时的条件判断, node为nil表示文件不存在, 返回true时应答412
+// If-Match: 文件必须存在且校验值一致; If-None-Match: * 文件必须不存在; If-Unmodified-Since: 文件未在该时间后修改
+func (ctl *TransportCtrl) isPreconditionFailed(r *http.Request, node *service.FNode) bool {
+	if im := r.Header.Get("If-Match"); len(im) > 0 {
+		if nil == node {
+			return true
+		}
+		if strings.TrimSpace(im) != "*" && !ctl.matchETag(im, ctl.getETag(node), true) {
+			return true
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); len(ius) > 0 && nil != node {
+		if t, err := http.ParseTime(ius); nil == err && node.Mtime/1000 > t.Unix() {
+			return true
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); len(inm) > 0 && nil != node {
+		if ctl.matchETag(inm, ctl.getETag(node), false) {
+			return true
+		}
+	}
+	return false
+}
+
+// getPreconditionCheck 写入提交前重新判断条件, 避免上传期间文件被其他请求修改后仍被覆盖, 没有条件头时返回nil
+func (ctl *TransportCtrl) getPreconditionCheck(r *http.Request) func(node *service.FNode) error {
+	if len(r.Header.Get("If-Match")) == 0 && len(r.Header.Get("If-None-Match")) == 0 && len(r.Header.Get("If-Unmodified-Since")) == 0 {
+		return nil
+	}
+	return func(node *service.FNode) error {
+		if ctl.isPreconditionFailed(r, node) {
+			return ErrorPreconditionFailed
+		}
+		return nil
+	}
+}
+
+// matchETag 判断逗号分隔的校验值列表中是否有匹配的, strong为true时弱校验值(W/)不参与匹配
+func (ctl *TransportCtrl) matchETag(header, etag string, strong bool) bool {
+	for _, val := range strings.Split(header, ",") {
+		val = strings.TrimSpace(val)
+		if val == "*" {
+			return true
+		}
+		if strings.HasPrefix(val, "W/") {
+			if strong {
+				continue
+			}
+			val = val[2:]
+		}
+		if val == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controller
+
+import (
+	"fileservice/business/service"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTransportCondition(t *testing.T) {
+	ctl := new(TransportCtrl)
+	mtime := time.Date(2021, 6, 1, 8, 0, 0, 0, time.UTC)
+	node := &service.FNode{Path: "/a.txt", IsFile: true, Size: 1024, Mtime: mtime.UnixMilli() + 300}
+	etag := ctl.getETag(node)
+	newReq := func(headers ...string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		return r
+	}
+	lastModified := mtime.Format(http.TimeFormat)
+	before := mtime.Add(-time.Second).Format(http.TimeFormat)
+	// 304
+	for i, c := range []struct {
+		r   *http.Request
+		res bool
+	}{
+		{newReq(), false},
+		{newReq("If-None-Match", etag), true},
+		{newReq("If-None-Match", `"x", W/`+etag), true},
+		{newReq("If-None-Match", `"x"`), false},
+		{newReq("If-None-Match", "*"), true},
+		{newReq("If-Modified-Since", lastModified), true},
+		{newReq("If-Modified-Since", before), false},
+		{newReq("If-None-Match", `"x"`, "If-Modified-Since", lastModified), false},
+	} {
+		if ctl.isNotModified(c.r, node) != c.res {
+			t.Fatal("isNotModified", i)
+		}
+	}
+	// If-Range
+	for i, c := range []struct {
+		r   *http.Request
+		res bool
+	}{
+		{newReq(), true},
+		{newReq("If-Range", etag), true},
+		{newReq("If-Range", "W/"+etag), false},
+		{newReq("If-Range", `"x"`), false},
+		{newReq("If-Range", lastModified), true},
+		{newReq("If-Range", before), false},
+		{newReq("If-Range", "bad"), false},
+	} {
+		if ctl.isRangeValid(c.r, node) != c.res {
+			t.Fatal("isRangeValid", i)
+		}
+	}
+	// 412
+	for i, c := range []struct {
+		r    *http.Request
+		node *service.FNode
+		res  bool
+	}{
+		{newReq(), node, false},
+		{newReq(), nil, false},
+		{newReq("If-Match", etag), node, false},
+		{newReq("If-Match", `"x"`), node, true},
+		{newReq("If-Match", "W/"+etag), node, true},
+		{newReq("If-Match", etag), nil, true},
+		{newReq("If-Match", "*"), node, false},
+		{newReq("If-Match", "*"), nil, true},
+		{newReq("If-None-Match", "*"), node, true},
+		{newReq("If-None-Match", "*"), nil, false},
+		{newReq("If-Unmodified-Since", lastModified), node, false},
+		{newReq("If-Unmodified-Since", before), node, true},
+	} {
+		if ctl.isPreconditionFailed(c.r, c.node) != c.res {
+			t.Fatal("isPreconditionFailed", i)
+		}
+	}
+}
@@ -12,6 +12,7 @@
 package controller
 
 import (
+	"fileservice/business/modules/filequota"
 	"fileservice/business/modules/filetransport"
 	"fileservice/business/service"
 	"io"
@@ -34,6 +35,9 @@ type TransportCtrl struct {
 	um  service.UserAuth4Rpc        `@autowired:"User4RPC"`
 	tt  service.TransportToken      `@autowired:"TransportToken"`
 	pms service.FilePermissionCheck `@autowired:"FilePermission"`
+	fq  service.FileQuota           `@autowired:"FileQuota"`
+	al  service.AuditLog            `@autowired:"AuditLog"`
+	th  service.Throttle            `@autowired:"Throttle"`
 }
 
 // AsController 实现 AsController 接口
@@ -45,6 +49,9 @@ func (ctl *TransportCtrl) AsController() ipakku.ControllerConfig {
 			HandlerFunc: [][]interface{}{
 				{http.MethodHead, "read/:[A-Za-z0-9]+$", ctl.ReadHead},
 				{http.MethodGet, "read/:[A-Za-z0-9]+$", ctl.Read},
+				{http.MethodHead, "signed", ctl.SignedReadHead},
+				{http.MethodGet, "signed", ctl.SignedRead},
+				{http.MethodPost, "rotatesignkey", ctl.RotateSignKey},
 				{http.MethodPost, "put/:[A-Za-z0-9]+$", ctl.Put},
 				{http.MethodPut, "put/:[A-Za-z0-9]+$", ctl.Put},
 				{http.MethodGet, "token", ctl.GetToken},
@@ -54,7 +61,7 @@ func (ctl *TransportCtrl) AsController() ipakku.ControllerConfig {
 		FilterConfig: ipakku.FilterConfig{
 			FilterFunc: [][]interface{}{
 				{`/:[\s\S]*`, func(rw http.ResponseWriter, r *http.Request) bool {
-					if strings.Contains(r.URL.Path, "/read/") || strings.Contains(r.URL.Path, "/put/") {
+					if strings.Contains(r.URL.Path, "/read/") || strings.Contains(r.URL.Path, "/put/") || strings.HasSuffix(r.URL.Path, "/signed") {
 						return true
 					} else if strings.Contains(r.URL.Path, "/token") && r.FormValue("type") == "submitupload" {
 						return true
@@ -89,30 +96,43 @@ func (ctl *TransportCtrl) GetToken(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	var err error
 	var token *service.StreamToken
+	userID := ctl.getUserID4Request(r)
+	props, err := ctl.parseTokenProps(r, userID, qtype)
+	if nil != err {
+		serviceutil.SendBadRequest(w, err.Error())
+		return
+	}
+	// 签名地址, 不写入缓存
+	if r.FormValue("mode") == "signed" {
+		ctl.getSignedToken(w, r, userID, qdata, qtype, props)
+		return
+	}
 	//
 	if qtype == "stream" {
-		if !ctl.checkPermision(ctl.getUserID4Request(r), qdata, service.FPM_Read) {
+		if !ctl.checkPermision(userID, qdata, service.FPM_Read) {
 			err = ErrorPermissionInsufficient
+			ctl.al.Record4Request(r, userID, service.AuditAction_FileDownload, qdata, "", err)
 		} else {
-			if token, err = ctl.tt.AskReadToken(qdata, nil); nil == err {
+			if token, err = ctl.tt.AskReadToken(qdata, props); nil == err {
 				token.TokenURL = "/filestream/v1/read/" + token.Token
 			}
 		}
 	} else if qtype == "download" {
-		if !ctl.checkPermision(ctl.getUserID4Request(r), qdata, service.FPM_Read) {
+		if !ctl.checkPermision(userID, qdata, service.FPM_Read) {
 			err = ErrorPermissionInsufficient
+			ctl.al.Record4Request(r, userID, service.AuditAction_FileDownload, qdata, "", err)
 		} else {
-			if token, err = ctl.tt.AskReadToken(qdata, nil); nil == err {
+			if token, err = ctl.tt.AskReadToken(qdata, props); nil == err {
 				token.TokenURL = httpclient.BuildURLWithArray("/filestream/v1/read/"+token.Token, [][]string{{"name", strutil.GetPathName(qdata)}})
 			}
 		}
 	} else if qtype == "upload" {
-		if !ctl.checkPermision(ctl.getUserID4Request(r), qdata, service.FPM_Write) {
+		if !ctl.checkPermision(userID, qdata, service.FPM_Write) {
 			err = ErrorPermissionInsufficient
+			ctl.al.Record4Request(r, userID, service.AuditAction_FileUpload, qdata, "", err)
 		} else {
-			if token, err = ctl.tt.AskWriteToken(qdata, nil); nil == err {
+			if token, err = ctl.tt.AskWriteToken(qdata, props); nil == err {
 				token.TokenURL = "/filestream/v1/put/" + token.Token
 			}
 		}
@@ -153,7 +173,7 @@ func (ctl *TransportCtrl) Put(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	token, err := ctl.getSteamToken(strutil.GetPathName(r.URL.Path))
+	token, err := ctl.getSteamToken(r, strutil.GetPathName(r.URL.Path), true)
 	if nil != err || nil == token {
 		serviceutil.SendBadRequest(w, err.Error())
 		return
@@ -162,6 +182,17 @@ func (ctl *TransportCtrl) Put(w http.ResponseWriter, r *http.Request) {
 		serviceutil.SendServerError(w, "path is empty")
 		return
 	}
+	// 条件写入, 防止覆盖他人的修改; 先判断一次避免无效上传, 提交前再判断一次
+	if node := ctl.fm.GetNode(token.FilePath); ctl.isPreconditionFailed(r, node) {
+		serviceutil.SendErrorAndStatus(w, http.StatusPreconditionFailed, ErrorPreconditionFailed.Error())
+		return
+	}
+	// 完整性校验, 不一致时放弃写入
+	expect, err := ctl.parseChecksum(r)
+	if nil != err {
+		serviceutil.SendBadRequest(w, err.Error())
+		return
+	}
 	//
 	filename := strutil.GetPathName(token.FilePath)
 	if mr, err := r.MultipartReader(); err == nil {
@@ -175,15 +206,29 @@ func (ctl *TransportCtrl) Put(w http.ResponseWriter, r *http.Request) {
 			if p, err = mr.NextPart(); nil == p || err == io.EOF {
 				break
 			}
+			// 校验值需要在文件之前
+			if p.FormName() == formNameChecksum {
+				if err = ctl.readChecksumField(&expect, p); nil != err {
+					serviceutil.SendBadRequest(w, err.Error())
+					return
+				}
+				continue
+			}
 			if p.FormName() != pName {
 				continue
 			}
 			hasfile = true
-			if err = ctl.fm.DoWrite(token.FilePath, p); nil != err {
-				serviceutil.SendServerError(w, err.Error())
+			cr := filetransport.NewChecksumReader(p, expect.md5, expect.sha256)
+			dst, err := ctl.writeFile(token, -1, cr, ctl.getPreconditionCheck(r))
+			if cr.IsMismatch() {
+				err = service.ErrorChecksumMismatch
+			}
+			ctl.al.Record4Request(r, token.Props[service.StreamTokenProp_UserID], service.AuditAction_FileUpload, token.FilePath, dst, err)
+			if nil != err {
+				ctl.sendWriteError(w, err)
 			} else {
 				p.Close()
-				serviceutil.SendSuccess(w, "")
+				ctl.sendWriteSuccess(w, dst, cr)
 			}
 			break
 		}
@@ -191,72 +236,189 @@ func (ctl *TransportCtrl) Put(w http.ResponseWriter, r *http.Request) {
 			serviceutil.SendServerError(w, "file not found from the form")
 		}
 	} else if nil != err && err == http.ErrNotMultipart {
-		if err := ctl.fm.DoWrite(token.FilePath, r.Body); nil != err {
-			serviceutil.SendServerError(w, err.Error())
+		cr := filetransport.NewChecksumReader(r.Body, expect.md5, expect.sha256)
+		dst, err := ctl.writeFile(token, r.ContentLength, cr, ctl.getPreconditionCheck(r))
+		if cr.IsMismatch() {
+			err = service.ErrorChecksumMismatch
+		}
+		ctl.al.Record4Request(r, token.Props[service.StreamTokenProp_UserID], service.AuditAction_FileUpload, token.FilePath, dst, err)
+		if nil != err {
+			ctl.sendWriteError(w, err)
 		} else {
-			serviceutil.SendSuccess(w, "")
+			ctl.sendWriteSuccess(w, dst, cr)
 		}
 	} else {
 		serviceutil.SendServerError(w, err.Error())
 	}
 }
 
-// Read 下载
+// writeFile 写入文件, 超出配额或token声明的大小时在数据提交前中断, size未知时为-1, check不为nil时在提交前判断写入条件,
+// 返回按冲突策略写入的最终路径
+func (ctl *TransportCtrl) writeFile(token *service.StreamToken, size int64, reader io.Reader, check func(node *service.FNode) error) (string, error) {
+	tr := ctl.th.NewReader(reader, token)
+	defer tr.Close()
+	reader = tr
+	var slr *filequota.LimitReader
+	if maxSize := ctl.getMaxUploadSize(token); maxSize >= 0 {
+		if size > maxSize {
+			return "", service.ErrorUploadTooLarge
+		}
+		slr = filequota.NewLimitReaderWithError(reader, maxSize, service.ErrorUploadTooLarge)
+		reader = slr
+	}
+	policy := ctl.getConflictPolicy(token)
+	oldSize := int64(0)
+	if policy == service.WriteConflict_Overwrite && ctl.fm.IsFile(token.FilePath) {
+		oldSize = ctl.fm.GetFileSize(token.FilePath)
+	}
+	// 预占配额, 大小未知时边读取边预占, 并发上传不会同时用掉同一份剩余空间
+	rv, err := ctl.fq.Reserve(token.FilePath, size, oldSize)
+	if nil != err {
+		return "", err
+	}
+	rr := filequota.NewReserveReader(reader, rv)
+	dst, err := ctl.fm.DoWriteWithCheck(token.FilePath, rr, policy, check)
+	if nil != err {
+		rv.Release()
+		if nil != slr && slr.IsExceeded() {
+			return "", service.ErrorUploadTooLarge
+		}
+		if rr.IsExceeded() {
+			return "", service.ErrorQuotaExceeded
+		}
+		return "", err
+	}
+	rv.Commit(ctl.fm.GetFileSize(dst) - oldSize)
+	return dst, nil
+}
+
+// sendWriteError 返回写入错误, 超出配额时返回507, 超出token声明的大小时返回413, 校验失败时返回400, 目标已存在时返回409,
+// 提交时条件不满足返回412
+func (ctl *TransportCtrl) sendWriteError(w http.ResponseWriter, err error) {
+	if err == service.ErrorFileExist {
+		serviceutil.SendErrorAndStatus(w, http.StatusConflict, err.Error())
+	} else if err == ErrorPreconditionFailed {
+		serviceutil.SendErrorAndStatus(w, http.StatusPreconditionFailed, err.Error())
+	} else if err == service.ErrorQuotaExceeded {
+		serviceutil.SendErrorAndStatus(w, http.StatusInsufficientStorage, err.Error())
+	} else if err == service.ErrorUploadTooLarge {
+		serviceutil.SendErrorAndStatus(w, http.StatusRequestEntityTooLarge, err.Error())
+	} else if err == service.ErrorChecksumMismatch {
+		serviceutil.SendBadRequest(w, err.Error())
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// ReadHead 下载前获取文件信息
 func (ctl *TransportCtrl) ReadHead(w http.ResponseWriter, r *http.Request) {
-	if token, err := ctl.getSteamToken(strutil.GetPathName(r.URL.Path)); nil != err || nil == token {
+	if token, err := ctl.getSteamToken(r, strutil.GetPathName(r.URL.Path), false); nil != err || nil == token {
 		serviceutil.SendBadRequest(w, err.Error())
-		return
 	} else {
-		maxSize := ctl.fm.GetFileSize(token.FilePath)
-		start, end, hasRange := serviceutil.GetRequestRange(r, maxSize)
-		w.Header().Set("Content-Length", strconv.Itoa(int(end-start)))
-		if hasRange {
-			w.Header().Set("Content-Range", "bytes "+strconv.Itoa(int(start))+"-"+strconv.Itoa(int(end-1))+"/"+strconv.Itoa(int(maxSize)))
-		}
+		ctl.readHead(w, r, token)
 	}
+}
 
+// readHead 输出文件信息
+func (ctl *TransportCtrl) readHead(w http.ResponseWriter, r *http.Request, token *service.StreamToken) {
+	node := ctl.fm.GetNode(token.FilePath)
+	if nil == node || !node.IsFile {
+		serviceutil.SendBadRequest(w, ErrorFileNotExist.Error())
+		return
+	}
+	ctl.setValidators(w, node)
+	if ctl.isNotModified(r, node) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	ranges, err := ctl.parseRange(r, node.Size)
+	if nil != err {
+		ctl.sendRangeError(w, node.Size, err)
+		return
+	}
+	if len(ranges) > 0 && !ctl.isRangeValid(r, node) {
+		ranges = nil
+	}
+	if len(ranges) > 1 {
+		ctl.setMultiRangeHeader(w, node, ranges)
+		w.WriteHeader(http.StatusPartialContent)
+	} else if len(ranges) == 1 {
+		w.Header().Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		w.Header().Set("Content-Range", ranges[0].contentRange(node.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(node.Size, 10))
+	}
 }
 
 // Read 下载
 func (ctl *TransportCtrl) Read(w http.ResponseWriter, r *http.Request) {
-	qToken := strutil.GetPathName(r.URL.Path)
-	token, err := ctl.getSteamToken(qToken)
-	if nil != err || nil == token {
+	if token, err := ctl.getSteamToken(r, strutil.GetPathName(r.URL.Path), true); nil != err || nil == token {
 		serviceutil.SendBadRequest(w, err.Error())
-		return
+	} else {
+		ctl.read(w, r, token)
 	}
+}
+
+// read 输出文件内容
+func (ctl *TransportCtrl) read(w http.ResponseWriter, r *http.Request, token *service.StreamToken) {
 	// 校验
-	if !ctl.fm.IsFile(token.FilePath) {
+	node := ctl.fm.GetNode(token.FilePath)
+	if nil == node || !node.IsFile {
 		serviceutil.SendBadRequest(w, ErrorFileNotExist.Error())
 		return
 	}
+	ctl.setValidators(w, node)
+	if ctl.isNotModified(r, node) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	// name
 	if name := r.FormValue("name"); len(name) > 0 {
 		w.Header().Set("Content-Type", strutil.GetMimeTypeBySuffix(name))
 		w.Header().Set("Content-Disposition", "attachment; filename="+name)
 	}
 	//
-	maxSize := ctl.fm.GetFileSize(token.FilePath)
-	start, end, hasRange := serviceutil.GetRequestRange(r, maxSize)
+	ranges, err := ctl.parseRange(r, node.Size)
+	if nil != err {
+		ctl.sendRangeError(w, node.Size, err)
+		return
+	}
+	// If-Range 不匹配时文件已变化, 返回完整内容, 避免续传拼接出两个版本
+	if len(ranges) > 0 && !ctl.isRangeValid(r, node) {
+		ranges = nil
+	}
+	if len(ranges) > 1 {
+		ctl.readMultiRange(w, r, token, node, ranges)
+		return
+	}
+	start, ctLength := int64(0), node.Size
+	if len(ranges) == 1 {
+		start, ctLength = ranges[0].start, ranges[0].length
+	}
 	//
-	if fr, err := ctl.fm.DoRead(token.FilePath, start); nil != err {
+	fr, err := ctl.fm.DoReadRange(token.FilePath, start, ctLength)
+	// 分段读取时只记录第一段
+	if start == 0 {
+		ctl.al.Record4Request(r, token.Props[service.StreamTokenProp_UserID], service.AuditAction_FileDownload, token.FilePath, "", err)
+	}
+	if nil != err {
 		serviceutil.SendServerError(w, err.Error())
 	} else {
 		defer fr.Close()
-		ctLength := end - start
 		if ctLength == 0 || ctLength < 0 {
 			w.WriteHeader(http.StatusNoContent)
 		} else {
-			w.Header().Set("Content-Length", strconv.Itoa(int(ctLength)))
-			if hasRange {
-				w.Header().Set("Content-Range", "bytes "+strconv.Itoa(int(start))+"-"+strconv.Itoa(int(end-1))+"/"+strconv.Itoa(int(maxSize)))
+			w.Header().Set("Content-Length", strconv.FormatInt(ctLength, 10))
+			if len(ranges) == 1 {
+				w.Header().Set("Content-Range", ranges[0].contentRange(node.Size))
 				w.WriteHeader(http.StatusPartialContent)
 			}
-			if _, err := io.Copy(w, filetransport.NewTokenReaderWarp(token, io.LimitReader(fr, ctLength), ctl.tt)); nil != err && err != io.EOF {
-				if opErr, ok := err.(*net.OpError); ok && nil != opErr.Err {
-					if opErr, ok = opErr.Err.(*net.OpError); ok && opErr.Op == "write" {
-						return
-					}
+			tr := ctl.th.NewReader(filetransport.NewTokenReaderWarp(token, fr, ctl.tt), token)
+			defer tr.Close()
+			if _, err := io.Copy(w, tr); nil != err && err != io.EOF {
+				if ctl.isClientClosed(err) {
+					return
 				}
 				logs.Errorln(err)
 				serviceutil.SendServerError(w, err.Error())
@@ -265,11 +427,12 @@ func (ctl *TransportCtrl) Read(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// getSteamToken 获取文件传输Token对象
-func (ctl *TransportCtrl) getSteamToken(token string) (*service.StreamToken, error) {
-	if tokenBody, err := ctl.tt.QueryToken(token); nil != err || nil == tokenBody {
-		return nil, ErrorOprationExpires
-	} else {
-		return tokenBody, nil
+// isClientClosed 是否是客户端断开导致的写入错误
+func (ctl *TransportCtrl) isClientClosed(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok && nil != opErr.Err {
+		if opErr, ok = opErr.Err.(*net.OpError); ok && opErr.Op == "write" {
+			return true
+		}
 	}
+	return false
 }
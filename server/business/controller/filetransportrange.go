@@ -0,0 +1,215 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 文件传输的分段下载, 支持 Range: bytes=a-b,c-d 多段请求
+
+package controller
+
+import (
+	"errors"
+	"fileservice/business/modules/filetransport"
+	"fileservice/business/service"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/wup364/pakku/utils/logs"
+	"github.com/wup364/pakku/utils/serviceutil"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// maxRanges 多段请求的段数上限, 超出时忽略 Range 返回完整内容
+const maxRanges = 64
+
+// ErrorRangeInvalid Range格式错误
+var ErrorRangeInvalid = errors.New("invalid range")
+
+// ErrorRangeNotSatisfiable Range都不在文件范围内
+var ErrorRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// httpRange 一个分段, 从start开始length个字节
+type httpRange struct {
+	start, length int64
+}
+
+// contentRange Content-Range 头的值
+func (ra httpRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(ra.start, 10) + "-" + strconv.FormatInt(ra.start+ra.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+// parseRange 解析 Range 头(兼容表单参数 Range), 没有或单位不是bytes时返回nil
+// 格式错误返回 ErrorRangeInvalid, 全部超出文件范围返回 ErrorRangeNotSatisfiable, 超出范围的段会被忽略
+func (ctl *TransportCtrl) parseRange(r *http.Request, size int64) ([]httpRange, error) {
+	qRange := r.Header.Get("Range")
+	if len(qRange) == 0 {
+		qRange = r.FormValue("Range")
+	}
+	if len(qRange) == 0 || !strings.HasPrefix(qRange, "bytes=") {
+		return nil, nil
+	}
+	var ranges []httpRange
+	noOverlap := false
+	for _, ra := range strings.Split(qRange[len("bytes="):], ",") {
+		if ra = strings.TrimSpace(ra); len(ra) == 0 {
+			continue
+		}
+		index := strings.Index(ra, "-")
+		if index < 0 {
+			return nil, ErrorRangeInvalid
+		}
+		qStart, qEnd := strings.TrimSpace(ra[:index]), strings.TrimSpace(ra[index+1:])
+		var res httpRange
+		if len(qStart) == 0 {
+			// -n 表示最后n个字节
+			if len(qEnd) == 0 || qEnd[0] == '-' || qEnd[0] == '+' {
+				return nil, ErrorRangeInvalid
+			}
+			n, err := strconv.ParseInt(qEnd, 10, 64)
+			if nil != err {
+				return nil, ErrorRangeInvalid
+			}
+			if n > size {
+				n = size
+			}
+			if n == 0 {
+				noOverlap = true
+				continue
+			}
+			res.start = size - n
+			res.length = n
+		} else {
+			start, err := strconv.ParseInt(qStart, 10, 64)
+			if nil != err || start < 0 {
+				return nil, ErrorRangeInvalid
+			}
+			if start >= size {
+				noOverlap = true
+				continue
+			}
+			res.start = start
+			if len(qEnd) == 0 {
+				res.length = size - start
+			} else {
+				end, err := strconv.ParseInt(qEnd, 10, 64)
+				if nil != err || start > end {
+					return nil, ErrorRangeInvalid
+				}
+				if end >= size {
+					end = size - 1
+				}
+				res.length = end - start + 1
+			}
+		}
+		ranges = append(ranges, res)
+	}
+	if len(ranges) == 0 {
+		if noOverlap {
+			return nil, ErrorRangeNotSatisfiable
+		}
+		return nil, ErrorRangeInvalid
+	}
+	// 段数过多或总长度超过文件大小时返回完整内容
+	if len(ranges) > maxRanges {
+		return nil, nil
+	}
+	sum := int64(0)
+	for _, ra := range ranges {
+		sum += ra.length
+	}
+	if len(ranges) > 1 && sum > size {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// sendRangeError 应答416
+func (ctl *TransportCtrl) sendRangeError(w http.ResponseWriter, size int64, err error) {
+	w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+	serviceutil.SendErrorAndStatus(w, http.StatusRequestedRangeNotSatisfiable, err.Error())
+}
+
+// getPartContentType 每段的 Content-Type, 没有指定时根据文件后缀判断
+func (ctl *TransportCtrl) getPartContentType(w http.ResponseWriter, node *service.FNode) string {
+	if ct := w.Header().Get("Content-Type"); len(ct) > 0 {
+		return ct
+	}
+	return strutil.GetMimeTypeBySuffix(strutil.GetPathName(node.Path))
+}
+
+// getPartHeader 每段的头信息
+func (ctl *TransportCtrl) getPartHeader(contentType string, ra httpRange, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {contentType},
+		"Content-Range": {ra.contentRange(size)},
+	}
+}
+
+// setMultiRangeHeader 设置多段应答的头信息, 返回分隔符
+func (ctl *TransportCtrl) setMultiRangeHeader(w http.ResponseWriter, node *service.FNode, ranges []httpRange) string {
+	// 用相同的分隔符预先计算总长度
+	var cw countingWriter
+	mw := multipart.NewWriter(&cw)
+	contentType := ctl.getPartContentType(w, node)
+	for _, ra := range ranges {
+		mw.CreatePart(ctl.getPartHeader(contentType, ra, node.Size))
+		cw += countingWriter(ra.length)
+	}
+	mw.Close()
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.FormatInt(int64(cw), 10))
+	return mw.Boundary()
+}
+
+// readMultiRange 以 multipart/byteranges 格式输出多段内容, 每段单独调用 DoReadRange
+func (ctl *TransportCtrl) readMultiRange(w http.ResponseWriter, r *http.Request, token *service.StreamToken, node *service.FNode, ranges []httpRange) {
+	contentType := ctl.getPartContentType(w, node)
+	boundary := ctl.setMultiRangeHeader(w, node, ranges)
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); nil != err {
+		serviceutil.SendServerError(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusPartialContent)
+	for i, ra := range ranges {
+		fr, err := ctl.fm.DoReadRange(token.FilePath, ra.start, ra.length)
+		// 分段读取时只记录第一段
+		if i == 0 && ra.start == 0 {
+			ctl.al.Record4Request(r, token.Props[service.StreamTokenProp_UserID], service.AuditAction_FileDownload, token.FilePath, "", err)
+		}
+		if nil != err {
+			logs.Errorln(err)
+			return
+		}
+		part, err := mw.CreatePart(ctl.getPartHeader(contentType, ra, node.Size))
+		if nil == err {
+			tr := ctl.th.NewReader(filetransport.NewTokenReaderWarp(token, fr, ctl.tt), token)
+			_, err = io.Copy(part, tr)
+			tr.Close()
+		}
+		fr.Close()
+		if nil != err && err != io.EOF {
+			if !ctl.isClientClosed(err) {
+				logs.Errorln(err)
+			}
+			return
+		}
+	}
+	mw.Close()
+}
+
+// countingWriter 只计算写入的长度
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
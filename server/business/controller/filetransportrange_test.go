@@ -0,0 +1,60 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controller
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	ctl := new(TransportCtrl)
+	for i, c := range []struct {
+		header string
+		size   int64
+		res    []httpRange
+		err    error
+	}{
+		{"", 10, nil, nil},
+		{"items=0-1", 10, nil, nil},
+		{"bytes=0-4", 10, []httpRange{{0, 5}}, nil},
+		{"bytes=5-", 10, []httpRange{{5, 5}}, nil},
+		{"bytes=-3", 10, []httpRange{{7, 3}}, nil},
+		{"bytes=-30", 10, []httpRange{{0, 10}}, nil},
+		{"bytes=8-20", 10, []httpRange{{8, 2}}, nil},
+		{"bytes=0-1, 4-5,-2", 10, []httpRange{{0, 2}, {4, 2}, {8, 2}}, nil},
+		{"bytes=0-1,20-30", 10, []httpRange{{0, 2}}, nil},
+		{"bytes=10-", 10, nil, ErrorRangeNotSatisfiable},
+		{"bytes=-0", 10, nil, ErrorRangeNotSatisfiable},
+		{"bytes=0-", 0, nil, ErrorRangeNotSatisfiable},
+		{"bytes=5-1", 10, nil, ErrorRangeInvalid},
+		{"bytes=a-1", 10, nil, ErrorRangeInvalid},
+		{"bytes=1", 10, nil, ErrorRangeInvalid},
+		{"bytes=--1", 10, nil, ErrorRangeInvalid},
+		{"bytes=", 10, nil, ErrorRangeInvalid},
+		// 总长度超过文件大小时返回完整内容
+		{"bytes=0-9,0-9", 10, nil, nil},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Range", c.header)
+		res, err := ctl.parseRange(r, c.size)
+		if err != c.err || !reflect.DeepEqual(res, c.res) {
+			t.Fatal(i, c.header, res, err)
+		}
+	}
+	// 段数上限
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Range", "bytes="+strings.Repeat("0-0,", maxRanges+1))
+	if res, err := ctl.parseRange(r, 1000); nil != err || nil != res {
+		t.Fatal(res, err)
+	}
+}
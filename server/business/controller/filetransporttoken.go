@@ -0,0 +1,167 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 传输token的使用限制: 次数、绑定IP、绝对过期时间、上传大小、限速
+
+package controller
+
+import (
+	"errors"
+	"fileservice/business/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wup364/pakku/utils/serviceutil"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// parseTokenProps 从请求参数中解析token的使用限制
+// ratelimit: 限速(字节/秒), maxuses: 最大使用次数, bindip: true时绑定申请者IP, expiresin: 有效期(秒), maxsize: 最大上传字节数
+// conflict: 上传目标已存在时 overwrite|fail|rename
+func (ctl *TransportCtrl) parseTokenProps(r *http.Request, userID, qtype string) (map[string]string, error) {
+	props := map[string]string{service.StreamTokenProp_UserID: userID}
+	if qrate := r.FormValue("ratelimit"); len(qrate) > 0 {
+		if rate, err := strconv.ParseInt(qrate, 10, 64); nil != err || rate < 0 {
+			return nil, errors.New("ratelimit is not a valid number")
+		}
+		props[service.StreamTokenProp_RateLimit] = qrate
+	}
+	if qmaxuses := r.FormValue("maxuses"); len(qmaxuses) > 0 {
+		if maxUses, err := strconv.ParseInt(qmaxuses, 10, 64); nil != err || maxUses <= 0 {
+			return nil, errors.New("maxuses is not a valid number")
+		}
+		props[service.StreamTokenProp_MaxUses] = qmaxuses
+	}
+	if qbindip := r.FormValue("bindip"); qbindip == "true" || qbindip == "1" {
+		props[service.StreamTokenProp_BindIP] = ctl.um.GetClientIP4Request(r)
+	}
+	if qexpiresin := r.FormValue("expiresin"); len(qexpiresin) > 0 {
+		expiresIn, err := strconv.ParseInt(qexpiresin, 10, 64)
+		if nil != err || expiresIn <= 0 {
+			return nil, errors.New("expiresin is not a valid number")
+		}
+		props[service.StreamTokenProp_ExpiresAt] = strconv.FormatInt(time.Now().UnixMilli()+expiresIn*1000, 10)
+	}
+	if qmaxsize := r.FormValue("maxsize"); len(qmaxsize) > 0 && qtype == "upload" {
+		if maxSize, err := strconv.ParseInt(qmaxsize, 10, 64); nil != err || maxSize < 0 {
+			return nil, errors.New("maxsize is not a valid number")
+		}
+		props[service.StreamTokenProp_MaxSize] = qmaxsize
+	}
+	if qconflict := r.FormValue("conflict"); len(qconflict) > 0 && qtype == "upload" {
+		if !service.IsWriteConflictPolicy(qconflict) {
+			return nil, service.ErrorConflictPolicyNotSupport
+		}
+		props[service.StreamTokenProp_Conflict] = qconflict
+	}
+	return props, nil
+}
+
+// getConflictPolicy 上传token声明的冲突策略, 默认覆盖
+func (ctl *TransportCtrl) getConflictPolicy(token *service.StreamToken) string {
+	if policy := token.Props[service.StreamTokenProp_Conflict]; len(policy) > 0 {
+		return policy
+	}
+	return service.WriteConflict_Overwrite
+}
+
+// getMaxUploadSize 上传token声明的最大字节数, -1为不限制
+func (ctl *TransportCtrl) getMaxUploadSize(token *service.StreamToken) int64 {
+	if maxSize, err := strconv.ParseInt(token.Props[service.StreamTokenProp_MaxSize], 10, 64); nil == err && maxSize >= 0 {
+		return maxSize
+	}
+	return -1
+}
+
+// getSteamToken 获取文件传输Token对象, 校验绑定IP和使用次数, count为true时计一次使用
+func (ctl *TransportCtrl) getSteamToken(r *http.Request, token string, count bool) (*service.StreamToken, error) {
+	if tokenBody, err := ctl.tt.UseToken(token, ctl.um.GetClientIP4Request(r), count); nil != err || nil == tokenBody {
+		return nil, ErrorOprationExpires
+	} else {
+		return tokenBody, nil
+	}
+}
+
+// getSignedToken 申请签名下载地址, 只支持 stream|download 和 expiresin 限制
+func (ctl *TransportCtrl) getSignedToken(w http.ResponseWriter, r *http.Request, userID, qdata, qtype string, props map[string]string) {
+	if qtype != "stream" && qtype != "download" {
+		serviceutil.SendBadRequest(w, ErrorNotSupport.Error())
+		return
+	}
+	for key := range props {
+		if key != service.StreamTokenProp_UserID && key != service.StreamTokenProp_ExpiresAt {
+			serviceutil.SendBadRequest(w, "signed url does not support the restriction: "+key)
+			return
+		}
+	}
+	if !ctl.checkPermision(userID, qdata, service.FPM_Read) {
+		ctl.al.Record4Request(r, userID, service.AuditAction_FileDownload, qdata, "", ErrorPermissionInsufficient)
+		serviceutil.SendBadRequest(w, ErrorPermissionInsufficient.Error())
+		return
+	}
+	expiresAt, _ := strconv.ParseInt(props[service.StreamTokenProp_ExpiresAt], 10, 64)
+	query, err := ctl.tt.SignURL(qdata, userID, expiresAt)
+	if nil != err {
+		serviceutil.SendBadRequest(w, err.Error())
+		return
+	}
+	if qtype == "download" {
+		query.Set("name", strutil.GetPathName(qdata))
+	}
+	token := service.StreamToken{
+		TokenURL: "/filestream/v1/signed?" + query.Encode(),
+		FilePath: qdata,
+		CTime:    time.Now().UnixMilli(),
+	}
+	serviceutil.SendSuccess(w, token.ToDto())
+}
+
+// SignedReadHead 签名地址下载前获取文件信息
+func (ctl *TransportCtrl) SignedReadHead(w http.ResponseWriter, r *http.Request) {
+	if token, err := ctl.tt.VerifyURL(r.URL.Query()); nil != err {
+		serviceutil.SendBadRequest(w, ErrorOprationExpires.Error())
+	} else {
+		ctl.readHead(w, r, token)
+	}
+}
+
+// SignedRead 签名地址下载, 只校验签名和有效期, 不查询缓存
+func (ctl *TransportCtrl) SignedRead(w http.ResponseWriter, r *http.Request) {
+	if token, err := ctl.tt.VerifyURL(r.URL.Query()); nil != err {
+		serviceutil.SendBadRequest(w, ErrorOprationExpires.Error())
+	} else {
+		ctl.read(w, r, token)
+	}
+}
+
+// RotateSignKey 轮换签名密钥, 使用旧密钥签名的地址在保留期内仍然有效
+func (ctl *TransportCtrl) RotateSignKey(w http.ResponseWriter, r *http.Request) {
+	if !ctl.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	kid, err := ctl.tt.RotateSignKey()
+	ctl.al.Record4Request(r, ctl.getUserID4Request(r), service.AuditAction_SignKeyRotate, kid, "", err)
+	if nil != err {
+		serviceutil.SendServerError(w, err.Error())
+	} else {
+		serviceutil.SendSuccess(w, kid)
+	}
+}
+
+// isAdmin 是否是管理员
+func (ctl *TransportCtrl) isAdmin(r *http.Request) bool {
+	if accesskey := ctl.um.GetAccessKey4Request(r); len(accesskey) > 0 {
+		if ack, err := ctl.um.GetUserAccess(accesskey); nil == err && ack.UserType == service.UserType_Admin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 定时任务接口
+
+package controller
+
+import (
+	"encoding/json"
+	"fileservice/business/service"
+	"net/http"
+	"strconv"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/serviceutil"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// JobCtrl 定时任务管理, 用户只能管理自己的定时任务, 管理员不受限制
+type JobCtrl struct {
+	um service.UserAuth4Rpc `@autowired:"User4RPC"`
+	jb service.JobScheduler `@autowired:"JobScheduler"`
+	al service.AuditLog     `@autowired:"AuditLog"`
+}
+
+// AsController 实现 AsController 接口
+func (ctl *JobCtrl) AsController() ipakku.ControllerConfig {
+	return ipakku.ControllerConfig{
+		RequestMapping: "/job/v1",
+		RouterConfig: ipakku.RouterConfig{
+			ToLowerCase: true,
+			HandlerFunc: [][]interface{}{
+				{http.MethodGet, ctl.ListJobs},
+				{http.MethodGet, ctl.QueryJob},
+				{http.MethodPost, ctl.AddJob},
+				{http.MethodPost, ctl.UpdateJob},
+				{http.MethodPost, ctl.DelJob},
+				{http.MethodPost, ctl.RunJob},
+				{http.MethodGet, ctl.ListJobRuns},
+			},
+		},
+		FilterConfig: ipakku.FilterConfig{
+			FilterFunc: [][]interface{}{
+				{`/:[\s\S]*`, ctl.um.GetAuthFilterFunc()},
+			},
+		},
+	}
+}
+
+// ListJobs 列出当前用户的定时任务, 管理员可以通过 userid 查看其他用户, userid=* 时列出全部
+func (ctl *JobCtrl) ListJobs(w http.ResponseWriter, r *http.Request) {
+	ack, err := ctl.um.GetUserAccess(ctl.um.GetAccessKey4Request(r))
+	if nil != err {
+		serviceutil.SendServerError(w, err.Error())
+		return
+	}
+	userID := ack.UserID
+	if qUserID := r.FormValue("userid"); len(qUserID) > 0 && qUserID != ack.UserID {
+		if ack.UserType != service.UserType_Admin {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if userID = qUserID; userID == "*" {
+			userID = ""
+		}
+	}
+	if jobs, err := ctl.jb.ListJobs(userID); nil == err {
+		jobdto := make([]*service.JobInfoDto, len(jobs))
+		for i := 0; i < len(jobs); i++ {
+			jobdto[i] = jobs[i].ToDto()
+		}
+		serviceutil.SendSuccess(w, jobdto)
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// QueryJob 查询单个定时任务
+func (ctl *JobCtrl) QueryJob(w http.ResponseWriter, r *http.Request) {
+	if job, ok := ctl.getJob(w, r); ok {
+		serviceutil.SendSuccess(w, job.ToDto())
+	}
+}
+
+// AddJob 添加定时任务, 管理员可以通过 userid 为其他用户添加
+// cron: 分 时 日 月 周, func: 异步任务名, params: 任务参数json对象, 支持 {YYYY} {MM} {DD} {hh} {mm} 时间占位符
+func (ctl *JobCtrl) AddJob(w http.ResponseWriter, r *http.Request) {
+	ack, err := ctl.um.GetUserAccess(ctl.um.GetAccessKey4Request(r))
+	if nil != err {
+		serviceutil.SendServerError(w, err.Error())
+		return
+	}
+	job, err := ctl.parseJob(r)
+	if nil != err {
+		serviceutil.SendBadRequest(w, err.Error())
+		return
+	}
+	job.UserID = ack.UserID
+	if qUserID := r.FormValue("userid"); len(qUserID) > 0 && qUserID != ack.UserID {
+		if ack.UserType != service.UserType_Admin {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		job.UserID = qUserID
+	}
+	res, err := ctl.jb.AddJob(job)
+	if nil == err {
+		ctl.al.Record4Request(r, ack.UserID, service.AuditAction_JobAdd, res.JobID, job.UserID, nil)
+		serviceutil.SendSuccess(w, res.ToDto())
+	} else {
+		ctl.al.Record4Request(r, ack.UserID, service.AuditAction_JobAdd, "", job.UserID, err)
+		serviceutil.SendBadRequest(w, err.Error())
+	}
+}
+
+// UpdateJob 修改定时任务, 参数与添加时相同
+func (ctl *JobCtrl) UpdateJob(w http.ResponseWriter, r *http.Request) {
+	old, ok := ctl.getJob(w, r)
+	if !ok {
+		return
+	}
+	job, err := ctl.parseJob(r)
+	if nil != err {
+		serviceutil.SendBadRequest(w, err.Error())
+		return
+	}
+	job.JobID = old.JobID
+	res, err := ctl.jb.UpdateJob(job)
+	ctl.al.Record4Request(r, "", service.AuditAction_JobEdit, old.JobID, "", err)
+	if nil == err {
+		serviceutil.SendSuccess(w, res.ToDto())
+	} else {
+		serviceutil.SendBadRequest(w, err.Error())
+	}
+}
+
+// DelJob 删除定时任务及执行记录
+func (ctl *JobCtrl) DelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := ctl.getJob(w, r)
+	if !ok {
+		return
+	}
+	err := ctl.jb.DelJob(job.JobID)
+	ctl.al.Record4Request(r, "", service.AuditAction_JobDel, job.JobID, "", err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// RunJob 立即执行一次, 返回执行记录
+func (ctl *JobCtrl) RunJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := ctl.getJob(w, r)
+	if !ok {
+		return
+	}
+	if run, err := ctl.jb.RunJob(job.JobID); nil == err {
+		serviceutil.SendSuccess(w, run.ToDto())
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// ListJobRuns 列出执行记录, 按时间倒序
+func (ctl *JobCtrl) ListJobRuns(w http.ResponseWriter, r *http.Request) {
+	job, ok := ctl.getJob(w, r)
+	if !ok {
+		return
+	}
+	limit := 100
+	if qLimit := r.FormValue("limit"); len(qLimit) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(qLimit); nil != err {
+			serviceutil.SendBadRequest(w, "limit is not a valid number")
+			return
+		}
+	}
+	if runs, err := ctl.jb.ListJobRuns(job.JobID, limit); nil == err {
+		rundto := make([]*service.JobRunInfoDto, len(runs))
+		for i := 0; i < len(runs); i++ {
+			rundto[i] = runs[i].ToDto()
+		}
+		serviceutil.SendSuccess(w, rundto)
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// getJob 获取 jobid 对应的定时任务, 非管理员只能获取自己的
+func (ctl *JobCtrl) getJob(w http.ResponseWriter, r *http.Request) (*service.JobInfo, bool) {
+	ack, err := ctl.um.GetUserAccess(ctl.um.GetAccessKey4Request(r))
+	if nil != err {
+		serviceutil.SendServerError(w, err.Error())
+		return nil, false
+	}
+	job, err := ctl.jb.QueryJob(r.FormValue("jobid"))
+	if nil != err {
+		if err == service.ErrorJobNotExist {
+			serviceutil.SendBadRequest(w, err.Error())
+		} else {
+			serviceutil.SendServerError(w, err.Error())
+		}
+		return nil, false
+	}
+	if job.UserID != ack.UserID && ack.UserType != service.UserType_Admin {
+		serviceutil.SendBadRequest(w, service.ErrorJobNotExist.Error())
+		return nil, false
+	}
+	return job, true
+}
+
+// parseJob 从请求中读取任务定义, enabled 默认为true
+func (ctl *JobCtrl) parseJob(r *http.Request) (service.JobInfo, error) {
+	job := service.JobInfo{
+		Name:     r.FormValue("name"),
+		Cron:     r.FormValue("cron"),
+		TaskType: r.FormValue("func"),
+		Enabled:  true,
+	}
+	if qEnabled := r.FormValue("enabled"); len(qEnabled) > 0 {
+		job.Enabled = strutil.String2Bool(qEnabled)
+	}
+	if qParams := r.FormValue("params"); len(qParams) > 0 {
+		if err := json.Unmarshal([]byte(qParams), &job.Params); nil != err {
+			return job, err
+		}
+	}
+	return job, nil
+}
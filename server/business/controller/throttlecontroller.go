@@ -0,0 +1,123 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 传输限速接口
+
+package controller
+
+import (
+	"fileservice/business/service"
+	"net/http"
+	"strconv"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/serviceutil"
+)
+
+// ThrottleCtrl 传输限速管理
+type ThrottleCtrl struct {
+	um service.UserAuth4Rpc `@autowired:"User4RPC"`
+	th service.Throttle     `@autowired:"Throttle"`
+	al service.AuditLog     `@autowired:"AuditLog"`
+}
+
+// AsController 实现 AsController 接口
+func (ctl *ThrottleCtrl) AsController() ipakku.ControllerConfig {
+	return ipakku.ControllerConfig{
+		RequestMapping: "/throttle/v1",
+		RouterConfig: ipakku.RouterConfig{
+			ToLowerCase: true,
+			HandlerFunc: [][]interface{}{
+				{http.MethodGet, ctl.ListLimits},
+				{http.MethodPost, ctl.SetLimit},
+				{http.MethodDelete, ctl.DelLimit},
+			},
+		},
+		FilterConfig: ipakku.FilterConfig{
+			FilterFunc: [][]interface{}{
+				{`/:[\s\S]*`, ctl.um.GetAuthFilterFunc()},
+			},
+		},
+	}
+}
+
+// checkPermission 检查是否是管理员
+func (ctl *ThrottleCtrl) checkPermission(w http.ResponseWriter, r *http.Request) bool {
+	if accesskey := ctl.um.GetAccessKey4Request(r); len(accesskey) > 0 {
+		if ack, err := ctl.um.GetUserAccess(accesskey); nil == err && ack.UserType == service.UserType_Admin {
+			return true
+		}
+	}
+	w.WriteHeader(http.StatusForbidden)
+	return false
+}
+
+// ListLimits 列出所有限速设置
+func (ctl *ThrottleCtrl) ListLimits(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	limits := ctl.th.ListLimits()
+	limitdto := make([]*service.ThrottleLimitDto, len(limits))
+	for i := 0; i < len(limits); i++ {
+		limitdto[i] = limits[i].ToDto()
+	}
+	serviceutil.SendSuccess(w, limitdto)
+}
+
+// SetLimit 添加或修改限速, scope: global|user|link, 速率单位为字节/秒, 0为不限制
+func (ctl *ThrottleCtrl) SetLimit(w http.ResponseWriter, r *http.Request) {
+	scope := r.FormValue("scope")
+	target := r.FormValue("target")
+	readRate, err := strconv.ParseInt(r.FormValue("readrate"), 10, 64)
+	if nil != err || readRate < 0 {
+		serviceutil.SendBadRequest(w, "readrate is not a valid number")
+		return
+	}
+	writeRate, err := strconv.ParseInt(r.FormValue("writerate"), 10, 64)
+	if nil != err || writeRate < 0 {
+		serviceutil.SendBadRequest(w, "writerate is not a valid number")
+		return
+	}
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	err = ctl.th.SetLimit(service.ThrottleLimit{
+		Scope:     scope,
+		Target:    target,
+		ReadRate:  readRate,
+		WriteRate: writeRate,
+	})
+	ctl.al.Record4Request(r, "", service.AuditAction_ThrottleSet, scope+":"+target, strconv.FormatInt(readRate, 10)+"/"+strconv.FormatInt(writeRate, 10), err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
+	} else if err == service.ErrorThrottleScopeNotSupport || err == service.ErrorThrottleTargetIsNil || err == service.ErrInvalidToken {
+		serviceutil.SendBadRequest(w, err.Error())
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// DelLimit 删除限速
+func (ctl *ThrottleCtrl) DelLimit(w http.ResponseWriter, r *http.Request) {
+	scope := r.FormValue("scope")
+	target := r.FormValue("target")
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	err := ctl.th.DelLimit(scope, target)
+	ctl.al.Record4Request(r, "", service.AuditAction_ThrottleDel, scope+":"+target, "", err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
+	} else if err == service.ErrorThrottleScopeNotSupport {
+		serviceutil.SendBadRequest(w, err.Error())
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
@@ -14,6 +14,7 @@ package controller
 import (
 	"fileservice/business/service"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/wup364/pakku/ipakku"
@@ -23,6 +24,7 @@ import (
 // UserCtrl 用户管理api
 type UserCtrl struct {
 	um service.User4RPC `@autowired:"User4RPC"`
+	al service.AuditLog `@autowired:"AuditLog"`
 }
 
 // AsController 实现 AsController 接口
@@ -40,6 +42,11 @@ func (ctl *UserCtrl) AsController() ipakku.ControllerConfig {
 				{"POST", ctl.UpdateUserPwd},
 				{"POST", ctl.CheckPwd},
 				{"POST", ctl.Logout},
+				{"GET", ctl.ListLoginLocks},
+				{"POST", ctl.UnlockLogin},
+				{"POST", ctl.UpdateUserDisabled},
+				{"POST", ctl.UpdateUserExpiredTime},
+				{"POST", ctl.UpdateUserPwdReset},
 			},
 		},
 		FilterConfig: ipakku.FilterConfig{
@@ -74,6 +81,25 @@ func (ctl *UserCtrl) checkPermission(w http.ResponseWriter, r *http.Request) boo
 	return false
 }
 
+// checkAdminPermission 检查是否是管理员
+func (ctl *UserCtrl) checkAdminPermission(w http.ResponseWriter, r *http.Request) bool {
+	if ctl.isAdmin(r) {
+		return true
+	}
+	w.WriteHeader(http.StatusForbidden)
+	return false
+}
+
+// isAdmin 当前请求是否是管理员发起
+func (ctl *UserCtrl) isAdmin(r *http.Request) bool {
+	if accesskey := ctl.um.GetAccessKey4Request(r); len(accesskey) > 0 {
+		if ack, err := ctl.um.GetUserAccess(accesskey); nil == err && ack.UserType == service.UserType_Admin {
+			return true
+		}
+	}
+	return false
+}
+
 // ListAllUsers 列出所有用户数据, 无分页
 func (ctl *UserCtrl) ListAllUsers(w http.ResponseWriter, r *http.Request) {
 	if !ctl.checkPermission(w, r) {
@@ -124,9 +150,12 @@ func (ctl *UserCtrl) AddUser(w http.ResponseWriter, r *http.Request) {
 		UserName: userName,
 		UserPWD:  userPwd,
 		UserType: service.UserType_Normal,
+		PwdReset: r.FormValue("pwdreset") == "true",
 	}
 
-	if err := ctl.um.AddUser(&uinfo); nil == err {
+	err := ctl.um.AddUser(&uinfo)
+	ctl.al.Record4Request(r, "", service.AuditAction_UserAdd, userID, "", err)
+	if nil == err {
 		serviceutil.SendSuccess(w, "")
 	} else {
 		serviceutil.SendServerError(w, err.Error())
@@ -144,7 +173,97 @@ func (ctl *UserCtrl) UpdateUserPwd(w http.ResponseWriter, r *http.Request) {
 	if !ctl.checkPermission(w, r) {
 		return
 	}
-	if err := ctl.um.UpdatePWD(userID, userPwd); nil == err {
+	err := ctl.um.UpdatePWD(userID, userPwd)
+	ctl.al.Record4Request(r, "", service.AuditAction_UserPassword, userID, "", err)
+	if nil != err {
+		serviceutil.SendServerError(w, err.Error())
+		return
+	}
+	// 管理员重置密码时, 可要求用户下次登录修改密码
+	if r.FormValue("pwdreset") == "true" && ctl.isAdmin(r) {
+		err := ctl.um.UpdateUserPwdReset(userID, true)
+		ctl.al.Record4Request(r, "", service.AuditAction_UserPwdReset, userID, "true", err)
+		if nil != err {
+			serviceutil.SendServerError(w, err.Error())
+			return
+		}
+	}
+	serviceutil.SendSuccess(w, "")
+}
+
+// UpdateUserDisabled 停用|启用账号
+func (ctl *UserCtrl) UpdateUserDisabled(w http.ResponseWriter, r *http.Request) {
+	userID := r.FormValue("userid")
+	if len(userID) == 0 {
+		serviceutil.SendBadRequest(w, ErrorUserIDIsNil.Error())
+		return
+	}
+	if !ctl.checkAdminPermission(w, r) {
+		return
+	}
+	disabled := r.FormValue("disabled") == "true"
+	if disabled {
+		users, _ := ctl.um.ListAllUsers()
+		count := 0
+		lauid := ""
+		for _, val := range users {
+			if val.UserType == service.UserType_Admin && !val.Disabled {
+				count++
+				lauid = val.UserID
+			}
+		}
+		if count <= 1 && (userID == lauid || len(lauid) == 0) {
+			serviceutil.SendBadRequest(w, "cannot disable the last admin user")
+			return
+		}
+	}
+	err := ctl.um.UpdateUserDisabled(userID, disabled)
+	ctl.al.Record4Request(r, "", service.AuditAction_UserDisable, userID, strconv.FormatBool(disabled), err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// UpdateUserExpiredTime 设置账号过期时间(毫秒时间戳), 0为永不过期
+func (ctl *UserCtrl) UpdateUserExpiredTime(w http.ResponseWriter, r *http.Request) {
+	userID := r.FormValue("userid")
+	if len(userID) == 0 {
+		serviceutil.SendBadRequest(w, ErrorUserIDIsNil.Error())
+		return
+	}
+	expiredTime, err := strconv.ParseInt(r.FormValue("expiredtime"), 10, 64)
+	if nil != err {
+		serviceutil.SendBadRequest(w, err.Error())
+		return
+	}
+	if !ctl.checkAdminPermission(w, r) {
+		return
+	}
+	err = ctl.um.UpdateUserExpiredTime(userID, expiredTime)
+	ctl.al.Record4Request(r, "", service.AuditAction_UserExpire, userID, strconv.FormatInt(expiredTime, 10), err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// UpdateUserPwdReset 设置下次登录时必须修改密码
+func (ctl *UserCtrl) UpdateUserPwdReset(w http.ResponseWriter, r *http.Request) {
+	userID := r.FormValue("userid")
+	if len(userID) == 0 {
+		serviceutil.SendBadRequest(w, ErrorUserIDIsNil.Error())
+		return
+	}
+	if !ctl.checkAdminPermission(w, r) {
+		return
+	}
+	pwdReset := r.FormValue("pwdreset") == "true"
+	err := ctl.um.UpdateUserPwdReset(userID, pwdReset)
+	ctl.al.Record4Request(r, "", service.AuditAction_UserPwdReset, userID, strconv.FormatBool(pwdReset), err)
+	if nil == err {
 		serviceutil.SendSuccess(w, "")
 	} else {
 		serviceutil.SendServerError(w, err.Error())
@@ -166,7 +285,9 @@ func (ctl *UserCtrl) UpdateUserName(w http.ResponseWriter, r *http.Request) {
 	if !ctl.checkPermission(w, r) {
 		return
 	}
-	if err := ctl.um.UpdateUserName(userID, userName); nil == err {
+	err := ctl.um.UpdateUserName(userID, userName)
+	ctl.al.Record4Request(r, "", service.AuditAction_UserRename, userID, userName, err)
+	if nil == err {
 		serviceutil.SendSuccess(w, "")
 	} else {
 		serviceutil.SendServerError(w, err.Error())
@@ -202,7 +323,9 @@ func (ctl *UserCtrl) DelUser(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	if err := ctl.um.DelUser(userID); nil == err {
+	err := ctl.um.DelUser(userID)
+	ctl.al.Record4Request(r, "", service.AuditAction_UserDelete, userID, "", err)
+	if nil == err {
 		serviceutil.SendSuccess(w, "")
 		// ctl.um.DestroyAccess(ctl.um.GetAccessKey4Request(r))
 	} else {
@@ -219,8 +342,42 @@ func (ctl *UserCtrl) CheckPwd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// 检查密码是否正确, 如果正确需要返回签名信息
-	if ack, err := ctl.um.AskAccess(userID, pwd); nil == err {
+	ack, err := ctl.um.AskAccess(userID, pwd, ctl.um.GetClientIP4Request(r))
+	ctl.al.Record4Request(r, userID, service.AuditAction_Login, userID, "", err)
+	if nil == err {
 		serviceutil.SendSuccess(w, ack)
+	} else if err == service.ErrorLoginLocked {
+		serviceutil.SendErrorAndStatus(w, http.StatusTooManyRequests, err.Error())
+	} else if err == service.ErrorUserDisabled || err == service.ErrorUserExpired {
+		serviceutil.SendErrorAndStatus(w, http.StatusForbidden, err.Error())
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// ListLoginLocks 列出因登录失败被锁定的账号和IP
+func (ctl *UserCtrl) ListLoginLocks(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkAdminPermission(w, r) {
+		return
+	}
+	serviceutil.SendSuccess(w, ctl.um.ListLoginLocks())
+}
+
+// UnlockLogin 解除账号或IP的登录锁定
+func (ctl *UserCtrl) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	userID := r.FormValue("userid")
+	clientIP := r.FormValue("ip")
+	if len(userID) == 0 && len(clientIP) == 0 {
+		serviceutil.SendBadRequest(w, ErrorParamsNotEmpty.Error())
+		return
+	}
+	if !ctl.checkAdminPermission(w, r) {
+		return
+	}
+	err := ctl.um.UnlockLogin(userID, clientIP)
+	ctl.al.Record4Request(r, "", service.AuditAction_UserUnlock, userID, clientIP, err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
 	} else {
 		serviceutil.SendServerError(w, err.Error())
 	}
@@ -229,7 +386,13 @@ func (ctl *UserCtrl) CheckPwd(w http.ResponseWriter, r *http.Request) {
 // Logout 注销会话
 func (ctl *UserCtrl) Logout(w http.ResponseWriter, r *http.Request) {
 	if ack := ctl.um.GetAccessKey4Request(r); len(ack) > 0 {
-		if err := ctl.um.DestroyAccess(ack); nil == err {
+		userID := ""
+		if access, err := ctl.um.GetUserAccess(ack); nil == err {
+			userID = access.UserID
+		}
+		err := ctl.um.DestroyAccess(ack)
+		ctl.al.Record4Request(r, userID, service.AuditAction_Logout, userID, "", err)
+		if nil == err {
 			serviceutil.SendSuccess(w, "")
 		} else {
 			serviceutil.SendServerError(w, err.Error())
@@ -0,0 +1,205 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Webhook接口
+
+package controller
+
+import (
+	"fileservice/business/service"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/serviceutil"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// WebhookCtrl Webhook管理, 仅管理员可用
+type WebhookCtrl struct {
+	um service.UserAuth4Rpc `@autowired:"User4RPC"`
+	wh service.Webhook      `@autowired:"Webhook"`
+	al service.AuditLog     `@autowired:"AuditLog"`
+}
+
+// AsController 实现 AsController 接口
+func (ctl *WebhookCtrl) AsController() ipakku.ControllerConfig {
+	return ipakku.ControllerConfig{
+		RequestMapping: "/webhook/v1",
+		RouterConfig: ipakku.RouterConfig{
+			ToLowerCase: true,
+			HandlerFunc: [][]interface{}{
+				{http.MethodGet, ctl.ListHooks},
+				{http.MethodGet, ctl.QueryHook},
+				{http.MethodPost, ctl.AddHook},
+				{http.MethodPost, ctl.UpdateHook},
+				{http.MethodPost, ctl.DelHook},
+				{http.MethodGet, ctl.ListDeliveries},
+			},
+		},
+		FilterConfig: ipakku.FilterConfig{
+			FilterFunc: [][]interface{}{
+				{`/:[\s\S]*`, ctl.um.GetAuthFilterFunc()},
+			},
+		},
+	}
+}
+
+// checkPermission 检查是否是管理员
+func (ctl *WebhookCtrl) checkPermission(w http.ResponseWriter, r *http.Request) bool {
+	if accesskey := ctl.um.GetAccessKey4Request(r); len(accesskey) > 0 {
+		if ack, err := ctl.um.GetUserAccess(accesskey); nil == err && ack.UserType == service.UserType_Admin {
+			return true
+		}
+	}
+	w.WriteHeader(http.StatusForbidden)
+	return false
+}
+
+// ListHooks 列出全部Webhook
+func (ctl *WebhookCtrl) ListHooks(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	if hooks, err := ctl.wh.ListHooks(); nil == err {
+		hookdto := make([]*service.WebhookInfoDto, len(hooks))
+		for i := 0; i < len(hooks); i++ {
+			hookdto[i] = hooks[i].ToDto()
+		}
+		serviceutil.SendSuccess(w, hookdto)
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// QueryHook 查询单个Webhook
+func (ctl *WebhookCtrl) QueryHook(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	if hook, ok := ctl.getHook(w, r); ok {
+		serviceutil.SendSuccess(w, hook.ToDto())
+	}
+}
+
+// AddHook 添加Webhook, events: 逗号分隔的事件, 为空时订阅全部; secret 为空时自动生成
+func (ctl *WebhookCtrl) AddHook(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	hook, err := ctl.wh.AddHook(ctl.parseHook(r))
+	if nil == err {
+		ctl.al.Record4Request(r, "", service.AuditAction_WebhookAdd, hook.HookID, hook.URL, nil)
+		serviceutil.SendSuccess(w, hook.ToDto())
+	} else {
+		ctl.al.Record4Request(r, "", service.AuditAction_WebhookAdd, "", r.FormValue("url"), err)
+		serviceutil.SendBadRequest(w, err.Error())
+	}
+}
+
+// UpdateHook 修改Webhook, 参数与添加时相同, secret 为空时保持不变
+func (ctl *WebhookCtrl) UpdateHook(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	old, ok := ctl.getHook(w, r)
+	if !ok {
+		return
+	}
+	hook := ctl.parseHook(r)
+	hook.HookID = old.HookID
+	res, err := ctl.wh.UpdateHook(hook)
+	ctl.al.Record4Request(r, "", service.AuditAction_WebhookEdit, old.HookID, hook.URL, err)
+	if nil == err {
+		serviceutil.SendSuccess(w, res.ToDto())
+	} else {
+		serviceutil.SendBadRequest(w, err.Error())
+	}
+}
+
+// DelHook 删除Webhook及投递记录
+func (ctl *WebhookCtrl) DelHook(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	hook, ok := ctl.getHook(w, r)
+	if !ok {
+		return
+	}
+	err := ctl.wh.DelHook(hook.HookID)
+	ctl.al.Record4Request(r, "", service.AuditAction_WebhookDel, hook.HookID, hook.URL, err)
+	if nil == err {
+		serviceutil.SendSuccess(w, "")
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// ListDeliveries 列出投递记录, 按时间倒序
+func (ctl *WebhookCtrl) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !ctl.checkPermission(w, r) {
+		return
+	}
+	hook, ok := ctl.getHook(w, r)
+	if !ok {
+		return
+	}
+	limit := 100
+	if qLimit := r.FormValue("limit"); len(qLimit) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(qLimit); nil != err {
+			serviceutil.SendBadRequest(w, "limit is not a valid number")
+			return
+		}
+	}
+	if dls, err := ctl.wh.ListDeliveries(hook.HookID, limit); nil == err {
+		dldto := make([]*service.WebhookDeliveryDto, len(dls))
+		for i := 0; i < len(dls); i++ {
+			dldto[i] = dls[i].ToDto()
+		}
+		serviceutil.SendSuccess(w, dldto)
+	} else {
+		serviceutil.SendServerError(w, err.Error())
+	}
+}
+
+// getHook 获取 hookid 对应的Webhook
+func (ctl *WebhookCtrl) getHook(w http.ResponseWriter, r *http.Request) (*service.WebhookInfo, bool) {
+	hook, err := ctl.wh.QueryHook(r.FormValue("hookid"))
+	if nil != err {
+		if err == service.ErrorWebhookNotExist {
+			serviceutil.SendBadRequest(w, err.Error())
+		} else {
+			serviceutil.SendServerError(w, err.Error())
+		}
+		return nil, false
+	}
+	return hook, true
+}
+
+// parseHook 从请求中读取Webhook定义, enabled 默认为true
+func (ctl *WebhookCtrl) parseHook(r *http.Request) service.WebhookInfo {
+	hook := service.WebhookInfo{
+		URL:        r.FormValue("url"),
+		Secret:     r.FormValue("secret"),
+		PathPrefix: r.FormValue("pathprefix"),
+		Events:     make([]string, 0),
+		Enabled:    true,
+	}
+	if qEnabled := r.FormValue("enabled"); len(qEnabled) > 0 {
+		hook.Enabled = strutil.String2Bool(qEnabled)
+	}
+	for _, event := range strings.Split(r.FormValue("events"), ",") {
+		if event = strings.TrimSpace(event); len(event) > 0 {
+			hook.Events = append(hook.Events, event)
+		}
+	}
+	return hook
+}
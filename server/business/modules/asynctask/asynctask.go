@@ -13,15 +13,23 @@ package asynctask
 
 import (
 	"errors"
+	"fileservice/business/constants"
 	"fileservice/business/service"
 
 	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/fileutil"
 	"github.com/wup364/pakku/utils/logs"
 )
 
 // AsyncTask 文件异步操作
 type AsyncTask struct {
-	c       ipakku.AppCache `@autowired:"AppCache"`
+	c       ipakku.AppCache      `@autowired:"AppCache"`
+	conf    ipakku.AppConfig     `@autowired:"AppConfig"`
+	sg      service.UserAuth4Rpc `@autowired:"User4RPC"`
+	ts      *TaskStory
+	tr      *TaskRegistry
+	sig     *TaskSignal
+	sched   *TaskScheduler
 	actions map[string]service.AsyncTaskExecI
 }
 
@@ -29,17 +37,57 @@ type AsyncTask struct {
 func (m *AsyncTask) AsModule() ipakku.Opts {
 	return ipakku.Opts{
 		Name:        "AsyncTask",
-		Version:     1.0,
+		Version:     1.1,
 		Description: "异步动作模块",
 		OnReady: func(mctx ipakku.Loader) {
-			m.AddTaskObject(new(CopyFile).Init(mctx))
-			m.AddTaskObject(new(MoveFile).Init(mctx))
+			deftDataSource := "./.datas/" + mctx.GetParam(ipakku.PARAMKEY_APPNAME).ToString("app") + ".db?cache=shared"
+			confDataSource := m.conf.GetConfig("asynctask.sotre.datasource").ToString(deftDataSource)
+			if confDataSource == deftDataSource {
+				m.mkSqliteDIR() // 创建sqlite文件存放目录
+			}
+			m.ts = new(TaskStory)
+			if err := m.ts.Initial(constants.DBSetting{
+				DriverName:     m.conf.GetConfig("asynctask.sotre.driver").ToString("sqlite3"),
+				DataSourceName: confDataSource,
+			}); nil != err {
+				logs.Panicln(err)
+			}
+			m.tr = NewTaskRegistry(m.ts)
+			m.sig = NewTaskSignal()
+			m.sched = NewTaskScheduler(m.tr,
+				int(service.GetInt64Config(m.conf, "asynctask.maxrunning", 8)),
+				int(service.GetInt64Config(m.conf, "asynctask.maxrunningperuser", 2)),
+				service.GetInt64Config(m.conf, "asynctask.smalltasksize", 16*1024*1024),
+			)
+			base := &taskBase{sg: m.sg, token: NewTaskToken(m.c), tr: m.tr, sig: m.sig, sched: m.sched}
+			cp := (&CopyFile{taskBase: base}).Init(mctx).(*CopyFile)
+			mv := (&MoveFile{taskBase: base}).Init(mctx).(*MoveFile)
+			del := (&DeleteFile{taskBase: base}).Init(mctx).(*DeleteFile)
+			m.AddTaskObject(cp)
+			m.AddTaskObject(mv)
+			m.AddTaskObject(del)
+			m.AddTaskObject((&FetchURL{taskBase: base}).Init(mctx))
+			m.AddTaskObject((&BatchFile{taskBase: base, cp: cp, mv: mv, del: del}).Init(mctx))
+		},
+		OnSetup: func() {
+			// 执行建库、建表
+			if err := m.ts.Install(); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnUpdate: func(cv float64) {
+			// 1.1 新增任务记录表
+			if cv < 1.1 {
+				if err := m.ts.Install(); nil != err {
+					logs.Panicln(err)
+				}
+			}
 		},
 		OnInit: func() {
 			if err := m.c.RegLib(service.CacheLib_AsyncTaskToken, service.CacheLib_AsyncTaskToken_Exp); nil != err {
 				logs.Panicln(err)
 			}
-
+			m.resumeTasks()
 		},
 	}
 }
@@ -64,3 +112,71 @@ func (m *AsyncTask) AddTaskObject(task service.AsyncTaskExecI) {
 		m.actions[task.Name()] = task
 	}
 }
+
+// ListTasks 列出用户的任务, 按开始时间倒序
+func (m *AsyncTask) ListTasks(userID string) ([]service.TaskInfo, error) {
+	return m.ts.ListTasks(userID)
+}
+
+// QueryTask 查询任务, 不存在返回 ErrorTaskNotExist
+func (m *AsyncTask) QueryTask(taskID string) (*service.TaskInfo, error) {
+	if task, err := m.ts.QueryTask(taskID); nil != err {
+		return nil, err
+	} else if nil == task {
+		return nil, service.ErrorTaskNotExist
+	} else {
+		return task, nil
+	}
+}
+
+// ClearTasks 清除用户已结束的任务, 返回清除条数
+func (m *AsyncTask) ClearTasks(userID string) (int64, error) {
+	return m.ts.DelTasks(userID, service.TaskState_Completed, service.TaskState_Failed, service.TaskState_Discontinued)
+}
+
+// Subscribe 订阅任务变化通知, 使用完毕后调用返回的取消函数
+func (m *AsyncTask) Subscribe(token string) (<-chan struct{}, func()) {
+	return m.sig.Subscribe(token)
+}
+
+// resumeTasks 处理进程重启前未结束的任务, 支持续传的任务继续执行(暂停的保持暂停), 否则标记为失败
+func (m *AsyncTask) resumeTasks() {
+	tasks, err := m.ts.ListTasksByState(service.TaskState_Running)
+	if nil != err {
+		logs.Errorln(err)
+		return
+	}
+	for _, state := range []string{service.TaskState_Queued, service.TaskState_Paused} {
+		if others, err := m.ts.ListTasksByState(state); nil != err {
+			logs.Errorln(err)
+		} else {
+			tasks = append(tasks, others...)
+		}
+	}
+	for _, task := range tasks {
+		if action, ok := m.actions[task.TaskType].(service.AsyncTaskResumeI); ok {
+			if err := action.Resume(task); nil != err {
+				logs.Errorln(err)
+				m.tr.Finish(task.TaskID, err)
+			} else {
+				logs.Infof("AsyncTask resume: %s %s\r\n", task.TaskType, task.TaskID)
+			}
+		} else {
+			m.tr.Finish(task.TaskID, service.ErrorTaskInterrupted)
+		}
+	}
+}
+
+// AddTaskListener 监听任务结束
+func (m *AsyncTask) AddTaskListener(listener service.TaskListener) {
+	m.tr.AddListener(listener)
+}
+
+// mkSqliteDIR 创建sqlite文件存放目录
+func (m *AsyncTask) mkSqliteDIR() {
+	if !fileutil.IsExist("./.datas") {
+		if err := fileutil.MkdirAll("./.datas"); nil != err {
+			logs.Panicln(err)
+		}
+	}
+}
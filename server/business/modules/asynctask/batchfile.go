@@ -0,0 +1,407 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 文件批量操作, 多选时一次提交, 执行完毕后返回每一项的结果
+
+package asynctask
+
+import (
+	"encoding/json"
+	"errors"
+	"fileservice/business/service"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/logs"
+	"github.com/wup364/pakku/utils/serviceutil"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// BatchItemResult 单项执行结果
+type BatchItemResult struct {
+	Path        string            // 源路径
+	Dst         string            // 目标路径|新名字
+	ErrorString string            // 错误信息, 为空时成功
+	Node        *service.FNodeDto // 文件信息, 仅查询信息时有
+}
+
+// BatchFileTokenObject 批量操作Token保存对象
+type BatchFileTokenObject struct {
+	ErrorString   string            // 错误信息
+	Op            string            // 操作类型
+	Src           string            // 当前正在处理的路径
+	Results       []BatchItemResult // 已处理项的结果
+	IsComplete    bool              // 是否执行完毕
+	IsDiscontinue bool              // 是否已中断操作
+	IsPaused      bool              // 是否已暂停, 在文件边界生效, 恢复后从暂停处继续
+	Progress      *TaskProgressDto  // 进度
+}
+
+// Clone 本地缓存拷贝接口
+func (dto *BatchFileTokenObject) Clone(val interface{}) error {
+	if tmp, ok := val.(*BatchFileTokenObject); ok {
+		tmp.ErrorString = dto.ErrorString
+		tmp.Op = dto.Op
+		tmp.Src = dto.Src
+		tmp.Results = append([]BatchItemResult{}, dto.Results...)
+		tmp.IsComplete = dto.IsComplete
+		tmp.IsDiscontinue = dto.IsDiscontinue
+		tmp.IsPaused = dto.IsPaused
+		tmp.Progress = dto.Progress
+	}
+	return nil
+}
+
+// ToJSON 转传JSON
+func (dto *BatchFileTokenObject) ToJSON() string {
+	if bt, err := json.Marshal(dto); nil != err {
+		return ""
+	} else {
+		return string(bt)
+	}
+}
+
+// TaskState 是否已暂停|中断
+func (dto *BatchFileTokenObject) TaskState() (paused, discontinued bool) {
+	return dto.IsPaused, dto.IsDiscontinue
+}
+
+// BatchFile 批量操作, 执行前统一校验权限, 单项失败不影响其他项, 冲突不等待用户选择直接记为失败
+type BatchFile struct {
+	*taskBase
+	fm       service.FileDatas           `@autowired:"FileDatas"`
+	pmc      service.FilePermissionCheck `@autowired:"FilePermission"`
+	cp       *CopyFile
+	mv       *MoveFile
+	del      *DeleteFile
+	progress sync.Map // 执行中任务的进度, token -> *TaskProgress
+}
+
+// Name 动作名字
+func (task *BatchFile) Name() string {
+	return "BatchFile"
+}
+
+// Init 初始化对象
+func (task *BatchFile) Init(mctx ipakku.Loader) service.AsyncTaskExecI {
+	if err := mctx.AutoWired(task); nil != err {
+		logs.Panicln(err)
+	}
+	return task
+}
+
+// Execute 动作执行, 返回一个tooken
+// op: info|delete|rename|copy|move, paths: 路径JSON数组, names: 重命名时的新名字JSON数组, dstPath: 复制|移动的目标文件夹
+func (task *BatchFile) Execute(r *http.Request) (string, error) {
+	qOp := r.FormValue("op")
+	qDstPath := strutil.Parse2UnixPath(r.FormValue("dstPath"))
+	qReplace := strutil.String2Bool(r.FormValue("replace"))
+	var qPaths, qNames []string
+	if err := json.Unmarshal([]byte(r.FormValue("paths")), &qPaths); nil != err || len(qPaths) == 0 {
+		return "", errors.New("paths parameter is not a valid json array")
+	}
+	for i := 0; i < len(qPaths); i++ {
+		if qPaths[i] = strutil.Parse2UnixPath(qPaths[i]); len(qPaths[i]) == 0 {
+			return "", errors.New("paths parameter contains empty path")
+		}
+	}
+	switch qOp {
+	case service.BatchOp_Info, service.BatchOp_Delete:
+	case service.BatchOp_Rename:
+		if err := json.Unmarshal([]byte(r.FormValue("names")), &qNames); nil != err || len(qNames) != len(qPaths) {
+			return "", errors.New("names parameter does not match paths")
+		}
+	case service.BatchOp_Copy, service.BatchOp_Move:
+		if len(qDstPath) == 0 {
+			return "", errors.New("dstPath parameter not found")
+		}
+	default:
+		return "", errors.New("op parameter must be one of info|delete|rename|copy|move")
+	}
+	// 执行前检查全部路径
+	userID := task.GetUserID4Request(r)
+	if err := task.checkPermission(userID, qOp, qPaths, qNames, qDstPath); nil != err {
+		return "", err
+	}
+	// 异步处理, 返回一个Token用于查询进度
+	token, err := task.token.AskToken(&BatchFileTokenObject{
+		Op:  qOp,
+		Src: qPaths[0],
+	})
+	if nil != err {
+		return "", err
+	}
+	paths, _ := json.Marshal(qPaths)
+	names, _ := json.Marshal(qNames)
+	params := map[string]string{
+		"op":      qOp,
+		"paths":   string(paths),
+		"names":   string(names),
+		"dstPath": qDstPath,
+		"replace": strconv.FormatBool(qReplace),
+	}
+	if err := task.tr.Begin(token, userID, task.Name(), params); nil != err {
+		task.token.DestroyToken(token, true)
+		return "", err
+	}
+	task.sched.Go(token, userID, func() int64 {
+		if qOp == service.BatchOp_Info || qOp == service.BatchOp_Rename {
+			return 0
+		}
+		return task.del.getSize(qPaths)
+	}, func() error {
+		return task.keepQueued(token, new(BatchFileTokenObject))
+	}, func() {
+		task.doTask(token, userID, qOp, qPaths, qNames, qDstPath, qReplace)
+	})
+	return token, nil
+}
+
+// checkPermission 检查全部路径的权限, 有一项不满足则全部不执行
+func (task *BatchFile) checkPermission(userID, op string, paths, names []string, dstPath string) error {
+	if (op == service.BatchOp_Copy || op == service.BatchOp_Move) && !task.pmc.HashPermission(userID, dstPath, service.FPM_Write) {
+		return service.ErrorPermissionInsufficient
+	}
+	for i := 0; i < len(paths); i++ {
+		switch op {
+		case service.BatchOp_Info:
+			if !task.pmc.HashPermission(userID, paths[i], service.FPM_Visible) {
+				return service.ErrorPermissionInsufficient
+			}
+		case service.BatchOp_Delete:
+			if !task.pmc.HashPermission(userID, paths[i], service.FPM_Write) {
+				return service.ErrorPermissionInsufficient
+			}
+		case service.BatchOp_Rename:
+			if len(names[i]) == 0 || strings.Contains(names[i], "/") {
+				return errors.New("invalid name: " + names[i])
+			}
+			if !task.pmc.HashPermission(userID, paths[i], service.FPM_Write) {
+				return service.ErrorPermissionInsufficient
+			}
+		case service.BatchOp_Copy, service.BatchOp_Move:
+			permission := int64(service.FPM_Read)
+			if op == service.BatchOp_Move {
+				permission = service.FPM_Write
+			}
+			if !task.pmc.HashPermission(userID, paths[i], permission) {
+				return service.ErrorPermissionInsufficient
+			}
+			if strings.HasPrefix(dstPath+"/", paths[i]+"/") {
+				return errors.New("cannot " + op + " " + paths[i] + " into itself")
+			}
+		}
+	}
+	return nil
+}
+
+// doTask 在后台逐项执行, 结束后登记结果
+func (task *BatchFile) doTask(token, userID, op string, paths, names []string, dstPath string, replace bool) {
+	var p *TaskProgress
+	if op == service.BatchOp_Info || op == service.BatchOp_Rename {
+		p = &TaskProgress{totalFiles: int64(len(paths)), lastTime: time.Now()}
+	} else {
+		p = NewTaskProgress4Paths(task.fm, paths)
+	}
+	task.progress.Store(token, p)
+	defer task.progress.Delete(token)
+	var batchErr error
+	failed := 0
+	for i := 0; i < len(paths) && nil == batchErr; i++ {
+		// 开始每一项前检查中断和暂停, 已中断时不再执行剩余项
+		if batchErr = task.checkToken(token, paths[i]); nil != batchErr {
+			break
+		}
+		item := BatchItemResult{Path: paths[i]}
+		// walk 在子项执行后调用, 子项的错误记入当前项后跳过, 再检查中断和暂停
+		walk := func(s_src string, itemErr error) error {
+			if nil != itemErr {
+				if len(item.ErrorString) == 0 {
+					item.ErrorString = s_src + ": " + itemErr.Error()
+				}
+				if op != service.BatchOp_Info && op != service.BatchOp_Rename {
+					p.Skip(task.fm, s_src)
+				}
+			}
+			return task.checkToken(token, s_src)
+		}
+		if !task.fm.IsExist(paths[i]) {
+			batchErr = walk(paths[i], service.ErrorFileNotExist)
+		} else {
+			switch op {
+			case service.BatchOp_Info:
+				node := task.fm.GetNode(paths[i]).ToDto()
+				item.Node = &node
+				batchErr = walk(paths[i], nil)
+			case service.BatchOp_Rename:
+				item.Dst = names[i]
+				batchErr = walk(paths[i], task.fm.DoRename(paths[i], names[i]))
+			case service.BatchOp_Delete:
+				batchErr = task.del.doDelete(p, paths[i], walk)
+			case service.BatchOp_Copy:
+				item.Dst = strutil.Parse2UnixPath(dstPath + "/" + strutil.GetPathName(paths[i]))
+				if task.fm.IsDir(paths[i]) && !task.fm.IsExist(item.Dst) {
+					if err := task.fm.DoMkDir(item.Dst); nil != err {
+						batchErr = walk(paths[i], err)
+						break
+					}
+				}
+				batchErr = task.cp.doCopy(p, paths[i], item.Dst, replace, false, false, func(s_src, s_dst string, copyErr *CopyError) error {
+					if nil != copyErr {
+						return walk(s_src, errors.New(copyErr.ErrorString))
+					}
+					return walk(s_src, nil)
+				})
+			case service.BatchOp_Move:
+				item.Dst = strutil.Parse2UnixPath(dstPath + "/" + strutil.GetPathName(paths[i]))
+				batchErr = task.mv.doMove(p, paths[i], item.Dst, replace, false, func(s_src, s_dst string, moveErr *MoveError) error {
+					if nil != moveErr {
+						return walk(s_src, errors.New(moveErr.ErrorString))
+					}
+					return walk(s_src, nil)
+				})
+			}
+		}
+		// 执行中途被中断时, 已经执行的部分同样记入结果
+		if op == service.BatchOp_Info || op == service.BatchOp_Rename {
+			p.AddFiles(1)
+		}
+		if len(item.ErrorString) > 0 {
+			failed++
+		}
+		if err := task.addResult(token, item); nil != err && nil == batchErr {
+			batchErr = err
+		}
+	}
+	// 到这里如果没有错误就是成功了, 有失败项时记为失败
+	tokenBody := new(BatchFileTokenObject)
+	if err := task.getTokenObject(token, tokenBody); nil == err {
+		if nil == batchErr && failed > 0 {
+			batchErr = fmt.Errorf("%d of %d items failed", failed, len(paths))
+		}
+		if nil != batchErr {
+			tokenBody.ErrorString = batchErr.Error()
+			logs.Errorln(batchErr)
+		} else {
+			tokenBody.ErrorString = ""
+		}
+		tokenBody.IsComplete = true
+		tokenBody.IsDiscontinue = service.ErrorDiscontinue.Error() == tokenBody.ErrorString
+		tokenBody.Progress = p.Snapshot()
+		if err := task.token.RefreshToken(token, tokenBody); nil != err {
+			logs.Errorln(err)
+		}
+		task.sig.Notify(token)
+		task.tr.Finish(token, batchErr)
+	} else {
+		logs.Errorln(err)
+		task.tr.Finish(token, err)
+	}
+}
+
+// checkToken 检查是否中断, 暂停时在这里等待, 并更新当前处理的路径
+func (task *BatchFile) checkToken(token, src string) error {
+	tokenBody := new(BatchFileTokenObject)
+	err := task.getTokenObject(token, tokenBody)
+	if nil != err {
+		return err
+	} else if tokenBody.IsDiscontinue {
+		return service.ErrorDiscontinue
+	} else if tokenBody.IsPaused {
+		if err = task.waitResume(token, tokenBody); nil != err {
+			return err
+		}
+	}
+	tokenBody.Src = src
+	return task.token.RefreshToken(token, tokenBody)
+}
+
+// addResult 记录一项的执行结果
+func (task *BatchFile) addResult(token string, item BatchItemResult) error {
+	tokenBody := new(BatchFileTokenObject)
+	err := task.getTokenObject(token, tokenBody)
+	if nil != err {
+		return err
+	}
+	tokenBody.Results = append(tokenBody.Results, item)
+	if err := task.token.RefreshToken(token, tokenBody); nil != err {
+		logs.Errorln(err)
+	}
+	task.sig.Notify(token)
+	return nil
+}
+
+// Status 查询动作状态, 在内部返回数据
+func (task *BatchFile) Status(w http.ResponseWriter, r *http.Request) {
+	qToken := r.FormValue("token")
+	qOperation := r.FormValue("operation")
+	tokenBody := new(BatchFileTokenObject)
+	if tokenErr := task.getTokenObject(qToken, tokenBody); nil != tokenErr {
+		serviceutil.SendBadRequest(w, service.ErrorOprationExpires.Error())
+		return
+	}
+	task.token.RefreshToken(qToken, tokenBody)
+	// 用于获取令牌信息
+	if len(qOperation) == 0 {
+		if p, ok := task.progress.Load(qToken); ok {
+			tokenBody.Progress = p.(*TaskProgress).Snapshot()
+		}
+		serviceutil.SendSuccess(w, tokenBody)
+
+		// 用于操作|中断
+	} else {
+		switch qOperation {
+		// 在当前文件处理完后暂停
+		case "pause":
+			if tokenBody.IsComplete {
+				serviceutil.SendBadRequest(w, service.ErrorOprationFailed.Error())
+				return
+			}
+			tokenBody.IsPaused = true
+		// 从暂停处继续
+		case "resume":
+			tokenBody.IsPaused = false
+		// 立即中断操作
+		case "discontinue":
+			tokenBody.ErrorString = ""
+			tokenBody.IsComplete = true
+			tokenBody.IsDiscontinue = true
+		default:
+			serviceutil.SendBadRequest(w, service.ErrorOprationFailed.Error())
+			return
+		}
+		if err := task.token.RefreshToken(qToken, tokenBody); nil != err {
+			serviceutil.SendServerError(w, err.Error())
+		} else {
+			task.sig.Notify(qToken)
+			serviceutil.SendSuccess(w, "")
+		}
+	}
+}
+
+// Snapshot 获取任务当前状态和事件类型, 用于推送进度, 批量操作不会有冲突事件
+func (task *BatchFile) Snapshot(token string) (string, interface{}, error) {
+	tokenBody := new(BatchFileTokenObject)
+	err := task.getTokenObject(token, tokenBody)
+	if nil != err {
+		return "", nil, err
+	}
+	if p, ok := task.progress.Load(token); ok {
+		tokenBody.Progress = p.(*TaskProgress).Snapshot()
+	}
+	if tokenBody.IsComplete {
+		return service.TaskEvent_Complete, tokenBody, nil
+	}
+	return service.TaskEvent_Progress, tokenBody, nil
+}
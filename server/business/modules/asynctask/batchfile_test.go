@@ -0,0 +1,87 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package asynctask
+
+import (
+	"fileservice/business/service"
+	"testing"
+)
+
+func testBatchFile(t *testing.T, m *AsyncTask, fd service.FileDatas) {
+	task := m.actions["BatchFile"].(*BatchFile)
+	var token string
+	var hook func(src string) error
+	task.del.fm = &hookDatas{FileDatas: fd, onDelete: func(src string) error { return hook(src) }}
+	hook = func(src string) error { return nil }
+
+	// 逐项结果: 不存在的项记为失败, 其他项照常执行
+	token = beginTask(t, task.taskBase, task.Name(), &BatchFileTokenObject{Op: service.BatchOp_Delete})
+	task.doTask(token, "user01", service.BatchOp_Delete, []string{"/x/1.txt", "/x/none.txt", "/x/2.txt"}, nil, "", false)
+	tokenBody := new(BatchFileTokenObject)
+	if err := task.getTokenObject(token, tokenBody); nil != err {
+		t.Fatal(err)
+	}
+	if !tokenBody.IsComplete || tokenBody.IsDiscontinue || tokenBody.ErrorString != "1 of 3 items failed" || len(tokenBody.Results) != 3 {
+		t.Fatal(tokenBody.ToJSON())
+	}
+	for i, path := range []string{"/x/1.txt", "/x/none.txt", "/x/2.txt"} {
+		if item := tokenBody.Results[i]; item.Path != path || (i == 1) != (len(item.ErrorString) > 0) {
+			t.Fatal(i, item)
+		}
+	}
+	if tokenBody.Results[1].ErrorString != "/x/none.txt: "+service.ErrorFileNotExist.Error() {
+		t.Fatal(tokenBody.Results[1])
+	}
+	if fd.IsExist("/x/1.txt") || fd.IsExist("/x/2.txt") {
+		t.Fatal("expected /x/1.txt and /x/2.txt deleted")
+	}
+	if info, _ := m.ts.QueryTask(token); info.State != service.TaskState_Failed || info.Result != tokenBody.ErrorString {
+		t.Fatal(info)
+	}
+
+	// 重命名的结果带新名字
+	token = beginTask(t, task.taskBase, task.Name(), &BatchFileTokenObject{Op: service.BatchOp_Rename})
+	task.doTask(token, "user01", service.BatchOp_Rename, []string{"/r/1.txt"}, []string{"one.txt"}, "", false)
+	if err := task.getTokenObject(token, tokenBody); nil != err {
+		t.Fatal(err)
+	}
+	if len(tokenBody.ErrorString) > 0 || len(tokenBody.Results) != 1 || tokenBody.Results[0] != (BatchItemResult{Path: "/r/1.txt", Dst: "one.txt"}) {
+		t.Fatal(tokenBody.ToJSON())
+	}
+	if !fd.IsFile("/r/one.txt") {
+		t.Fatal("expected /r/one.txt")
+	}
+	if info, _ := m.ts.QueryTask(token); info.State != service.TaskState_Completed {
+		t.Fatal(info)
+	}
+
+	// 中断: 已执行的项保留结果, 包括中断时正在执行的项, 剩余项不再处理
+	hook = func(src string) error {
+		if src == "/y/2.txt" {
+			return discontinue(task.taskBase, token, new(BatchFileTokenObject))
+		}
+		return nil
+	}
+	token = beginTask(t, task.taskBase, task.Name(), &BatchFileTokenObject{Op: service.BatchOp_Delete})
+	task.doTask(token, "user01", service.BatchOp_Delete, []string{"/y/1.txt", "/y/2.txt", "/y/3.txt"}, nil, "", false)
+	if err := task.getTokenObject(token, tokenBody); nil != err {
+		t.Fatal(err)
+	}
+	if !tokenBody.IsComplete || !tokenBody.IsDiscontinue || len(tokenBody.Results) != 2 ||
+		tokenBody.Results[0] != (BatchItemResult{Path: "/y/1.txt"}) || tokenBody.Results[1] != (BatchItemResult{Path: "/y/2.txt"}) {
+		t.Fatal(tokenBody.ToJSON())
+	}
+	if fd.IsExist("/y/2.txt") || !fd.IsFile("/y/3.txt") {
+		t.Fatal("expected /y/3.txt kept after discontinue")
+	}
+	if info, _ := m.ts.QueryTask(token); info.State != service.TaskState_Discontinued {
+		t.Fatal(info)
+	}
+}
@@ -16,6 +16,8 @@ import (
 	"errors"
 	"fileservice/business/service"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/wup364/pakku/ipakku"
@@ -27,17 +29,19 @@ import (
 
 // CopyFileTokenObject 复制文件Token保存对象
 type CopyFileTokenObject struct {
-	ErrorString   string // 错误信息
-	Src           string // 当前正在处理的源路径
-	Dst           string // 当前正在处理的目标路径
-	IsSrcExist    bool   // 源路径是否存在
-	IsDstExist    bool   // 目标路径是否存在
-	IsReplace     bool   // 是否替换, 单次中断执行指令, 读取后设为false
-	IsReplaceAll  bool   // 是否替换, 单次API执行指令, 设置后后续中断时自动替换
-	IsIgnore      bool   // 是否忽略错误, 单次中断执行指令, 读取后设为false
-	IsIgnoreAll   bool   // 是否忽略错误, 单次API执行指令, 设置后后续中断时自动替换
-	IsComplete    bool   // 是否执行完毕
-	IsDiscontinue bool   // 是否已中断操作
+	ErrorString   string           // 错误信息
+	Src           string           // 当前正在处理的源路径
+	Dst           string           // 当前正在处理的目标路径
+	IsSrcExist    bool             // 源路径是否存在
+	IsDstExist    bool             // 目标路径是否存在
+	IsReplace     bool             // 是否替换, 单次中断执行指令, 读取后设为false
+	IsReplaceAll  bool             // 是否替换, 单次API执行指令, 设置后后续中断时自动替换
+	IsIgnore      bool             // 是否忽略错误, 单次中断执行指令, 读取后设为false
+	IsIgnoreAll   bool             // 是否忽略错误, 单次API执行指令, 设置后后续中断时自动替换
+	IsComplete    bool             // 是否执行完毕
+	IsDiscontinue bool             // 是否已中断操作
+	IsPaused      bool             // 是否已暂停, 在文件边界生效, 恢复后从暂停处继续
+	Progress      *TaskProgressDto // 进度
 }
 
 // Clone 本地缓存拷贝接口
@@ -54,6 +58,8 @@ func (dto *CopyFileTokenObject) Clone(val interface{}) error {
 		tmp.IsIgnoreAll = dto.IsIgnoreAll
 		tmp.IsComplete = dto.IsComplete
 		tmp.IsDiscontinue = dto.IsDiscontinue
+		tmp.IsPaused = dto.IsPaused
+		tmp.Progress = dto.Progress
 	}
 	return nil
 }
@@ -67,17 +73,22 @@ func (dto *CopyFileTokenObject) ToJSON() string {
 	}
 }
 
+// TaskState 是否已暂停|中断
+func (dto *CopyFileTokenObject) TaskState() (paused, discontinued bool) {
+	return dto.IsPaused, dto.IsDiscontinue
+}
+
 // CopyFile 复制文件|文件夹
 type CopyFile struct {
-	c     ipakku.AppCache             `@autowired:"AppCache"`
-	fm    service.FileDatas           `@autowired:"FileDatas"`
-	sg    service.UserAuth4Rpc        `@autowired:"User4RPC"`
-	pmc   service.FilePermissionCheck `@autowired:"FilePermission"`
-	token *TaskToken
+	*taskBase
+	fm       service.FileDatas           `@autowired:"FileDatas"`
+	pmc      service.FilePermissionCheck `@autowired:"FilePermission"`
+	qc       service.FileQuotaCheck      `@autowired:"FileQuota"`
+	progress sync.Map                    // 执行中任务的进度, token -> *TaskProgress
 }
 
 // Name 动作名字
-func (task CopyFile) Name() string {
+func (task *CopyFile) Name() string {
 	return "CopyFile"
 }
 
@@ -86,7 +97,6 @@ func (task *CopyFile) Init(mctx ipakku.Loader) service.AsyncTaskExecI {
 	if err := mctx.AutoWired(task); nil != err {
 		logs.Panicln(err)
 	}
-	task.token = NewTaskToken(task.c)
 	return task
 }
 
@@ -125,124 +135,201 @@ func (task *CopyFile) Execute(r *http.Request) (string, error) {
 	if nil != err {
 		return "", err
 	}
-	go func(token string) {
-		// 这里面已经不属于一个会话, 使用令牌保存数据
-		copyDirErr := task.doCopy(qSrcPath, qDstPath, qReplace, qIgnore, func(s_src, s_dst string, copyErr *CopyError) error {
-			// 获取令牌数据, 不存在则说明已经销毁
-			var tokenBody *CopyFileTokenObject
-			if tokenObj, err := task.getTokenObject(token); nil != err {
-				return err
-			} else if tokenObj.IsDiscontinue {
-				return service.ErrorDiscontinue
-			} else {
-				tokenBody = tokenObj
-				tokenBody.IsIgnore = false
-				tokenBody.IsReplace = false
-				tokenBody.IsSrcExist = false
-				tokenBody.IsDstExist = false
-				tokenBody.ErrorString = ""
-				tokenBody.Src = s_src
-				tokenBody.Dst = s_dst
-				// 更新缓存
-				if err := task.token.RefreshToken(token, tokenBody); nil != err {
-					logs.Errorln(err)
+	params := map[string]string{
+		"srcPath": qSrcPath,
+		"dstPath": qDstPath,
+		"replace": strconv.FormatBool(qReplace),
+		"ignore":  strconv.FormatBool(qIgnore),
+	}
+	if err := task.tr.Begin(token, userID, task.Name(), params); nil != err {
+		task.token.DestroyToken(token, true)
+		return "", err
+	}
+	task.sched.Go(token, userID, func() int64 {
+		_, bytes := countTree(task.fm, qSrcPath)
+		return bytes
+	}, func() error {
+		return task.keepQueued(token, new(CopyFileTokenObject))
+	}, func() {
+		task.doTask(token, userID, qSrcPath, qDstPath, qReplace, qIgnore, false)
+	})
+	return token, nil
+}
+
+// Resume 重启后继续执行中断的任务, 目标位置已存在且大小一致的文件视为中断前已复制完成, 不再重复复制
+func (task *CopyFile) Resume(info service.TaskInfo) error {
+	qSrcPath := info.Params["srcPath"]
+	qDstPath := info.Params["dstPath"]
+	qReplace := strutil.String2Bool(info.Params["replace"])
+	qIgnore := strutil.String2Bool(info.Params["ignore"])
+	if err := task.token.RefreshToken(info.TaskID, &CopyFileTokenObject{
+		Src:          qSrcPath,
+		Dst:          qDstPath,
+		IsSrcExist:   true,
+		IsReplaceAll: qReplace,
+		IsIgnoreAll:  qIgnore,
+		IsPaused:     info.State == service.TaskState_Paused,
+	}); nil != err {
+		return err
+	}
+	go func() {
+		// 重启前已暂停的任务先等待恢复, 再重新排队
+		if info.State == service.TaskState_Paused {
+			if err := task.waitResume(info.TaskID, new(CopyFileTokenObject)); nil != err {
+				task.tr.Finish(info.TaskID, err)
+				return
+			}
+		}
+		task.sched.Go(info.TaskID, info.UserID, func() int64 {
+			_, bytes := countTree(task.fm, qSrcPath)
+			return bytes
+		}, func() error {
+			return task.keepQueued(info.TaskID, new(CopyFileTokenObject))
+		}, func() {
+			task.doTask(info.TaskID, info.UserID, qSrcPath, qDstPath, qReplace, qIgnore, true)
+		})
+	}()
+	return nil
+}
+
+// doTask 在后台执行任务, 结束后登记结果, resume为true时跳过中断前已复制完成的文件
+func (task *CopyFile) doTask(token, userID, qSrcPath, qDstPath string, qReplace, qIgnore, resume bool) {
+	// 这里面已经不属于一个会话, 使用令牌保存数据
+	p := NewTaskProgress(task.fm, qSrcPath)
+	task.progress.Store(token, p)
+	defer task.progress.Delete(token)
+	copyDirErr := task.doCopy(p, qSrcPath, qDstPath, qReplace, qIgnore, resume, func(s_src, s_dst string, copyErr *CopyError) error {
+		// 获取令牌数据, 不存在则说明已经销毁
+		tokenBody := new(CopyFileTokenObject)
+		if err := task.getTokenObject(token, tokenBody); nil != err {
+			return err
+		} else if tokenBody.IsDiscontinue {
+			return service.ErrorDiscontinue
+		} else {
+			// 暂停时在这里等待, 已处理的文件不会重复处理
+			if tokenBody.IsPaused {
+				if err = task.waitResume(token, tokenBody); nil != err {
+					return err
 				}
 			}
-			// 如果遇到错误了
-			if nil != copyErr {
-				// 判断是否是目标位置已经存在的错误, 如果是的话需要选择是否覆盖他
-				if copyErr.DstIsExist {
-					// 查找之前是否设置了 替换全部错误
-					if tokenBody.IsReplaceAll {
-						// 先删除然后再替换, 如果覆盖操作没有出现问题
-						if reCopyErr := task.doCopy(s_src, s_dst, true, false, nil); nil == reCopyErr {
-							return nil
-						} else {
-							tokenBody.ErrorString = reCopyErr.Error()
-						}
-					}
-					// 如果设置了自动覆盖, 但是任然出错, 则判断是否忽略错误选项
-					if tokenBody.IsIgnoreAll {
-						tokenBody.ErrorString = ""
-						return nil // 跳过这个文件
-					}
-				} else {
-					// 不是路径重复类错误
-					// 如果是其他错误就不管了, 暂时无法处理只能选择 忽略|暂停
-					// 查找之前是否设置了 忽略全部错误
-					if tokenBody.IsIgnoreAll {
-						return nil // 跳过这个文件
+			tokenBody.IsIgnore = false
+			tokenBody.IsReplace = false
+			tokenBody.IsSrcExist = false
+			tokenBody.IsDstExist = false
+			tokenBody.ErrorString = ""
+			tokenBody.Src = s_src
+			tokenBody.Dst = s_dst
+			// 更新缓存
+			if err := task.token.RefreshToken(token, tokenBody); nil != err {
+				logs.Errorln(err)
+			}
+		}
+		// 如果遇到错误了
+		if nil != copyErr {
+			// 判断是否是目标位置已经存在的错误, 如果是的话需要选择是否覆盖他
+			if copyErr.DstIsExist {
+				// 查找之前是否设置了 替换全部错误
+				if tokenBody.IsReplaceAll {
+					// 先删除然后再替换, 如果覆盖操作没有出现问题
+					if reCopyErr := task.doCopy(p, s_src, s_dst, true, false, false, nil); nil == reCopyErr {
+						return nil
+					} else {
+						tokenBody.ErrorString = reCopyErr.Error()
 					}
 				}
+				// 如果设置了自动覆盖, 但是任然出错, 则判断是否忽略错误选项
+				if tokenBody.IsIgnoreAll {
+					tokenBody.ErrorString = ""
+					p.Skip(task.fm, s_src)
+					return nil // 跳过这个文件
+				}
+			} else {
+				// 不是路径重复类错误
+				// 如果是其他错误就不管了, 暂时无法处理只能选择 忽略|暂停
+				// 查找之前是否设置了 忽略全部错误
+				if tokenBody.IsIgnoreAll {
+					p.Skip(task.fm, s_src)
+					return nil // 跳过这个文件
+				}
+			}
 
-				// 到此说明 没有设置自动覆盖和自动忽略
-				tokenBody.IsSrcExist = copyErr.SrcIsExist
-				tokenBody.IsDstExist = copyErr.DstIsExist
-				// 设置错误, 等待客户端获取, 等待操作
-				if len(tokenBody.ErrorString) == 0 {
-					tokenBody.ErrorString = copyErr.ErrorString
+			// 到此说明 没有设置自动覆盖和自动忽略
+			tokenBody.IsSrcExist = copyErr.SrcIsExist
+			tokenBody.IsDstExist = copyErr.DstIsExist
+			// 设置错误, 等待客户端获取, 等待操作
+			if len(tokenBody.ErrorString) == 0 {
+				tokenBody.ErrorString = copyErr.ErrorString
+			}
+			// 更新缓存-抛出错误
+			if err := task.token.RefreshToken(token, tokenBody); nil != err {
+				logs.Errorln(err)
+			}
+			task.sig.Notify(token)
+			notify, cancel := task.sig.Subscribe(token)
+			defer cancel()
+			for {
+				// 循环读取最想指令
+				if err := task.getTokenObject(token, tokenBody); nil != err {
+					return err
+				} else if tokenBody.IsDiscontinue {
+					return service.ErrorDiscontinue
 				}
-				// 更新缓存-抛出错误
-				if err := task.token.RefreshToken(token, tokenBody); nil != err {
-					logs.Errorln(err)
+				// 选择了忽略|忽略全部
+				if tokenBody.IsIgnore || tokenBody.IsIgnoreAll {
+					p.Skip(task.fm, s_src)
+					return nil
 				}
-				for {
-					// 循环读取最想指令
-					if token, err := task.getTokenObject(token); nil != err {
-						return err
-					} else if token.IsDiscontinue {
-						return service.ErrorDiscontinue
-					} else {
-						tokenBody = token
-					}
-					// 选择了忽略|忽略全部
-					if tokenBody.IsIgnore || tokenBody.IsIgnoreAll {
-						return nil
-					}
-					// 选择了覆盖|覆盖全部
-					if tokenBody.IsReplace || tokenBody.IsReplaceAll {
-						if copyErr.SrcIsExist {
-							// 先删除然后再替换
-							if reCopyErr := task.doCopy(s_src, s_dst, true, false, nil); nil == reCopyErr {
-								return nil
-							} else {
-								tokenBody.ErrorString = reCopyErr.Error()
-							}
+				// 选择了覆盖|覆盖全部
+				if tokenBody.IsReplace || tokenBody.IsReplaceAll {
+					if copyErr.SrcIsExist {
+						// 先删除然后再替换
+						if reCopyErr := task.doCopy(p, s_src, s_dst, true, false, false, nil); nil == reCopyErr {
+							return nil
 						} else {
-							tokenBody.ErrorString = fileutil.PathNotExist("replace", s_src).Error()
-						}
-						// 更新缓存-抛出错误
-						if err := task.token.RefreshToken(token, tokenBody); nil != err {
-							logs.Errorln(err)
+							tokenBody.ErrorString = reCopyErr.Error()
 						}
+					} else {
+						tokenBody.ErrorString = fileutil.PathNotExist("replace", s_src).Error()
+					}
+					// 更新缓存-抛出错误
+					if err := task.token.RefreshToken(token, tokenBody); nil != err {
+						logs.Errorln(err)
 					}
-					time.Sleep(time.Duration(100) * time.Millisecond) // 休眠100ms
+				}
+				// 等待客户端的指令, 同时定时检查令牌, 兼容多个实例共享缓存的情况
+				select {
+				case <-notify:
+				case <-time.After(time.Second):
 				}
 			}
-			return nil
-		})
-		// 到这里如果没有错误就是成功了
-		if tokenBody, err := task.getTokenObject(token); nil == err {
-			if nil != copyDirErr {
-				tokenBody.ErrorString = copyDirErr.Error()
-				logs.Errorln(copyDirErr)
-			} else {
-				tokenBody.ErrorString = ""
-			}
-			tokenBody.IsComplete = true
-			tokenBody.IsDiscontinue = service.ErrorDiscontinue.Error() == tokenBody.ErrorString
-			if err := task.token.RefreshToken(token, tokenBody); nil != err {
-				logs.Errorln(err)
-			}
+		}
+		return nil
+	})
+	// 到这里如果没有错误就是成功了
+	tokenBody := new(CopyFileTokenObject)
+	if err := task.getTokenObject(token, tokenBody); nil == err {
+		if nil != copyDirErr {
+			tokenBody.ErrorString = copyDirErr.Error()
+			logs.Errorln(copyDirErr)
 		} else {
+			tokenBody.ErrorString = ""
+		}
+		tokenBody.IsComplete = true
+		tokenBody.IsDiscontinue = service.ErrorDiscontinue.Error() == tokenBody.ErrorString
+		tokenBody.Progress = p.Snapshot()
+		if err := task.token.RefreshToken(token, tokenBody); nil != err {
 			logs.Errorln(err)
 		}
-	}(token)
-	return token, nil
+		task.sig.Notify(token)
+		task.tr.Finish(token, copyDirErr)
+	} else {
+		logs.Errorln(err)
+		task.tr.Finish(token, err)
+	}
 }
 
-// doCopy 递归copy 重复策略 replace|ignore
-func (task *CopyFile) doCopy(src, dst string, replace, ignore bool, walk func(s_src, s_dst string, copyErr *CopyError) error) error {
+// doCopy 递归copy 重复策略 replace|ignore, resume为true时跳过目标位置已存在且大小一致的文件
+func (task *CopyFile) doCopy(p *TaskProgress, src, dst string, replace, ignore, resume bool, walk func(s_src, s_dst string, copyErr *CopyError) error) error {
 	doWalk := func(src, dst string, err error) error {
 		if nil == walk {
 			return err
@@ -258,12 +345,15 @@ func (task *CopyFile) doCopy(src, dst string, replace, ignore bool, walk func(s_
 		}
 	}
 	if task.fm.IsFile(src) {
+		if resume && task.isCopied(p, src, dst) {
+			return doWalk(src, dst, nil)
+		}
 		if !replace && task.fm.IsExist(dst) {
 			if err := doWalk(src, dst, fileutil.PathExist("copy", dst)); nil != err {
 				return err
 			}
 		} else {
-			if err := doWalk(src, dst, task.fm.DoCopy(src, dst, replace)); nil != err {
+			if err := doWalk(src, dst, task.copyFile(p, src, dst, replace)); nil != err {
 				return err
 			}
 		}
@@ -274,6 +364,12 @@ func (task *CopyFile) doCopy(src, dst string, replace, ignore bool, walk func(s_
 				childdst := dst + "/" + names[i]
 				dstexist := task.fm.IsExist(childdst)
 				if task.fm.IsFile(child) {
+					if resume && task.isCopied(p, child, childdst) {
+						if err := doWalk(child, childdst, nil); nil != err {
+							return err
+						}
+						continue
+					}
 					// 如果不是覆盖重复模式, 则判断是否忽略
 					if !replace && dstexist {
 						if !ignore {
@@ -281,12 +377,13 @@ func (task *CopyFile) doCopy(src, dst string, replace, ignore bool, walk func(s_
 								return err
 							}
 						} else {
+							p.Skip(task.fm, child)
 							doWalk(child, childdst, nil)
 							continue
 						}
 					} else {
 						// 目标位置不存在|目标存在但是允许覆盖
-						if err := doWalk(child, childdst, task.fm.DoCopy(child, childdst, replace)); nil != err {
+						if err := doWalk(child, childdst, task.copyFile(p, child, childdst, replace)); nil != err {
 							return err
 						}
 					}
@@ -298,6 +395,7 @@ func (task *CopyFile) doCopy(src, dst string, replace, ignore bool, walk func(s_
 								return err
 							}
 						} else {
+							p.Skip(task.fm, child)
 							doWalk(child, childdst, nil)
 							continue
 						}
@@ -307,7 +405,7 @@ func (task *CopyFile) doCopy(src, dst string, replace, ignore bool, walk func(s_
 								return err
 							}
 						}
-						if err := task.doCopy(child, childdst, replace, ignore, walk); nil != err {
+						if err := task.doCopy(p, child, childdst, replace, ignore, resume, walk); nil != err {
 							return err
 						}
 					}
@@ -321,18 +419,85 @@ func (task *CopyFile) doCopy(src, dst string, replace, ignore bool, walk func(s_
 	return nil
 }
 
+// isCopied 目标位置已存在且大小和源文件一致时视为已复制完成, 计入进度; 写入先落到临时位置再提交, 不会留下写了一半的目标文件
+func (task *CopyFile) isCopied(p *TaskProgress, src, dst string) bool {
+	if !task.fm.IsFile(dst) {
+		return false
+	}
+	size := task.fm.GetFileSize(src)
+	if size != task.fm.GetFileSize(dst) {
+		return false
+	}
+	p.AddBytes(size)
+	p.AddFiles(1)
+	return true
+}
+
+// copyFile 复制单个文件, 复制前预占配额, 完成后结算用量, 统计进度
+func (task *CopyFile) copyFile(p *TaskProgress, src, dst string, replace bool) error {
+	if !task.qc.HasQuotas() {
+		return task.streamCopy(p, src, dst, replace)
+	}
+	size, oldSize := task.fm.GetFileSize(src), int64(0)
+	if replace && task.fm.IsFile(dst) {
+		oldSize = task.fm.GetFileSize(dst)
+	}
+	rv, err := task.qc.Reserve(dst, size, oldSize)
+	if nil != err {
+		return err
+	}
+	if err = task.streamCopy(p, src, dst, replace); nil != err {
+		rv.Release()
+		return err
+	}
+	rv.Commit(size - oldSize)
+	return nil
+}
+
+// streamCopy 读取源文件写入目标位置, 读取的字节数计入进度; 目标是文件夹时交给驱动处理冲突,
+// 不覆盖时以失败策略写入, 避免检查后目标被其他请求创建而被覆盖
+func (task *CopyFile) streamCopy(p *TaskProgress, src, dst string, replace bool) error {
+	if task.fm.IsDir(dst) {
+		if err := task.fm.DoCopy(src, dst, replace); nil != err {
+			return err
+		}
+		p.AddBytes(task.fm.GetFileSize(src))
+		p.AddFiles(1)
+		return nil
+	}
+	fr, err := task.fm.DoRead(src, 0)
+	if nil != err {
+		return err
+	}
+	defer fr.Close()
+	policy := service.WriteConflict_Overwrite
+	if !replace {
+		policy = service.WriteConflict_Fail
+	}
+	cr := p.NewReader(fr)
+	if _, err := task.fm.DoWriteWithPolicy(dst, cr, policy); nil != err {
+		cr.Rollback()
+		return err
+	}
+	p.AddFiles(1)
+	return nil
+}
+
 // Status 查询动作状态, 在内部返回数据
 func (task *CopyFile) Status(w http.ResponseWriter, r *http.Request) {
 	qToken := r.FormValue("token")
 	qOperation := r.FormValue("operation")
-	tokenBody, tokenErr := task.getTokenObject(qToken)
-	if nil == tokenBody || nil != tokenErr {
+	tokenBody := new(CopyFileTokenObject)
+	if tokenErr := task.getTokenObject(qToken, tokenBody); nil != tokenErr {
 		serviceutil.SendBadRequest(w, service.ErrorOprationExpires.Error())
 		return
 	}
 	task.token.RefreshToken(qToken, tokenBody)
 	// 用于获取令牌信息
 	if len(qOperation) == 0 {
+		if p, ok := task.progress.Load(qToken); ok {
+			tokenBody.Progress = p.(*TaskProgress).Snapshot()
+		}
 		serviceutil.SendSuccess(w, tokenBody)
 
 		// 用于操作|中断
@@ -354,6 +519,16 @@ func (task *CopyFile) Status(w http.ResponseWriter, r *http.Request) {
 		case "replaceall":
 			tokenBody.ErrorString = ""
 			tokenBody.IsReplaceAll = true
+		// 在当前文件处理完后暂停
+		case "pause":
+			if tokenBody.IsComplete {
+				serviceutil.SendBadRequest(w, service.ErrorOprationFailed.Error())
+				return
+			}
+			tokenBody.IsPaused = true
+		// 从暂停处继续
+		case "resume":
+			tokenBody.IsPaused = false
 		// 立即中断操作
 		case "discontinue":
 			tokenBody.ErrorString = ""
@@ -366,26 +541,26 @@ func (task *CopyFile) Status(w http.ResponseWriter, r *http.Request) {
 		if err := task.token.RefreshToken(qToken, tokenBody); nil != err {
 			serviceutil.SendServerError(w, err.Error())
 		} else {
+			task.sig.Notify(qToken)
 			serviceutil.SendSuccess(w, "")
 		}
 	}
 }
 
-// getTokenObject 获取文件传输Token对象
-func (task *CopyFile) getTokenObject(token string) (*CopyFileTokenObject, error) {
-	var tokenBody CopyFileTokenObject
-	if err := task.token.QueryToken(token, &tokenBody); nil != err {
-		return nil, err
+// Snapshot 获取任务当前状态和事件类型, 用于推送进度
+func (task *CopyFile) Snapshot(token string) (string, interface{}, error) {
+	tokenBody := new(CopyFileTokenObject)
+	err := task.getTokenObject(token, tokenBody)
+	if nil != err {
+		return "", nil, err
 	}
-	return &tokenBody, nil
-}
-
-// GetUserID4Request 获取登录用户
-func (task *CopyFile) GetUserID4Request(r *http.Request) string {
-	if askstr := task.sg.GetAccessKey4Request(r); len(askstr) > 0 {
-		if ack, err := task.sg.GetUserAccess(askstr); nil == err {
-			return ack.UserID
-		}
+	if p, ok := task.progress.Load(token); ok {
+		tokenBody.Progress = p.(*TaskProgress).Snapshot()
+	}
+	if tokenBody.IsComplete {
+		return service.TaskEvent_Complete, tokenBody, nil
+	} else if len(tokenBody.ErrorString) > 0 {
+		return service.TaskEvent_Conflict, tokenBody, nil
 	}
-	return ""
+	return service.TaskEvent_Progress, tokenBody, nil
 }
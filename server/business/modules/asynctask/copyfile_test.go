@@ -0,0 +1,72 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package asynctask
+
+import (
+	"fileservice/business/service"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCopyFile(t *testing.T, m *AsyncTask, fd service.FileDatas) {
+	task := m.actions["CopyFile"].(*CopyFile)
+	// 中断前 /d/1.txt 已复制完成(大小一致), /d/3.txt 只写了一部分(大小不一致)
+	if err := fd.DoWrite("/d/3.txt", strings.NewReader("x")); nil != err {
+		t.Fatal(err)
+	}
+	token := beginTask(t, task.taskBase, task.Name(), new(CopyFileTokenObject))
+	if err := task.Resume(service.TaskInfo{
+		TaskID: token,
+		UserID: "user01",
+		State:  service.TaskState_Running,
+		Params: map[string]string{"srcPath": "/s", "dstPath": "/d", "replace": "true", "ignore": "false"},
+	}); nil != err {
+		t.Fatal(err)
+	}
+	var info *service.TaskInfo
+	for i := 0; i < 250; i++ {
+		if info, _ = m.ts.QueryTask(token); nil != info && info.State != service.TaskState_Running && info.State != service.TaskState_Queued {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if nil == info || info.State != service.TaskState_Completed {
+		t.Fatal(info)
+	}
+	// 已完成的文件不重复复制, 其他文件照常复制
+	for dst, expect := range map[string]string{"/d/1.txt": "/d/1.txt", "/d/2.txt": "/s/2.txt", "/d/3.txt": "/s/3.txt"} {
+		if val := readFile(t, fd, dst); val != expect {
+			t.Fatal(dst, val)
+		}
+	}
+	tokenBody := new(CopyFileTokenObject)
+	if err := task.getTokenObject(token, tokenBody); nil != err {
+		t.Fatal(err)
+	}
+	if !tokenBody.IsComplete || nil == tokenBody.Progress || tokenBody.Progress.ProcessedFiles != 3 {
+		t.Fatal(tokenBody.ToJSON())
+	}
+}
+
+// readFile 读取文件内容
+func readFile(t *testing.T, fd service.FileDatas, src string) string {
+	fr, err := fd.DoRead(src, 0)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	bs, err := io.ReadAll(fr)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
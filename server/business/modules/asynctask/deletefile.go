@@ -0,0 +1,397 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 文件异步操作, 一般用于批量操作或后台任务
+
+package asynctask
+
+import (
+	"encoding/json"
+	"errors"
+	"fileservice/business/service"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/logs"
+	"github.com/wup364/pakku/utils/serviceutil"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// DeleteFailure 删除失败并被忽略的项
+type DeleteFailure struct {
+	Path        string // 路径
+	ErrorString string // 错误信息
+}
+
+// DeleteFileTokenObject 删除文件Token保存对象
+type DeleteFileTokenObject struct {
+	ErrorString   string           // 错误信息
+	Src           string           // 当前正在处理的路径
+	Failures      []DeleteFailure  // 删除失败并被忽略的项
+	IsIgnore      bool             // 是否忽略错误, 单次中断执行指令, 读取后设为false
+	IsIgnoreAll   bool             // 是否忽略错误, 单次API执行指令, 设置后后续中断时自动忽略
+	IsComplete    bool             // 是否执行完毕
+	IsDiscontinue bool             // 是否已中断操作
+	IsPaused      bool             // 是否已暂停, 在文件边界生效, 恢复后从暂停处继续
+	Progress      *TaskProgressDto // 进度
+}
+
+// Clone 本地缓存拷贝接口
+func (dto *DeleteFileTokenObject) Clone(val interface{}) error {
+	if tmp, ok := val.(*DeleteFileTokenObject); ok {
+		tmp.ErrorString = dto.ErrorString
+		tmp.Src = dto.Src
+		tmp.Failures = append([]DeleteFailure{}, dto.Failures...)
+		tmp.IsIgnore = dto.IsIgnore
+		tmp.IsIgnoreAll = dto.IsIgnoreAll
+		tmp.IsComplete = dto.IsComplete
+		tmp.IsDiscontinue = dto.IsDiscontinue
+		tmp.IsPaused = dto.IsPaused
+		tmp.Progress = dto.Progress
+	}
+	return nil
+}
+
+// ToJSON 转传JSON
+func (dto *DeleteFileTokenObject) ToJSON() string {
+	if bt, err := json.Marshal(dto); nil != err {
+		return ""
+	} else {
+		return string(bt)
+	}
+}
+
+// TaskState 是否已暂停|中断
+func (dto *DeleteFileTokenObject) TaskState() (paused, discontinued bool) {
+	return dto.IsPaused, dto.IsDiscontinue
+}
+
+// DeleteFile 删除多个文件|文件夹, 逐个文件删除以便统计进度和中断
+type DeleteFile struct {
+	*taskBase
+	fm       service.FileDatas           `@autowired:"FileDatas"`
+	pmc      service.FilePermissionCheck `@autowired:"FilePermission"`
+	qc       service.FileQuotaCheck      `@autowired:"FileQuota"`
+	progress sync.Map                    // 执行中任务的进度, token -> *TaskProgress
+}
+
+// Name 动作名字
+func (task *DeleteFile) Name() string {
+	return "DeleteFile"
+}
+
+// Init 初始化对象
+func (task *DeleteFile) Init(mctx ipakku.Loader) service.AsyncTaskExecI {
+	if err := mctx.AutoWired(task); nil != err {
+		logs.Panicln(err)
+	}
+	return task
+}
+
+// Execute 动作执行, 返回一个tooken, path 参数可以传多个
+func (task *DeleteFile) Execute(r *http.Request) (string, error) {
+	qIgnore := strutil.String2Bool(r.FormValue("ignore"))
+	qPaths := make([]string, 0, len(r.Form["path"]))
+	for _, val := range r.Form["path"] {
+		if val = strutil.Parse2UnixPath(val); len(val) > 0 {
+			qPaths = append(qPaths, val)
+		}
+	}
+	if len(qPaths) == 0 {
+		return "", errors.New("path parameter not found")
+	}
+	// 执行前检查全部路径
+	userID := task.GetUserID4Request(r)
+	for _, val := range qPaths {
+		if !task.pmc.HashPermission(userID, val, service.FPM_Write) {
+			return "", service.ErrorPermissionInsufficient
+		}
+		if !task.fm.IsExist(val) {
+			return "", service.ErrorFileNotExist
+		}
+	}
+	// 异步处理, 返回一个Token用于查询进度
+	token, err := task.token.AskToken(&DeleteFileTokenObject{
+		Src:         qPaths[0],
+		IsIgnoreAll: qIgnore,
+	})
+	if nil != err {
+		return "", err
+	}
+	paths, err := json.Marshal(qPaths)
+	if nil != err {
+		return "", err
+	}
+	params := map[string]string{
+		"paths":  string(paths),
+		"ignore": strconv.FormatBool(qIgnore),
+	}
+	if err := task.tr.Begin(token, userID, task.Name(), params); nil != err {
+		task.token.DestroyToken(token, true)
+		return "", err
+	}
+	task.sched.Go(token, userID, func() int64 {
+		return task.getSize(qPaths)
+	}, func() error {
+		return task.keepQueued(token, new(DeleteFileTokenObject))
+	}, func() {
+		task.doTask(token, userID, qPaths)
+	})
+	return token, nil
+}
+
+// Resume 进程重启后继续删除剩余的路径, 已删除的路径会被跳过
+func (task *DeleteFile) Resume(info service.TaskInfo) error {
+	var qPaths []string
+	if err := json.Unmarshal([]byte(info.Params["paths"]), &qPaths); nil != err {
+		return err
+	}
+	if err := task.token.RefreshToken(info.TaskID, &DeleteFileTokenObject{
+		IsIgnoreAll: strutil.String2Bool(info.Params["ignore"]),
+		IsPaused:    info.State == service.TaskState_Paused,
+	}); nil != err {
+		return err
+	}
+	go func() {
+		// 重启前已暂停的任务先等待恢复, 再重新排队
+		if info.State == service.TaskState_Paused {
+			if err := task.waitResume(info.TaskID, new(DeleteFileTokenObject)); nil != err {
+				task.tr.Finish(info.TaskID, err)
+				return
+			}
+		}
+		task.sched.Go(info.TaskID, info.UserID, func() int64 {
+			return task.getSize(qPaths)
+		}, func() error {
+			return task.keepQueued(info.TaskID, new(DeleteFileTokenObject))
+		}, func() {
+			task.doTask(info.TaskID, info.UserID, qPaths)
+		})
+	}()
+	return nil
+}
+
+// doTask 在后台执行任务, 结束后登记结果
+func (task *DeleteFile) doTask(token, userID string, qPaths []string) {
+	p := NewTaskProgress4Paths(task.fm, qPaths)
+	task.progress.Store(token, p)
+	defer task.progress.Delete(token)
+	var delErr error
+	for i := 0; i < len(qPaths) && nil == delErr; i++ {
+		delErr = task.doDelete(p, qPaths[i], func(s_src string, deleteErr error) error {
+			// 获取令牌数据, 不存在则说明已经销毁
+			tokenBody := new(DeleteFileTokenObject)
+			if err := task.getTokenObject(token, tokenBody); nil != err {
+				return err
+			} else if tokenBody.IsDiscontinue {
+				return service.ErrorDiscontinue
+			} else {
+				// 暂停时在这里等待, 已处理的文件不会重复处理
+				if tokenBody.IsPaused {
+					if err = task.waitResume(token, tokenBody); nil != err {
+						return err
+					}
+				}
+				tokenBody.IsIgnore = false
+				tokenBody.ErrorString = ""
+				tokenBody.Src = s_src
+				if nil == deleteErr {
+					if err := task.token.RefreshToken(token, tokenBody); nil != err {
+						logs.Errorln(err)
+					}
+					return nil
+				}
+			}
+			// 查找之前是否设置了 忽略全部错误
+			if tokenBody.IsIgnoreAll {
+				return task.addFailure(p, token, tokenBody, s_src, deleteErr)
+			}
+			// 设置错误, 等待客户端获取, 等待操作
+			tokenBody.ErrorString = deleteErr.Error()
+			if err := task.token.RefreshToken(token, tokenBody); nil != err {
+				logs.Errorln(err)
+			}
+			task.sig.Notify(token)
+			notify, cancel := task.sig.Subscribe(token)
+			defer cancel()
+			for {
+				// 循环读取最新指令
+				if err := task.getTokenObject(token, tokenBody); nil != err {
+					return err
+				} else if tokenBody.IsDiscontinue {
+					return service.ErrorDiscontinue
+				}
+				// 选择了忽略|忽略全部
+				if tokenBody.IsIgnore || tokenBody.IsIgnoreAll {
+					return task.addFailure(p, token, tokenBody, s_src, deleteErr)
+				}
+				// 等待客户端的指令, 同时定时检查令牌, 兼容多个实例共享缓存的情况
+				select {
+				case <-notify:
+				case <-time.After(time.Second):
+				}
+			}
+		})
+	}
+	// 到这里如果没有错误就是成功了, 有忽略的失败项时记为失败
+	tokenBody := new(DeleteFileTokenObject)
+	if err := task.getTokenObject(token, tokenBody); nil == err {
+		if nil == delErr && len(tokenBody.Failures) > 0 {
+			delErr = fmt.Errorf("%d items failed to delete, first: %s", len(tokenBody.Failures), tokenBody.Failures[0].Path)
+		}
+		if nil != delErr {
+			tokenBody.ErrorString = delErr.Error()
+			logs.Errorln(delErr)
+		} else {
+			tokenBody.ErrorString = ""
+		}
+		tokenBody.IsComplete = true
+		tokenBody.IsDiscontinue = service.ErrorDiscontinue.Error() == tokenBody.ErrorString
+		tokenBody.Progress = p.Snapshot()
+		if err := task.token.RefreshToken(token, tokenBody); nil != err {
+			logs.Errorln(err)
+		}
+		task.sig.Notify(token)
+		task.tr.Finish(token, delErr)
+	} else {
+		logs.Errorln(err)
+		task.tr.Finish(token, err)
+	}
+}
+
+// getSize 统计多个路径的大小, 用于排队时判断是否是小任务
+func (task *DeleteFile) getSize(paths []string) int64 {
+	var size int64
+	for _, src := range paths {
+		_, bytes := countTree(task.fm, src)
+		size += bytes
+	}
+	return size
+}
+
+// addFailure 记录被忽略的失败项, 跳过的文件计入进度
+func (task *DeleteFile) addFailure(p *TaskProgress, token string, tokenBody *DeleteFileTokenObject, src string, deleteErr error) error {
+	p.Skip(task.fm, src)
+	tokenBody.ErrorString = ""
+	tokenBody.Failures = append(tokenBody.Failures, DeleteFailure{Path: src, ErrorString: deleteErr.Error()})
+	if err := task.token.RefreshToken(token, tokenBody); nil != err {
+		logs.Errorln(err)
+	}
+	return nil
+}
+
+// doDelete 递归删除, 文件夹在子项删除完后删除, 有子项被忽略时保留文件夹
+func (task *DeleteFile) doDelete(p *TaskProgress, src string, walk func(s_src string, deleteErr error) error) error {
+	if task.fm.IsFile(src) {
+		return walk(src, task.deleteFile(p, src))
+	} else if task.fm.IsDir(src) {
+		if names := task.fm.GetDirList(src, -1, -1); len(names) > 0 {
+			for i := 0; i < len(names); i++ {
+				if err := task.doDelete(p, strutil.Parse2UnixPath(src+"/"+names[i]), walk); nil != err {
+					return err
+				}
+			}
+		}
+		if len(task.fm.GetDirList(src, 1, 0)) == 0 {
+			return walk(src, task.fm.DoDelete(src))
+		}
+	}
+	// 不存在的路径视为已经删除
+	return nil
+}
+
+// deleteFile 删除单个文件, 配置了配额时归还所在路径的用量
+func (task *DeleteFile) deleteFile(p *TaskProgress, src string) error {
+	size := task.fm.GetFileSize(src)
+	if err := task.fm.DoDelete(src); nil != err {
+		return err
+	}
+	if task.qc.HasQuotas() {
+		task.qc.AddUsage(src, -size)
+	}
+	p.AddFiles(1)
+	p.AddBytes(size)
+	return nil
+}
+
+// Status 查询动作状态, 在内部返回数据
+func (task *DeleteFile) Status(w http.ResponseWriter, r *http.Request) {
+	qToken := r.FormValue("token")
+	qOperation := r.FormValue("operation")
+	tokenBody := new(DeleteFileTokenObject)
+	if tokenErr := task.getTokenObject(qToken, tokenBody); nil != tokenErr {
+		serviceutil.SendBadRequest(w, service.ErrorOprationExpires.Error())
+		return
+	}
+	task.token.RefreshToken(qToken, tokenBody)
+	// 用于获取令牌信息
+	if len(qOperation) == 0 {
+		if p, ok := task.progress.Load(qToken); ok {
+			tokenBody.Progress = p.(*TaskProgress).Snapshot()
+		}
+		serviceutil.SendSuccess(w, tokenBody)
+
+		// 用于操作|中断
+	} else {
+		switch qOperation {
+		// 忽略单个 错误
+		case "ignore":
+			tokenBody.IsIgnore = true
+		// 为后续的 错误 执行忽略
+		case "ignoreall":
+			tokenBody.IsIgnoreAll = true
+		// 在当前文件处理完后暂停
+		case "pause":
+			if tokenBody.IsComplete {
+				serviceutil.SendBadRequest(w, service.ErrorOprationFailed.Error())
+				return
+			}
+			tokenBody.IsPaused = true
+		// 从暂停处继续
+		case "resume":
+			tokenBody.IsPaused = false
+		// 立即中断操作
+		case "discontinue":
+			tokenBody.ErrorString = ""
+			tokenBody.IsComplete = true
+			tokenBody.IsDiscontinue = true
+		default:
+			serviceutil.SendBadRequest(w, service.ErrorOprationFailed.Error())
+			return
+		}
+		if err := task.token.RefreshToken(qToken, tokenBody); nil != err {
+			serviceutil.SendServerError(w, err.Error())
+		} else {
+			task.sig.Notify(qToken)
+			serviceutil.SendSuccess(w, "")
+		}
+	}
+}
+
+// Snapshot 获取任务当前状态和事件类型, 用于推送进度
+func (task *DeleteFile) Snapshot(token string) (string, interface{}, error) {
+	tokenBody := new(DeleteFileTokenObject)
+	err := task.getTokenObject(token, tokenBody)
+	if nil != err {
+		return "", nil, err
+	}
+	if p, ok := task.progress.Load(token); ok {
+		tokenBody.Progress = p.(*TaskProgress).Snapshot()
+	}
+	if tokenBody.IsComplete {
+		return service.TaskEvent_Complete, tokenBody, nil
+	} else if len(tokenBody.ErrorString) > 0 {
+		return service.TaskEvent_Conflict, tokenBody, nil
+	}
+	return service.TaskEvent_Progress, tokenBody, nil
+}
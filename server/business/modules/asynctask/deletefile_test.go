@@ -0,0 +1,164 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package asynctask
+
+import (
+	"errors"
+	"fileservice/business/modules/filedatas"
+	"fileservice/business/modules/filepermission"
+	"fileservice/business/modules/filequota"
+	"fileservice/business/modules/user4rpc"
+	"fileservice/business/service"
+	"os"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/wup364/pakku"
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/modules/appconfig"
+)
+
+// hookDatas 删除前回调, 用于模拟删除失败和执行中途中断
+type hookDatas struct {
+	service.FileDatas
+	onDelete func(src string) error
+}
+
+// DoDelete 回调返回错误时不删除
+func (fd *hookDatas) DoDelete(src string) error {
+	if err := fd.onDelete(src); nil != err {
+		return err
+	}
+	return fd.FileDatas.DoDelete(src)
+}
+
+// newTestAsyncTask 启动异步任务模块, 文件挂载到临时目录
+func newTestAsyncTask(t *testing.T, files ...string) (*AsyncTask, service.FileDatas) {
+	// 应用配置、模块版本和默认的sqlite文件都在工作目录下, 切换到临时目录使每次运行互不影响
+	wd, err := os.Getwd()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	app := pakku.NewApplication("asynctask-test").EnableCoreModule().BootStart()
+	var conf ipakku.AppConfig
+	app.GetModuleByName(new(appconfig.AppConfig).AsModule().Name, &conf)
+	conf.SetConfig(filedatas.CONFKEY_MOUNT+"./."+filedatas.CONFKEY_MOUNTTYPE, "LOCAL")
+	conf.SetConfig(filedatas.CONFKEY_MOUNT+"./."+filedatas.CONFKEY_MOUNTADDR, t.TempDir())
+	var fd service.FileDatas
+	app.LoadModule(new(filedatas.FileDatas)).GetModuleByName(new(filedatas.FileDatas).AsModule().Name, &fd)
+	app.LoadModule(new(filepermission.FilePermission))
+	app.LoadModule(new(user4rpc.User4RPC))
+	app.LoadModule(new(filequota.FileQuota))
+	m := new(AsyncTask)
+	app.LoadModule(m)
+	for _, path := range files {
+		if err = fd.DoWrite(path, strings.NewReader(path)); nil != err {
+			t.Fatal(err)
+		}
+	}
+	return m, fd
+}
+
+// beginTask 申请令牌并登记任务, 和Execute一致
+func beginTask(t *testing.T, task *taskBase, name string, tokenBody taskTokenObject) string {
+	token, err := task.token.AskToken(tokenBody)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = task.tr.Begin(token, "user01", name, nil); nil != err {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// discontinue 和Status的discontinue操作一致
+func discontinue(task *taskBase, token string, tokenBody taskTokenObject) error {
+	if err := task.getTokenObject(token, tokenBody); nil != err {
+		return err
+	}
+	switch val := tokenBody.(type) {
+	case *DeleteFileTokenObject:
+		val.IsComplete, val.IsDiscontinue = true, true
+	case *BatchFileTokenObject:
+		val.IsComplete, val.IsDiscontinue = true, true
+	}
+	return task.token.RefreshToken(token, tokenBody)
+}
+
+// TestFileTasks 缓存库注册在进程内, 模块只能启动一次, 各任务作为子测试执行
+func TestFileTasks(t *testing.T) {
+	m, fd := newTestAsyncTask(t,
+		"/a/1.txt", "/a/2.txt", "/b/3.txt", "/c/1.txt", "/c/2.txt", "/c/3.txt",
+		"/x/1.txt", "/x/2.txt", "/r/1.txt", "/y/1.txt", "/y/2.txt", "/y/3.txt",
+		"/s/1.txt", "/s/2.txt", "/s/3.txt", "/d/1.txt",
+	)
+	t.Run("DeleteFile", func(t *testing.T) { testDeleteFile(t, m, fd) })
+	t.Run("BatchFile", func(t *testing.T) { testBatchFile(t, m, fd) })
+	t.Run("CopyFile", func(t *testing.T) { testCopyFile(t, m, fd) })
+}
+
+func testDeleteFile(t *testing.T, m *AsyncTask, fd service.FileDatas) {
+	task := m.actions["DeleteFile"].(*DeleteFile)
+	var token string
+	var hook func(src string) error
+	task.fm = &hookDatas{FileDatas: fd, onDelete: func(src string) error { return hook(src) }}
+
+	// 部分失败: 忽略全部错误时记录失败项, 其他路径继续删除, 有子项失败的文件夹保留
+	hook = func(src string) error {
+		if src == "/a/2.txt" {
+			return errors.New("device busy")
+		}
+		return nil
+	}
+	token = beginTask(t, task.taskBase, task.Name(), &DeleteFileTokenObject{IsIgnoreAll: true})
+	task.doTask(token, "user01", []string{"/a", "/b"})
+	tokenBody := new(DeleteFileTokenObject)
+	if err := task.getTokenObject(token, tokenBody); nil != err {
+		t.Fatal(err)
+	}
+	if !tokenBody.IsComplete || tokenBody.IsDiscontinue || len(tokenBody.Failures) != 1 ||
+		tokenBody.Failures[0] != (DeleteFailure{Path: "/a/2.txt", ErrorString: "device busy"}) {
+		t.Fatal(tokenBody.ToJSON())
+	}
+	if fd.IsExist("/a/1.txt") || !fd.IsFile("/a/2.txt") || fd.IsExist("/b") {
+		t.Fatal("expected /a/1.txt and /b deleted, /a/2.txt kept")
+	}
+	if info, _ := m.ts.QueryTask(token); info.State != service.TaskState_Failed || !strings.Contains(info.Result, "/a/2.txt") {
+		t.Fatal(info)
+	}
+
+	// 中断: 当前文件删除后停止, 剩余路径不再处理
+	hook = func(src string) error {
+		if src == "/c/2.txt" {
+			return discontinue(task.taskBase, token, new(DeleteFileTokenObject))
+		}
+		return nil
+	}
+	token = beginTask(t, task.taskBase, task.Name(), new(DeleteFileTokenObject))
+	task.doTask(token, "user01", []string{"/c/1.txt", "/c/2.txt", "/c/3.txt"})
+	if err := task.getTokenObject(token, tokenBody); nil != err {
+		t.Fatal(err)
+	}
+	if !tokenBody.IsComplete || !tokenBody.IsDiscontinue || len(tokenBody.Failures) != 0 {
+		t.Fatal(tokenBody.ToJSON())
+	}
+	if fd.IsExist("/c/1.txt") || fd.IsExist("/c/2.txt") || !fd.IsFile("/c/3.txt") {
+		t.Fatal("expected /c/3.txt kept after discontinue")
+	}
+	if info, _ := m.ts.QueryTask(token); info.State != service.TaskState_Discontinued {
+		t.Fatal(info)
+	}
+}
@@ -0,0 +1,420 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 文件异步操作, 一般用于批量操作或后台任务
+
+package asynctask
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fileservice/business/modules/filequota"
+	"fileservice/business/service"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/logs"
+	"github.com/wup364/pakku/utils/serviceutil"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// FetchURLTokenObject 下载远程文件Token保存对象
+type FetchURLTokenObject struct {
+	ErrorString   string           // 错误信息
+	URL           string           // 远程地址
+	Dst           string           // 目标路径, 完成后为按冲突策略写入的最终路径
+	Retries       int              // 已重试次数
+	IsComplete    bool             // 是否执行完毕
+	IsDiscontinue bool             // 是否已中断操作
+	IsPaused      bool             // 是否已暂停, 暂停时断开连接, 恢复后从断点续传
+	Progress      *TaskProgressDto // 进度
+}
+
+// Clone 本地缓存拷贝接口
+func (dto *FetchURLTokenObject) Clone(val interface{}) error {
+	if tmp, ok := val.(*FetchURLTokenObject); ok {
+		tmp.ErrorString = dto.ErrorString
+		tmp.URL = dto.URL
+		tmp.Dst = dto.Dst
+		tmp.Retries = dto.Retries
+		tmp.IsComplete = dto.IsComplete
+		tmp.IsDiscontinue = dto.IsDiscontinue
+		tmp.IsPaused = dto.IsPaused
+		tmp.Progress = dto.Progress
+	}
+	return nil
+}
+
+// ToJSON 转传JSON
+func (dto *FetchURLTokenObject) ToJSON() string {
+	if bt, err := json.Marshal(dto); nil != err {
+		return ""
+	} else {
+		return string(bt)
+	}
+}
+
+// TaskState 是否已暂停|中断
+func (dto *FetchURLTokenObject) TaskState() (paused, discontinued bool) {
+	return dto.IsPaused, dto.IsDiscontinue
+}
+
+// FetchURL 下载远程http(s)文件到文件夹
+type FetchURL struct {
+	*taskBase
+	conf     ipakku.AppConfig            `@autowired:"AppConfig"`
+	fm       service.FileDatas           `@autowired:"FileDatas"`
+	pmc      service.FilePermissionCheck `@autowired:"FilePermission"`
+	qc       service.FileQuotaCheck      `@autowired:"FileQuota"`
+	hf       *HostFilter
+	uf       *URLFetcher
+	maxSize  int64    // 允许下载的最大字节数, <=0为不限制
+	progress sync.Map // 执行中任务的进度, token -> *TaskProgress
+}
+
+// Name 动作名字
+func (task *FetchURL) Name() string {
+	return "FetchURL"
+}
+
+// Init 初始化对象
+func (task *FetchURL) Init(mctx ipakku.Loader) service.AsyncTaskExecI {
+	if err := mctx.AutoWired(task); nil != err {
+		logs.Panicln(err)
+	}
+	hf, err := NewHostFilter(
+		task.conf.GetConfig("asynctask.fetchurl.allowhosts").ToString(""),
+		task.conf.GetConfig("asynctask.fetchurl.denyhosts").ToString(""),
+	)
+	if nil != err {
+		logs.Panicln(err)
+	}
+	task.hf = hf
+	task.maxSize = service.GetInt64Config(task.conf, "asynctask.fetchurl.maxsize", 0)
+	task.uf = NewURLFetcher(hf,
+		time.Duration(service.GetInt64Config(task.conf, "asynctask.fetchurl.timeout", 30))*time.Second,
+		int(service.GetInt64Config(task.conf, "asynctask.fetchurl.retries", 3)),
+	)
+	return task
+}
+
+// Execute 动作执行, 返回一个tooken
+// url: 远程地址, dstPath: 目标文件夹, name: 保存的文件名, 默认取地址中的文件名
+// conflict: 目标已存在时 overwrite|fail|rename, 默认rename; maxsize: 最大字节数, 不能超过配置的上限
+func (task *FetchURL) Execute(r *http.Request) (string, error) {
+	qURL := r.FormValue("url")
+	qDstPath := strutil.Parse2UnixPath(r.FormValue("dstPath"))
+	qConflict := r.FormValue("conflict")
+	if len(qURL) == 0 {
+		return "", errors.New("url parameter not found")
+	}
+	if len(qDstPath) == 0 {
+		return "", errors.New("dstPath parameter not found")
+	}
+	if len(qConflict) == 0 {
+		qConflict = service.WriteConflict_Rename
+	} else if !service.IsWriteConflictPolicy(qConflict) {
+		return "", service.ErrorConflictPolicyNotSupport
+	}
+	maxSize := task.maxSize
+	if qMaxSize := r.FormValue("maxsize"); len(qMaxSize) > 0 {
+		size, err := strconv.ParseInt(qMaxSize, 10, 64)
+		if nil != err || size <= 0 {
+			return "", errors.New("maxsize is not a valid number")
+		}
+		if maxSize <= 0 || size < maxSize {
+			maxSize = size
+		}
+	}
+	u, err := task.checkURL(qURL)
+	if nil != err {
+		return "", err
+	}
+	qName := path.Base(r.FormValue("name"))
+	if qName == "." || qName == "/" {
+		qName = path.Base(u.Path)
+	}
+	if qName == "." || qName == "/" || qName == ".." {
+		qName = "download"
+	}
+	dst := strutil.Parse2UnixPath(qDstPath + "/" + qName)
+	userID := task.GetUserID4Request(r)
+	if !task.pmc.HashPermission(userID, dst, service.FPM_Write) {
+		return "", service.ErrorPermissionInsufficient
+	}
+	if !task.fm.IsDir(qDstPath) {
+		return "", service.ErrorParentFolderNotExist
+	}
+	// 异步处理, 返回一个Token用于查询进度
+	token, err := task.token.AskToken(&FetchURLTokenObject{URL: qURL, Dst: dst})
+	if nil != err {
+		return "", err
+	}
+	params := map[string]string{
+		"url":      qURL,
+		"dstPath":  qDstPath,
+		"name":     qName,
+		"conflict": qConflict,
+		"maxsize":  strconv.FormatInt(maxSize, 10),
+	}
+	if err := task.tr.Begin(token, userID, task.Name(), params); nil != err {
+		task.token.DestroyToken(token, true)
+		return "", err
+	}
+	task.schedule(token, userID, params)
+	return token, nil
+}
+
+// Resume 进程重启后重新下载, 重启前写入的临时数据已经丢失
+func (task *FetchURL) Resume(info service.TaskInfo) error {
+	if err := task.token.RefreshToken(info.TaskID, &FetchURLTokenObject{
+		URL:      info.Params["url"],
+		Dst:      strutil.Parse2UnixPath(info.Params["dstPath"] + "/" + info.Params["name"]),
+		IsPaused: info.State == service.TaskState_Paused,
+	}); nil != err {
+		return err
+	}
+	go func() {
+		// 重启前已暂停的任务先等待恢复, 再重新排队
+		if info.State == service.TaskState_Paused {
+			if err := task.waitResume(info.TaskID, new(FetchURLTokenObject)); nil != err {
+				task.tr.Finish(info.TaskID, err)
+				return
+			}
+		}
+		task.schedule(info.TaskID, info.UserID, info.Params)
+	}()
+	return nil
+}
+
+// schedule 排队执行, 远程文件大小未知, 按大任务排队
+func (task *FetchURL) schedule(token, userID string, params map[string]string) {
+	task.sched.Go(token, userID, func() int64 {
+		return math.MaxInt64
+	}, func() error {
+		return task.keepQueued(token, new(FetchURLTokenObject))
+	}, func() {
+		task.doTask(token, userID, params)
+	})
+}
+
+// checkURL 校验地址的协议和主机, 连接时还会校验解析后的IP
+func (task *FetchURL) checkURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if nil != err {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, service.ErrorURLNotSupport
+	}
+	named, err := task.hf.CheckName(u.Hostname())
+	if nil != err {
+		return nil, err
+	}
+	if ip := net.ParseIP(u.Hostname()); nil != ip {
+		if err := task.hf.CheckIP(ip, named); nil != err {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// doTask 在后台执行任务, 结束后登记结果
+func (task *FetchURL) doTask(token, userID string, params map[string]string) {
+	p := NewTaskProgress4Stream()
+	task.progress.Store(token, p)
+	defer task.progress.Delete(token)
+	dst, fetchErr := task.doFetch(p, token, userID, params)
+	tokenBody := new(FetchURLTokenObject)
+	if err := task.getTokenObject(token, tokenBody); nil == err {
+		if nil != fetchErr {
+			tokenBody.ErrorString = fetchErr.Error()
+			logs.Errorln(fetchErr)
+		} else {
+			tokenBody.ErrorString = ""
+			tokenBody.Dst = dst
+		}
+		tokenBody.IsComplete = true
+		tokenBody.IsDiscontinue = service.ErrorDiscontinue.Error() == tokenBody.ErrorString
+		tokenBody.Progress = p.Snapshot()
+		if err := task.token.RefreshToken(token, tokenBody); nil != err {
+			logs.Errorln(err)
+		}
+		task.sig.Notify(token)
+		task.tr.Finish(token, fetchErr)
+	} else {
+		logs.Errorln(err)
+		task.tr.Finish(token, err)
+	}
+}
+
+// doFetch 下载并写入目标位置, 返回最终路径; 大小已知时写入前预占配额, 未知时边下载边预占
+func (task *FetchURL) doFetch(p *TaskProgress, token, userID string, params map[string]string) (string, error) {
+	maxSize, _ := strconv.ParseInt(params["maxsize"], 10, 64)
+	dst := strutil.Parse2UnixPath(params["dstPath"] + "/" + params["name"])
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fr, err := task.uf.Open(ctx, params["url"], maxSize)
+	if nil != err {
+		return "", err
+	}
+	defer fr.Close()
+	p.SetTotalBytes(fr.Size())
+	oldSize := int64(0)
+	if params["conflict"] == service.WriteConflict_Overwrite && task.fm.IsFile(dst) {
+		oldSize = task.fm.GetFileSize(dst)
+	}
+	// 配置了配额时边读取边预占, 没有声明大小的响应同样受配额限制
+	var rv service.QuotaReservation
+	var r io.Reader = fr
+	if task.qc.HasQuotas() {
+		size := fr.Size()
+		if size < 0 {
+			size = 0
+		}
+		if rv, err = task.qc.Reserve(dst, size, oldSize); nil != err {
+			return "", err
+		}
+		r = filequota.NewReserveReader(r, rv)
+	}
+	reader := &fetchControlReader{task: task, token: token, fr: fr, r: p.NewReader(r), last: time.Now()}
+	if dst, err = task.fm.DoWriteWithPolicy(dst, reader, params["conflict"]); nil != err {
+		if nil != rv {
+			rv.Release()
+		}
+		// 驱动会重新包装错误, 使用读取时的错误
+		if nil != reader.err {
+			return "", reader.err
+		}
+		return "", err
+	}
+	if nil != rv {
+		rv.Commit(task.fm.GetFileSize(dst) - oldSize)
+	}
+	p.SetTotalBytes(task.fm.GetFileSize(dst))
+	p.AddFiles(1)
+	return dst, nil
+}
+
+// fetchControlReader 下载流, 每秒检查一次令牌, 处理暂停和中断
+type fetchControlReader struct {
+	task  *FetchURL
+	token string
+	fr    *FetchReader
+	r     io.Reader
+	last  time.Time
+	err   error // 读取时的错误(不含EOF)
+}
+
+// Read 读取数据
+func (cr *fetchControlReader) Read(b []byte) (n int, err error) {
+	if time.Since(cr.last) >= time.Second {
+		cr.last = time.Now()
+		err = cr.task.checkToken(cr.token, cr.fr)
+	}
+	if nil == err {
+		n, err = cr.r.Read(b)
+	}
+	if nil != err && err != io.EOF {
+		cr.err = err
+	}
+	return n, err
+}
+
+// checkToken 检查是否中断或暂停, 暂停时断开连接等待恢复, 同时保持令牌不过期
+func (task *FetchURL) checkToken(token string, fr *FetchReader) error {
+	tokenBody := new(FetchURLTokenObject)
+	err := task.getTokenObject(token, tokenBody)
+	if nil != err {
+		return err
+	} else if tokenBody.IsDiscontinue {
+		return service.ErrorDiscontinue
+	} else if tokenBody.IsPaused {
+		// 等待期间断开远程连接, 恢复后从断点续传
+		fr.Suspend()
+		if err = task.waitResume(token, tokenBody); nil != err {
+			return err
+		}
+	}
+	tokenBody.Retries = fr.Retries()
+	return task.token.RefreshToken(token, tokenBody)
+}
+
+// Status 查询动作状态, 在内部返回数据
+func (task *FetchURL) Status(w http.ResponseWriter, r *http.Request) {
+	qToken := r.FormValue("token")
+	qOperation := r.FormValue("operation")
+	tokenBody := new(FetchURLTokenObject)
+	if tokenErr := task.getTokenObject(qToken, tokenBody); nil != tokenErr {
+		serviceutil.SendBadRequest(w, service.ErrorOprationExpires.Error())
+		return
+	}
+	task.token.RefreshToken(qToken, tokenBody)
+	// 用于获取令牌信息
+	if len(qOperation) == 0 {
+		if p, ok := task.progress.Load(qToken); ok {
+			tokenBody.Progress = p.(*TaskProgress).Snapshot()
+		}
+		serviceutil.SendSuccess(w, tokenBody)
+
+		// 用于操作|中断
+	} else {
+		switch qOperation {
+		// 暂停下载, 断开连接
+		case "pause":
+			if tokenBody.IsComplete {
+				serviceutil.SendBadRequest(w, service.ErrorOprationFailed.Error())
+				return
+			}
+			tokenBody.IsPaused = true
+		// 从断点继续
+		case "resume":
+			tokenBody.IsPaused = false
+		// 立即中断操作
+		case "discontinue":
+			tokenBody.ErrorString = ""
+			tokenBody.IsComplete = true
+			tokenBody.IsDiscontinue = true
+		default:
+			serviceutil.SendBadRequest(w, service.ErrorOprationFailed.Error())
+			return
+		}
+		if err := task.token.RefreshToken(qToken, tokenBody); nil != err {
+			serviceutil.SendServerError(w, err.Error())
+		} else {
+			task.sig.Notify(qToken)
+			serviceutil.SendSuccess(w, "")
+		}
+	}
+}
+
+// Snapshot 获取任务当前状态和事件类型, 用于推送进度
+func (task *FetchURL) Snapshot(token string) (string, interface{}, error) {
+	tokenBody := new(FetchURLTokenObject)
+	err := task.getTokenObject(token, tokenBody)
+	if nil != err {
+		return "", nil, err
+	}
+	if p, ok := task.progress.Load(token); ok {
+		tokenBody.Progress = p.(*TaskProgress).Snapshot()
+	}
+	if tokenBody.IsComplete {
+		return service.TaskEvent_Complete, tokenBody, nil
+	}
+	return service.TaskEvent_Progress, tokenBody, nil
+}
@@ -16,6 +16,8 @@ import (
 	"errors"
 	"fileservice/business/service"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/wup364/pakku/ipakku"
@@ -27,17 +29,19 @@ import (
 
 // MoveFileTokenObject 移动Token保存对象
 type MoveFileTokenObject struct {
-	ErrorString   string // 错误信息
-	Src           string // 当前正在处理的源路径
-	Dst           string // 当前正在处理的目标路径
-	IsSrcExist    bool   // 源路径是否存在
-	IsDstExist    bool   // 目标路径是否存在
-	IsReplace     bool   // 是否替换, 单次中断执行指令, 读取后设为false
-	IsReplaceAll  bool   // 是否替换, 单次API执行指令, 设置后后续中断时自动替换
-	IsIgnore      bool   // 是否忽略错误, 单次中断执行指令, 读取后设为false
-	IsIgnoreAll   bool   // 是否忽略错误, 单次API执行指令, 设置后后续中断时自动替换
-	IsComplete    bool   // 是否执行完毕
-	IsDiscontinue bool   // 是否已中断操作
+	ErrorString   string           // 错误信息
+	Src           string           // 当前正在处理的源路径
+	Dst           string           // 当前正在处理的目标路径
+	IsSrcExist    bool             // 源路径是否存在
+	IsDstExist    bool             // 目标路径是否存在
+	IsReplace     bool             // 是否替换, 单次中断执行指令, 读取后设为false
+	IsReplaceAll  bool             // 是否替换, 单次API执行指令, 设置后后续中断时自动替换
+	IsIgnore      bool             // 是否忽略错误, 单次中断执行指令, 读取后设为false
+	IsIgnoreAll   bool             // 是否忽略错误, 单次API执行指令, 设置后后续中断时自动替换
+	IsComplete    bool             // 是否执行完毕
+	IsDiscontinue bool             // 是否已中断操作
+	IsPaused      bool             // 是否已暂停, 在文件边界生效, 恢复后从暂停处继续
+	Progress      *TaskProgressDto // 进度
 }
 
 func (dto *MoveFileTokenObject) Clone(val interface{}) error {
@@ -53,6 +57,8 @@ func (dto *MoveFileTokenObject) Clone(val interface{}) error {
 		tmp.IsIgnoreAll = dto.IsIgnoreAll
 		tmp.IsComplete = dto.IsComplete
 		tmp.IsDiscontinue = dto.IsDiscontinue
+		tmp.IsPaused = dto.IsPaused
+		tmp.Progress = dto.Progress
 	}
 	return nil
 }
@@ -66,17 +72,22 @@ func (dto *MoveFileTokenObject) ToJSON() string {
 	}
 }
 
+// TaskState 是否已暂停|中断
+func (dto *MoveFileTokenObject) TaskState() (paused, discontinued bool) {
+	return dto.IsPaused, dto.IsDiscontinue
+}
+
 // MoveFile 移动|文件夹
 type MoveFile struct {
-	c     ipakku.AppCache             `@autowired:"AppCache"`
-	fm    service.FileDatas           `@autowired:"FileDatas"`
-	sg    service.UserAuth4Rpc        `@autowired:"User4RPC"`
-	pmc   service.FilePermissionCheck `@autowired:"FilePermission"`
-	token *TaskToken
+	*taskBase
+	fm       service.FileDatas           `@autowired:"FileDatas"`
+	pmc      service.FilePermissionCheck `@autowired:"FilePermission"`
+	qc       service.FileQuotaCheck      `@autowired:"FileQuota"`
+	progress sync.Map                    // 执行中任务的进度, token -> *TaskProgress
 }
 
 // Name 动作名字
-func (task MoveFile) Name() string {
+func (task *MoveFile) Name() string {
 	return "MoveFile"
 }
 
@@ -85,7 +96,6 @@ func (task *MoveFile) Init(mctx ipakku.Loader) service.AsyncTaskExecI {
 	if err := mctx.AutoWired(task); nil != err {
 		logs.Panicln(err)
 	}
-	task.token = NewTaskToken(task.c)
 	return task
 }
 
@@ -124,121 +134,203 @@ func (task *MoveFile) Execute(r *http.Request) (string, error) {
 	if nil != err {
 		return "", err
 	}
-	go func(token string) {
-		moveDirErr := task.doMove(qSrcPath, qDstPath, qReplace, qIgnore, func(s_src, s_dst string, moveErr *MoveError) error {
-			// 获取令牌数据, 不存在则说明已经销毁
-			var tokenBody *MoveFileTokenObject
-			if tokenObj, err := task.getTokenObject(token); nil != err {
-				return err
-			} else if tokenObj.IsDiscontinue {
-				return service.ErrorDiscontinue
-			} else {
-				tokenBody = tokenObj
-				tokenBody.IsIgnore = false
-				tokenBody.IsReplace = false
-				tokenBody.IsSrcExist = false
-				tokenBody.IsDstExist = false
-				tokenBody.ErrorString = ""
-				tokenBody.Src = s_src
-				tokenBody.Dst = s_dst
-				// 更新缓存
-				if err := task.token.RefreshToken(token, tokenBody); nil != err {
-					logs.Errorln(err)
+	params := map[string]string{
+		"srcPath": qSrcPath,
+		"dstPath": qDstPath,
+		"replace": strconv.FormatBool(qReplace),
+		"ignore":  strconv.FormatBool(qIgnore),
+	}
+	if err := task.tr.Begin(token, userID, task.Name(), params); nil != err {
+		task.token.DestroyToken(token, true)
+		return "", err
+	}
+	task.sched.Go(token, userID, func() int64 {
+		_, bytes := countTree(task.fm, qSrcPath)
+		return bytes
+	}, func() error {
+		return task.keepQueued(token, new(MoveFileTokenObject))
+	}, func() {
+		task.doTask(token, qSrcPath, qDstPath, qReplace, qIgnore)
+	})
+	return token, nil
+}
+
+// Resume 进程重启后继续移动源路径中剩余的文件
+func (task *MoveFile) Resume(info service.TaskInfo) error {
+	qSrcPath := info.Params["srcPath"]
+	qDstPath := info.Params["dstPath"]
+	qReplace := strutil.String2Bool(info.Params["replace"])
+	qIgnore := strutil.String2Bool(info.Params["ignore"])
+	// 源路径已经不存在, 说明中断前已经移动完毕
+	if !task.fm.IsExist(qSrcPath) && task.fm.IsExist(qDstPath) {
+		task.tr.Finish(info.TaskID, nil)
+		return nil
+	}
+	if err := task.token.RefreshToken(info.TaskID, &MoveFileTokenObject{
+		Src:          qSrcPath,
+		Dst:          qDstPath,
+		IsSrcExist:   true,
+		IsReplaceAll: qReplace,
+		IsIgnoreAll:  qIgnore,
+		IsPaused:     info.State == service.TaskState_Paused,
+	}); nil != err {
+		return err
+	}
+	go func() {
+		// 重启前已暂停的任务先等待恢复, 再重新排队
+		if info.State == service.TaskState_Paused {
+			if err := task.waitResume(info.TaskID, new(MoveFileTokenObject)); nil != err {
+				task.tr.Finish(info.TaskID, err)
+				return
+			}
+		}
+		task.sched.Go(info.TaskID, info.UserID, func() int64 {
+			_, bytes := countTree(task.fm, qSrcPath)
+			return bytes
+		}, func() error {
+			return task.keepQueued(info.TaskID, new(MoveFileTokenObject))
+		}, func() {
+			task.doTask(info.TaskID, qSrcPath, qDstPath, qReplace, qIgnore)
+		})
+	}()
+	return nil
+}
+
+// doTask 在后台执行任务, 结束后登记结果
+func (task *MoveFile) doTask(token, qSrcPath, qDstPath string, qReplace, qIgnore bool) {
+	p := NewTaskProgress(task.fm, qSrcPath)
+	task.progress.Store(token, p)
+	defer task.progress.Delete(token)
+	moveDirErr := task.doMove(p, qSrcPath, qDstPath, qReplace, qIgnore, func(s_src, s_dst string, moveErr *MoveError) error {
+		// 获取令牌数据, 不存在则说明已经销毁
+		tokenBody := new(MoveFileTokenObject)
+		if err := task.getTokenObject(token, tokenBody); nil != err {
+			return err
+		} else if tokenBody.IsDiscontinue {
+			return service.ErrorDiscontinue
+		} else {
+			// 暂停时在这里等待, 已处理的文件不会重复处理
+			if tokenBody.IsPaused {
+				if err = task.waitResume(token, tokenBody); nil != err {
+					return err
 				}
 			}
-			// 如果遇到错误了
-			if nil != moveErr {
-				// 判断是否是目标位置已经存在的错误, 如果是的话需要选择是否覆盖他
-				if moveErr.DstIsExist {
-					// 查找之前是否设置了 替换全部错误
-					if tokenBody.IsReplaceAll {
-						// 先删除然后再替换, 如果覆盖操作没有出现问题
-						if reMoveErr := task.doMove(s_src, s_dst, true, false, nil); nil == reMoveErr {
-							return nil
-						} else {
-							tokenBody.ErrorString = reMoveErr.Error()
-						}
-					}
-					// 如果设置了自动覆盖, 但是任然出错, 则判断是否忽略错误选项
-					if tokenBody.IsIgnoreAll {
-						tokenBody.ErrorString = ""
-						return nil // 跳过这个文件
-					}
-				} else {
-					// 不是路径重复类错误
-					// 如果是其他错误就不管了, 暂时无法处理只能选择 忽略|暂停
-					// 查找之前是否设置了 忽略全部错误
-					if tokenBody.IsIgnoreAll {
-						return nil // 跳过这个文件
+			tokenBody.IsIgnore = false
+			tokenBody.IsReplace = false
+			tokenBody.IsSrcExist = false
+			tokenBody.IsDstExist = false
+			tokenBody.ErrorString = ""
+			tokenBody.Src = s_src
+			tokenBody.Dst = s_dst
+			// 更新缓存
+			if err := task.token.RefreshToken(token, tokenBody); nil != err {
+				logs.Errorln(err)
+			}
+		}
+		// 如果遇到错误了
+		if nil != moveErr {
+			// 判断是否是目标位置已经存在的错误, 如果是的话需要选择是否覆盖他
+			if moveErr.DstIsExist {
+				// 查找之前是否设置了 替换全部错误
+				if tokenBody.IsReplaceAll {
+					// 先删除然后再替换, 如果覆盖操作没有出现问题
+					if reMoveErr := task.doMove(p, s_src, s_dst, true, false, nil); nil == reMoveErr {
+						return nil
+					} else {
+						tokenBody.ErrorString = reMoveErr.Error()
 					}
 				}
+				// 如果设置了自动覆盖, 但是任然出错, 则判断是否忽略错误选项
+				if tokenBody.IsIgnoreAll {
+					tokenBody.ErrorString = ""
+					p.Skip(task.fm, s_src)
+					return nil // 跳过这个文件
+				}
+			} else {
+				// 不是路径重复类错误
+				// 如果是其他错误就不管了, 暂时无法处理只能选择 忽略|暂停
+				// 查找之前是否设置了 忽略全部错误
+				if tokenBody.IsIgnoreAll {
+					p.Skip(task.fm, s_src)
+					return nil // 跳过这个文件
+				}
+			}
 
-				// 到此说明 没有设置自动覆盖和自动忽略
-				tokenBody.IsSrcExist = moveErr.SrcIsExist
-				tokenBody.IsDstExist = moveErr.DstIsExist
-				// 设置错误, 等待客户端获取, 等待操作
-				if len(tokenBody.ErrorString) == 0 {
-					tokenBody.ErrorString = moveErr.ErrorString
+			// 到此说明 没有设置自动覆盖和自动忽略
+			tokenBody.IsSrcExist = moveErr.SrcIsExist
+			tokenBody.IsDstExist = moveErr.DstIsExist
+			// 设置错误, 等待客户端获取, 等待操作
+			if len(tokenBody.ErrorString) == 0 {
+				tokenBody.ErrorString = moveErr.ErrorString
+			}
+			// 更新缓存-抛出错误
+			if err := task.token.RefreshToken(token, tokenBody); nil != err {
+				logs.Errorln(err)
+			}
+			task.sig.Notify(token)
+			notify, cancel := task.sig.Subscribe(token)
+			defer cancel()
+			for {
+				// 循环读取最想指令
+				if err := task.getTokenObject(token, tokenBody); nil != err {
+					return err
+				} else if tokenBody.IsDiscontinue {
+					return service.ErrorDiscontinue
 				}
-				// 更新缓存-抛出错误
-				if err := task.token.RefreshToken(token, tokenBody); nil != err {
-					logs.Errorln(err)
+				// 选择了忽略|忽略全部
+				if tokenBody.IsIgnore || tokenBody.IsIgnoreAll {
+					p.Skip(task.fm, s_src)
+					return nil
 				}
-				for {
-					// 循环读取最想指令
-					if token, err := task.getTokenObject(token); nil != err {
-						return err
-					} else if token.IsDiscontinue {
-						return service.ErrorDiscontinue
-					} else {
-						tokenBody = token
-					}
-					// 选择了忽略|忽略全部
-					if tokenBody.IsIgnore || tokenBody.IsIgnoreAll {
-						return nil
-					}
-					// 选择了覆盖|覆盖全部
-					if tokenBody.IsReplace || tokenBody.IsReplaceAll {
-						if moveErr.SrcIsExist {
-							if moveCopyErr := task.doMove(s_src, s_dst, true, false, nil); nil == moveCopyErr {
-								return nil
-							} else {
-								tokenBody.ErrorString = moveCopyErr.Error()
-							}
+				// 选择了覆盖|覆盖全部
+				if tokenBody.IsReplace || tokenBody.IsReplaceAll {
+					if moveErr.SrcIsExist {
+						if moveCopyErr := task.doMove(p, s_src, s_dst, true, false, nil); nil == moveCopyErr {
+							return nil
 						} else {
-							tokenBody.ErrorString = fileutil.PathNotExist("replace", s_src).Error()
-						}
-						// 更新缓存-抛出错误
-						if err := task.token.RefreshToken(token, tokenBody); nil != err {
-							logs.Errorln(err)
+							tokenBody.ErrorString = moveCopyErr.Error()
 						}
+					} else {
+						tokenBody.ErrorString = fileutil.PathNotExist("replace", s_src).Error()
+					}
+					// 更新缓存-抛出错误
+					if err := task.token.RefreshToken(token, tokenBody); nil != err {
+						logs.Errorln(err)
 					}
-					time.Sleep(time.Duration(100) * time.Millisecond) // 休眠100ms
+				}
+				// 等待客户端的指令, 同时定时检查令牌, 兼容多个实例共享缓存的情况
+				select {
+				case <-notify:
+				case <-time.After(time.Second):
 				}
 			}
-			return nil
-		})
-		// 到这里如果没有错误就是成功了
-		if tokenBody, err := task.getTokenObject(token); nil == err {
-			if nil != moveDirErr {
-				tokenBody.ErrorString = moveDirErr.Error()
-				logs.Errorln(moveDirErr)
-			} else {
-				tokenBody.ErrorString = ""
-			}
-			tokenBody.IsComplete = true
-			tokenBody.IsDiscontinue = service.ErrorDiscontinue.Error() == tokenBody.ErrorString
-			if err := task.token.RefreshToken(token, tokenBody); nil != err {
-				logs.Errorln(err)
-			}
+		}
+		return nil
+	})
+	// 到这里如果没有错误就是成功了
+	tokenBody := new(MoveFileTokenObject)
+	if err := task.getTokenObject(token, tokenBody); nil == err {
+		if nil != moveDirErr {
+			tokenBody.ErrorString = moveDirErr.Error()
+			logs.Errorln(moveDirErr)
 		} else {
+			tokenBody.ErrorString = ""
+		}
+		tokenBody.IsComplete = true
+		tokenBody.IsDiscontinue = service.ErrorDiscontinue.Error() == tokenBody.ErrorString
+		tokenBody.Progress = p.Snapshot()
+		if err := task.token.RefreshToken(token, tokenBody); nil != err {
 			logs.Errorln(err)
 		}
-	}(token)
-	return token, nil
+		task.sig.Notify(token)
+		task.tr.Finish(token, moveDirErr)
+	} else {
+		logs.Errorln(err)
+		task.tr.Finish(token, err)
+	}
 }
 
-func (task *MoveFile) doMove(src, dst string, replace, ignore bool, walk func(s_src, s_dst string, moveErr *MoveError) error) error {
+func (task *MoveFile) doMove(p *TaskProgress, src, dst string, replace, ignore bool, walk func(s_src, s_dst string, moveErr *MoveError) error) error {
 	doWalk := func(src, dst string, err error) error {
 		if nil == walk {
 			return err
@@ -259,7 +351,7 @@ func (task *MoveFile) doMove(src, dst string, replace, ignore bool, walk func(s_
 				return err
 			}
 		} else {
-			if err := doWalk(src, dst, task.fm.DoMove(src, dst, replace)); nil != err {
+			if err := doWalk(src, dst, task.moveFile(p, src, dst, replace)); nil != err {
 				return err
 			}
 		}
@@ -277,12 +369,13 @@ func (task *MoveFile) doMove(src, dst string, replace, ignore bool, walk func(s_
 								return err
 							}
 						} else {
+							p.Skip(task.fm, child)
 							doWalk(child, childdst, nil)
 							continue
 						}
 					} else {
 						// 目标位置不存在|目标存在但是允许覆盖
-						if err := doWalk(child, childdst, task.fm.DoMove(child, childdst, replace)); nil != err {
+						if err := doWalk(child, childdst, task.moveFile(p, child, childdst, replace)); nil != err {
 							return err
 						}
 					}
@@ -294,16 +387,17 @@ func (task *MoveFile) doMove(src, dst string, replace, ignore bool, walk func(s_
 								return err
 							}
 						} else {
+							p.Skip(task.fm, child)
 							doWalk(child, childdst, nil)
 							continue
 						}
 					} else {
 						if !dstexist {
-							if err := doWalk(child, childdst, task.fm.DoMove(child, childdst, replace)); nil != err {
+							if err := doWalk(child, childdst, task.moveFile(p, child, childdst, replace)); nil != err {
 								return err
 							}
 						} else {
-							if err := task.doMove(child, childdst, replace, ignore, walk); nil != err {
+							if err := task.doMove(p, child, childdst, replace, ignore, walk); nil != err {
 								return err
 							}
 						}
@@ -311,7 +405,7 @@ func (task *MoveFile) doMove(src, dst string, replace, ignore bool, walk func(s_
 				}
 			}
 		} else {
-			if err := doWalk(src, dst, task.fm.DoMove(src, dst, replace)); nil != err {
+			if err := doWalk(src, dst, task.moveFile(p, src, dst, replace)); nil != err {
 				return err
 			}
 		}
@@ -327,18 +421,53 @@ func (task *MoveFile) doMove(src, dst string, replace, ignore bool, walk func(s_
 	return nil
 }
 
+// moveFile 移动文件|文件夹, 移入有配额的路径时校验并统计用量, 完成后累加进度
+func (task *MoveFile) moveFile(p *TaskProgress, src, dst string, replace bool) error {
+	var files, bytes int64
+	if nil != p {
+		files, bytes = countTree(task.fm, src)
+	}
+	if !task.qc.HasQuotas() {
+		if err := task.fm.DoMove(src, dst, replace); nil != err {
+			return err
+		}
+		p.AddFiles(files)
+		p.AddBytes(bytes)
+		return nil
+	}
+	size := task.qc.GetPathSize(src)
+	oldSize := int64(0)
+	if replace && task.fm.IsExist(dst) {
+		oldSize = task.qc.GetPathSize(dst)
+	}
+	if err := task.qc.CheckMove(src, dst, size-oldSize); nil != err {
+		return err
+	}
+	if err := task.fm.DoMove(src, dst, replace); nil != err {
+		return err
+	}
+	task.qc.AddUsage(dst, -oldSize)
+	task.qc.MoveUsage(src, dst, size)
+	p.AddFiles(files)
+	p.AddBytes(bytes)
+	return nil
+}
+
 // Status 查询动作状态, 在内部返回数据
 func (task *MoveFile) Status(w http.ResponseWriter, r *http.Request) {
 	qToken := r.FormValue("token")
 	qOperation := r.FormValue("operation")
-	tokenBody, tokenErr := task.getTokenObject(qToken)
-	if nil == tokenBody || nil != tokenErr {
+	tokenBody := new(MoveFileTokenObject)
+	if tokenErr := task.getTokenObject(qToken, tokenBody); nil != tokenErr {
 		serviceutil.SendBadRequest(w, service.ErrorOprationExpires.Error())
 		return
 	}
 	task.token.RefreshToken(qToken, tokenBody)
 	// 用于获取令牌信息
 	if len(qOperation) == 0 {
+		if p, ok := task.progress.Load(qToken); ok {
+			tokenBody.Progress = p.(*TaskProgress).Snapshot()
+		}
 		serviceutil.SendSuccess(w, tokenBody)
 
 		// 用于操作|中断
@@ -360,6 +489,16 @@ func (task *MoveFile) Status(w http.ResponseWriter, r *http.Request) {
 		case "replaceall":
 			tokenBody.ErrorString = ""
 			tokenBody.IsReplaceAll = true
+		// 在当前文件处理完后暂停
+		case "pause":
+			if tokenBody.IsComplete {
+				serviceutil.SendBadRequest(w, service.ErrorOprationFailed.Error())
+				return
+			}
+			tokenBody.IsPaused = true
+		// 从暂停处继续
+		case "resume":
+			tokenBody.IsPaused = false
 		// 立即中断操作
 		case "discontinue":
 			tokenBody.ErrorString = ""
@@ -372,26 +511,26 @@ func (task *MoveFile) Status(w http.ResponseWriter, r *http.Request) {
 		if err := task.token.RefreshToken(qToken, tokenBody); nil != err {
 			serviceutil.SendServerError(w, err.Error())
 		} else {
+			task.sig.Notify(qToken)
 			serviceutil.SendSuccess(w, "")
 		}
 	}
 }
 
-// getTokenObject 获取文件传输Token对象
-func (task *MoveFile) getTokenObject(token string) (*MoveFileTokenObject, error) {
-	var tokenBody MoveFileTokenObject
-	if err := task.token.QueryToken(token, &tokenBody); nil != err {
-		return nil, err
+// Snapshot 获取任务当前状态和事件类型, 用于推送进度
+func (task *MoveFile) Snapshot(token string) (string, interface{}, error) {
+	tokenBody := new(MoveFileTokenObject)
+	err := task.getTokenObject(token, tokenBody)
+	if nil != err {
+		return "", nil, err
 	}
-	return &tokenBody, nil
-}
-
-// GetUserID4Request 获取登录用户
-func (task *MoveFile) GetUserID4Request(r *http.Request) string {
-	if askstr := task.sg.GetAccessKey4Request(r); len(askstr) > 0 {
-		if ack, err := task.sg.GetUserAccess(askstr); nil == err {
-			return ack.UserID
-		}
+	if p, ok := task.progress.Load(token); ok {
+		tokenBody.Progress = p.(*TaskProgress).Snapshot()
+	}
+	if tokenBody.IsComplete {
+		return service.TaskEvent_Complete, tokenBody, nil
+	} else if len(tokenBody.ErrorString) > 0 {
+		return service.TaskEvent_Conflict, tokenBody, nil
 	}
-	return ""
+	return service.TaskEvent_Progress, tokenBody, nil
 }
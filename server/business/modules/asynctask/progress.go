@@ -0,0 +1,187 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 任务进度统计, 预扫描源路径得到总量, 复制过程中按字节累加
+
+package asynctask
+
+import (
+	"fileservice/business/service"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TaskProgressDto 任务进度, 总量为-1时表示还在扫描中
+type TaskProgressDto struct {
+	TotalFiles     int64 // 文件总数
+	ProcessedFiles int64 // 已处理的文件数, 包含跳过的文件
+	TotalBytes     int64 // 字节总数
+	ProcessedBytes int64 // 已处理的字节数
+	Speed          int64 // 当前速度, 字节/秒
+	Remaining      int64 // 预计剩余时间, 秒, -1为未知
+}
+
+// NewTaskProgress 新建进度统计, 在后台扫描源路径
+func NewTaskProgress(fm service.FileDatas, src string) *TaskProgress {
+	p := &TaskProgress{totalFiles: -1, totalBytes: -1, lastTime: time.Now()}
+	go func() {
+		files, bytes := countTree(fm, src)
+		atomic.StoreInt64(&p.totalBytes, bytes)
+		atomic.StoreInt64(&p.totalFiles, files)
+	}()
+	return p
+}
+
+// NewTaskProgress4Paths 先统计多个路径的总量再新建进度统计, 用于删除等边处理边减少源文件的任务
+func NewTaskProgress4Paths(fm service.FileDatas, srcs []string) *TaskProgress {
+	p := &TaskProgress{lastTime: time.Now()}
+	for _, src := range srcs {
+		files, bytes := countTree(fm, src)
+		p.totalFiles += files
+		p.totalBytes += bytes
+	}
+	return p
+}
+
+// NewTaskProgress4Stream 新建单个文件的进度统计, 总大小在获取到后设置, 用于下载等不能预扫描的任务
+func NewTaskProgress4Stream() *TaskProgress {
+	return &TaskProgress{totalFiles: 1, totalBytes: -1, lastTime: time.Now()}
+}
+
+// TaskProgress 任务进度统计, 方法允许在nil上调用
+type TaskProgress struct {
+	totalFiles     int64
+	processedFiles int64
+	totalBytes     int64
+	processedBytes int64
+	lock           sync.Mutex
+	lastTime       time.Time
+	lastBytes      int64
+	speed          int64
+}
+
+// AddBytes 累加已处理的字节数
+func (p *TaskProgress) AddBytes(n int64) {
+	if nil != p {
+		atomic.AddInt64(&p.processedBytes, n)
+	}
+}
+
+// SetTotalBytes 设置字节总数, -1为未知
+func (p *TaskProgress) SetTotalBytes(n int64) {
+	if nil != p {
+		atomic.StoreInt64(&p.totalBytes, n)
+	}
+}
+
+// AddFiles 累加已处理的文件数
+func (p *TaskProgress) AddFiles(n int64) {
+	if nil != p {
+		atomic.AddInt64(&p.processedFiles, n)
+	}
+}
+
+// Skip 跳过一个文件或文件夹, 计入已处理
+func (p *TaskProgress) Skip(fm service.FileDatas, src string) {
+	if nil != p {
+		files, bytes := countTree(fm, src)
+		p.AddFiles(files)
+		p.AddBytes(bytes)
+	}
+}
+
+// NewReader 包装读取流, 读取时累加字节数
+func (p *TaskProgress) NewReader(r io.Reader) *CountReader {
+	return &CountReader{r: r, p: p}
+}
+
+// Snapshot 获取当前进度, 速度按两次获取之间的平均值平滑计算
+func (p *TaskProgress) Snapshot() *TaskProgressDto {
+	if nil == p {
+		return nil
+	}
+	dto := &TaskProgressDto{
+		TotalFiles:     atomic.LoadInt64(&p.totalFiles),
+		ProcessedFiles: atomic.LoadInt64(&p.processedFiles),
+		TotalBytes:     atomic.LoadInt64(&p.totalBytes),
+		ProcessedBytes: atomic.LoadInt64(&p.processedBytes),
+		Remaining:      -1,
+	}
+	p.lock.Lock()
+	// 还没有速度时缩短采样间隔, 尽快给出预计时间
+	if dt := time.Since(p.lastTime); dt >= time.Second || (p.speed == 0 && dt >= 200*time.Millisecond) {
+		current := int64(float64(dto.ProcessedBytes-p.lastBytes) / dt.Seconds())
+		if p.speed == 0 {
+			p.speed = current
+		} else {
+			p.speed = (p.speed*7 + current*3) / 10
+		}
+		p.lastTime, p.lastBytes = time.Now(), dto.ProcessedBytes
+	}
+	dto.Speed = p.speed
+	p.lock.Unlock()
+	// 扫描中或者跳过了部分文件时已处理可能大于总数
+	if dto.TotalBytes >= 0 {
+		if dto.ProcessedBytes > dto.TotalBytes {
+			dto.ProcessedBytes = dto.TotalBytes
+		}
+		if dto.ProcessedFiles > dto.TotalFiles {
+			dto.ProcessedFiles = dto.TotalFiles
+		}
+		if dto.Speed > 0 {
+			dto.Remaining = (dto.TotalBytes - dto.ProcessedBytes) / dto.Speed
+		}
+	}
+	return dto
+}
+
+// CountReader 计数读取流
+type CountReader struct {
+	r io.Reader
+	p *TaskProgress
+	n int64
+}
+
+// Read 读取并计数
+func (cr *CountReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	cr.n += int64(n)
+	cr.p.AddBytes(int64(n))
+	return n, err
+}
+
+// Rollback 失败时撤回已计数的字节
+func (cr *CountReader) Rollback() {
+	cr.p.AddBytes(-cr.n)
+	cr.n = 0
+}
+
+// countTree 统计路径下的文件数和字节数
+func countTree(fm service.FileDatas, src string) (files int64, bytes int64) {
+	if fm.IsFile(src) {
+		return 1, fm.GetFileSize(src)
+	}
+	nodes, err := fm.GetDirNodeList(src, -1, -1)
+	if nil != err {
+		return 0, 0
+	}
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].IsFile {
+			files++
+			bytes += nodes[i].Size
+		} else if nodes[i].IsDir {
+			cf, cb := countTree(fm, nodes[i].Path)
+			files += cf
+			bytes += cb
+		}
+	}
+	return files, bytes
+}
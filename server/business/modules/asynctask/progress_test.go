@@ -0,0 +1,59 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package asynctask
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaskProgress(t *testing.T) {
+	// nil 上调用不报错
+	var np *TaskProgress
+	np.AddFiles(1)
+	np.AddBytes(1)
+	if np.Snapshot() != nil {
+		t.Fatal("nil progress snapshot")
+	}
+	p := &TaskProgress{totalFiles: 2, totalBytes: 10, lastTime: time.Now().Add(-time.Second)}
+	cr := p.NewReader(strings.NewReader("hello"))
+	if _, err := io.Copy(io.Discard, cr); nil != err {
+		t.Fatal(err)
+	}
+	p.AddFiles(1)
+	dto := p.Snapshot()
+	if dto.ProcessedBytes != 5 || dto.ProcessedFiles != 1 || dto.Speed <= 0 || dto.Remaining < 0 {
+		t.Fatal(dto)
+	}
+	// 写入失败时撤回
+	cr = p.NewReader(io.MultiReader(strings.NewReader("abc"), errReader{}))
+	if _, err := io.Copy(io.Discard, cr); nil == err {
+		t.Fatal("expected error")
+	}
+	cr.Rollback()
+	if dto := p.Snapshot(); dto.ProcessedBytes != 5 {
+		t.Fatal(dto)
+	}
+	// 已处理不超过总数
+	p.AddBytes(100)
+	p.AddFiles(5)
+	if dto := p.Snapshot(); dto.ProcessedBytes != 10 || dto.ProcessedFiles != 2 || dto.Remaining != 0 {
+		t.Fatal(dto)
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read(b []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
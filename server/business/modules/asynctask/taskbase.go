@@ -0,0 +1,82 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 各异步任务共用的令牌、登记、信号和调度
+
+package asynctask
+
+import (
+	"fileservice/business/service"
+	"net/http"
+	"time"
+
+	"github.com/wup364/pakku/utils/logs"
+)
+
+// taskTokenObject 可暂停|中断的任务Token保存对象
+type taskTokenObject interface {
+	TaskState() (paused, discontinued bool)
+}
+
+// taskBase 异步任务公共部分, 由各任务嵌入
+type taskBase struct {
+	sg    service.UserAuth4Rpc
+	token *TaskToken
+	tr    *TaskRegistry
+	sig   *TaskSignal
+	sched *TaskScheduler
+}
+
+// getTokenObject 获取Token对象, 写入tokenBody
+func (task *taskBase) getTokenObject(token string, tokenBody taskTokenObject) error {
+	return task.token.QueryToken(token, tokenBody)
+}
+
+// waitResume 等待恢复或中断, 等待期间不占用读写流, 并保持令牌不过期; 恢复后tokenBody为最新的Token对象
+func (task *taskBase) waitResume(token string, tokenBody taskTokenObject) error {
+	task.tr.Update(token, service.TaskState_Paused)
+	defer task.tr.Update(token, service.TaskState_Running)
+	notify, cancel := task.sig.Subscribe(token)
+	defer cancel()
+	for {
+		if err := task.getTokenObject(token, tokenBody); nil != err {
+			return err
+		} else if paused, discontinued := tokenBody.TaskState(); discontinued {
+			return service.ErrorDiscontinue
+		} else if !paused {
+			return nil
+		} else if err := task.token.RefreshToken(token, tokenBody); nil != err {
+			logs.Errorln(err)
+		}
+		select {
+		case <-notify:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// keepQueued 排队期间保持令牌不过期, 已中断时放弃排队
+func (task *taskBase) keepQueued(token string, tokenBody taskTokenObject) error {
+	if err := task.getTokenObject(token, tokenBody); nil != err {
+		return err
+	} else if _, discontinued := tokenBody.TaskState(); discontinued {
+		return service.ErrorDiscontinue
+	}
+	return task.token.RefreshToken(token, tokenBody)
+}
+
+// GetUserID4Request 获取登录用户
+func (task *taskBase) GetUserID4Request(r *http.Request) string {
+	if askstr := task.sg.GetAccessKey4Request(r); len(askstr) > 0 {
+		if ack, err := task.sg.GetUserAccess(askstr); nil == err {
+			return ack.UserID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 任务登记, 记录任务的发起人、参数和执行结果
+
+package asynctask
+
+import (
+	"fileservice/business/service"
+	"sync"
+	"time"
+
+	"github.com/wup364/pakku/utils/logs"
+)
+
+// NewTaskRegistry 新建任务登记
+func NewTaskRegistry(ts *TaskStory) *TaskRegistry {
+	return &TaskRegistry{ts: ts}
+}
+
+// TaskRegistry 任务登记
+type TaskRegistry struct {
+	ts        *TaskStory
+	lock      sync.RWMutex
+	listeners []service.TaskListener
+}
+
+// Begin 登记一个执行中的任务
+func (tr *TaskRegistry) Begin(taskID, userID, taskType string, params map[string]string) error {
+	return tr.ts.AddTask(service.TaskInfo{
+		TaskID:   taskID,
+		UserID:   userID,
+		TaskType: taskType,
+		Params:   params,
+		State:    service.TaskState_Running,
+		StTime:   time.Now().UnixMilli(),
+	})
+}
+
+// Update 登记任务状态变化, 如暂停、恢复
+func (tr *TaskRegistry) Update(taskID, state string) {
+	if err := tr.ts.UpdateState(taskID, state, "", 0); nil != err {
+		logs.Errorln(err)
+	}
+}
+
+// Finish 登记任务结束, err为空时成功, 为 ErrorDiscontinue 时为已中断
+func (tr *TaskRegistry) Finish(taskID string, err error) {
+	state, result := service.TaskState_Completed, ""
+	if nil != err {
+		if err.Error() == service.ErrorDiscontinue.Error() {
+			state = service.TaskState_Discontinued
+		} else {
+			state, result = service.TaskState_Failed, err.Error()
+		}
+	}
+	if err := tr.ts.UpdateState(taskID, state, result, time.Now().UnixMilli()); nil != err {
+		logs.Errorln(err)
+		return
+	}
+	tr.emit(taskID)
+}
+
+// AddListener 监听任务结束
+func (tr *TaskRegistry) AddListener(listener service.TaskListener) {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	tr.listeners = append(tr.listeners, listener)
+}
+
+// emit 通知任务结束
+func (tr *TaskRegistry) emit(taskID string) {
+	tr.lock.RLock()
+	defer tr.lock.RUnlock()
+	if len(tr.listeners) == 0 {
+		return
+	}
+	task, err := tr.ts.QueryTask(taskID)
+	if nil != err || nil == task {
+		logs.Errorln(taskID, err)
+		return
+	}
+	for _, listener := range tr.listeners {
+		listener(*task)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 任务调度, 限制全局和每个用户同时执行的任务数, 超出的任务排队等待
+
+package asynctask
+
+import (
+	"fileservice/business/service"
+	"sync"
+	"time"
+)
+
+// maxQueueSkips 大任务被后来的小任务越过的次数上限, 达到后视同小任务, 避免小任务不断到来时大任务一直等待
+const maxQueueSkips = 8
+
+// NewTaskScheduler 新建任务调度, maxRunning|maxPerUser <=0 时不限制, 预估大小不超过smallSize的任务优先执行
+func NewTaskScheduler(tr *TaskRegistry, maxRunning, maxPerUser int, smallSize int64) *TaskScheduler {
+	return &TaskScheduler{
+		tr:          tr,
+		maxRunning:  maxRunning,
+		maxPerUser:  maxPerUser,
+		smallSize:   smallSize,
+		maxSkips:    maxQueueSkips,
+		userRunning: make(map[string]int),
+	}
+}
+
+// TaskScheduler 任务调度, 先进先出, 小任务优先, 大任务被越过 maxSkips 次后不再让出
+type TaskScheduler struct {
+	tr          *TaskRegistry
+	lock        sync.Mutex
+	maxRunning  int
+	maxPerUser  int
+	smallSize   int64
+	maxSkips    int
+	running     int
+	userRunning map[string]int
+	queue       []*queuedTask
+}
+
+// queuedTask 排队中的任务
+type queuedTask struct {
+	taskID  string
+	userID  string
+	small   bool
+	skipped int // 被小任务越过的次数
+	ready   chan struct{}
+}
+
+// Go 申请到执行名额后在后台执行任务
+// 排队期间每秒调用一次check, 用于保持令牌和检查是否中断, check返回错误时放弃排队并登记任务结束
+// size 为任务的预估大小, 只在需要排队时调用
+func (s *TaskScheduler) Go(taskID, userID string, size func() int64, check func() error, run func()) {
+	go func() {
+		release, err := s.Acquire(taskID, userID, size, check)
+		if nil != err {
+			s.tr.Finish(taskID, err)
+			return
+		}
+		defer release()
+		run()
+	}()
+}
+
+// Acquire 申请执行名额, 名额不足时排队等待, 执行完毕后需调用返回的释放函数
+func (s *TaskScheduler) Acquire(taskID, userID string, size func() int64, check func() error) (func(), error) {
+	release := s.releaseFunc(userID)
+	s.lock.Lock()
+	if s.canRun(userID) {
+		s.start(userID)
+		s.lock.Unlock()
+		return release, nil
+	}
+	s.lock.Unlock()
+	// 需要排队, 在锁外统计任务大小
+	small := nil == size || size() <= s.smallSize
+	s.lock.Lock()
+	if s.canRun(userID) {
+		s.start(userID)
+		s.lock.Unlock()
+		return release, nil
+	}
+	qt := &queuedTask{taskID: taskID, userID: userID, small: small, ready: make(chan struct{})}
+	s.queue = append(s.queue, qt)
+	s.lock.Unlock()
+	s.tr.Update(taskID, service.TaskState_Queued)
+	for {
+		select {
+		case <-qt.ready:
+			s.tr.Update(taskID, service.TaskState_Running)
+			return release, nil
+		case <-time.After(time.Second):
+			if nil == check {
+				continue
+			}
+			if err := check(); nil != err {
+				if s.dequeue(qt) {
+					return nil, err
+				}
+				// 放弃前已经拿到了名额, 归还名额
+				<-qt.ready
+				release()
+				return nil, err
+			}
+		}
+	}
+}
+
+// releaseFunc 归还名额的函数, 多次调用只生效一次
+func (s *TaskScheduler) releaseFunc(userID string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			s.running--
+			if s.userRunning[userID]--; s.userRunning[userID] <= 0 {
+				delete(s.userRunning, userID)
+			}
+			s.dispatch()
+		})
+	}
+}
+
+// canRun 是否还有名额, 需要在锁内调用
+func (s *TaskScheduler) canRun(userID string) bool {
+	if s.maxRunning > 0 && s.running >= s.maxRunning {
+		return false
+	}
+	return s.maxPerUser <= 0 || s.userRunning[userID] < s.maxPerUser
+}
+
+// start 占用名额, 需要在锁内调用
+func (s *TaskScheduler) start(userID string) {
+	s.running++
+	s.userRunning[userID]++
+}
+
+// dispatch 把名额分配给排队中的任务, 小任务优先, 同类任务先进先出, 需要在锁内调用;
+// 被越过的大任务记一次, 达到 maxSkips 次后视同小任务
+func (s *TaskScheduler) dispatch() {
+	for {
+		next := -1
+		for i := 0; i < len(s.queue); i++ {
+			if !s.canRun(s.queue[i].userID) {
+				continue
+			}
+			if s.queue[i].small || s.queue[i].skipped >= s.maxSkips {
+				next = i
+				break
+			}
+			if next == -1 {
+				next = i
+			}
+		}
+		if next == -1 {
+			return
+		}
+		for i := 0; i < next; i++ {
+			if s.canRun(s.queue[i].userID) {
+				s.queue[i].skipped++
+			}
+		}
+		qt := s.queue[next]
+		s.queue = append(s.queue[:next], s.queue[next+1:]...)
+		s.start(qt.userID)
+		close(qt.ready)
+	}
+}
+
+// dequeue 从队列中移除, 已经分配了名额时返回false
+func (s *TaskScheduler) dequeue(qt *queuedTask) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := 0; i < len(s.queue); i++ {
+		if s.queue[i] == qt {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
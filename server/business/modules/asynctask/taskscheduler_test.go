@@ -0,0 +1,137 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package asynctask
+
+import (
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTaskScheduler(t *testing.T) {
+	ts := new(TaskStory)
+	if err := ts.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "task.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if err := ts.Install(); nil != err {
+		t.Fatal(err)
+	}
+	tr := NewTaskRegistry(ts)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6"} {
+		if err := tr.Begin(id, "user01", "CopyFile", nil); nil != err {
+			t.Fatal(err)
+		}
+	}
+	s := NewTaskScheduler(tr, 2, 1, 10)
+	size := func(n int64) func() int64 { return func() int64 { return n } }
+	// 每个用户限制1个
+	r1, err := s.Acquire("1", "user01", size(100), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	started := make(chan string, 4)
+	acquire := func(id, userID string, n int64) {
+		go func() {
+			release, err := s.Acquire(id, userID, size(n), nil)
+			if nil != err {
+				t.Error(err)
+				return
+			}
+			started <- id
+			time.Sleep(10 * time.Millisecond)
+			release()
+		}()
+	}
+	acquire("2", "user01", 100)
+	waitStat(t, s, 1, 1)
+	if task, _ := ts.QueryTask("2"); task.State != service.TaskState_Queued {
+		t.Fatal(task)
+	}
+	// 其他用户不受影响, 但占满了全局名额
+	r3, err := s.Acquire("3", "user02", size(100), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	acquire("4", "user03", 100)
+	acquire("5", "user04", 1)
+	waitStat(t, s, 2, 3)
+	// 全局名额释放后小任务优先
+	r3()
+	if id := <-started; id != "5" {
+		t.Fatal(id)
+	}
+	if id := <-started; id != "4" {
+		t.Fatal(id)
+	}
+	// 用户名额释放后排队的任务才执行
+	r1()
+	r1()
+	if id := <-started; id != "2" {
+		t.Fatal(id)
+	}
+	if task, _ := ts.QueryTask("2"); task.State != service.TaskState_Running {
+		t.Fatal(task)
+	}
+	// check 返回错误时放弃排队
+	waitStat(t, s, 0, 0)
+	r6, _ := s.Acquire("6", "user05", nil, nil)
+	r7, _ := s.Acquire("7", "user06", nil, nil)
+	if _, err := s.Acquire("8", "user07", nil, func() error { return service.ErrorDiscontinue }); err != service.ErrorDiscontinue {
+		t.Fatal(err)
+	}
+	if len(s.queue) != 0 {
+		t.Fatal(s.queue)
+	}
+	r6()
+	r7()
+	if s.running != 0 || len(s.userRunning) != 0 {
+		t.Fatal(s.running, s.userRunning)
+	}
+	// 大任务被越过 maxSkips 次后不再让给后来的小任务
+	s = NewTaskScheduler(tr, 1, 0, 10)
+	s.maxSkips = 1
+	for _, id := range []string{"9", "10", "11", "12"} {
+		if err := tr.Begin(id, "user01", "CopyFile", nil); nil != err {
+			t.Fatal(err)
+		}
+	}
+	r9, _ := s.Acquire("9", "user01", nil, nil)
+	acquire("10", "user01", 100)
+	waitStat(t, s, 1, 1)
+	acquire("11", "user01", 1)
+	waitStat(t, s, 1, 2)
+	acquire("12", "user01", 1)
+	waitStat(t, s, 1, 3)
+	r9()
+	for _, expect := range []string{"11", "10", "12"} {
+		if id := <-started; id != expect {
+			t.Fatal(id, expect)
+		}
+	}
+}
+
+// waitStat 等待执行中和排队中的数量达到预期
+func waitStat(t *testing.T, s *TaskScheduler, running, queued int) {
+	for i := 0; i < 100; i++ {
+		s.lock.Lock()
+		r, q := s.running, len(s.queue)
+		s.lock.Unlock()
+		if r == running && q == queued {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("scheduler stat not match", running, queued)
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 任务信号, 用户操作任务后通知等待中的任务和推送连接
+
+package asynctask
+
+import "sync"
+
+// NewTaskSignal 新建任务信号
+func NewTaskSignal() *TaskSignal {
+	return &TaskSignal{subs: make(map[string]map[chan struct{}]bool)}
+}
+
+// TaskSignal 按token订阅任务的变化通知
+type TaskSignal struct {
+	lock sync.Mutex
+	subs map[string]map[chan struct{}]bool
+}
+
+// Subscribe 订阅任务变化, 使用完毕后需调用返回的取消函数
+func (ts *TaskSignal) Subscribe(token string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	ts.lock.Lock()
+	if _, ok := ts.subs[token]; !ok {
+		ts.subs[token] = make(map[chan struct{}]bool)
+	}
+	ts.subs[token][ch] = true
+	ts.lock.Unlock()
+	return ch, func() {
+		ts.lock.Lock()
+		defer ts.lock.Unlock()
+		if subs, ok := ts.subs[token]; ok {
+			delete(subs, ch)
+			if len(subs) == 0 {
+				delete(ts.subs, token)
+			}
+		}
+	}
+}
+
+// Notify 通知所有订阅者, 订阅者还未处理上次通知时不重复发送
+func (ts *TaskSignal) Notify(token string) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	for ch := range ts.subs[token] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package asynctask
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTaskSignal(t *testing.T) {
+	sig := NewTaskSignal()
+	ch1, cancel1 := sig.Subscribe("t1")
+	ch2, cancel2 := sig.Subscribe("t1")
+	other, cancel3 := sig.Subscribe("t2")
+	defer cancel3()
+	// 未处理的通知不会阻塞
+	sig.Notify("t1")
+	sig.Notify("t1")
+	for _, ch := range []<-chan struct{}{ch1, ch2} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("no signal")
+		}
+	}
+	select {
+	case <-other:
+		t.Fatal("unexpected signal")
+	default:
+	}
+	cancel1()
+	cancel2()
+	if _, ok := sig.subs["t1"]; ok {
+		t.Fatal("subscription not released")
+	}
+}
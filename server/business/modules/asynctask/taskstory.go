@@ -0,0 +1,146 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 用db存放任务记录
+
+package asynctask
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"time"
+)
+
+// TaskStory 任务记录存储
+type TaskStory struct {
+	db *sql.DB
+}
+
+// Initial 初始化配置
+func (ts *TaskStory) Initial(st constants.DBSetting) (err error) {
+	if nil == ts.db {
+		ts.db, err = sql.Open(st.DriverName, st.DataSourceName)
+		if nil == err {
+			if st.DriverName == "sqlite3" {
+				// database is locked
+				// https://github.com/mattn/go-sqlite3/issues/209
+				ts.db.SetMaxOpenConns(1)
+			} else {
+				ts.db.SetMaxIdleConns(250)
+				ts.db.SetConnMaxLifetime(time.Hour)
+			}
+		}
+	}
+	return err
+}
+
+// Install 初始化 filetasks 表
+func (ts *TaskStory) Install() (err error) {
+	var tx *sql.Tx
+	if tx, err = ts.db.Begin(); err == nil {
+		for _, ddl := range []string{
+			`CREATE TABLE IF NOT EXISTS filetasks(
+				taskid VARCHAR(64) PRIMARY KEY,
+				userid VARCHAR(64) NULL,
+				tasktype VARCHAR(64) NULL,
+				params TEXT(4000) NULL,
+				state VARCHAR(32) NULL,
+				result TEXT(1000) NULL,
+				sttime BIGINT DEFAULT 0,
+				endtime BIGINT DEFAULT 0
+			);`,
+			`CREATE INDEX idx_filetasks_userid ON filetasks(userid);`,
+		} {
+			if _, err = tx.Exec(ddl); nil != err {
+				tx.Rollback()
+				return err
+			}
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// AddTask 添加任务记录
+func (ts *TaskStory) AddTask(task service.TaskInfo) error {
+	params, err := json.Marshal(task.Params)
+	if nil != err {
+		return err
+	}
+	_, err = ts.db.Exec("INSERT INTO filetasks(taskid, userid, tasktype, params, state, result, sttime, endtime) values(?,?,?,?,?,?,?,?)",
+		task.TaskID, task.UserID, task.TaskType, string(params), task.State, task.Result, task.StTime, task.EndTime)
+	return err
+}
+
+// UpdateState 修改任务状态
+func (ts *TaskStory) UpdateState(taskID, state, result string, endTime int64) error {
+	_, err := ts.db.Exec("UPDATE filetasks SET state=?, result=?, endtime=? WHERE taskid=?", state, result, endTime, taskID)
+	return err
+}
+
+// ListTasks 列出用户的任务, 按开始时间倒序
+func (ts *TaskStory) ListTasks(userID string) ([]service.TaskInfo, error) {
+	return ts.query("SELECT taskid, userid, tasktype, params, state, result, sttime, endtime FROM filetasks WHERE userid = ? ORDER BY sttime DESC", userID)
+}
+
+// ListTasksByState 列出某个状态的全部任务
+func (ts *TaskStory) ListTasksByState(state string) ([]service.TaskInfo, error) {
+	return ts.query("SELECT taskid, userid, tasktype, params, state, result, sttime, endtime FROM filetasks WHERE state = ? ORDER BY sttime", state)
+}
+
+// QueryTask 根据任务ID查询, 不存在返回nil
+func (ts *TaskStory) QueryTask(taskID string) (*service.TaskInfo, error) {
+	if tasks, err := ts.query("SELECT taskid, userid, tasktype, params, state, result, sttime, endtime FROM filetasks WHERE taskid = ?", taskID); nil != err {
+		return nil, err
+	} else if len(tasks) > 0 {
+		return &tasks[0], nil
+	}
+	return nil, nil
+}
+
+// DelTasks 删除用户某些状态的任务
+func (ts *TaskStory) DelTasks(userID string, states ...string) (count int64, err error) {
+	for i := 0; i < len(states); i++ {
+		var res sql.Result
+		if res, err = ts.db.Exec("DELETE FROM filetasks WHERE userid = ? AND state = ?", userID, states[i]); nil != err {
+			return count, err
+		}
+		if n, err := res.RowsAffected(); nil == err {
+			count += n
+		}
+	}
+	return count, nil
+}
+
+// query 查询任务列表
+func (ts *TaskStory) query(query string, args ...interface{}) ([]service.TaskInfo, error) {
+	rows, err := ts.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]service.TaskInfo, 0)
+	for rows.Next() {
+		var params string
+		task := service.TaskInfo{}
+		if err := rows.Scan(&task.TaskID, &task.UserID, &task.TaskType, &params, &task.State, &task.Result, &task.StTime, &task.EndTime); err != nil {
+			return nil, err
+		}
+		if len(params) > 0 {
+			if err := json.Unmarshal([]byte(params), &task.Params); nil != err {
+				return nil, err
+			}
+		}
+		res = append(res, task)
+	}
+	return res, nil
+}
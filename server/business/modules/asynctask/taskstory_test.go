@@ -0,0 +1,91 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package asynctask
+
+import (
+	"errors"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/wup364/pakku/ipakku"
+)
+
+// resumeTask 测试用的可续传任务
+type resumeTask struct {
+	resumed []string
+}
+
+func (task *resumeTask) Name() string                                   { return "Resume" }
+func (task *resumeTask) Init(mctx ipakku.Loader) service.AsyncTaskExecI { return task }
+func (task *resumeTask) Execute(r *http.Request) (string, error)        { return "", nil }
+func (task *resumeTask) Status(w http.ResponseWriter, r *http.Request)  {}
+func (task *resumeTask) Resume(info service.TaskInfo) error {
+	task.resumed = append(task.resumed, info.TaskID)
+	return nil
+}
+
+func TestTaskRegistry(t *testing.T) {
+	ts := new(TaskStory)
+	if err := ts.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "task.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if err := ts.Install(); nil != err {
+		t.Fatal(err)
+	}
+	m := &AsyncTask{ts: ts, tr: NewTaskRegistry(ts)}
+	rt := new(resumeTask)
+	m.AddTaskObject(rt)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		taskType := "CopyFile"
+		if id == "4" {
+			taskType = rt.Name()
+		}
+		if err := m.tr.Begin(id, "user01", taskType, map[string]string{"srcPath": "/a"}); nil != err {
+			t.Fatal(err)
+		}
+	}
+	m.tr.Finish("1", nil)
+	m.tr.Finish("2", service.ErrorDiscontinue)
+	m.tr.Finish("3", errors.New("copy failed"))
+	if task, err := m.QueryTask("3"); nil != err || task.State != service.TaskState_Failed || task.Result != "copy failed" || task.Params["srcPath"] != "/a" {
+		t.Fatal(task, err)
+	}
+	if task, err := m.QueryTask("2"); nil != err || task.State != service.TaskState_Discontinued {
+		t.Fatal(task, err)
+	}
+	if _, err := m.QueryTask("5"); err != service.ErrorTaskNotExist {
+		t.Fatal(err)
+	}
+	// 重启后不支持续传的任务标记为失败
+	m.tr.Begin("5", "user02", "CopyFile", nil)
+	m.tr.Begin("6", "user01", rt.Name(), nil)
+	m.tr.Update("6", service.TaskState_Paused)
+	m.resumeTasks()
+	if len(rt.resumed) != 2 || rt.resumed[0] != "4" || rt.resumed[1] != "6" {
+		t.Fatal(rt.resumed)
+	}
+	if task, err := m.QueryTask("5"); nil != err || task.State != service.TaskState_Failed || task.Result != service.ErrorTaskInterrupted.Error() {
+		t.Fatal(task, err)
+	}
+	// 只清除已结束的任务
+	if count, err := m.ClearTasks("user01"); nil != err || count != 3 {
+		t.Fatal(count, err)
+	}
+	if tasks, err := m.ListTasks("user01"); nil != err || len(tasks) != 2 {
+		t.Fatal(tasks, err)
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 远程文件下载, 校验目标主机防止SSRF, 断线后使用Range从断点继续
+
+package asynctask
+
+import (
+	"context"
+	"errors"
+	"fileservice/business/service"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewHostFilter 新建主机过滤, allow|deny 为逗号分隔的域名(*.example.com)、IP或网段(10.0.0.0/8)
+// 命中deny的主机总是拒绝; allow不为空时只允许命中allow的主机, 为空时只允许公网地址
+func NewHostFilter(allow, deny string) (*HostFilter, error) {
+	hf := new(HostFilter)
+	var err error
+	if hf.allowNames, hf.allowNets, err = parseHosts(allow); nil != err {
+		return nil, err
+	}
+	if hf.denyNames, hf.denyNets, err = parseHosts(deny); nil != err {
+		return nil, err
+	}
+	return hf, nil
+}
+
+// reservedNets 默认拒绝的其他保留地址: 本网络, 运营商级NAT(含云厂商的元数据服务 100.100.100.200), NAT64
+var reservedNets = func() []*net.IPNet {
+	nets := make([]*net.IPNet, 0)
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "64:ff9b::/96", "64:ff9b:1::/48"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		nets = append(nets, ipnet)
+	}
+	return nets
+}()
+
+// HostFilter 主机过滤, 在建立连接时校验解析后的IP, 跳转和DNS重绑定都不能绕过
+type HostFilter struct {
+	allowNames []string
+	allowNets  []*net.IPNet
+	denyNames  []string
+	denyNets   []*net.IPNet
+}
+
+// CheckName 校验域名, 返回是否被allow显式允许
+func (hf *HostFilter) CheckName(host string) (bool, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if len(host) == 0 || matchHostName(hf.denyNames, host) {
+		return false, service.ErrorHostNotAllowed
+	}
+	return matchHostName(hf.allowNames, host), nil
+}
+
+// CheckIP 校验解析后的IP, named 为域名已被allow显式允许
+func (hf *HostFilter) CheckIP(ip net.IP, named bool) error {
+	if matchHostIP(hf.denyNets, ip) {
+		return service.ErrorHostNotAllowed
+	}
+	if len(hf.allowNames) > 0 || len(hf.allowNets) > 0 {
+		if named || matchHostIP(hf.allowNets, ip) {
+			return nil
+		}
+		return service.ErrorHostNotAllowed
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || matchHostIP(reservedNets, ip) {
+		return service.ErrorHostNotAllowed
+	}
+	return nil
+}
+
+// DialContext 解析域名后逐个校验IP再连接, 连接的就是校验过的IP
+func (hf *HostFilter) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if nil != err {
+		return nil, err
+	}
+	named, err := hf.CheckName(host)
+	if nil != err {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if nil != err {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	err = service.ErrorHostNotAllowed
+	for _, ip := range ips {
+		if cerr := hf.CheckIP(ip.IP, named); nil != cerr {
+			continue
+		}
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port)); nil == err {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// parseHosts 解析逗号分隔的主机列表
+func parseHosts(hosts string) (names []string, nets []*net.IPNet, err error) {
+	for _, item := range strings.Split(hosts, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); len(item) == 0 {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			_, ipnet, err := net.ParseCIDR(item)
+			if nil != err {
+				return nil, nil, err
+			}
+			nets = append(nets, ipnet)
+		} else if ip := net.ParseIP(item); nil != ip {
+			bits := 8 * net.IPv6len
+			if nil != ip.To4() {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else {
+			names = append(names, item)
+		}
+	}
+	return names, nets, nil
+}
+
+// matchHostName 域名匹配, *.example.com 匹配子域名
+func matchHostName(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if pattern == host || strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHostIP IP是否在网段内
+func matchHostIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewURLFetcher 新建下载器, timeout 为等待响应头的时间, retries 为失败后的重试次数
+func NewURLFetcher(hf *HostFilter, timeout time.Duration, retries int) *URLFetcher {
+	return &URLFetcher{
+		retries: retries,
+		backoff: time.Second,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 nil, // 不使用代理, 否则连接的是代理而不是校验过的主机
+				DialContext:           hf.DialContext,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ResponseHeaderTimeout: timeout,
+				MaxIdleConnsPerHost:   2,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 10 {
+					return errors.New("stopped after 10 redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return service.ErrorURLNotSupport
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// URLFetcher 下载器
+type URLFetcher struct {
+	client  *http.Client
+	retries int
+	backoff time.Duration // 第n次重试前等待 n*backoff
+}
+
+// Open 发起请求, 返回可以断点续传的读取流, maxSize>0 时超出大小返回 ErrorFetchTooLarge
+func (uf *URLFetcher) Open(ctx context.Context, rawURL string, maxSize int64) (*FetchReader, error) {
+	fr := &FetchReader{uf: uf, ctx: ctx, url: rawURL, size: -1, maxSize: maxSize}
+	if err := fr.open(); nil != err {
+		return nil, err
+	}
+	if maxSize > 0 && fr.size > maxSize {
+		fr.Close()
+		return nil, service.ErrorFetchTooLarge
+	}
+	return fr, nil
+}
+
+// FetchReader 下载流, 连接中断时从已读取的位置重新请求, 远程文件变化时放弃
+type FetchReader struct {
+	uf        *URLFetcher
+	ctx       context.Context
+	url       string
+	body      io.ReadCloser
+	offset    int64  // 已读取的字节数
+	size      int64  // 文件大小, -1为未知
+	maxSize   int64  // 允许的最大字节数, <=0为不限制
+	validator string // ETag|Last-Modified, 续传时用于 If-Range
+	retries   int    // 已重试次数
+}
+
+// Size 文件大小, -1为未知
+func (fr *FetchReader) Size() int64 {
+	return fr.size
+}
+
+// Retries 已重试次数
+func (fr *FetchReader) Retries() int {
+	return fr.retries
+}
+
+// Read 读取数据, 连接中断时重新连接, 超出重试次数后返回错误
+func (fr *FetchReader) Read(b []byte) (int, error) {
+	for {
+		if nil == fr.body {
+			if err := fr.open(); nil != err {
+				return 0, err
+			}
+		}
+		n, err := fr.body.Read(b)
+		fr.offset += int64(n)
+		if fr.maxSize > 0 && fr.offset > fr.maxSize {
+			return n, service.ErrorFetchTooLarge
+		}
+		if nil == err {
+			return n, nil
+		}
+		if err == io.EOF && (fr.size < 0 || fr.offset >= fr.size) {
+			return n, io.EOF
+		}
+		// 连接中断, 先返回已读取的数据, 下次读取时续传
+		fr.Suspend()
+		if nil != fr.ctx.Err() {
+			return n, fr.ctx.Err()
+		}
+		if fr.retries >= fr.uf.retries {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		fr.retries++
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Suspend 断开当前连接, 下次读取时从断点继续, 用于长时间暂停
+func (fr *FetchReader) Suspend() {
+	if nil != fr.body {
+		fr.body.Close()
+		fr.body = nil
+	}
+}
+
+// Close 关闭连接
+func (fr *FetchReader) Close() error {
+	fr.Suspend()
+	return nil
+}
+
+// open 建立连接, 失败时按重试次数重试, 远程返回4xx或不支持续传时不重试
+func (fr *FetchReader) open() error {
+	for {
+		err := fr.request()
+		if nil == err {
+			return nil
+		}
+		var serr *fetchStatusError
+		if errors.As(err, &serr) && !serr.retryable() || errors.Is(err, service.ErrorHostNotAllowed) ||
+			err == service.ErrorFetchNotResumable || fr.retries >= fr.uf.retries {
+			return err
+		}
+		fr.retries++
+		select {
+		case <-fr.ctx.Done():
+			return fr.ctx.Err()
+		case <-time.After(time.Duration(fr.retries) * fr.uf.backoff):
+		}
+	}
+}
+
+// request 发起请求, offset>0 时请求剩余部分
+func (fr *FetchReader) request() error {
+	req, err := http.NewRequestWithContext(fr.ctx, http.MethodGet, fr.url, nil)
+	if nil != err {
+		return err
+	}
+	if fr.offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(fr.offset, 10)+"-")
+		if len(fr.validator) > 0 {
+			req.Header.Set("If-Range", fr.validator)
+		}
+	}
+	resp, err := fr.uf.client.Do(req)
+	if nil != err {
+		return err
+	}
+	if fr.offset == 0 && resp.StatusCode == http.StatusOK {
+		fr.size = resp.ContentLength
+		if fr.validator = resp.Header.Get("ETag"); len(fr.validator) == 0 || strings.HasPrefix(fr.validator, "W/") {
+			fr.validator = resp.Header.Get("Last-Modified")
+		}
+	} else if fr.offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		// 续传的起点必须是断点, 文件大小不能变化
+		if start, size := parseContentRange(resp.Header.Get("Content-Range")); start != fr.offset || fr.size >= 0 && size >= 0 && size != fr.size {
+			resp.Body.Close()
+			return service.ErrorFetchNotResumable
+		}
+	} else if fr.offset > 0 && resp.StatusCode == http.StatusOK {
+		// 不支持Range或If-Range不一致(文件已变化)
+		resp.Body.Close()
+		return service.ErrorFetchNotResumable
+	} else {
+		resp.Body.Close()
+		return &fetchStatusError{code: resp.StatusCode, status: resp.Status}
+	}
+	fr.body = resp.Body
+	return nil
+}
+
+// parseContentRange 解析 bytes start-end/size, size未知时为-1
+func parseContentRange(contentRange string) (start, size int64) {
+	start, size = -1, -1
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return
+	}
+	spec := strings.SplitN(strings.TrimPrefix(contentRange, "bytes "), "/", 2)
+	if len(spec) != 2 {
+		return
+	}
+	if val, err := strconv.ParseInt(strings.SplitN(spec[0], "-", 2)[0], 10, 64); nil == err {
+		start = val
+	}
+	if val, err := strconv.ParseInt(spec[1], 10, 64); nil == err {
+		size = val
+	}
+	return
+}
+
+// fetchStatusError 远程返回的错误状态
+type fetchStatusError struct {
+	code   int
+	status string
+}
+
+func (e *fetchStatusError) Error() string {
+	return fmt.Sprintf("unexpected response status: %s", e.status)
+}
+
+// retryable 5xx和429可以重试
+func (e *fetchStatusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests
+}
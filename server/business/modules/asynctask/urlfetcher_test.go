@@ -0,0 +1,139 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package asynctask
+
+import (
+	"context"
+	"errors"
+	"fileservice/business/service"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostFilter(t *testing.T) {
+	hf, err := NewHostFilter("", "8.8.8.0/24,*.blocked.com")
+	if nil != err {
+		t.Fatal(err)
+	}
+	// 未配置allow时只允许公网地址
+	for ip, allowed := range map[string]bool{
+		"127.0.0.1": false, "10.1.2.3": false, "169.254.169.254": false, "::1": false, "0.0.0.0": false, "0.1.2.3": false,
+		"100.100.100.200": false, "64:ff9b::a9fe:a9fe": false, "8.8.8.8": false, "1.1.1.1": true,
+	} {
+		if err := hf.CheckIP(net.ParseIP(ip), false); (nil == err) != allowed {
+			t.Fatal(ip, err)
+		}
+	}
+	if _, err := hf.CheckName("a.blocked.com"); err != service.ErrorHostNotAllowed {
+		t.Fatal(err)
+	}
+	// 配置allow后只允许命中的主机, deny优先
+	hf, err = NewHostFilter("127.0.0.1,10.0.0.0/8,files.internal", "10.0.0.1")
+	if nil != err {
+		t.Fatal(err)
+	}
+	for ip, allowed := range map[string]bool{"127.0.0.1": true, "10.1.2.3": true, "10.0.0.1": false, "1.1.1.1": false} {
+		if err := hf.CheckIP(net.ParseIP(ip), false); (nil == err) != allowed {
+			t.Fatal(ip, err)
+		}
+	}
+	if named, err := hf.CheckName("files.internal"); nil != err || !named || nil != hf.CheckIP(net.ParseIP("192.168.1.1"), named) {
+		t.Fatal(named, err)
+	}
+	if _, err := NewHostFilter("10.0.0.0/33", ""); nil == err {
+		t.Fatal("invalid cidr accepted")
+	}
+}
+
+func TestURLFetcher(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var requests, drops int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/flaky":
+			// 前两次请求只返回一半数据就断开, 续传时按Range返回剩余部分
+			w.Header().Set("ETag", `"v1"`)
+			if atomic.AddInt32(&drops, 1) <= 2 {
+				start := 0
+				if rg := r.Header.Get("Range"); len(rg) > 0 {
+					start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rg, "bytes="), "-"))
+					w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-"+strconv.Itoa(len(content)-1)+"/"+strconv.Itoa(len(content)))
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+					w.WriteHeader(http.StatusPartialContent)
+				} else {
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+				}
+				w.Write([]byte(content[start : start+(len(content)-start)/2]))
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+		case "/norange":
+			// 不支持续传
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write([]byte(content[:100]))
+		case "/missing":
+			http.NotFound(w, r)
+		case "/redirect":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+		default:
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+		}
+	}))
+	defer srv.Close()
+	open := func(hf *HostFilter, path string, maxSize int64) (string, *FetchReader, error) {
+		uf := NewURLFetcher(hf, 5*time.Second, 3)
+		uf.backoff = time.Millisecond
+		fr, err := uf.Open(context.Background(), srv.URL+path, maxSize)
+		if nil != err {
+			return "", nil, err
+		}
+		defer fr.Close()
+		bs, err := io.ReadAll(fr)
+		return string(bs), fr, err
+	}
+	// 未允许的本地地址, 跳转到本地地址
+	deny, _ := NewHostFilter("", "")
+	if _, _, err := open(deny, "/", 0); !errors.Is(err, service.ErrorHostNotAllowed) {
+		t.Fatal(err)
+	}
+	allow, _ := NewHostFilter("127.0.0.1", "")
+	if _, _, err := open(allow, "/redirect", 0); !errors.Is(err, service.ErrorHostNotAllowed) {
+		t.Fatal(err)
+	}
+	// 正常下载
+	if bs, fr, err := open(allow, "/", 0); nil != err || bs != content || fr.Size() != int64(len(content)) {
+		t.Fatal(len(bs), err)
+	}
+	// 断线续传
+	if bs, fr, err := open(allow, "/flaky", 0); nil != err || bs != content || fr.Retries() != 2 {
+		t.Fatal(len(bs), fr, err)
+	}
+	// 不支持续传时失败
+	if _, _, err := open(allow, "/norange", 0); err != service.ErrorFetchNotResumable {
+		t.Fatal(err)
+	}
+	// 4xx不重试
+	atomic.StoreInt32(&requests, 0)
+	if _, _, err := open(allow, "/missing", 0); nil == err || atomic.LoadInt32(&requests) != 1 {
+		t.Fatal(err, requests)
+	}
+	// 大小限制
+	if _, _, err := open(allow, "/", 100); err != service.ErrorFetchTooLarge {
+		t.Fatal(err)
+	}
+}
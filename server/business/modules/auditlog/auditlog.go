@@ -0,0 +1,180 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 审计日志模块, 日志异步批量写库, 按保留天数定期清理
+
+package auditlog
+
+import (
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/fileutil"
+	"github.com/wup364/pakku/utils/logs"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// AuditLog 审计日志模块
+type AuditLog struct {
+	as            *AuditStory
+	queue         chan service.AuditInfo
+	retentionDays int64
+	conf          ipakku.AppConfig     `@autowired:"AppConfig"`
+	um            service.UserAuth4Rpc `@autowired:"User4RPC"`
+}
+
+// AsModule 模块加载器接口实现, 返回模块信息&配置
+func (al *AuditLog) AsModule() ipakku.Opts {
+	return ipakku.Opts{
+		Name:        "AuditLog",
+		Version:     1.0,
+		Description: "审计日志模块",
+		OnReady: func(mctx ipakku.Loader) {
+			deftDataSource := "./.datas/" + mctx.GetParam(ipakku.PARAMKEY_APPNAME).ToString("app") + ".db?cache=shared"
+			confDataSource := al.conf.GetConfig("auditlog.sotre.datasource").ToString(deftDataSource)
+			if confDataSource == deftDataSource {
+				al.mkSqliteDIR() // 创建sqlite文件存放目录
+			}
+			al.as = new(AuditStory)
+			if err := al.as.Initial(constants.DBSetting{
+				DriverName:     al.conf.GetConfig("auditlog.sotre.driver").ToString("sqlite3"),
+				DataSourceName: confDataSource,
+			}); nil != err {
+				logs.Panicln(err)
+			}
+			al.retentionDays = service.GetInt64Config(al.conf, "auditlog.retentiondays", 180)
+		},
+		OnSetup: func() {
+			// 执行建库、建表
+			if err := al.as.Install(); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnInit: func() {
+			al.queue = make(chan service.AuditInfo, 1024)
+			al.um.AddLoginEventListener(al.onLoginEvent)
+			go al.doWrite()
+			go al.doCleanup()
+		},
+	}
+}
+
+// onLoginEvent 记录登录锁定和延迟, 锁定时操作对象为被锁定的账号或IP, 延迟时为延迟时长(毫秒)
+func (al *AuditLog) onLoginEvent(event service.LoginEvent) {
+	info := service.AuditInfo{UserID: event.UserID, ClientIP: event.ClientIP, CtTime: event.Time}
+	switch event.Event {
+	case service.LoginEvent_Locked:
+		info.Action = service.AuditAction_LoginLocked
+		if info.Src = event.UserID; len(info.Src) == 0 {
+			info.Src = event.ClientIP
+		}
+		info.Result = service.ErrorLoginLocked.Error()
+	case service.LoginEvent_Throttled:
+		info.Action = service.AuditAction_LoginThrottled
+		info.Src = strconv.FormatInt(event.DelayMS, 10)
+	default:
+		return
+	}
+	al.Record(info)
+}
+
+// Record 记录一条日志
+func (al *AuditLog) Record(info service.AuditInfo) {
+	if len(info.AuditID) == 0 {
+		info.AuditID = strutil.GetUUID()
+	}
+	if info.CtTime == 0 {
+		info.CtTime = time.Now().UnixMilli()
+	}
+	if len(info.Result) == 0 {
+		info.Result = service.AuditResult_Success
+	}
+	al.queue <- info
+}
+
+// Record4Request 记录一条请求日志, userID为空时从会话中获取
+func (al *AuditLog) Record4Request(r *http.Request, userID, action, src, dst string, err error) {
+	if len(userID) == 0 {
+		if ack, aerr := al.um.GetUserAccess(al.um.GetAccessKey4Request(r)); nil == aerr {
+			userID = ack.UserID
+		}
+	}
+	info := service.AuditInfo{
+		UserID:   userID,
+		ClientIP: al.um.GetClientIP4Request(r),
+		Action:   action,
+		Src:      src,
+		Dst:      dst,
+	}
+	if nil != err {
+		info.Result = err.Error()
+	}
+	al.Record(info)
+}
+
+// QueryAudits 按条件查询日志, 按时间倒序
+func (al *AuditLog) QueryAudits(query service.AuditQuery) ([]service.AuditInfo, error) {
+	if query.Start > 0 && query.End > 0 && query.End <= query.Start {
+		return nil, service.ErrorAuditQueryRange
+	}
+	if query.Limit <= 0 || query.Limit > 1000 {
+		query.Limit = 1000
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+	return al.as.QueryAudits(query)
+}
+
+// Cleanup 删除某个时间(毫秒)之前的日志
+func (al *AuditLog) Cleanup(before int64) (int64, error) {
+	return al.as.DelAudits(before)
+}
+
+// doWrite 从队列中取出日志批量写库
+func (al *AuditLog) doWrite() {
+	for {
+		audits := []service.AuditInfo{<-al.queue}
+		for len(audits) < 100 && len(al.queue) > 0 {
+			audits = append(audits, <-al.queue)
+		}
+		if err := al.as.AddAudits(audits); nil != err {
+			logs.Errorln(err)
+		}
+	}
+}
+
+// doCleanup 每天清理一次超过保留天数的日志, 保留天数<=0时不清理
+func (al *AuditLog) doCleanup() {
+	if al.retentionDays <= 0 {
+		return
+	}
+	for {
+		before := time.Now().Add(-time.Duration(al.retentionDays) * 24 * time.Hour).UnixMilli()
+		if count, err := al.Cleanup(before); nil != err {
+			logs.Errorln(err)
+		} else if count > 0 {
+			logs.Infof("AuditLog cleanup: %d logs before %d\r\n", count, before)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// mkSqliteDIR 创建sqlite文件存放目录
+func (al *AuditLog) mkSqliteDIR() {
+	if !fileutil.IsExist("./.datas") {
+		if err := fileutil.MkdirAll("./.datas"); nil != err {
+			logs.Panicln(err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 用db存放审计日志
+
+package auditlog
+
+import (
+	"database/sql"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"strings"
+	"time"
+)
+
+// AuditStory 审计日志存储
+type AuditStory struct {
+	db *sql.DB
+}
+
+// Initial 初始化配置
+func (as *AuditStory) Initial(st constants.DBSetting) (err error) {
+	if nil == as.db {
+		as.db, err = sql.Open(st.DriverName, st.DataSourceName)
+		if nil == err {
+			if st.DriverName == "sqlite3" {
+				// database is locked
+				// https://github.com/mattn/go-sqlite3/issues/209
+				as.db.SetMaxOpenConns(1)
+			} else {
+				as.db.SetMaxIdleConns(250)
+				as.db.SetConnMaxLifetime(time.Hour)
+			}
+		}
+	}
+	return err
+}
+
+// Install 初始化 auditlogs 表
+func (as *AuditStory) Install() (err error) {
+	var tx *sql.Tx
+	if tx, err = as.db.Begin(); err == nil {
+		for _, ddl := range []string{
+			`CREATE TABLE IF NOT EXISTS auditlogs(
+				auditid VARCHAR(64) PRIMARY KEY,
+				userid VARCHAR(64) NULL,
+				clientip VARCHAR(64) NULL,
+				action VARCHAR(64) NULL,
+				src TEXT(1000) NULL,
+				dst TEXT(1000) NULL,
+				result TEXT(1000) NULL,
+				cttime BIGINT DEFAULT 0
+			);`,
+			`CREATE INDEX idx_auditlogs_cttime ON auditlogs(cttime);`,
+		} {
+			if _, err = tx.Exec(ddl); nil != err {
+				tx.Rollback()
+				return err
+			}
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// AddAudits 批量写入日志
+func (as *AuditStory) AddAudits(audits []service.AuditInfo) (err error) {
+	if len(audits) == 0 {
+		return nil
+	}
+	// 开启事务
+	var ts *sql.Tx
+	if ts, err = as.db.Begin(); err != nil {
+		return err
+	}
+	//
+	var stmt *sql.Stmt
+	if stmt, err = ts.Prepare("INSERT INTO auditlogs(auditid, userid, clientip, action, src, dst, result, cttime) values(?,?,?,?,?,?,?,?)"); err != nil {
+		ts.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, val := range audits {
+		if _, err = stmt.Exec(val.AuditID, val.UserID, val.ClientIP, val.Action, val.Src, val.Dst, val.Result, val.CtTime); err != nil {
+			ts.Rollback()
+			return err
+		}
+	}
+	return ts.Commit()
+}
+
+// QueryAudits 按条件查询日志, 按时间倒序
+func (as *AuditStory) QueryAudits(query service.AuditQuery) ([]service.AuditInfo, error) {
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+	if len(query.UserID) > 0 {
+		where = append(where, "userid = ?")
+		args = append(args, query.UserID)
+	}
+	if len(query.Action) > 0 {
+		where = append(where, "action = ?")
+		args = append(args, query.Action)
+	}
+	if len(query.Path) > 0 {
+		// 路径中的 % _ 需要转义
+		like := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(query.Path) + "/%"
+		where = append(where, "(src = ? OR src LIKE ? ESCAPE '!' OR dst = ? OR dst LIKE ? ESCAPE '!')")
+		args = append(args, query.Path, like, query.Path, like)
+	}
+	if query.Start > 0 {
+		where = append(where, "cttime >= ?")
+		args = append(args, query.Start)
+	}
+	if query.End > 0 {
+		where = append(where, "cttime < ?")
+		args = append(args, query.End)
+	}
+	sqlStr := "SELECT auditid, userid, clientip, action, src, dst, result, cttime FROM auditlogs"
+	if len(where) > 0 {
+		sqlStr += " WHERE " + strings.Join(where, " AND ")
+	}
+	sqlStr += " ORDER BY cttime DESC LIMIT ? OFFSET ?"
+	args = append(args, query.Limit, query.Offset)
+	rows, err := as.db.Query(sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]service.AuditInfo, 0)
+	for rows.Next() {
+		audit := service.AuditInfo{}
+		if err := rows.Scan(&audit.AuditID, &audit.UserID, &audit.ClientIP, &audit.Action, &audit.Src, &audit.Dst, &audit.Result, &audit.CtTime); err != nil {
+			return nil, err
+		}
+		res = append(res, audit)
+	}
+	return res, nil
+}
+
+// DelAudits 删除某个时间(毫秒)之前的日志
+func (as *AuditStory) DelAudits(before int64) (int64, error) {
+	res, err := as.db.Exec("DELETE FROM auditlogs WHERE cttime < ?", before)
+	if nil != err {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package auditlog
+
+import (
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestAuditStory(t *testing.T) {
+	as := new(AuditStory)
+	if err := as.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "audit.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if err := as.Install(); nil != err {
+		t.Fatal(err)
+	}
+	if err := as.AddAudits([]service.AuditInfo{
+		{AuditID: "1", UserID: "user01", Action: service.AuditAction_FileDelete, Src: "/a/1.txt", Result: service.AuditResult_Success, CtTime: 100},
+		{AuditID: "2", UserID: "user01", Action: service.AuditAction_FileRename, Src: "/a_b/2.txt", Dst: "/a_b/3.txt", Result: service.AuditResult_Success, CtTime: 200},
+		{AuditID: "3", UserID: "user02", Action: service.AuditAction_FileCopy, Src: "/b/1.txt", Dst: "/a", Result: service.AuditResult_Success, CtTime: 300},
+		{AuditID: "4", UserID: "user02", Action: service.AuditAction_FileMkdir, Src: "/a%", Result: "error", CtTime: 400},
+	}); nil != err {
+		t.Fatal(err)
+	}
+	check := func(query service.AuditQuery, ids ...string) {
+		t.Helper()
+		query.Limit = 100
+		audits, err := as.QueryAudits(query)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if len(audits) != len(ids) {
+			t.Fatal(query, audits)
+		}
+		for i := 0; i < len(ids); i++ {
+			if audits[i].AuditID != ids[i] {
+				t.Fatal(query, audits)
+			}
+		}
+	}
+	check(service.AuditQuery{}, "4", "3", "2", "1")
+	check(service.AuditQuery{UserID: "user01"}, "2", "1")
+	check(service.AuditQuery{Action: service.AuditAction_FileCopy}, "3")
+	// 路径匹配src或dst, 通配符不生效
+	check(service.AuditQuery{Path: "/a"}, "3", "1")
+	check(service.AuditQuery{Path: "/a_b"}, "2")
+	check(service.AuditQuery{Start: 200, End: 400}, "3", "2")
+	if count, err := as.DelAudits(300); nil != err || count != 2 {
+		t.Fatal(count, err)
+	}
+	check(service.AuditQuery{}, "4", "3")
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 文件变更日志模块, 监听 FileDatas 的修改并按单调递增的序号记录, 序号在库中分配, 超出保留条数的旧记录定期清理
+
+package changejournal
+
+import (
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"sync"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/fileutil"
+	"github.com/wup364/pakku/utils/logs"
+)
+
+// ChangeJournal 文件变更日志模块
+type ChangeJournal struct {
+	cs         *ChangeStory
+	lock       sync.Mutex
+	firstSeq   int64 // 本实例已知的最小序号, 仅用于判断何时清理
+	maxEntries int64
+	conf       ipakku.AppConfig  `@autowired:"AppConfig"`
+	fm         service.FileDatas `@autowired:"FileDatas"`
+}
+
+// AsModule 模块加载器接口实现, 返回模块信息&配置
+func (m *ChangeJournal) AsModule() ipakku.Opts {
+	return ipakku.Opts{
+		Name:        "ChangeJournal",
+		Version:     1.0,
+		Description: "文件变更日志模块",
+		OnReady: func(mctx ipakku.Loader) {
+			deftDataSource := "./.datas/" + mctx.GetParam(ipakku.PARAMKEY_APPNAME).ToString("app") + ".db?cache=shared"
+			confDataSource := m.conf.GetConfig("changejournal.sotre.datasource").ToString(deftDataSource)
+			if confDataSource == deftDataSource {
+				m.mkSqliteDIR() // 创建sqlite文件存放目录
+			}
+			m.cs = new(ChangeStory)
+			if err := m.cs.Initial(constants.DBSetting{
+				DriverName:     m.conf.GetConfig("changejournal.sotre.driver").ToString("sqlite3"),
+				DataSourceName: confDataSource,
+			}); nil != err {
+				logs.Panicln(err)
+			}
+			m.maxEntries = service.GetInt64Config(m.conf, "changejournal.maxentries", 100000)
+		},
+		OnSetup: func() {
+			// 执行建库、建表
+			if err := m.cs.Install(); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnInit: func() {
+			if err := m.start(); nil != err {
+				logs.Panicln(err)
+			}
+			m.fm.AddEventListener(m.onFileEvent)
+		},
+	}
+}
+
+// LastSeq 当前最大序号, 从库中读取, 包含其他实例写入的记录
+func (m *ChangeJournal) LastSeq() int64 {
+	_, lastSeq, err := m.cs.SeqRange()
+	if nil != err {
+		logs.Errorln(err)
+	}
+	return lastSeq
+}
+
+// ListChanges 返回序号大于since的变更, since之后的记录已被清理或since超出当前序号时resync为true
+func (m *ChangeJournal) ListChanges(since int64, limit int) ([]service.ChangeInfo, bool, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+	firstSeq, lastSeq, err := m.cs.SeqRange()
+	if nil != err {
+		return nil, false, err
+	}
+	if since < 0 || since > lastSeq || (firstSeq > 0 && since < firstSeq-1) {
+		return make([]service.ChangeInfo, 0), true, nil
+	}
+	changes, err := m.cs.ListChanges(since, limit)
+	if nil != err {
+		return nil, false, err
+	}
+	// 查询期间被清理
+	if len(changes) > 0 && changes[0].Seq > since+1 {
+		return make([]service.ChangeInfo, 0), true, nil
+	}
+	return changes, false, nil
+}
+
+// start 读取当前最小序号
+func (m *ChangeJournal) start() (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.firstSeq, _, err = m.cs.SeqRange()
+	return err
+}
+
+// onFileEvent 文件变化回调, 序号由库在写入时分配; 本实例的事件在锁内依次写入, 保证序号顺序与事件顺序一致
+func (m *ChangeJournal) onFileEvent(event service.FileEvent) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	seq, err := m.cs.AddChange(service.ChangeInfo{
+		Event:  event.Event,
+		Path:   event.Path,
+		Dst:    event.Dst,
+		CtTime: event.Time,
+	})
+	if nil != err {
+		logs.Errorln(err)
+		return
+	}
+	if m.firstSeq == 0 {
+		m.firstSeq = seq
+	}
+	// 超出保留条数的10%时清理, 避免每次写入都删除; 其他实例可能已清理过, 重复删除没有影响
+	if m.maxEntries > 0 && seq-m.firstSeq+1 > m.maxEntries+m.maxEntries/10 {
+		if err := m.cs.Truncate(seq - m.maxEntries); nil != err {
+			logs.Errorln(err)
+		} else {
+			m.firstSeq = seq - m.maxEntries + 1
+		}
+	}
+}
+
+// mkSqliteDIR 创建sqlite文件存放目录
+func (m *ChangeJournal) mkSqliteDIR() {
+	if !fileutil.IsExist("./.datas") {
+		if err := fileutil.MkdirAll("./.datas"); nil != err {
+			logs.Panicln(err)
+		}
+	}
+}
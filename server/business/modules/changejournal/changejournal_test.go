@@ -0,0 +1,94 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package changejournal
+
+import (
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestChangeJournal(t *testing.T) {
+	cs := new(ChangeStory)
+	if err := cs.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "changes.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if err := cs.Install(); nil != err {
+		t.Fatal(err)
+	}
+	m := &ChangeJournal{cs: cs, maxEntries: 10}
+	if err := m.start(); nil != err {
+		t.Fatal(err)
+	}
+	// 空日志
+	if changes, resync, err := m.ListChanges(0, 0); nil != err || resync || len(changes) != 0 {
+		t.Fatal(changes, resync, err)
+	}
+	for i := 1; i <= 5; i++ {
+		m.onFileEvent(service.FileEvent{Event: service.FileEvent_Created, Path: "/a/" + strconv.Itoa(i)})
+	}
+	m.onFileEvent(service.FileEvent{Event: service.FileEvent_Moved, Path: "/a/5", Dst: "/b/5"})
+	if m.LastSeq() != 6 {
+		t.Fatal(m.LastSeq())
+	}
+	changes, resync, err := m.ListChanges(2, 3)
+	if nil != err || resync || len(changes) != 3 || changes[0].Seq != 3 || changes[2].Seq != 5 {
+		t.Fatal(changes, resync, err)
+	}
+	if changes, _, _ = m.ListChanges(5, 0); len(changes) != 1 || changes[0].Dst != "/b/5" {
+		t.Fatal(changes)
+	}
+	// 序号超出当前范围
+	if _, resync, _ = m.ListChanges(7, 0); !resync {
+		t.Fatal("expect resync")
+	}
+	// 超出保留条数的10%后清理到只剩10条: 12条时清理1-2
+	for i := 7; i <= 12; i++ {
+		m.onFileEvent(service.FileEvent{Event: service.FileEvent_Deleted, Path: "/a/" + strconv.Itoa(i)})
+	}
+	if first, last, err := cs.SeqRange(); nil != err || first != 3 || last != 12 {
+		t.Fatal(first, last, err)
+	}
+	if _, resync, _ = m.ListChanges(1, 0); !resync {
+		t.Fatal("expect resync")
+	}
+	if changes, resync, _ = m.ListChanges(2, 0); resync || len(changes) != 10 {
+		t.Fatal(changes, resync)
+	}
+	// 重启后序号继续递增
+	m = &ChangeJournal{cs: cs, maxEntries: 10}
+	if err := m.start(); nil != err {
+		t.Fatal(err)
+	}
+	m.onFileEvent(service.FileEvent{Event: service.FileEvent_Modified, Path: "/a/1"})
+	if changes, resync, _ = m.ListChanges(12, 0); resync || len(changes) != 1 || changes[0].Seq != 13 {
+		t.Fatal(changes, resync)
+	}
+	// 两个实例共用一个库, 序号在库中分配, 交替写入不重复, 彼此都能读到
+	m2 := &ChangeJournal{cs: cs, maxEntries: 10}
+	if err := m2.start(); nil != err {
+		t.Fatal(err)
+	}
+	m2.onFileEvent(service.FileEvent{Event: service.FileEvent_Modified, Path: "/a/2"})
+	m.onFileEvent(service.FileEvent{Event: service.FileEvent_Modified, Path: "/a/3"})
+	if m.LastSeq() != 15 || m2.LastSeq() != 15 {
+		t.Fatal(m.LastSeq(), m2.LastSeq())
+	}
+	if changes, resync, _ = m2.ListChanges(13, 0); resync || len(changes) != 2 || changes[0].Path != "/a/2" || changes[1].Path != "/a/3" {
+		t.Fatal(changes, resync)
+	}
+}
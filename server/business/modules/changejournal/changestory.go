@@ -0,0 +1,130 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 用db存放文件变更记录
+
+package changejournal
+
+import (
+	"database/sql"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"time"
+)
+
+// ChangeStory 变更记录存储
+type ChangeStory struct {
+	db *sql.DB
+}
+
+// Initial 初始化配置
+func (cs *ChangeStory) Initial(st constants.DBSetting) (err error) {
+	if nil == cs.db {
+		cs.db, err = sql.Open(st.DriverName, st.DataSourceName)
+		if nil == err {
+			if st.DriverName == "sqlite3" {
+				// database is locked
+				// https://github.com/mattn/go-sqlite3/issues/209
+				cs.db.SetMaxOpenConns(1)
+			} else {
+				cs.db.SetMaxIdleConns(250)
+				cs.db.SetConnMaxLifetime(time.Hour)
+			}
+		}
+	}
+	return err
+}
+
+// Install 初始化 filechanges 表
+func (cs *ChangeStory) Install() (err error) {
+	var tx *sql.Tx
+	if tx, err = cs.db.Begin(); err == nil {
+		for _, ddl := range []string{
+			`CREATE TABLE IF NOT EXISTS filechanges(
+				seq BIGINT PRIMARY KEY,
+				event VARCHAR(32) NULL,
+				path VARCHAR(1000) NULL,
+				dst VARCHAR(1000) NULL,
+				cttime BIGINT DEFAULT 0
+			);`,
+		} {
+			if _, err = tx.Exec(ddl); nil != err {
+				tx.Rollback()
+				return err
+			}
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// AddChange 添加变更记录, 在事务内取当前最大序号+1作为新序号, 多个实例共用一个库时序号也不会重复;
+// 并发写入时主键冲突会重试
+func (cs *ChangeStory) AddChange(ch service.ChangeInfo) (seq int64, err error) {
+	for i := 0; i < 3; i++ {
+		if seq, err = cs.addChange(ch); nil == err {
+			return seq, nil
+		}
+	}
+	return 0, err
+}
+
+// addChange 分配序号并写入
+func (cs *ChangeStory) addChange(ch service.ChangeInfo) (int64, error) {
+	tx, err := cs.db.Begin()
+	if nil != err {
+		return 0, err
+	}
+	var last sql.NullInt64
+	if err = tx.QueryRow("SELECT MAX(seq) FROM filechanges").Scan(&last); nil != err {
+		tx.Rollback()
+		return 0, err
+	}
+	seq := last.Int64 + 1
+	if _, err = tx.Exec("INSERT INTO filechanges(seq, event, path, dst, cttime) values(?,?,?,?,?)",
+		seq, ch.Event, ch.Path, ch.Dst, ch.CtTime); nil != err {
+		tx.Rollback()
+		return 0, err
+	}
+	return seq, tx.Commit()
+}
+
+// ListChanges 列出序号大于since的变更, 按序号排序
+func (cs *ChangeStory) ListChanges(since int64, limit int) ([]service.ChangeInfo, error) {
+	rows, err := cs.db.Query("SELECT seq, event, path, dst, cttime FROM filechanges WHERE seq > ? ORDER BY seq LIMIT ?", since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]service.ChangeInfo, 0)
+	for rows.Next() {
+		ch := service.ChangeInfo{}
+		if err := rows.Scan(&ch.Seq, &ch.Event, &ch.Path, &ch.Dst, &ch.CtTime); err != nil {
+			return nil, err
+		}
+		res = append(res, ch)
+	}
+	return res, nil
+}
+
+// SeqRange 最小和最大序号, 没有记录时返回0
+func (cs *ChangeStory) SeqRange() (min int64, max int64, err error) {
+	var nmin, nmax sql.NullInt64
+	if err = cs.db.QueryRow("SELECT MIN(seq), MAX(seq) FROM filechanges").Scan(&nmin, &nmax); nil != err {
+		return 0, 0, err
+	}
+	return nmin.Int64, nmax.Int64, nil
+}
+
+// Truncate 删除序号小于等于seq的记录
+func (cs *ChangeStory) Truncate(seq int64) error {
+	_, err := cs.db.Exec("DELETE FROM filechanges WHERE seq <= ?", seq)
+	return err
+}
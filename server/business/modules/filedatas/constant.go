@@ -16,4 +16,15 @@ const (
 	CONFKEY_MOUNTTYPE = "type"
 	// CONFKEY_MOUNTADDR CONFKEY_MOUNTADDR
 	CONFKEY_MOUNTADDR = "addr"
+	// CONFKEY_SOTREDRIVER 配置文件 - 摘要缓存数据库驱动
+	CONFKEY_SOTREDRIVER = "filedatas.sotre.driver"
+	// CONFKEY_SOTREDATASOURCE 配置文件 - 摘要缓存数据库连接
+	CONFKEY_SOTREDATASOURCE = "filedatas.sotre.datasource"
+	// CONFKEY_HASHALGO 配置文件 - 摘要算法 sha256|sha1|md5
+	CONFKEY_HASHALGO = "filedatas.hash.algorithm"
+	// CONFKEY_HASHONWRITE 配置文件 - 是否在写入时计算摘要
+	CONFKEY_HASHONWRITE = "filedatas.hash.onwrite"
 )
+
+// maxConflictRename 改名策略最多尝试的候选数量
+const maxConflictRename = 1000
@@ -13,34 +13,72 @@ package filedatas
 
 import (
 	"errors"
+	"fileservice/business/constants"
 	"fileservice/business/modules/filedatas/dirmount"
 	"fileservice/business/modules/filedatas/fsdrivers"
 	"fileservice/business/modules/filedatas/ifiledatas"
 	"fileservice/business/service"
 	"io"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/fileutil"
 	"github.com/wup364/pakku/utils/logs"
 	"github.com/wup364/pakku/utils/strutil"
 )
 
 // FileDatas 文件数据管理
 type FileDatas struct {
-	mt ifiledatas.DIRMount
-	c  ipakku.AppConfig `@autowired:"AppConfig"`
+	mt          ifiledatas.DIRMount
+	c           ipakku.AppConfig `@autowired:"AppConfig"`
+	lock        sync.RWMutex
+	listeners   []service.FileEventListener
+	hs          *HashStory
+	hashAlgo    string // 摘要算法
+	hashOnWrite bool   // 是否在写入时计算摘要
+	wlock       sync.Mutex
+	writing     map[string]bool      // 不覆盖写入时预占的目标路径
+	plocks      map[string]*pathLock // 正在提交写入的路径
+}
+
+// pathLock 路径锁, refs为0时从map中移除
+type pathLock struct {
+	sync.Mutex
+	refs int
 }
 
 // AsModule 模块加载器接口实现, 返回模块信息&配置
 func (fns *FileDatas) AsModule() ipakku.Opts {
 	return ipakku.Opts{
 		Name:        "FileDatas",
-		Version:     1.0,
+		Version:     1.1,
 		Description: "数据存取模块",
 		OnReady: func(mctx ipakku.Loader) {
-
+			deftDataSource := "./.datas/" + mctx.GetParam(ipakku.PARAMKEY_APPNAME).ToString("app") + ".db?cache=shared"
+			confDataSource := fns.c.GetConfig(CONFKEY_SOTREDATASOURCE).ToString(deftDataSource)
+			if confDataSource == deftDataSource {
+				fns.mkSqliteDIR() // 创建sqlite文件存放目录
+			}
+			fns.hs = new(HashStory)
+			if err := fns.hs.Initial(constants.DBSetting{
+				DriverName:     fns.c.GetConfig(CONFKEY_SOTREDRIVER).ToString("sqlite3"),
+				DataSourceName: confDataSource,
+			}); nil != err {
+				logs.Panicln(err)
+			}
+			fns.hashAlgo = strings.ToLower(fns.c.GetConfig(CONFKEY_HASHALGO).ToString("sha256"))
+			if nil == newHasher(fns.hashAlgo) {
+				logs.Panicln("unsupported hash algorithm: " + fns.hashAlgo)
+			}
+			fns.hashOnWrite = fns.c.GetConfig(CONFKEY_HASHONWRITE).ToBool(true)
 		},
 		OnSetup: func() {
+			// 执行建表
+			if err := fns.hs.Install(); nil != err {
+				logs.Panicln(err)
+			}
 			mount := fns.c.GetConfig(CONFKEY_MOUNT).ToStrMap(make(map[string]interface{}))
 			if len(mount) == 0 {
 				fns.c.SetConfig(CONFKEY_MOUNT+"./."+CONFKEY_MOUNTTYPE, "LOCAL")
@@ -48,6 +86,14 @@ func (fns *FileDatas) AsModule() ipakku.Opts {
 				logs.Infoln("Add mount: type=LOCAL, addr=./datas")
 			}
 		},
+		OnUpdate: func(cv float64) {
+			// 1.1 新增文件摘要缓存表
+			if cv < 1.1 {
+				if err := fns.hs.Install(); nil != err {
+					logs.Panicln(err)
+				}
+			}
+		},
 		OnInit: func() {
 			mount := fns.c.GetConfig(CONFKEY_MOUNT).ToStrMap(make(map[string]interface{}))
 			if len(mount) == 0 {
@@ -75,7 +121,12 @@ func (fns *FileDatas) DoRename(relativePath, newName string) error {
 	if nil != err {
 		return err
 	}
-	return fs.DoRename(relativePath, newName)
+	srcHash := fns.getValidHash(relativePath)
+	if err = fs.DoRename(relativePath, newName); nil == err {
+		fns.afterMove(relativePath, strutil.Parse2UnixPath(strutil.GetPathParent(relativePath)+"/"+newName), srcHash)
+		fns.emit(service.FileEvent_Moved, relativePath, strutil.Parse2UnixPath(strutil.GetPathParent(relativePath)+"/"+newName))
+	}
+	return err
 }
 
 // DoMkDir DoMkDir
@@ -84,7 +135,10 @@ func (fns *FileDatas) DoMkDir(relativePath string) error {
 	if nil != err {
 		return err
 	}
-	return fs.DoMkDir(relativePath)
+	if err = fs.DoMkDir(relativePath); nil == err {
+		fns.emit(service.FileEvent_Created, relativePath, "")
+	}
+	return err
 }
 
 // DoDelete 删除文件|文件夹
@@ -93,7 +147,11 @@ func (fns *FileDatas) DoDelete(relativePath string) error {
 	if nil != err {
 		return err
 	}
-	return fs.DoDelete(relativePath)
+	if err = fs.DoDelete(relativePath); nil == err {
+		fns.delHash(relativePath)
+		fns.emit(service.FileEvent_Deleted, relativePath, "")
+	}
+	return err
 }
 
 // DoMove 移动文件|文件夹
@@ -106,7 +164,12 @@ func (fns *FileDatas) DoMove(src, dst string, replace bool) error {
 	if nil != err {
 		return err
 	}
-	return fs.DoMove(src, dst, replace)
+	srcHash := fns.getValidHash(src)
+	if err = fs.DoMove(src, dst, replace); nil == err {
+		fns.afterMove(src, dst, srcHash)
+		fns.emit(service.FileEvent_Moved, src, dst)
+	}
+	return err
 }
 
 // DoCopy 复制文件|夹
@@ -119,16 +182,179 @@ func (fns *FileDatas) DoCopy(src, dst string, replace bool) error {
 	if nil != err {
 		return err
 	}
-	return fs.DoCopy(src, dst, replace)
+	srcHash := fns.getValidHash(src)
+	if err = fs.DoCopy(src, dst, replace); nil == err {
+		fns.afterCopy(dst, srcHash)
+		fns.emit(service.FileEvent_Created, dst, "")
+	}
+	return err
 }
 
 // DoWrite 写入文件
 func (fns *FileDatas) DoWrite(relativePath string, ioReader io.Reader) error {
+	_, err := fns.DoWriteWithPolicy(relativePath, ioReader, service.WriteConflict_Overwrite)
+	return err
+}
+
+// DoWriteWithPolicy 按冲突策略写入文件, 返回最终路径
+func (fns *FileDatas) DoWriteWithPolicy(relativePath string, ioReader io.Reader, policy string) (string, error) {
+	return fns.DoWriteWithCheck(relativePath, ioReader, policy, nil)
+}
+
+// DoWriteWithCheck 按冲突策略写入文件, 提交前在路径锁内用目标当前的信息(不存在时为nil)调用check, 返回错误时放弃写入;
+// 同一路径的提交依次进行, 检查和提交之间不会插入其他写入. 驱动未实现 CheckedWriter 时, 有check的写入全程持有路径锁
+func (fns *FileDatas) DoWriteWithCheck(relativePath string, ioReader io.Reader, policy string, check func(node *service.FNode) error) (string, error) {
+	if !service.IsWriteConflictPolicy(policy) {
+		return "", service.ErrorConflictPolicyNotSupport
+	}
 	fs, err := fns.getPathDriver(relativePath)
 	if nil != err {
-		return err
+		return "", err
+	}
+	event := service.FileEvent_Created
+	if policy == service.WriteConflict_Overwrite && fs.IsExist(relativePath) {
+		event = service.FileEvent_Modified
+	}
+	ioReader, hasher := fns.hashReader(ioReader)
+	var unlock func()
+	commitCheck := func() error {
+		unlock = fns.lockPath(relativePath)
+		if nil == check {
+			return nil
+		}
+		return check(fns.getDriverNode(fs, relativePath))
+	}
+	dst := relativePath
+	if cw, ok := fs.(ifiledatas.CheckedWriter); ok {
+		dst, err = cw.DoWriteWithCheck(relativePath, ioReader, policy, commitCheck)
+	} else if nil == check {
+		dst, err = fns.writeConflict(fs, relativePath, ioReader, policy)
+	} else if err = commitCheck(); nil == err {
+		dst, err = fns.writeConflict(fs, relativePath, ioReader, policy)
+	}
+	if nil != unlock {
+		unlock()
+	}
+	if err == ifiledatas.ErrorFileExist {
+		err = service.ErrorFileExist
+	}
+	if nil == err {
+		fns.afterWrite(dst, hasher)
+		fns.emit(event, dst, "")
+	}
+	return dst, err
+}
+
+// writeConflict 按冲突策略写入, 驱动实现了 ConflictWriter 时交给驱动处理
+func (fns *FileDatas) writeConflict(fs ifiledatas.FileDriver, relativePath string, ioReader io.Reader, policy string) (string, error) {
+	if cw, ok := fs.(ifiledatas.ConflictWriter); ok {
+		return cw.DoWriteWithPolicy(relativePath, ioReader, policy)
+	}
+	return fns.writeWithPolicy(fs, relativePath, ioReader, policy)
+}
+
+// getDriverNode 从驱动读取文件基本信息, 不计算摘要, 不存在时返回nil
+func (fns *FileDatas) getDriverNode(fs ifiledatas.FileDriver, relativePath string) *service.FNode {
+	node := fs.GetNode(relativePath)
+	if nil == node {
+		return nil
+	}
+	return &service.FNode{
+		Path:   node.Path,
+		IsDir:  node.IsDir,
+		IsFile: node.IsFile,
+		Mtime:  node.Mtime,
+		Size:   node.Size,
+		Hash:   node.Hash,
+	}
+}
+
+// lockPath 锁定路径, 返回解锁函数
+func (fns *FileDatas) lockPath(relativePath string) func() {
+	relativePath = strutil.Parse2UnixPath(relativePath)
+	fns.wlock.Lock()
+	if nil == fns.plocks {
+		fns.plocks = make(map[string]*pathLock)
+	}
+	pl, ok := fns.plocks[relativePath]
+	if !ok {
+		pl = new(pathLock)
+		fns.plocks[relativePath] = pl
+	}
+	pl.refs++
+	fns.wlock.Unlock()
+	pl.Lock()
+	return func() {
+		pl.Unlock()
+		fns.wlock.Lock()
+		if pl.refs--; pl.refs == 0 {
+			delete(fns.plocks, relativePath)
+		}
+		fns.wlock.Unlock()
+	}
+}
+
+// writeWithPolicy 驱动未实现 ConflictWriter 时, 在本进程内预占目标名称后写入
+func (fns *FileDatas) writeWithPolicy(fs ifiledatas.FileDriver, relativePath string, ioReader io.Reader, policy string) (string, error) {
+	if policy == service.WriteConflict_Overwrite {
+		return relativePath, fs.DoWrite(relativePath, ioReader)
+	}
+	dst, err := fns.reserveWrite(fs, relativePath, policy)
+	if nil != err {
+		return "", err
+	}
+	defer fns.releaseWrite(dst)
+	return dst, fs.DoWrite(dst, ioReader)
+}
+
+// reserveWrite 选取一个不存在且未被占用的目标名称
+func (fns *FileDatas) reserveWrite(fs ifiledatas.FileDriver, relativePath string, policy string) (string, error) {
+	fns.wlock.Lock()
+	defer fns.wlock.Unlock()
+	if nil == fns.writing {
+		fns.writing = make(map[string]bool)
+	}
+	for i := 0; i < maxConflictRename; i++ {
+		dst := relativePath
+		if i > 0 {
+			dst = ifiledatas.ConflictName(relativePath, i)
+		}
+		if !fns.writing[dst] && !fs.IsExist(dst) {
+			fns.writing[dst] = true
+			return dst, nil
+		}
+		if policy != service.WriteConflict_Rename {
+			return "", service.ErrorFileExist
+		}
+	}
+	return "", errors.New("too many files with the same name: " + relativePath)
+}
+
+// releaseWrite 释放预占的目标名称
+func (fns *FileDatas) releaseWrite(dst string) {
+	fns.wlock.Lock()
+	defer fns.wlock.Unlock()
+	delete(fns.writing, dst)
+}
+
+// AddEventListener 监听文件变化, 在操作成功后同步回调
+func (fns *FileDatas) AddEventListener(listener service.FileEventListener) {
+	fns.lock.Lock()
+	defer fns.lock.Unlock()
+	fns.listeners = append(fns.listeners, listener)
+}
+
+// emit 通知文件变化
+func (fns *FileDatas) emit(event, src, dst string) {
+	fns.lock.RLock()
+	defer fns.lock.RUnlock()
+	if len(fns.listeners) == 0 {
+		return
+	}
+	fe := service.FileEvent{Event: event, Path: src, Dst: dst, Time: time.Now().UnixMilli()}
+	for _, listener := range fns.listeners {
+		listener(fe)
 	}
-	return fs.DoWrite(relativePath, ioReader)
 }
 
 // DoRead 读取文件
@@ -140,6 +366,25 @@ func (fns *FileDatas) DoRead(relativePath string, offset int64) (io.ReadCloser,
 	return fs.DoRead(relativePath, offset)
 }
 
+// DoReadRange 从offset开始读取length个字节, length<0时读取到结尾; 驱动未实现 RangeReader 时使用 DoRead 截取
+func (fns *FileDatas) DoReadRange(relativePath string, offset, length int64) (io.ReadCloser, error) {
+	fs, err := fns.getPathDriver(relativePath)
+	if nil != err {
+		return nil, err
+	}
+	if length < 0 {
+		return fs.DoRead(relativePath, offset)
+	}
+	if rr, ok := fs.(ifiledatas.RangeReader); ok {
+		return rr.DoReadRange(relativePath, offset, length)
+	}
+	fr, err := fs.DoRead(relativePath, offset)
+	if nil != err {
+		return nil, err
+	}
+	return ifiledatas.NewLimitReadCloser(fr, length), nil
+}
+
 // IsFile 是否是文件, 如果路径不对或者驱动不对则为 false
 func (fns *FileDatas) IsFile(relativePath string) bool {
 	fs, err := fns.getPathDriver(relativePath)
@@ -192,13 +437,16 @@ func (fns *FileDatas) GetNode(relativePath string) *service.FNode {
 		return nil
 	}
 	if node := fs.GetNode(relativePath); nil != node {
-		return &service.FNode{
+		res := []service.FNode{{
 			Path:   node.Path,
 			IsDir:  node.IsDir,
 			IsFile: node.IsFile,
 			Mtime:  node.Mtime,
 			Size:   node.Size,
-		}
+			Hash:   node.Hash,
+		}}
+		fns.fillHash(res)
+		return &res[0]
 	}
 	return nil
 }
@@ -228,8 +476,10 @@ func (fns *FileDatas) GetNodes(src []string, ignoreNotIsExist bool) (res []servi
 				IsFile: merge[i].IsFile,
 				Mtime:  merge[i].Mtime,
 				Size:   merge[i].Size,
+				Hash:   merge[i].Hash,
 			}
 		}
+		fns.fillHash(res)
 	} else {
 		return res, err
 	}
@@ -280,6 +530,7 @@ func (fns *FileDatas) GetDirNodeList(relativePath string, limit int, offset int)
 						IsFile: nodes[i].IsFile,
 						Mtime:  nodes[i].Mtime,
 						Size:   nodes[i].Size,
+						Hash:   nodes[i].Hash,
 					}
 					if node.IsFile {
 						files = append(files, node)
@@ -287,6 +538,7 @@ func (fns *FileDatas) GetDirNodeList(relativePath string, limit int, offset int)
 						folders = append(folders, node)
 					}
 				}
+				fns.fillHash(files)
 				// 合并
 				res = append(res, folders...)
 				res = append(res, files...)
@@ -296,6 +548,15 @@ func (fns *FileDatas) GetDirNodeList(relativePath string, limit int, offset int)
 	return res, err
 }
 
+// mkSqliteDIR 创建sqlite文件存放目录
+func (fns *FileDatas) mkSqliteDIR() {
+	if !fileutil.IsExist("./.datas") {
+		if err := fileutil.MkdirAll("./.datas"); nil != err {
+			logs.Panicln(err)
+		}
+	}
+}
+
 // groupPathByDriver 根据驱动类型分类
 func (fns *FileDatas) groupPathByDriver(srcList []string) (map[string][]string, error) {
 	result := make(map[string][]string)
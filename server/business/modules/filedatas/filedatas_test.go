@@ -12,13 +12,19 @@
 package filedatas
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fileservice/business/service"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 
+	_ "github.com/mattn/go-sqlite3"
+
 	"github.com/wup364/pakku"
 	"github.com/wup364/pakku/ipakku"
 	"github.com/wup364/pakku/modules/appconfig"
@@ -28,6 +34,15 @@ import (
 )
 
 func TestFileDatas(t *testing.T) {
+	// 在临时目录启动, 上次运行留下的配置会跳过安装, 新库里就没有摘要表
+	wd, err := os.Getwd()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); nil != err {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
 	app := pakku.NewApplication("filedatas-test").EnableCoreModule().BootStart()
 	var conf ipakku.AppConfig
 	app.GetModuleByName(new(appconfig.AppConfig).AsModule().Name, &conf)
@@ -37,6 +52,7 @@ func TestFileDatas(t *testing.T) {
 	conf.SetConfig(CONFKEY_MOUNT+"./test."+CONFKEY_MOUNTTYPE, "LOCAL")
 	conf.SetConfig(CONFKEY_MOUNT+"./test."+CONFKEY_MOUNTADDR, os.TempDir()+"/"+app.GetInstanceID())
 	// conf.SetConfig(CONFKEY_MOUNT+"./test."+CONFKEY_MOUNTADDR, "D:\\"+app.GetInstanceID())
+	conf.SetConfig(CONFKEY_SOTREDATASOURCE, filepath.Join(t.TempDir(), "x.db"))
 	// 获取对象
 	var fsm service.FileDatas
 	app.LoadModule(new(FileDatas)).GetModuleByName(new(FileDatas).AsModule().Name, &fsm)
@@ -51,13 +67,114 @@ func TestFileDatas(t *testing.T) {
 	// DoWrite
 	writeFile := wkdir + "/txt.txt"
 	defer fsm.DoDelete(writeFile)
-	err := fsm.DoWrite(writeFile, strings.NewReader(strutil.GetUUID()))
+	err = fsm.DoWrite(writeFile, strings.NewReader(strutil.GetUUID()))
 	checkErr(err)
 	wkdirs := fsm.GetDirList(wkdir, -1, -1)
 	if len(wkdirs) == 0 {
 		panic("DoWrite: " + writeFile + " failed")
 	}
 	fmt.Println(wkdirs)
+	// DoReadRange
+	rangeFile := wkdir + "/range.txt"
+	defer fsm.DoDelete(rangeFile)
+	checkErr(fsm.DoWrite(rangeFile, strings.NewReader("0123456789")))
+	for _, c := range [][]interface{}{{int64(2), int64(3), "234"}, {int64(8), int64(10), "89"}, {int64(8), int64(-1), "89"}, {int64(4), int64(0), ""}} {
+		fr, err := fsm.DoReadRange(rangeFile, c[0].(int64), c[1].(int64))
+		checkErr(err)
+		bs, err := io.ReadAll(fr)
+		fr.Close()
+		checkErr(err)
+		if string(bs) != c[2].(string) {
+			logs.Panicln("DoReadRange failed", c, string(bs))
+		}
+	}
+	// 冲突策略: fail不覆盖, rename生成 name (n).ext, 并发写入同名文件时各自得到不同路径
+	if _, err := fsm.DoWriteWithPolicy(rangeFile, strings.NewReader("x"), service.WriteConflict_Fail); err != service.ErrorFileExist {
+		logs.Panicln("conflict fail policy failed", err)
+	}
+	if bs, _ := readAll(fsm, rangeFile); bs != "0123456789" {
+		logs.Panicln("conflict fail policy overwrote the file", bs)
+	}
+	renamed := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			dst, err := fsm.DoWriteWithPolicy(rangeFile, strings.NewReader(strconv.Itoa(i)), service.WriteConflict_Rename)
+			checkErr(err)
+			renamed <- dst
+		}(i)
+	}
+	for i := 0; i < 3; i++ {
+		dst := <-renamed
+		defer fsm.DoDelete(dst)
+	}
+	for i := 1; i <= 3; i++ {
+		if dst := wkdir + "/range (" + strconv.Itoa(i) + ").txt"; !fsm.IsFile(dst) {
+			logs.Panicln("conflict rename policy failed", dst)
+		}
+	}
+	if dst, err := fsm.DoWriteWithPolicy(wkdir+"/.hidden", strings.NewReader("x"), service.WriteConflict_Rename); nil != err || dst != wkdir+"/.hidden" {
+		logs.Panicln("conflict rename policy failed", dst, err)
+	} else if dst, err = fsm.DoWriteWithPolicy(dst, strings.NewReader("x"), service.WriteConflict_Rename); nil != err || dst != wkdir+"/.hidden (1)" {
+		logs.Panicln("conflict rename policy failed", dst, err)
+	}
+	defer fsm.DoDelete(wkdir + "/.hidden")
+	defer fsm.DoDelete(wkdir + "/.hidden (1)")
+	// 提交前判断条件: 上传期间文件被其他请求修改时放弃写入, 保留对方的修改
+	condFile := wkdir + "/cond.txt"
+	defer fsm.DoDelete(condFile)
+	checkErr(fsm.DoWrite(condFile, strings.NewReader("v1")))
+	before := fsm.GetNode(condFile)
+	errChanged := errors.New("changed")
+	unchanged := func(node *service.FNode) error {
+		if nil == node || node.Size != before.Size || node.Mtime != before.Mtime {
+			return errChanged
+		}
+		return nil
+	}
+	changer := &eofReader{Reader: strings.NewReader("v2"), onEOF: func() {
+		checkErr(fsm.DoWrite(condFile, strings.NewReader("other")))
+	}}
+	if _, err := fsm.DoWriteWithCheck(condFile, changer, service.WriteConflict_Overwrite, unchanged); err != errChanged {
+		logs.Panicln("check before commit failed", err)
+	}
+	if bs, _ := readAll(fsm, condFile); bs != "other" {
+		logs.Panicln("check before commit overwrote the file", bs)
+	}
+	before = fsm.GetNode(condFile)
+	if _, err := fsm.DoWriteWithCheck(condFile, strings.NewReader("v3"), service.WriteConflict_Overwrite, unchanged); nil != err {
+		logs.Panicln("check before commit failed", err)
+	}
+	if bs, _ := readAll(fsm, condFile); bs != "v3" {
+		logs.Panicln("check before commit failed", bs)
+	}
+	// 摘要: 写入时计算, 复制后复用, 缓存失效后即时计算
+	rangeHash := fmt.Sprintf("%x", sha256.Sum256([]byte("0123456789")))
+	if node := fsm.GetNode(rangeFile); nil == node || node.Hash != rangeHash {
+		logs.Panicln("hash on write failed", node)
+	}
+	hashFile := wkdir + "/hash.txt"
+	defer fsm.DoDelete(hashFile)
+	checkErr(fsm.DoCopy(rangeFile, hashFile, true))
+	if node := fsm.GetNode(hashFile); nil == node || node.Hash != rangeHash {
+		logs.Panicln("hash on copy failed", node)
+	}
+	fns := fsm.(*FileDatas)
+	fns.delHash(hashFile)
+	if node := fsm.GetNode(hashFile); nil == node || node.Hash != "" {
+		logs.Panicln("hash cache not removed", node)
+	}
+	if node, err := fsm.DoHash(hashFile); nil != err || node.Hash != rangeHash {
+		logs.Panicln("DoHash failed", node, err)
+	}
+	if nodes, err := fsm.GetDirNodeList(wkdir, -1, -1); nil != err {
+		checkErr(err)
+	} else {
+		for _, node := range nodes {
+			if node.Path == hashFile && node.Hash != rangeHash {
+				logs.Panicln("hash of list failed", node)
+			}
+		}
+	}
 	// GetDirListInfo
 	// fsinfo, err := fsm.GetDirNodeList(wkdir)
 	// checkErr(err)
@@ -102,3 +219,28 @@ func checkErr(err error) {
 		panic(err)
 	}
 }
+
+func readAll(fsm service.FileDatas, src string) (string, error) {
+	fr, err := fsm.DoRead(src, 0)
+	if nil != err {
+		return "", err
+	}
+	defer fr.Close()
+	bs, err := io.ReadAll(fr)
+	return string(bs), err
+}
+
+// eofReader 读到结尾时回调一次
+type eofReader struct {
+	io.Reader
+	onEOF func()
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF && nil != r.onEOF {
+		r.onEOF()
+		r.onEOF = nil
+	}
+	return n, err
+}
@@ -0,0 +1,183 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 文件内容摘要, 写入时计算, 按 挂载点+路径+修改时间+大小 缓存
+
+package filedatas
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fileservice/business/service"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/wup364/pakku/utils/logs"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// newHasher 根据算法名称创建摘要计算器, 不支持时返回nil
+func newHasher(algo string) hash.Hash {
+	switch strings.ToLower(algo) {
+	case "sha256":
+		return sha256.New()
+	case "sha1":
+		return sha1.New()
+	case "md5":
+		return md5.New()
+	}
+	return nil
+}
+
+// DoHash 获取文件信息并计算内容摘要, 缓存有效时不重复计算
+func (fns *FileDatas) DoHash(relativePath string) (*service.FNode, error) {
+	fs, err := fns.getPathDriver(relativePath)
+	if nil != err {
+		return nil, err
+	}
+	node := fns.GetNode(relativePath)
+	if nil == node || !node.IsFile {
+		return nil, service.ErrorFileNotExist
+	}
+	if len(node.Hash) > 0 {
+		return node, nil
+	}
+	fr, err := fs.DoRead(node.Path, 0)
+	if nil != err {
+		return nil, err
+	}
+	defer fr.Close()
+	hasher := newHasher(fns.hashAlgo)
+	if _, err = io.Copy(hasher, fr); nil != err {
+		return nil, err
+	}
+	node.Hash = hex.EncodeToString(hasher.Sum(nil))
+	// 计算期间文件被修改时不缓存
+	if now := fs.GetNode(node.Path); nil != now && now.Mtime == node.Mtime && now.Size == node.Size {
+		fns.saveHash(node.Path, node.Mtime, node.Size, node.Hash)
+	}
+	return node, nil
+}
+
+// hashReader 包装写入流, 写入时同步计算摘要; 不在写入时计算则返回nil
+func (fns *FileDatas) hashReader(ioReader io.Reader) (io.Reader, hash.Hash) {
+	if !fns.hashOnWrite || nil == ioReader {
+		return ioReader, nil
+	}
+	hasher := newHasher(fns.hashAlgo)
+	return io.TeeReader(ioReader, hasher), hasher
+}
+
+// afterWrite 写入成功后保存摘要
+func (fns *FileDatas) afterWrite(relativePath string, hasher hash.Hash) {
+	if nil == hasher {
+		fns.delHash(relativePath)
+		return
+	}
+	if node := fns.mt.GetFileDriver(relativePath).GetNode(relativePath); nil != node && node.IsFile {
+		fns.saveHash(node.Path, node.Mtime, node.Size, hex.EncodeToString(hasher.Sum(nil)))
+	}
+}
+
+// afterMove 移动|重命名成功后迁移摘要, 文件夹直接清除旧记录
+func (fns *FileDatas) afterMove(src, dst string, srcHash *FileHash) {
+	fns.delHash(src)
+	fns.afterCopy(dst, srcHash)
+}
+
+// afterCopy 复制成功后, 源文件摘要有效且大小一致时复用
+func (fns *FileDatas) afterCopy(dst string, srcHash *FileHash) {
+	fns.delHash(dst)
+	if nil == srcHash {
+		return
+	}
+	if node := fns.mt.GetFileDriver(dst).GetNode(dst); nil != node && node.IsFile && node.Size == srcHash.Size {
+		fns.saveHash(node.Path, node.Mtime, node.Size, srcHash.Hash)
+	}
+}
+
+// getValidHash 获取有效的摘要缓存, 只有文件的修改时间、大小、算法一致时有效
+func (fns *FileDatas) getValidHash(relativePath string) *FileHash {
+	relativePath, _ = checkPathSafety(relativePath)
+	node := fns.mt.GetFileDriver(relativePath).GetNode(relativePath)
+	if nil == node || !node.IsFile {
+		return nil
+	}
+	fh, err := fns.hs.GetHash(fns.getMountPath(relativePath), relativePath)
+	if nil != err {
+		logs.Errorln(err)
+		return nil
+	}
+	if nil == fh || fh.Algo != fns.hashAlgo || fh.Mtime != node.Mtime || fh.Size != node.Size {
+		return nil
+	}
+	return fh
+}
+
+// fillHash 从缓存中填充文件摘要, 驱动已提供的不覆盖
+func (fns *FileDatas) fillHash(nodes []service.FNode) {
+	// 同一文件夹下的文件一次查出
+	cache := make(map[string]map[string]FileHash)
+	for i := 0; i < len(nodes); i++ {
+		if !nodes[i].IsFile || len(nodes[i].Hash) > 0 {
+			continue
+		}
+		mount := fns.getMountPath(nodes[i].Path)
+		parent := strutil.GetPathParent(nodes[i].Path)
+		key := mount + ":" + parent
+		hashes, ok := cache[key]
+		if !ok {
+			hashes = make(map[string]FileHash)
+			if list, err := fns.hs.ListHashByParent(mount, parent); nil != err {
+				logs.Errorln(err)
+			} else {
+				for _, fh := range list {
+					hashes[fh.Path] = fh
+				}
+			}
+			cache[key] = hashes
+		}
+		if fh, ok := hashes[nodes[i].Path]; ok && fh.Algo == fns.hashAlgo && fh.Mtime == nodes[i].Mtime && fh.Size == nodes[i].Size {
+			nodes[i].Hash = fh.Hash
+		}
+	}
+}
+
+// saveHash 保存摘要
+func (fns *FileDatas) saveHash(relativePath string, mtime, size int64, hash string) {
+	if err := fns.hs.SaveHash(FileHash{
+		Mount: fns.getMountPath(relativePath),
+		Path:  relativePath,
+		Mtime: mtime,
+		Size:  size,
+		Algo:  fns.hashAlgo,
+		Hash:  hash,
+	}, strutil.GetPathParent(relativePath)); nil != err {
+		logs.Errorln(err)
+	}
+}
+
+// delHash 删除路径及其子路径的摘要
+func (fns *FileDatas) delHash(relativePath string) {
+	relativePath, _ = checkPathSafety(relativePath)
+	if err := fns.hs.DelHash(fns.getMountPath(relativePath), relativePath); nil != err {
+		logs.Errorln(err)
+	}
+}
+
+// getMountPath 获取路径所在的挂载点
+func (fns *FileDatas) getMountPath(relativePath string) string {
+	if mnode := fns.mt.GetMountNode(relativePath); nil != mnode {
+		return mnode.Path
+	}
+	return "/"
+}
@@ -15,6 +15,7 @@ import (
 	"errors"
 	"fileservice/business/modules/filedatas/ifiledatas"
 	"io"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -29,6 +30,8 @@ const (
 	sysDir      = ".sys"                // 系统文件存放位置
 	tempDir     = sysDir + "/.cache"    // 系统文件-缓存位置
 	deletingDir = sysDir + "/.deleting" // 系统文件-待删除文件位置
+
+	maxConflictRename = 1000 // 改名策略最多尝试的候选数量
 )
 
 // LocalDriver 本地文件挂载操作驱动
@@ -371,39 +374,124 @@ func (locl *LocalDriver) DoRead(relativePath string, offset int64) (io.ReadClose
 	return fs, nil
 }
 
+// DoReadRange 从offset开始读取length个字节, 需要手动关闭流
+func (locl *LocalDriver) DoReadRange(relativePath string, offset, length int64) (io.ReadCloser, error) {
+	absDst, _, err := locl.getAbsolutePath(locl.mtn, relativePath)
+	if nil != err {
+		return nil, locl.wrapError(relativePath, "", err)
+	}
+	fs, err := fileutil.OpenFile(absDst)
+	if nil != err {
+		return nil, locl.wrapError(relativePath, "", err)
+	}
+	return ifiledatas.LimitReadCloser{Reader: io.NewSectionReader(fs, offset, length), Closer: fs}, nil
+}
+
 // DoWrite 写入文件， 先写入临时位置, 然后移动到正确位置
 func (locl *LocalDriver) DoWrite(relativePath string, ioReader io.Reader) error {
+	_, err := locl.DoWriteWithPolicy(relativePath, ioReader, ifiledatas.WriteConflict_Overwrite)
+	return err
+}
+
+// DoWriteWithPolicy 写入文件, 先写入临时位置, 提交时按冲突策略处理已存在的目标, 返回最终路径
+func (locl *LocalDriver) DoWriteWithPolicy(relativePath string, ioReader io.Reader, policy string) (string, error) {
+	return locl.DoWriteWithCheck(relativePath, ioReader, policy, nil)
+}
+
+// DoWriteWithCheck 同 DoWriteWithPolicy, 临时文件写完后、提交前调用check, 返回错误时删除临时文件并返回该错误
+func (locl *LocalDriver) DoWriteWithCheck(relativePath string, ioReader io.Reader, policy string, check func() error) (string, error) {
 	if ioReader == nil {
-		return locl.wrapError(relativePath, "", errors.New("IO Reader is nil"))
+		return "", locl.wrapError(relativePath, "", errors.New("IO Reader is nil"))
 	}
 	absDst, _, err := locl.getAbsolutePath(locl.mtn, relativePath)
 	if nil != err {
-		return locl.wrapError(relativePath, "", err)
+		return "", locl.wrapError(relativePath, "", err)
 	}
 	tempPath := locl.getAbsoluteTempPath(locl.mtn)
 	fs, wErr := fileutil.GetWriter(tempPath)
 	if wErr != nil {
-		return locl.wrapError(relativePath, "", wErr)
+		return "", locl.wrapError(relativePath, "", wErr)
 	}
 	_, cpErr := io.Copy(fs, ioReader)
-	if nil == cpErr {
-		fsCloseErr := fs.Close()
-		if fsCloseErr == nil {
-			return locl.wrapError(relativePath, "", fileutil.MoveFiles(tempPath, absDst, true, false))
+	if fsCloseErr := fs.Close(); nil == cpErr {
+		cpErr = fsCloseErr
+	}
+	if nil == cpErr && nil != check {
+		if err := check(); nil != err {
+			fileutil.RemoveFile(tempPath)
+			return "", err
 		}
-		return locl.wrapError(relativePath, "", fsCloseErr)
 	}
-	fsCloseErr := fs.Close()
-	if nil != fsCloseErr {
-		return locl.wrapError(relativePath, "", fsCloseErr)
+	if nil == cpErr {
+		dst, err := locl.commitWrite(tempPath, relativePath, absDst, policy)
+		if nil == err || err == ifiledatas.ErrorFileExist {
+			return dst, err
+		}
+		cpErr = err
 	}
 	if fileutil.IsFile(tempPath) {
 		rmErr := fileutil.RemoveFile(tempPath)
 		if rmErr != nil {
-			return locl.wrapError(relativePath, "", rmErr)
+			return "", locl.wrapError(relativePath, "", rmErr)
+		}
+	}
+	return "", locl.wrapError(relativePath, "", cpErr)
+}
+
+// commitWrite 把临时文件提交到目标位置, 不覆盖时由 placeFile 原子地占用目标, 目标已存在则失败, 不会有竞争
+func (locl *LocalDriver) commitWrite(tempPath, relativePath, absDst, policy string) (string, error) {
+	switch policy {
+	case ifiledatas.WriteConflict_Overwrite, "":
+		return relativePath, fileutil.MoveFiles(tempPath, absDst, true, false)
+	case ifiledatas.WriteConflict_Fail:
+		if err := locl.placeFile(tempPath, absDst); nil != err {
+			if os.IsExist(err) {
+				fileutil.RemoveFile(tempPath)
+				return "", ifiledatas.ErrorFileExist
+			}
+			return "", err
+		}
+		return relativePath, nil
+	case ifiledatas.WriteConflict_Rename:
+		for i := 0; i < maxConflictRename; i++ {
+			dst, abs := relativePath, absDst
+			if i > 0 {
+				dst, abs = ifiledatas.ConflictName(relativePath, i), ifiledatas.ConflictName(absDst, i)
+			}
+			if err := locl.placeFile(tempPath, abs); nil != err {
+				if os.IsExist(err) {
+					continue
+				}
+				return "", err
+			}
+			return dst, nil
 		}
+		return "", errors.New("Too many files with the same name: " + relativePath)
+	}
+	return "", errors.New("Unsupported conflict policy: " + policy)
+}
+
+// placeFile 把临时文件放到目标位置, 目标已存在时返回 os.ErrExist, 父目录不存在时自动创建;
+// 优先使用硬链接, 文件系统不支持硬链接时先独占创建目标文件占位, 再把临时文件重命名过去
+func (locl *LocalDriver) placeFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); nil != err {
+		return err
+	}
+	if err := os.Link(src, dst); nil == err {
+		return fileutil.RemoveFile(src)
+	} else if os.IsExist(err) {
+		return err
 	}
-	return locl.wrapError(relativePath, "", cpErr)
+	fs, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if nil != err {
+		return err
+	}
+	fs.Close()
+	if err := os.Rename(src, dst); nil != err {
+		os.Remove(dst)
+		return err
+	}
+	return nil
 }
 
 // getAbsolutePath (mnode, 虚拟路径)(绝对位置, 挂载位置, 错误)处理路径拼接
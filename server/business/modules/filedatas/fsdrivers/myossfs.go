@@ -15,7 +15,9 @@ import (
 	"errors"
 	"fileservice/business/modules/filedatas/ifiledatas"
 	"io"
+	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/wup364/filestorage/opensdk"
@@ -366,6 +368,53 @@ func (driver *MyOssDriver) DoRead(src string, offset int64) (reader io.ReadClose
 	return reader, e
 }
 
+// DoReadRange 从offset开始读取length个字节, 只向存储节点请求这一段, 需要手动关闭流
+func (driver *MyOssDriver) DoReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	absSrc, _, err := driver.getAbsolutePath(driver.mtn, src)
+	if nil != err {
+		return nil, err
+	}
+	token, err := driver.sdk.DoAskReadToken(absSrc)
+	if nil != err {
+		return nil, err
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	streamURL, err := driver.sdk.GetReadStreamURL(token.NodeNo, token.Token, token.EndPoint)
+	if nil != err {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodGet, streamURL, nil)
+	if nil != err {
+		return nil, err
+	}
+	r.Header.Set("Connection", "Keep-Alive")
+	r.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-"+strconv.FormatInt(offset+length-1, 10))
+	resp, err := http.DefaultClient.Do(r)
+	if nil != err {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return ifiledatas.NewLimitReadCloser(resp.Body, length), nil
+	case http.StatusOK:
+		// 存储节点不支持Range时跳过前面的内容
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); nil != err {
+			resp.Body.Close()
+			return nil, err
+		}
+		return ifiledatas.NewLimitReadCloser(resp.Body, length), nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	default:
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, errors.New("[" + resp.Status + "] " + string(msg))
+	}
+}
+
 // DoWrite 写入文件， 先写入临时位置, 然后移动到正确位置
 func (driver *MyOssDriver) DoWrite(src string, ioReader io.Reader) error {
 	if ioReader == nil {
@@ -0,0 +1,151 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 用db存放文件内容摘要缓存, 以 挂载点+路径 区分, 修改时间和大小一致时有效
+// 路径可能很长, 主键和索引使用 挂载点+路径 的定长摘要
+
+package filedatas
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fileservice/business/constants"
+	"time"
+	"unicode/utf8"
+)
+
+// FileHash 文件摘要缓存记录
+type FileHash struct {
+	Mount string
+	Path  string
+	Mtime int64
+	Size  int64
+	Algo  string
+	Hash  string
+}
+
+// HashStory 文件摘要存储
+type HashStory struct {
+	db *sql.DB
+}
+
+// Initial 初始化配置
+func (hs *HashStory) Initial(st constants.DBSetting) (err error) {
+	if nil == hs.db {
+		hs.db, err = sql.Open(st.DriverName, st.DataSourceName)
+		if nil == err {
+			if st.DriverName == "sqlite3" {
+				// database is locked
+				// https://github.com/mattn/go-sqlite3/issues/209
+				hs.db.SetMaxOpenConns(1)
+			} else {
+				hs.db.SetMaxIdleConns(250)
+				hs.db.SetConnMaxLifetime(time.Hour)
+			}
+		}
+	}
+	return err
+}
+
+// Install 初始化 filehashes 表
+func (hs *HashStory) Install() (err error) {
+	var tx *sql.Tx
+	if tx, err = hs.db.Begin(); err == nil {
+		for _, ddl := range []string{
+			`CREATE TABLE IF NOT EXISTS filehashes(
+				pathhash CHAR(64) NOT NULL PRIMARY KEY,
+				parenthash CHAR(64) NOT NULL,
+				mount VARCHAR(1000) NOT NULL,
+				path VARCHAR(1000) NOT NULL,
+				mtime BIGINT DEFAULT 0,
+				size BIGINT DEFAULT 0,
+				algo VARCHAR(16) NOT NULL,
+				hash VARCHAR(128) NOT NULL
+			);`,
+			`CREATE INDEX idx_filehashes_parent ON filehashes(parenthash);`,
+		} {
+			if _, err = tx.Exec(ddl); nil != err {
+				tx.Rollback()
+				return err
+			}
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// SaveHash 保存摘要, 已存在则覆盖
+func (hs *HashStory) SaveHash(fh FileHash, parent string) (err error) {
+	var tx *sql.Tx
+	if tx, err = hs.db.Begin(); err == nil {
+		key := getPathHash(fh.Mount, fh.Path)
+		if _, err = tx.Exec("DELETE FROM filehashes WHERE pathhash=?", key); nil != err {
+			tx.Rollback()
+			return err
+		}
+		if _, err = tx.Exec("INSERT INTO filehashes(pathhash, parenthash, mount, path, mtime, size, algo, hash) values(?,?,?,?,?,?,?,?)",
+			key, getPathHash(fh.Mount, parent), fh.Mount, fh.Path, fh.Mtime, fh.Size, fh.Algo, fh.Hash); nil != err {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// GetHash 查询摘要, 不存在时返回nil
+func (hs *HashStory) GetHash(mount, path string) (*FileHash, error) {
+	fh := FileHash{}
+	err := hs.db.QueryRow("SELECT mount, path, mtime, size, algo, hash FROM filehashes WHERE pathhash=?", getPathHash(mount, path)).
+		Scan(&fh.Mount, &fh.Path, &fh.Mtime, &fh.Size, &fh.Algo, &fh.Hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if nil != err {
+		return nil, err
+	}
+	return &fh, nil
+}
+
+// ListHashByParent 查询文件夹下所有文件的摘要
+func (hs *HashStory) ListHashByParent(mount, parent string) ([]FileHash, error) {
+	rows, err := hs.db.Query("SELECT mount, path, mtime, size, algo, hash FROM filehashes WHERE parenthash=?", getPathHash(mount, parent))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]FileHash, 0)
+	for rows.Next() {
+		fh := FileHash{}
+		if err := rows.Scan(&fh.Mount, &fh.Path, &fh.Mtime, &fh.Size, &fh.Algo, &fh.Hash); err != nil {
+			return nil, err
+		}
+		res = append(res, fh)
+	}
+	return res, nil
+}
+
+// DelHash 删除路径及其子路径的摘要
+func (hs *HashStory) DelHash(mount, path string) error {
+	if path == "/" {
+		_, err := hs.db.Exec("DELETE FROM filehashes WHERE mount=?", mount)
+		return err
+	}
+	prefix := path + "/"
+	_, err := hs.db.Exec("DELETE FROM filehashes WHERE mount=? AND (path=? OR SUBSTR(path, 1, ?)=?)",
+		mount, path, utf8.RuneCountInString(prefix), prefix)
+	return err
+}
+
+// getPathHash 挂载点+路径 的sha256摘要, 用作定长的主键
+func getPathHash(mount, path string) string {
+	sum := sha256.Sum256([]byte(mount + "\x00" + path))
+	return hex.EncodeToString(sum[:])
+}
@@ -11,7 +11,12 @@
 
 package ifiledatas
 
-import "io"
+import (
+	"errors"
+	"io"
+	"path"
+	"strconv"
+)
 
 const (
 	AccessTokenType_Read  AccessTokenType = 0
@@ -46,6 +51,54 @@ type FileDriver interface {
 	DoRead(src string, offset int64) (io.ReadCloser, error)
 }
 
+// RangeReader 驱动可选实现, 只读取文件的一段, 未实现时使用 DoRead 截取
+type RangeReader interface {
+	DoReadRange(src string, offset, length int64) (io.ReadCloser, error)
+}
+
+const (
+	// WriteConflict_Overwrite 目标已存在时覆盖
+	WriteConflict_Overwrite = "overwrite"
+	// WriteConflict_Fail 目标已存在时失败
+	WriteConflict_Fail = "fail"
+	// WriteConflict_Rename 目标已存在时改名为 name (n).ext
+	WriteConflict_Rename = "rename"
+)
+
+// ErrorFileExist 目标文件已存在
+var ErrorFileExist = errors.New("file already exists")
+
+// ConflictWriter 驱动可选实现, 提交写入时按冲突策略处理已存在的目标, 返回最终路径
+type ConflictWriter interface {
+	DoWriteWithPolicy(src string, ioReader io.Reader, policy string) (string, error)
+}
+
+// CheckedWriter 驱动可选实现, 数据写完后、提交到目标位置前调用check, check返回错误时放弃写入并原样返回该错误
+type CheckedWriter interface {
+	DoWriteWithCheck(src string, ioReader io.Reader, policy string, check func() error) (string, error)
+}
+
+// ConflictName 第n个候选名称, /a/b.txt -> /a/b (n).txt, 没有后缀(含.开头的文件)时追加在末尾
+func ConflictName(src string, n int) string {
+	dir, name := path.Split(src)
+	ext := path.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	return dir + name[:len(name)-len(ext)] + " (" + strconv.Itoa(n) + ")" + ext
+}
+
+// LimitReadCloser 最多读取N个字节, 关闭时关闭原始流
+type LimitReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// NewLimitReadCloser 最多读取n个字节
+func NewLimitReadCloser(rc io.ReadCloser, n int64) io.ReadCloser {
+	return LimitReadCloser{Reader: io.LimitReader(rc, n), Closer: rc}
+}
+
 // Node 文件|夹基础属性
 type Node struct {
 	Path   string
@@ -53,6 +106,7 @@ type Node struct {
 	IsFile bool
 	IsDir  bool
 	Size   int64
+	Hash   string // 内容摘要, 驱动能直接提供时填写, 为空时由上层计算
 }
 
 // AccessToken token信息
@@ -0,0 +1,142 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 存储配额模块
+
+package filequota
+
+import (
+	"fileservice/business/constants"
+	"fileservice/business/service"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/fileutil"
+	"github.com/wup364/pakku/utils/logs"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// FileQuota 存储配额模块
+type FileQuota struct {
+	*QuotaCheck
+	qs   *QuotaStory
+	conf ipakku.AppConfig     `@autowired:"AppConfig"`
+	fd   service.FileDatas    `@autowired:"FileDatas"`
+	um   service.UserAuth4Rpc `@autowired:"User4RPC"`
+}
+
+// AsModule 模块加载器接口实现, 返回模块信息&配置
+func (fq *FileQuota) AsModule() ipakku.Opts {
+	return ipakku.Opts{
+		Name:        "FileQuota",
+		Version:     1.0,
+		Description: "存储配额模块",
+		OnReady: func(mctx ipakku.Loader) {
+			deftDataSource := "./.datas/" + mctx.GetParam(ipakku.PARAMKEY_APPNAME).ToString("app") + ".db?cache=shared"
+			confDataSource := fq.conf.GetConfig("filequota.sotre.datasource").ToString(deftDataSource)
+			if confDataSource == deftDataSource {
+				fq.mkSqliteDIR() // 创建sqlite文件存放目录
+			}
+			fq.qs = new(QuotaStory)
+			if err := fq.qs.Initial(constants.DBSetting{
+				DriverName:     fq.conf.GetConfig("filequota.sotre.driver").ToString("sqlite3"),
+				DataSourceName: confDataSource,
+			}); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnSetup: func() {
+			// 执行建库、建表
+			if err := fq.qs.Install(); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnInit: func() {
+			fq.QuotaCheck = NewQuotaCheck(fq.qs, fq.fd, fq.um.GetHomePath)
+			if err := fq.InitQuota(); nil != err {
+				logs.Panicln(err)
+			}
+		},
+	}
+}
+
+// ListQuotas 列出所有配额, 无分页
+func (fq *FileQuota) ListQuotas() ([]service.QuotaInfo, error) {
+	return fq.qs.ListQuotas()
+}
+
+// QueryQuota 根据配额ID查询详细信息
+func (fq *FileQuota) QueryQuota(quotaID string) (*service.QuotaInfo, error) {
+	return fq.qs.QueryQuota(quotaID)
+}
+
+// AddQuota 添加配额, 统计当前已用空间, 用户配额统计用户主目录
+func (fq *FileQuota) AddQuota(quota service.QuotaInfo) error {
+	switch quota.QuotaType {
+	case service.QuotaType_User:
+	case service.QuotaType_Path:
+		quota.Target = strutil.Parse2UnixPath(quota.Target)
+	default:
+		return service.ErrorQuotaTypeNotSupport
+	}
+	if len(quota.Target) > 0 {
+		path := fq.GetQuotaPath(quota)
+		if len(path) == 0 {
+			return service.ErrorUserHomeIllegal
+		}
+		quota.UsedSize = fq.GetPathSize(path)
+	}
+	if err := fq.qs.AddQuota(quota); nil != err {
+		return err
+	}
+	return fq.InitQuota()
+}
+
+// UpdateQuota 修改配额上限
+func (fq *FileQuota) UpdateQuota(quota service.QuotaInfo) error {
+	if err := fq.qs.UpdateMaxSize(quota.QuotaID, quota.MaxSize); nil != err {
+		return err
+	}
+	return fq.InitQuota()
+}
+
+// DelQuota 根据配额ID删除配额
+func (fq *FileQuota) DelQuota(quotaID string) error {
+	if err := fq.qs.DelQuota(quotaID); nil != err {
+		return err
+	}
+	return fq.InitQuota()
+}
+
+// RecountQuota 重新统计配额的已用空间
+func (fq *FileQuota) RecountQuota(quotaID string) error {
+	quota, err := fq.qs.QueryQuota(quotaID)
+	if nil != err {
+		return err
+	}
+	if nil == quota {
+		return service.ErrorQuotaNotExist
+	}
+	path := fq.GetQuotaPath(*quota)
+	if len(path) == 0 {
+		return service.ErrorUserHomeIllegal
+	}
+	if err = fq.qs.UpdateUsedSize(quotaID, fq.GetPathSize(path)); nil != err {
+		return err
+	}
+	return fq.InitQuota()
+}
+
+// mkSqliteDIR 创建sqlite文件存放目录
+func (fq *FileQuota) mkSqliteDIR() {
+	if !fileutil.IsExist("./.datas") {
+		if err := fileutil.MkdirAll("./.datas"); nil != err {
+			logs.Panicln(err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 限制写入数据量, 超出配额或限制时中断读取
+
+package filequota
+
+import (
+	"fileservice/business/service"
+	"io"
+)
+
+// NewLimitReader 最多读取limit字节, 超出时返回 ErrorQuotaExceeded
+func NewLimitReader(r io.Reader, limit int64) *LimitReader {
+	return &LimitReader{r: r, n: limit, err: service.ErrorQuotaExceeded}
+}
+
+// NewLimitReaderWithError 最多读取limit字节, 超出时返回err
+func NewLimitReaderWithError(r io.Reader, limit int64, err error) *LimitReader {
+	return &LimitReader{r: r, n: limit, err: err}
+}
+
+// LimitReader 配额限制读取
+type LimitReader struct {
+	r        io.Reader
+	n        int64
+	err      error
+	exceeded bool
+}
+
+// Read 读取数据, 剩余额度为0时还有数据则视为超出限制
+func (lr *LimitReader) Read(p []byte) (int, error) {
+	if lr.n <= 0 {
+		var b [1]byte
+		if n, err := lr.r.Read(b[:]); n > 0 {
+			lr.exceeded = true
+			return 0, lr.err
+		} else {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > lr.n {
+		p = p[:lr.n]
+	}
+	n, err := lr.r.Read(p)
+	lr.n -= int64(n)
+	return n, err
+}
+
+// IsExceeded 是否超出了配额或限制
+func (lr *LimitReader) IsExceeded() bool {
+	return lr.exceeded
+}
+
+// NewReserveReader 边读取边预占配额, 配额不足时返回 ErrorQuotaExceeded
+func NewReserveReader(r io.Reader, rv service.QuotaReservation) *ReserveReader {
+	return &ReserveReader{r: r, rv: rv}
+}
+
+// ReserveReader 边读取边预占配额, 数据量未知时并发写入也不会超出配额
+type ReserveReader struct {
+	r        io.Reader
+	rv       service.QuotaReservation
+	n        int64
+	exceeded bool
+}
+
+// Read 读取数据, 读到的数据先预占配额再返回
+func (rr *ReserveReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if n > 0 {
+		if rerr := rr.rv.Ensure(rr.n + int64(n)); nil != rerr {
+			rr.exceeded = true
+			return 0, rerr
+		}
+		rr.n += int64(n)
+	}
+	return n, err
+}
+
+// IsExceeded 是否超出了配额
+func (rr *ReserveReader) IsExceeded() bool {
+	return rr.exceeded
+}
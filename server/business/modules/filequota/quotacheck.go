@@ -0,0 +1,292 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 配额计算校验, 配额数据常驻内存, 用量变化时同步写库
+// 用户配额作用于用户主目录, 和路径配额一样按路径统计; 写入前预占空间, 写入完成后结算
+
+package filequota
+
+import (
+	"fileservice/business/service"
+	"strings"
+	"sync"
+
+	"github.com/wup364/pakku/utils/logs"
+)
+
+// NewQuotaCheck NewQuotaCheck, home 用于获取用户主目录
+func NewQuotaCheck(qs *QuotaStory, fd service.FileDatas, home func(userID string) string) *QuotaCheck {
+	return &QuotaCheck{
+		qs:       qs,
+		fd:       fd,
+		home:     home,
+		quotas:   make([]*service.QuotaInfo, 0),
+		paths:    make(map[string]string),
+		reserved: make(map[string]int64),
+	}
+}
+
+// QuotaCheck 配额校验&统计
+type QuotaCheck struct {
+	qs       *QuotaStory
+	fd       service.FileDatas
+	home     func(userID string) string
+	lock     sync.RWMutex
+	quotas   []*service.QuotaInfo
+	paths    map[string]string // 配额ID -> 作用的路径
+	reserved map[string]int64  // 配额ID -> 写入中预占的空间
+}
+
+// InitQuota 加载配额到内存
+func (qc *QuotaCheck) InitQuota() error {
+	list, err := qc.qs.ListQuotas()
+	if nil != err {
+		return err
+	}
+	quotas := make([]*service.QuotaInfo, len(list))
+	paths := make(map[string]string, len(list))
+	for i := 0; i < len(list); i++ {
+		quotas[i] = &list[i]
+		paths[list[i].QuotaID] = qc.GetQuotaPath(list[i])
+	}
+	qc.lock.Lock()
+	qc.quotas = quotas
+	qc.paths = paths
+	qc.lock.Unlock()
+	return nil
+}
+
+// GetQuotaPath 配额作用的路径, 用户配额为用户主目录, 未配置主目录时返回空
+func (qc *QuotaCheck) GetQuotaPath(quota service.QuotaInfo) string {
+	switch quota.QuotaType {
+	case service.QuotaType_User:
+		return qc.home(quota.Target)
+	case service.QuotaType_Path:
+		return quota.Target
+	}
+	return ""
+}
+
+// HasQuotas 是否配置了配额
+func (qc *QuotaCheck) HasQuotas() bool {
+	qc.lock.RLock()
+	defer qc.lock.RUnlock()
+	return len(qc.quotas) > 0
+}
+
+// GetPathSize 计算文件|文件夹大小
+func (qc *QuotaCheck) GetPathSize(path string) int64 {
+	node := qc.fd.GetNode(path)
+	if nil == node {
+		return 0
+	}
+	if node.IsFile {
+		return node.Size
+	}
+	size := int64(0)
+	if nodes, err := qc.fd.GetDirNodeList(path, -1, -1); nil == err {
+		for i := 0; i < len(nodes); i++ {
+			if nodes[i].IsFile {
+				size += nodes[i].Size
+			} else {
+				size += qc.GetPathSize(nodes[i].Path)
+			}
+		}
+	}
+	return size
+}
+
+// Reserve 预占在路径下写入size字节的空间, credit为覆盖写入时被替换的旧文件大小, 写入的数据先抵扣credit
+func (qc *QuotaCheck) Reserve(path string, size, credit int64) (service.QuotaReservation, error) {
+	rv := &Reservation{qc: qc, path: path, credit: credit, reserved: make(map[string]int64)}
+	if err := rv.Ensure(size); nil != err {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// CheckMove 从src移动size字节到dst是否超出配额
+func (qc *QuotaCheck) CheckMove(src, dst string, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	qc.lock.RLock()
+	defer qc.lock.RUnlock()
+	for _, quota := range qc.quotas {
+		if qc.isMatched(quota, dst) && !qc.isMatched(quota, src) && qc.getFree(quota) < size {
+			return service.ErrorQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// AddUsage 写入|删除后更新用量
+func (qc *QuotaCheck) AddUsage(path string, delta int64) {
+	if delta == 0 {
+		return
+	}
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	qc.addUsage(path, delta)
+}
+
+// MoveUsage 移动后更新配额用量
+func (qc *QuotaCheck) MoveUsage(src, dst string, size int64) {
+	if size == 0 {
+		return
+	}
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	for _, quota := range qc.quotas {
+		inSrc, inDst := qc.isMatched(quota, src), qc.isMatched(quota, dst)
+		if inDst && !inSrc {
+			qc.setUsedSize(quota, quota.UsedSize+size)
+		} else if inSrc && !inDst {
+			qc.setUsedSize(quota, quota.UsedSize-size)
+		}
+	}
+}
+
+// ListUsage 列出在路径下写入时受限的配额
+func (qc *QuotaCheck) ListUsage(path string) []service.QuotaInfo {
+	qc.lock.RLock()
+	defer qc.lock.RUnlock()
+	res := make([]service.QuotaInfo, 0)
+	for _, quota := range qc.quotas {
+		if qc.isMatched(quota, path) {
+			res = append(res, *quota)
+		}
+	}
+	return res
+}
+
+// GetRemaining 路径下剩余可写入的空间, 扣除写入中预占的空间, -1为不限制
+func (qc *QuotaCheck) GetRemaining(path string) int64 {
+	qc.lock.RLock()
+	defer qc.lock.RUnlock()
+	remaining := int64(-1)
+	for _, quota := range qc.quotas {
+		if !qc.isMatched(quota, path) {
+			continue
+		}
+		if free := qc.getFree(quota); remaining == -1 || free < remaining {
+			remaining = free
+		}
+	}
+	return remaining
+}
+
+// getFree 配额剩余的空间, 需要在锁内调用
+func (qc *QuotaCheck) getFree(quota *service.QuotaInfo) int64 {
+	if free := quota.MaxSize - quota.UsedSize - qc.reserved[quota.QuotaID]; free > 0 {
+		return free
+	}
+	return 0
+}
+
+// addUsage 更新用量, 需要在锁内调用
+func (qc *QuotaCheck) addUsage(path string, delta int64) {
+	for _, quota := range qc.quotas {
+		if qc.isMatched(quota, path) {
+			qc.setUsedSize(quota, quota.UsedSize+delta)
+		}
+	}
+}
+
+// setUsedSize 修改已用空间并写库, 需要在锁内调用
+func (qc *QuotaCheck) setUsedSize(quota *service.QuotaInfo, usedSize int64) {
+	if usedSize < 0 {
+		usedSize = 0
+	}
+	quota.UsedSize = usedSize
+	if err := qc.qs.UpdateUsedSize(quota.QuotaID, usedSize); nil != err {
+		logs.Errorln(err)
+	}
+}
+
+// isMatched 配额是否作用于该路径, 需要在锁内调用
+func (qc *QuotaCheck) isMatched(quota *service.QuotaInfo, path string) bool {
+	target := qc.paths[quota.QuotaID]
+	if len(target) == 0 {
+		return false
+	}
+	return target == "/" || path == target || strings.HasPrefix(path, target+"/")
+}
+
+// Reservation 写入前预占的配额
+type Reservation struct {
+	qc       *QuotaCheck
+	path     string
+	credit   int64            // 覆盖写入时被替换的旧文件大小
+	size     int64            // 已确保可以写入的字节数
+	reserved map[string]int64 // 配额ID -> 预占的空间
+}
+
+// Ensure 确保预占的空间足够写入size字节, 不够时追加预占, 任一配额不足时返回 ErrorQuotaExceeded
+func (rv *Reservation) Ensure(size int64) error {
+	if size <= rv.size {
+		return nil
+	}
+	// 超出旧文件大小的部分才需要预占
+	need := size - rv.size
+	if rv.size < rv.credit {
+		if need -= rv.credit - rv.size; need < 0 {
+			need = 0
+		}
+	}
+	qc := rv.qc
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	if need > 0 {
+		matched := make([]*service.QuotaInfo, 0)
+		for _, quota := range qc.quotas {
+			if qc.isMatched(quota, rv.path) {
+				if qc.getFree(quota) < need {
+					return service.ErrorQuotaExceeded
+				}
+				matched = append(matched, quota)
+			}
+		}
+		for _, quota := range matched {
+			qc.reserved[quota.QuotaID] += need
+			rv.reserved[quota.QuotaID] += need
+		}
+	}
+	rv.size = size
+	return nil
+}
+
+// Commit 写入完成, 按实际的用量变化结算并释放预占
+func (rv *Reservation) Commit(delta int64) {
+	rv.qc.lock.Lock()
+	defer rv.qc.lock.Unlock()
+	rv.release()
+	if delta != 0 {
+		rv.qc.addUsage(rv.path, delta)
+	}
+}
+
+// Release 放弃写入, 释放预占
+func (rv *Reservation) Release() {
+	rv.qc.lock.Lock()
+	defer rv.qc.lock.Unlock()
+	rv.release()
+}
+
+// release 释放预占, 需要在锁内调用
+func (rv *Reservation) release() {
+	for quotaID, size := range rv.reserved {
+		if left := rv.qc.reserved[quotaID] - size; left > 0 {
+			rv.qc.reserved[quotaID] = left
+		} else {
+			delete(rv.qc.reserved, quotaID)
+		}
+	}
+	rv.reserved = make(map[string]int64)
+}
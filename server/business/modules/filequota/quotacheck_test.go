@@ -0,0 +1,115 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package filequota
+
+import (
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	qs := new(QuotaStory)
+	if err := qs.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "quota.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if err := qs.Install(); nil != err {
+		t.Fatal(err)
+	}
+	qs.AddQuota(service.QuotaInfo{QuotaID: "u1", QuotaType: service.QuotaType_User, Target: "user01", MaxSize: 100})
+	qs.AddQuota(service.QuotaInfo{QuotaID: "p1", QuotaType: service.QuotaType_Path, Target: "/a", MaxSize: 50})
+	qc := NewQuotaCheck(qs, nil, func(userID string) string { return "/home/" + userID })
+	if err := qc.InitQuota(); nil != err {
+		t.Fatal(err)
+	}
+	// 路径配额 50
+	if _, err := qc.Reserve("/a/1.txt", 60, 0); err != service.ErrorQuotaExceeded {
+		t.Fatal(err)
+	}
+	// 用户配额只统计主目录
+	rv, err := qc.Reserve("/home/user01/1.txt", 60, 0)
+	if nil != err {
+		t.Fatal(err)
+	}
+	rv.Commit(60)
+	if remaining := qc.GetRemaining("/home/user01"); remaining != 40 {
+		t.Fatal(remaining)
+	}
+	if remaining := qc.GetRemaining("/b"); remaining != -1 {
+		t.Fatal(remaining)
+	}
+	// 预占的空间不能被并发的写入再次使用, 释放后归还
+	rv, err = qc.Reserve("/home/user01/2.txt", 30, 0)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err := qc.Reserve("/home/user01/3.txt", 30, 0); err != service.ErrorQuotaExceeded {
+		t.Fatal(err)
+	}
+	if err := rv.Ensure(40); nil != err {
+		t.Fatal(err)
+	}
+	if err := rv.Ensure(41); err != service.ErrorQuotaExceeded {
+		t.Fatal(err)
+	}
+	rv.Release()
+	if remaining := qc.GetRemaining("/home/user01/2.txt"); remaining != 40 {
+		t.Fatal(remaining)
+	}
+	// 覆盖写入时旧文件的大小可以抵扣
+	if rv, err = qc.Reserve("/home/user01/1.txt", 100, 60); nil != err {
+		t.Fatal(err)
+	}
+	rv.Release()
+	// 大小未知时边读取边预占
+	if rv, err = qc.Reserve("/a/2.txt", 0, 0); nil != err {
+		t.Fatal(err)
+	}
+	rr := NewReserveReader(strings.NewReader(strings.Repeat("0", 60)), rv)
+	if _, err := io.ReadAll(rr); err != service.ErrorQuotaExceeded || !rr.IsExceeded() {
+		t.Fatal(err)
+	}
+	rv.Release()
+	// 移动到 /a 只影响路径配额
+	if err := qc.CheckMove("/b/1.txt", "/a/1.txt", 60); err != service.ErrorQuotaExceeded {
+		t.Fatal(err)
+	}
+	qc.MoveUsage("/b/1.txt", "/a/1.txt", 30)
+	qc.AddUsage("/home/user01/1.txt", -200)
+	// 用量写库, 重新加载后保持一致
+	if err := qc.InitQuota(); nil != err {
+		t.Fatal(err)
+	}
+	if quotas := qc.ListUsage("/a/2.txt"); len(quotas) != 1 || quotas[0].UsedSize != 30 {
+		t.Fatal(quotas)
+	}
+	if quotas := qc.ListUsage("/home/user01/2.txt"); len(quotas) != 1 || quotas[0].UsedSize != 0 {
+		t.Fatal(quotas)
+	}
+}
+
+func TestLimitReader(t *testing.T) {
+	lr := NewLimitReader(strings.NewReader("0123456789"), 10)
+	if bt, err := io.ReadAll(lr); nil != err || len(bt) != 10 || lr.IsExceeded() {
+		t.Fatal(len(bt), err)
+	}
+	lr = NewLimitReader(strings.NewReader("0123456789"), 5)
+	if _, err := io.ReadAll(lr); err != service.ErrorQuotaExceeded || !lr.IsExceeded() {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 用db存放配额数据
+
+package filequota
+
+import (
+	"database/sql"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"time"
+
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// QuotaStory 配额存储
+type QuotaStory struct {
+	db *sql.DB
+}
+
+// Initial 初始化配置
+func (qs *QuotaStory) Initial(st constants.DBSetting) (err error) {
+	if nil == qs.db {
+		qs.db, err = sql.Open(st.DriverName, st.DataSourceName)
+		if nil == err {
+			if st.DriverName == "sqlite3" {
+				// database is locked
+				// https://github.com/mattn/go-sqlite3/issues/209
+				qs.db.SetMaxOpenConns(1)
+			} else {
+				qs.db.SetMaxIdleConns(250)
+				qs.db.SetConnMaxLifetime(time.Hour)
+			}
+		}
+	}
+	return err
+}
+
+// Install 初始化 filequotas 表
+func (qs *QuotaStory) Install() (err error) {
+	var tx *sql.Tx
+	if tx, err = qs.db.Begin(); err == nil {
+		if _, err = tx.Exec(
+			`CREATE TABLE IF NOT EXISTS filequotas(
+				quotaid VARCHAR(64) PRIMARY KEY,
+				quotatype VARCHAR(16) NULL,
+				target TEXT(1000) NULL,
+				maxsize BIGINT DEFAULT 0,
+				usedsize BIGINT DEFAULT 0,
+				cttime DATE NULL
+			);`); nil == err {
+			//
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	return err
+}
+
+// ListQuotas 列出所有配额, 无分页
+func (qs *QuotaStory) ListQuotas() ([]service.QuotaInfo, error) {
+	rows, err := qs.db.Query("SELECT quotaid, quotatype, target, maxsize, usedsize, cttime FROM filequotas")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]service.QuotaInfo, 0)
+	for rows.Next() {
+		quota := service.QuotaInfo{}
+		if err := rows.Scan(&quota.QuotaID, &quota.QuotaType, &quota.Target, &quota.MaxSize, &quota.UsedSize, &quota.CtTime); err != nil {
+			return nil, err
+		}
+		res = append(res, quota)
+	}
+	return res, nil
+}
+
+// QueryQuota 根据配额ID查询详细信息
+func (qs *QuotaStory) QueryQuota(quotaID string) (*service.QuotaInfo, error) {
+	rows, err := qs.db.Query("SELECT quotaid, quotatype, target, maxsize, usedsize, cttime FROM filequotas WHERE quotaid = ?", quotaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	if rows.Next() {
+		quota := service.QuotaInfo{}
+		if err := rows.Scan(&quota.QuotaID, &quota.QuotaType, &quota.Target, &quota.MaxSize, &quota.UsedSize, &quota.CtTime); err != nil {
+			return nil, err
+		}
+		return &quota, nil
+	}
+	return nil, nil
+}
+
+// AddQuota 添加配额
+func (qs *QuotaStory) AddQuota(quota service.QuotaInfo) (err error) {
+	if len(quota.Target) == 0 {
+		return service.ErrorQuotaTargetIsNil
+	}
+	if len(quota.QuotaID) == 0 {
+		quota.QuotaID = strutil.GetUUID()
+	}
+	// 开启事务
+	var ts *sql.Tx
+	if ts, err = qs.db.Begin(); err != nil {
+		return err
+	}
+	//
+	var stmt *sql.Stmt
+	if stmt, err = ts.Prepare("INSERT INTO filequotas(quotaid, quotatype, target, maxsize, usedsize, cttime) values(?,?,?,?,?,?)"); err != nil {
+		ts.Rollback()
+		return err
+	}
+	if _, err = stmt.Exec(quota.QuotaID, quota.QuotaType, quota.Target, quota.MaxSize, quota.UsedSize, time.Now()); err != nil {
+		ts.Rollback()
+	} else {
+		err = ts.Commit()
+	}
+	return err
+}
+
+// UpdateMaxSize 修改配额上限
+func (qs *QuotaStory) UpdateMaxSize(quotaID string, maxSize int64) (err error) {
+	return qs.exec("UPDATE filequotas SET maxsize=? WHERE quotaid=?", quotaID, maxSize, quotaID)
+}
+
+// UpdateUsedSize 修改已用空间
+func (qs *QuotaStory) UpdateUsedSize(quotaID string, usedSize int64) (err error) {
+	return qs.exec("UPDATE filequotas SET usedsize=? WHERE quotaid=?", quotaID, usedSize, quotaID)
+}
+
+// DelQuota 删除配额
+func (qs *QuotaStory) DelQuota(quotaID string) (err error) {
+	return qs.exec("DELETE FROM filequotas WHERE quotaid = ?", quotaID, quotaID)
+}
+
+// exec 在事务中执行一条语句
+func (qs *QuotaStory) exec(query, quotaID string, args ...interface{}) (err error) {
+	if len(quotaID) == 0 {
+		return service.ErrorQuotaIDIsNil
+	}
+	// 开启事务
+	var ts *sql.Tx
+	if ts, err = qs.db.Begin(); err != nil {
+		return err
+	}
+	//
+	var stmt *sql.Stmt
+	if stmt, err = ts.Prepare(query); err != nil {
+		ts.Rollback()
+		return err
+	}
+	if _, err = stmt.Exec(args...); err != nil {
+		ts.Rollback()
+	} else {
+		err = ts.Commit()
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 边读取边计算摘要, 读到结尾时校验, 不一致时中断写入
+
+package filetransport
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fileservice/business/service"
+	"hash"
+	"io"
+)
+
+// NewChecksumReader 计算md5和sha256, 期望值为nil时不校验
+func NewChecksumReader(r io.Reader, expectMD5, expectSHA256 []byte) *ChecksumReader {
+	return &ChecksumReader{r: r, md5: md5.New(), sha256: sha256.New(), expectMD5: expectMD5, expectSHA256: expectSHA256}
+}
+
+// ChecksumReader 摘要校验读取, 不一致时在结尾返回 ErrorChecksumMismatch 代替 io.EOF, 使驱动放弃提交
+type ChecksumReader struct {
+	r            io.Reader
+	md5          hash.Hash
+	sha256       hash.Hash
+	expectMD5    []byte
+	expectSHA256 []byte
+	mismatch     bool
+}
+
+// Read 读取数据
+func (cr *ChecksumReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.md5.Write(p[:n])
+		cr.sha256.Write(p[:n])
+	}
+	if err == io.EOF && !cr.verify() {
+		cr.mismatch = true
+		return n, service.ErrorChecksumMismatch
+	}
+	return n, err
+}
+
+// verify 校验摘要
+func (cr *ChecksumReader) verify() bool {
+	if nil != cr.expectMD5 && !bytes.Equal(cr.expectMD5, cr.md5.Sum(nil)) {
+		return false
+	}
+	if nil != cr.expectSHA256 && !bytes.Equal(cr.expectSHA256, cr.sha256.Sum(nil)) {
+		return false
+	}
+	return true
+}
+
+// IsMismatch 是否校验失败
+func (cr *ChecksumReader) IsMismatch() bool {
+	return cr.mismatch
+}
+
+// SumMD5 已读取内容的md5
+func (cr *ChecksumReader) SumMD5() []byte {
+	return cr.md5.Sum(nil)
+}
+
+// SumSHA256 已读取内容的sha256
+func (cr *ChecksumReader) SumSHA256() []byte {
+	return cr.sha256.Sum(nil)
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package filetransport
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fileservice/business/service"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestChecksumReader(t *testing.T) {
+	data := strings.Repeat("checksum", 10000)
+	sumMD5 := md5.Sum([]byte(data))
+	sumSHA256 := sha256.Sum256([]byte(data))
+	for i, c := range []struct {
+		md5, sha256 []byte
+		mismatch    bool
+	}{
+		{nil, nil, false},
+		{sumMD5[:], nil, false},
+		{nil, sumSHA256[:], false},
+		{sumMD5[:], sumSHA256[:], false},
+		{sumSHA256[:16], nil, true},
+		{sumMD5[:], sumMD5[:], true},
+	} {
+		cr := NewChecksumReader(strings.NewReader(data), c.md5, c.sha256)
+		n, err := io.Copy(io.Discard, cr)
+		if c.mismatch {
+			if err != service.ErrorChecksumMismatch || !cr.IsMismatch() {
+				t.Fatal(i, err)
+			}
+		} else if nil != err || cr.IsMismatch() || n != int64(len(data)) {
+			t.Fatal(i, n, err)
+		}
+		if string(cr.SumMD5()) != string(sumMD5[:]) || string(cr.SumSHA256()) != string(sumSHA256[:]) {
+			t.Fatal(i, "sum")
+		}
+	}
+}
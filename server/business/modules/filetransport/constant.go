@@ -0,0 +1,23 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package filetransport
+
+const (
+	// CONFKEY_SOTREDRIVER 配置文件 - 签名密钥数据库驱动, 多实例部署时需要使用同一个数据库
+	CONFKEY_SOTREDRIVER = "filestream.sotre.driver"
+	// CONFKEY_SOTREDATASOURCE 配置文件 - 签名密钥数据库连接
+	CONFKEY_SOTREDATASOURCE = "filestream.sotre.datasource"
+	// CONFKEY_SIGNKEYS 配置文件 - 旧版本的签名密钥, kid:secret 逗号分隔, 第一个为当前密钥; 数据库中没有密钥时导入
+	CONFKEY_SIGNKEYS = "filestream.sign.keys"
+	// CONFKEY_SIGNKEEPKEYS 配置文件 - 轮换时保留的旧密钥个数
+	CONFKEY_SIGNKEEPKEYS = "filestream.sign.keepkeys"
+	// CONFKEY_SIGNEXPIRES 配置文件 - 签名地址默认有效期(秒)
+	CONFKEY_SIGNEXPIRES = "filestream.sign.expires"
+)
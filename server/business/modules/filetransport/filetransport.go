@@ -12,7 +12,11 @@
 package filetransport
 
 import (
+	"fileservice/business/constants"
 	"fileservice/business/service"
+	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/wup364/pakku/ipakku"
@@ -21,22 +25,65 @@ import (
 	"github.com/wup364/pakku/utils/strutil"
 )
 
+// signKeyReloadInterval 定时重新加载密钥环, 其他实例轮换后尽快使用新的签名密钥
+const signKeyReloadInterval = time.Minute
+
 // TransportToken 文件传输token
 type TransportToken struct {
-	f service.FileDatas `@autowired:"FileDatas"`
-	c ipakku.AppCache   `@autowired:"AppCache"`
+	lock       sync.Mutex // 保护使用次数的读写
+	signer     *URLSigner
+	ks         *SignKeyStory
+	signLock   sync.Mutex        // 保护密钥环的加载
+	signLoaded time.Time         // 上次加载密钥环的时间
+	signMissed time.Time         // 上次因未知密钥ID加载密钥环的时间
+	signKeep   int               // 轮换时保留的旧密钥个数
+	f          service.FileDatas `@autowired:"FileDatas"`
+	c          ipakku.AppCache   `@autowired:"AppCache"`
+	conf       ipakku.AppConfig  `@autowired:"AppConfig"`
 }
 
 // AsModule 模块加载器接口实现, 返回模块信息&配置
 func (n *TransportToken) AsModule() ipakku.Opts {
 	return ipakku.Opts{
 		Name:        "TransportToken",
-		Version:     1.0,
+		Version:     1.1,
 		Description: "文件传输控制",
+		OnReady: func(mctx ipakku.Loader) {
+			deftDataSource := "./.datas/" + mctx.GetParam(ipakku.PARAMKEY_APPNAME).ToString("app") + ".db?cache=shared"
+			confDataSource := n.conf.GetConfig(CONFKEY_SOTREDATASOURCE).ToString(deftDataSource)
+			if confDataSource == deftDataSource {
+				n.mkSqliteDIR() // 创建sqlite文件存放目录
+			}
+			n.ks = new(SignKeyStory)
+			if err := n.ks.Initial(constants.DBSetting{
+				DriverName:     n.conf.GetConfig(CONFKEY_SOTREDRIVER).ToString("sqlite3"),
+				DataSourceName: confDataSource,
+			}); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnSetup: func() {
+			// 执行建库、建表
+			if err := n.ks.Install(); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnUpdate: func(cv float64) {
+			// 1.1 新增签名密钥表
+			if cv < 1.1 {
+				if err := n.ks.Install(); nil != err {
+					logs.Panicln(err)
+				}
+			}
+		},
 		OnInit: func() {
 			if err := n.c.RegLib(service.CacheLib_StreamToken, service.CacheLib_StreamToken_Exp); nil != err {
 				logs.Panicln(err)
 			}
+			n.signKeep = int(service.GetInt64Config(n.conf, CONFKEY_SIGNKEEPKEYS, 1))
+			if err := n.initSignKeys(); nil != err {
+				logs.Panicln(err)
+			}
 		},
 	}
 }
@@ -49,6 +96,7 @@ func (n *TransportToken) AskWriteToken(src string, props map[string]string) (*se
 		CTime:    time.Now().UnixMilli(),
 		MTime:    time.Now().UnixMilli(),
 		Type:     service.StreamTokenType_Write,
+		Props:    props,
 	}
 	if err := n.c.Set(service.CacheLib_StreamToken, st.Token, st); nil == err {
 		return st, nil
@@ -69,6 +117,7 @@ func (n *TransportToken) AskReadToken(src string, props map[string]string) (*ser
 		CTime:    time.Now().UnixMilli(),
 		MTime:    time.Now().UnixMilli(),
 		Type:     service.StreamTokenType_Read,
+		Props:    props,
 	}
 	if err := n.c.Set(service.CacheLib_StreamToken, st.Token, st); nil == err {
 		return st, nil
@@ -77,10 +126,13 @@ func (n *TransportToken) AskReadToken(src string, props map[string]string) (*ser
 	}
 }
 
-// QueryToken 查出token
+// QueryToken 查出token, 超过绝对过期时间的视为无效
 func (n *TransportToken) QueryToken(token string) (*service.StreamToken, error) {
 	var val service.StreamToken
 	if err := n.c.Get(service.CacheLib_StreamToken, token, &val); nil == err {
+		if expiresAt := getPropInt(&val, service.StreamTokenProp_ExpiresAt); expiresAt > 0 && time.Now().UnixMilli() >= expiresAt {
+			return nil, service.ErrInvalidToken
+		}
 		return &val, nil
 	} else if err == ipakku.ErrNoCacheHit {
 		return nil, service.ErrInvalidToken
@@ -90,8 +142,38 @@ func (n *TransportToken) QueryToken(token string) (*service.StreamToken, error)
 
 }
 
-// RefreshToken RefreshToken
+// UseToken 校验绑定IP和使用次数, count为true时使用次数+1;
+// 计数是"读取-加一-写回", 只在本实例内加锁, 缓存没有原子自增操作. 多个实例共用缓存时并发请求可能读到同一个次数,
+// 实际使用次数可能略超过 maxUses, 需要严格限制次数时应把同一个令牌的请求路由到同一个实例
+func (n *TransportToken) UseToken(token, clientIP string, count bool) (*service.StreamToken, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	st, err := n.QueryToken(token)
+	if nil != err {
+		return nil, err
+	}
+	if bindIP := st.Props[service.StreamTokenProp_BindIP]; len(bindIP) > 0 && bindIP != clientIP {
+		return nil, service.ErrInvalidToken
+	}
+	if maxUses := getPropInt(st, service.StreamTokenProp_MaxUses); maxUses > 0 {
+		uses := getPropInt(st, service.StreamTokenProp_Uses)
+		if uses >= maxUses {
+			return nil, service.ErrInvalidToken
+		}
+		if count {
+			st.Props[service.StreamTokenProp_Uses] = strconv.FormatInt(uses+1, 10)
+			if err = n.c.Set(service.CacheLib_StreamToken, token, st); nil != err {
+				return nil, err
+			}
+		}
+	}
+	return st, nil
+}
+
+// RefreshToken 刷新token的有效期, 不会超过绝对过期时间
 func (n *TransportToken) RefreshToken(token string) (st *service.StreamToken, err error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
 	if st, err = n.QueryToken(token); nil == err {
 		st.MTime = time.Now().UnixMilli()
 		if err = n.c.Set(service.CacheLib_StreamToken, token, st); nil != err {
@@ -116,3 +198,119 @@ func (n *TransportToken) DestroyToken(token string, override bool) (err error) {
 	}
 	return err
 }
+
+// getPropInt 读取token中的数字属性, 不存在或格式不对时为0
+func getPropInt(st *service.StreamToken, key string) int64 {
+	if val, err := strconv.ParseInt(st.Props[key], 10, 64); nil == err {
+		return val
+	}
+	return 0
+}
+
+// SignURL 生成签名下载地址参数, 校验时不查询缓存; expiresAt<=0时使用默认有效期
+func (n *TransportToken) SignURL(src, userID string, expiresAt int64) (url.Values, error) {
+	if node := n.f.GetNode(src); nil == node || !node.IsFile {
+		return nil, fileutil.PathNotExist("signURL", src)
+	}
+	if expiresAt <= 0 {
+		expiresAt = time.Now().UnixMilli() + service.GetInt64Config(n.conf, CONFKEY_SIGNEXPIRES, service.CacheLib_StreamToken_Exp)*1000
+	}
+	if err := n.loadSignKeys(signKeyReloadInterval); nil != err {
+		logs.Errorln(err)
+	}
+	return n.signer.Sign(src, userID, expiresAt)
+}
+
+// VerifyURL 校验签名地址, 返回只读的token信息, 该token不在缓存中, 不能刷新
+func (n *TransportToken) VerifyURL(query url.Values) (*service.StreamToken, error) {
+	// 其他实例轮换后产生的新密钥, 重新加载后再校验, 限制加载频率
+	if !n.signer.HasKeyID(query.Get(signParamKeyID)) && n.markSignMissed() {
+		if err := n.loadSignKeys(0); nil != err {
+			logs.Errorln(err)
+		}
+	}
+	path, userID, expiresAt, err := n.signer.Verify(query)
+	if nil != err {
+		return nil, err
+	}
+	return &service.StreamToken{
+		FilePath: path,
+		CTime:    time.Now().UnixMilli(),
+		MTime:    time.Now().UnixMilli(),
+		Type:     service.StreamTokenType_Read,
+		Props: map[string]string{
+			service.StreamTokenProp_UserID:    userID,
+			service.StreamTokenProp_ExpiresAt: strconv.FormatInt(expiresAt, 10),
+		},
+	}, nil
+}
+
+// RotateSignKey 轮换签名密钥, 旧密钥保留用于校验未过期的地址, 返回新密钥ID
+func (n *TransportToken) RotateSignKey() (string, error) {
+	key, err := newSignKey()
+	if nil != err {
+		return "", err
+	}
+	if err = n.ks.AddKey(key, n.signKeep); nil != err {
+		return "", err
+	}
+	return key.kid, n.loadSignKeys(0)
+}
+
+// initSignKeys 加载密钥环, 数据库中没有密钥时导入旧版本配置文件中的密钥或者生成新密钥
+func (n *TransportToken) initSignKeys() error {
+	n.signer = NewURLSigner("")
+	if err := n.loadSignKeys(0); nil != err || n.signer.HasKey() {
+		return err
+	}
+	if nil != n.conf {
+		keys := NewURLSigner(n.conf.GetConfig(CONFKEY_SIGNKEYS).ToString("")).keys
+		// 倒序保存, 第一个仍为当前密钥
+		for i := len(keys) - 1; i >= 0; i-- {
+			if err := n.ks.AddKey(keys[i], len(keys)); nil != err {
+				return err
+			}
+		}
+		if len(keys) > 0 {
+			return n.loadSignKeys(0)
+		}
+	}
+	_, err := n.RotateSignKey()
+	return err
+}
+
+// loadSignKeys 从数据库加载密钥环, 距上次加载不足maxAge时跳过
+func (n *TransportToken) loadSignKeys(maxAge time.Duration) error {
+	n.signLock.Lock()
+	defer n.signLock.Unlock()
+	if maxAge > 0 && time.Since(n.signLoaded) < maxAge {
+		return nil
+	}
+	keys, err := n.ks.ListKeys()
+	if nil != err {
+		return err
+	}
+	n.signer.SetKeys(keys)
+	n.signLoaded = time.Now()
+	return nil
+}
+
+// markSignMissed 遇到未知密钥ID时是否需要重新加载, 每秒最多一次, 防止伪造的kid频繁查询数据库
+func (n *TransportToken) markSignMissed() bool {
+	n.signLock.Lock()
+	defer n.signLock.Unlock()
+	if time.Since(n.signMissed) < time.Second {
+		return false
+	}
+	n.signMissed = time.Now()
+	return true
+}
+
+// mkSqliteDIR 创建sqlite文件存放目录
+func (n *TransportToken) mkSqliteDIR() {
+	if !fileutil.IsExist("./.datas") {
+		if err := fileutil.MkdirAll("./.datas"); nil != err {
+			logs.Panicln(err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package filetransport
+
+import (
+	"fileservice/business/service"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wup364/pakku"
+	"github.com/wup364/pakku/modules/appcache"
+)
+
+func TestTokenRestrictions(t *testing.T) {
+	app := pakku.NewApplication("filetransport-test").EnableCoreModule().BootStart()
+	tt := new(TransportToken)
+	app.GetModuleByName(new(appcache.AppCache).AsModule().Name, &tt.c)
+	if err := tt.c.RegLib(service.CacheLib_StreamToken, service.CacheLib_StreamToken_Exp); nil != err {
+		t.Fatal(err)
+	}
+	// 使用次数
+	st, err := tt.AskWriteToken("/a.txt", map[string]string{service.StreamTokenProp_MaxUses: "2"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err = tt.UseToken(st.Token, "", false); nil != err {
+		t.Fatal("check should not count", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = tt.UseToken(st.Token, "", true); nil != err {
+			t.Fatal(i, err)
+		}
+	}
+	if _, err = tt.UseToken(st.Token, "", true); err != service.ErrInvalidToken {
+		t.Fatal("token should be used up", err)
+	}
+	// 绑定IP
+	st, _ = tt.AskWriteToken("/a.txt", map[string]string{service.StreamTokenProp_BindIP: "10.0.0.1"})
+	if _, err = tt.UseToken(st.Token, "10.0.0.2", true); err != service.ErrInvalidToken {
+		t.Fatal("token should be bound to ip", err)
+	}
+	if _, err = tt.UseToken(st.Token, "10.0.0.1", true); nil != err {
+		t.Fatal(err)
+	}
+	// 绝对过期时间, 刷新不能延长
+	expiresAt := time.Now().UnixMilli() + 200
+	st, _ = tt.AskWriteToken("/a.txt", map[string]string{service.StreamTokenProp_ExpiresAt: strconv.FormatInt(expiresAt, 10)})
+	if _, err = tt.RefreshToken(st.Token); nil != err {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err = tt.RefreshToken(st.Token); err != service.ErrInvalidToken {
+		t.Fatal("refresh should not extend expiry", err)
+	}
+	if _, err = tt.QueryToken(st.Token); err != service.ErrInvalidToken {
+		t.Fatal("token should be expired", err)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 用db存放签名密钥, 多实例共享同一个密钥环
+
+package filetransport
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fileservice/business/constants"
+	"time"
+)
+
+// SignKeyStory 签名密钥存储
+type SignKeyStory struct {
+	db *sql.DB
+}
+
+// Initial 初始化配置
+func (ks *SignKeyStory) Initial(st constants.DBSetting) (err error) {
+	if nil == ks.db {
+		ks.db, err = sql.Open(st.DriverName, st.DataSourceName)
+		if nil == err {
+			if st.DriverName == "sqlite3" {
+				// database is locked
+				// https://github.com/mattn/go-sqlite3/issues/209
+				ks.db.SetMaxOpenConns(1)
+			} else {
+				ks.db.SetMaxIdleConns(250)
+				ks.db.SetConnMaxLifetime(time.Hour)
+			}
+		}
+	}
+	return err
+}
+
+// Install 初始化 filesignkeys 表
+func (ks *SignKeyStory) Install() (err error) {
+	var tx *sql.Tx
+	if tx, err = ks.db.Begin(); err == nil {
+		for _, ddl := range []string{
+			`CREATE TABLE IF NOT EXISTS filesignkeys(
+				kid VARCHAR(32) NOT NULL,
+				secret VARCHAR(128) NOT NULL,
+				ctime BIGINT NOT NULL,
+				PRIMARY KEY(kid)
+			);`,
+		} {
+			if _, err = tx.Exec(ddl); nil != err {
+				tx.Rollback()
+				return err
+			}
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// ListKeys 列出密钥, 新密钥在前
+func (ks *SignKeyStory) ListKeys() ([]signKey, error) {
+	rows, err := ks.db.Query("SELECT kid, secret FROM filesignkeys ORDER BY ctime DESC, kid DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]signKey, 0)
+	for rows.Next() {
+		var kid, secret string
+		if err := rows.Scan(&kid, &secret); err != nil {
+			return nil, err
+		}
+		if bt, err := hex.DecodeString(secret); nil == err && len(bt) > 0 {
+			res = append(res, signKey{kid: kid, secret: bt})
+		}
+	}
+	return res, nil
+}
+
+// AddKey 保存新密钥, 最多保留keep个旧密钥
+func (ks *SignKeyStory) AddKey(key signKey, keep int) (err error) {
+	var tx *sql.Tx
+	if tx, err = ks.db.Begin(); err == nil {
+		if _, err = tx.Exec("INSERT INTO filesignkeys(kid, secret, ctime) values(?,?,?)",
+			key.kid, hex.EncodeToString(key.secret), time.Now().UnixNano()); nil != err {
+			tx.Rollback()
+			return err
+		}
+		if keep < 0 {
+			keep = 0
+		}
+		var kids []string
+		if kids, err = ks.listKids(tx); nil != err {
+			tx.Rollback()
+			return err
+		}
+		for i := keep + 1; i < len(kids); i++ {
+			if _, err = tx.Exec("DELETE FROM filesignkeys WHERE kid=?", kids[i]); nil != err {
+				tx.Rollback()
+				return err
+			}
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// listKids 列出密钥ID, 新密钥在前
+func (ks *SignKeyStory) listKids(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query("SELECT kid FROM filesignkeys ORDER BY ctime DESC, kid DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	kids := make([]string, 0)
+	for rows.Next() {
+		var kid string
+		if err := rows.Scan(&kid); err != nil {
+			return nil, err
+		}
+		kids = append(kids, kid)
+	}
+	return kids, rows.Err()
+}
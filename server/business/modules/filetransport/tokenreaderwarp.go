@@ -17,8 +17,11 @@ import (
 	"time"
 )
 
-// NewTokenReaderWarp 可以在持续读取数据的情况下保持token有效
+// NewTokenReaderWarp 可以在持续读取数据的情况下保持token有效, 签名地址等不在缓存中的token无需刷新
 func NewTokenReaderWarp(t *service.StreamToken, r io.Reader, tr TokenRefreshI) io.Reader {
+	if nil == t || len(t.Token) == 0 {
+		return r
+	}
 	return &tokenReaderWarp{maxCount: 1000, refreshMS: service.CacheLib_StreamToken_Exp / 2, token: t, r: r, tri: tr}
 }
 
@@ -0,0 +1,176 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 签名下载地址, 不依赖缓存校验; 密钥以 kid:secret 逗号分隔存放, 第一个为当前签名密钥, 其余只用于校验
+
+package filetransport
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fileservice/business/service"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 签名地址参数
+const (
+	signParamPath    = "path"
+	signParamUserID  = "uid"
+	signParamPerm    = "perm"
+	signParamExpires = "exp"
+	signParamKeyID   = "kid"
+	signParamSign    = "sig"
+	// signPermRead 只读
+	signPermRead = "r"
+)
+
+// signKey 签名密钥
+type signKey struct {
+	kid    string
+	secret []byte
+}
+
+// URLSigner 签名地址生成&校验
+type URLSigner struct {
+	lock sync.RWMutex
+	keys []signKey
+}
+
+// NewURLSigner 从 kid:secret,kid:secret 格式的字符串中加载密钥
+func NewURLSigner(keys string) *URLSigner {
+	signer := new(URLSigner)
+	for _, item := range strings.Split(keys, ",") {
+		if kv := strings.SplitN(strings.TrimSpace(item), ":", 2); len(kv) == 2 && len(kv[0]) > 0 {
+			if secret, err := hex.DecodeString(kv[1]); nil == err && len(secret) > 0 {
+				signer.keys = append(signer.keys, signKey{kid: kv[0], secret: secret})
+			}
+		}
+	}
+	return signer
+}
+
+// HasKey 是否有可用的签名密钥
+func (s *URLSigner) HasKey() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.keys) > 0
+}
+
+// HasKeyID 是否有该ID的密钥
+func (s *URLSigner) HasKeyID(kid string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, key := range s.keys {
+		if key.kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// SetKeys 替换全部密钥, 第一个为当前签名密钥
+func (s *URLSigner) SetKeys(keys []signKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys = keys
+}
+
+// Rotate 生成新的签名密钥, 最多保留keep个旧密钥用于校验未过期的地址, 返回新密钥ID
+func (s *URLSigner) Rotate(keep int) (string, error) {
+	key, err := newSignKey()
+	if nil != err {
+		return "", err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys := append([]signKey{key}, s.keys...)
+	if keep < 0 {
+		keep = 0
+	}
+	if len(keys) > keep+1 {
+		keys = keys[:keep+1]
+	}
+	s.keys = keys
+	return key.kid, nil
+}
+
+// newSignKey 生成随机密钥
+func newSignKey() (signKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); nil != err {
+		return signKey{}, err
+	}
+	return signKey{kid: strconv.FormatInt(time.Now().UnixNano(), 36), secret: secret}, nil
+}
+
+// String 转为 kid:secret,kid:secret 格式保存
+func (s *URLSigner) String() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	items := make([]string, len(s.keys))
+	for i, key := range s.keys {
+		items[i] = key.kid + ":" + hex.EncodeToString(key.secret)
+	}
+	return strings.Join(items, ",")
+}
+
+// Sign 使用当前密钥签名, 返回地址参数
+func (s *URLSigner) Sign(path, userID string, expiresAt int64) (url.Values, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.keys) == 0 {
+		return nil, service.ErrInvalidToken
+	}
+	exp := strconv.FormatInt(expiresAt, 10)
+	return url.Values{
+		signParamPath:    {path},
+		signParamUserID:  {userID},
+		signParamPerm:    {signPermRead},
+		signParamExpires: {exp},
+		signParamKeyID:   {s.keys[0].kid},
+		signParamSign:    {sign(s.keys[0], signPermRead, path, userID, exp)},
+	}, nil
+}
+
+// Verify 校验签名和过期时间, 返回文件路径和用户ID
+func (s *URLSigner) Verify(query url.Values) (path, userID string, expiresAt int64, err error) {
+	path, userID = query.Get(signParamPath), query.Get(signParamUserID)
+	perm, exp, kid := query.Get(signParamPerm), query.Get(signParamExpires), query.Get(signParamKeyID)
+	if perm != signPermRead || len(path) == 0 {
+		return "", "", 0, service.ErrInvalidToken
+	}
+	if expiresAt, err = strconv.ParseInt(exp, 10, 64); nil != err || time.Now().UnixMilli() >= expiresAt {
+		return "", "", 0, service.ErrInvalidToken
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, key := range s.keys {
+		if key.kid == kid {
+			if hmac.Equal([]byte(sign(key, perm, path, userID, exp)), []byte(query.Get(signParamSign))) {
+				return path, userID, expiresAt, nil
+			}
+			break
+		}
+	}
+	return "", "", 0, service.ErrInvalidToken
+}
+
+// sign HMAC-SHA256(kid, perm, path, uid, exp)
+func sign(key signKey, perm, path, userID, exp string) string {
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write([]byte(strings.Join([]string{key.kid, perm, path, userID, exp}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,118 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package filetransport
+
+import (
+	"fileservice/business/constants"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner("")
+	if signer.HasKey() {
+		t.Fatal("empty signer should not have key")
+	}
+	if _, err := signer.Rotate(1); nil != err {
+		t.Fatal(err)
+	}
+	query, err := signer.Sign("/a/b.txt", "user01", time.Now().UnixMilli()+60000)
+	if nil != err {
+		t.Fatal(err)
+	}
+	// 额外参数不影响签名
+	query.Set("name", "b.txt")
+	if path, userID, _, err := signer.Verify(query); nil != err || path != "/a/b.txt" || userID != "user01" {
+		t.Fatal("verify failed", path, userID, err)
+	}
+	// 篡改
+	for _, key := range []string{signParamPath, signParamUserID, signParamExpires, signParamPerm, signParamSign} {
+		tampered := make(map[string][]string)
+		for k, v := range query {
+			tampered[k] = v
+		}
+		tampered[key] = []string{query.Get(key) + "0"}
+		if _, _, _, err := signer.Verify(tampered); nil == err {
+			t.Fatal("tampered " + key + " should be rejected")
+		}
+	}
+	// 过期
+	expired, _ := signer.Sign("/a/b.txt", "user01", time.Now().UnixMilli()-1)
+	if _, _, _, err := signer.Verify(expired); nil == err {
+		t.Fatal("expired url should be rejected")
+	}
+	// 轮换后旧地址在保留期内有效, 超出保留个数后失效; 重新加载后一致
+	signer.Rotate(1)
+	if _, _, _, err := signer.Verify(query); nil != err {
+		t.Fatal("previous key should still be valid", err)
+	}
+	if _, _, _, err := NewURLSigner(signer.String()).Verify(query); nil != err {
+		t.Fatal("reloaded signer should verify", err)
+	}
+	signer.Rotate(1)
+	if _, _, _, err := signer.Verify(query); nil == err {
+		t.Fatal("dropped key should be rejected")
+	}
+}
+
+func TestSignKeyRing(t *testing.T) {
+	// 两个实例共享同一个数据库
+	datasource := filepath.Join(t.TempDir(), "keys.db")
+	newInstance := func() *TransportToken {
+		ks := new(SignKeyStory)
+		if err := ks.Initial(constants.DBSetting{DriverName: "sqlite3", DataSourceName: datasource}); nil != err {
+			t.Fatal(err)
+		}
+		if err := ks.Install(); nil != err {
+			t.Fatal(err)
+		}
+		n := &TransportToken{ks: ks, signKeep: 1}
+		if err := n.initSignKeys(); nil != err {
+			t.Fatal(err)
+		}
+		return n
+	}
+	a, b := newInstance(), newInstance()
+	if a.signer.String() != b.signer.String() {
+		t.Fatal("instances should share the first key")
+	}
+	// a 轮换后, b 遇到新的kid时重新加载
+	kid, err := a.RotateSignKey()
+	if nil != err {
+		t.Fatal(err)
+	}
+	query, _ := a.signer.Sign("/a.txt", "user01", time.Now().UnixMilli()+60000)
+	if query.Get(signParamKeyID) != kid {
+		t.Fatal("should sign with the new key")
+	}
+	if _, err := b.VerifyURL(query); nil != err {
+		t.Fatal("other instance should verify the new key", err)
+	}
+	// 超出保留个数的密钥被删除, 重新加载后失效
+	old, _ := b.signer.Sign("/a.txt", "user01", time.Now().UnixMilli()+60000)
+	if _, err := b.RotateSignKey(); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := a.RotateSignKey(); nil != err {
+		t.Fatal(err)
+	}
+	if err := b.loadSignKeys(0); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := b.VerifyURL(old); nil == err {
+		t.Fatal("dropped key should be rejected")
+	}
+	if keys, _ := a.ks.ListKeys(); len(keys) != 2 {
+		t.Fatal("should keep 1 previous key", len(keys))
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// cron表达式解析, 格式: 分 时 日 月 周, 支持 * , - / 以及 @hourly 等别名
+
+package jobscheduler
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorCronExpr cron表达式格式错误
+var ErrorCronExpr = errors.New("invalid cron expression, format: minute hour day month weekday")
+
+// cronAlias 表达式别名
+var cronAlias = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// CronExpr 解析后的cron表达式, 每一段用位表示允许的值
+type CronExpr struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+// ParseCron 解析cron表达式
+func ParseCron(expr string) (*CronExpr, error) {
+	expr = strings.TrimSpace(expr)
+	if alias, ok := cronAlias[expr]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, ErrorCronExpr
+	}
+	var err error
+	c := &CronExpr{}
+	if c.minute, err = parseCronField(fields[0], 0, 59); nil != err {
+		return nil, err
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); nil != err {
+		return nil, err
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); nil != err {
+		return nil, err
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); nil != err {
+		return nil, err
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); nil != err {
+		return nil, err
+	}
+	// 7 也表示周日
+	if c.dow&(1<<7) > 0 {
+		c.dow |= 1
+	}
+	c.domStar = strings.HasPrefix(fields[2], "*")
+	c.dowStar = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseCronField 解析一段, 返回允许值的位集合
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); nil != err || step <= 0 {
+				return 0, ErrorCronExpr
+			}
+			part = part[:i]
+		}
+		start, end := min, max
+		if part != "*" {
+			var err error
+			if i := strings.Index(part, "-"); i >= 0 {
+				if start, err = strconv.Atoi(part[:i]); nil != err {
+					return 0, ErrorCronExpr
+				}
+				if end, err = strconv.Atoi(part[i+1:]); nil != err {
+					return 0, ErrorCronExpr
+				}
+			} else {
+				if start, err = strconv.Atoi(part); nil != err {
+					return 0, ErrorCronExpr
+				}
+				// 5/10 表示从5开始每10个
+				if end = start; step > 1 {
+					end = max
+				}
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, ErrorCronExpr
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Match 时间是否匹配, 精确到分钟
+func (c *CronExpr) Match(t time.Time) bool {
+	return c.minute&(1<<uint(t.Minute())) > 0 && c.hour&(1<<uint(t.Hour())) > 0 && c.matchDay(t)
+}
+
+// Next 返回t之后下一个匹配的时间, 5年内没有匹配时返回零值
+func (c *CronExpr) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		} else if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		} else if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		} else if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay 日期是否匹配, 日和周都有限制时满足其一即可
+func (c *CronExpr) matchDay(t time.Time) bool {
+	if c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := c.dom&(1<<uint(t.Day())) > 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) > 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
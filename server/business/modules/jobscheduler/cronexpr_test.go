@@ -0,0 +1,84 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package jobscheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); nil == err {
+			t.Fatal("expected error:", expr)
+		}
+	}
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if nil != err {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	cases := []struct {
+		expr  string
+		time  string
+		match bool
+	}{
+		{"* * * * *", "2024-03-05 10:17", true},
+		{"0 2 * * *", "2024-03-05 02:00", true},
+		{"0 2 * * *", "2024-03-05 02:01", false},
+		{"*/15 9-17 * * 1-5", "2024-03-05 09:45", true},
+		{"*/15 9-17 * * 1-5", "2024-03-09 09:45", false}, // 周六
+		{"5/10 * * * *", "2024-03-05 10:25", true},
+		{"5/10 * * * *", "2024-03-05 10:20", false},
+		{"0 0 1,15 * *", "2024-03-15 00:00", true},
+		{"0 0 * * 7", "2024-03-10 00:00", true},  // 周日
+		{"0 0 13 * 5", "2024-03-08 00:00", true}, // 日和周都有限制时满足其一
+		{"@weekly", "2024-03-10 00:00", true},
+		{"@monthly", "2024-03-02 00:00", false},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.expr)
+		if nil != err {
+			t.Fatal(c.expr, err)
+		}
+		if cron.Match(at(c.time)) != c.match {
+			t.Fatal(c.expr, c.time, !c.match)
+		}
+	}
+	// 下次执行时间
+	next := []struct {
+		expr string
+		from string
+		next string
+	}{
+		{"0 2 * * *", "2024-03-05 02:00", "2024-03-06 02:00"},
+		{"30 * * * *", "2024-03-05 10:17", "2024-03-05 10:30"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"0 0 * * 1", "2024-12-31 12:00", "2025-01-06 00:00"},
+	}
+	for _, c := range next {
+		cron, _ := ParseCron(c.expr)
+		if got := cron.Next(at(c.from)); !got.Equal(at(c.next)) {
+			t.Fatal(c.expr, c.from, got)
+		}
+	}
+	if cron, _ := ParseCron("0 0 31 2 *"); !cron.Next(time.Now()).IsZero() {
+		t.Fatal("expected no next time")
+	}
+}
+
+func TestExpandParam(t *testing.T) {
+	tm := time.Date(2024, 3, 5, 7, 9, 0, 0, time.Local)
+	if val := expandParam("/archive/{YYYY}-{MM}-{DD}/{hh}{mm}", tm); val != "/archive/2024-03-05/0709" {
+		t.Fatal(val)
+	}
+}
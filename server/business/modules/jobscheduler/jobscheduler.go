@@ -0,0 +1,302 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 定时任务模块, 按cron表达式以所属用户的身份执行异步任务, 并保留执行记录
+
+package jobscheduler
+
+import (
+	"errors"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/fileutil"
+	"github.com/wup364/pakku/utils/logs"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+// JobScheduler 定时任务模块
+type JobScheduler struct {
+	js         *JobStory
+	maxHistory int
+	conf       ipakku.AppConfig     `@autowired:"AppConfig"`
+	ast        service.AsyncTask    `@autowired:"AsyncTask"`
+	um         service.UserAuth4Rpc `@autowired:"User4RPC"`
+	al         service.AuditLog     `@autowired:"AuditLog"`
+}
+
+// AsModule 模块加载器接口实现, 返回模块信息&配置
+func (m *JobScheduler) AsModule() ipakku.Opts {
+	return ipakku.Opts{
+		Name:        "JobScheduler",
+		Version:     1.0,
+		Description: "定时任务模块",
+		OnReady: func(mctx ipakku.Loader) {
+			deftDataSource := "./.datas/" + mctx.GetParam(ipakku.PARAMKEY_APPNAME).ToString("app") + ".db?cache=shared"
+			confDataSource := m.conf.GetConfig("jobscheduler.sotre.datasource").ToString(deftDataSource)
+			if confDataSource == deftDataSource {
+				m.mkSqliteDIR() // 创建sqlite文件存放目录
+			}
+			m.js = new(JobStory)
+			if err := m.js.Initial(constants.DBSetting{
+				DriverName:     m.conf.GetConfig("jobscheduler.sotre.driver").ToString("sqlite3"),
+				DataSourceName: confDataSource,
+			}); nil != err {
+				logs.Panicln(err)
+			}
+			m.maxHistory = int(service.GetInt64Config(m.conf, "jobscheduler.maxhistory", 100))
+		},
+		OnSetup: func() {
+			// 执行建库、建表
+			if err := m.js.Install(); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnInit: func() {
+			go m.doSchedule()
+		},
+	}
+}
+
+// AddJob 添加定时任务, 返回补全ID和下次执行时间后的任务
+func (m *JobScheduler) AddJob(job service.JobInfo) (*service.JobInfo, error) {
+	if err := m.checkJob(&job); nil != err {
+		return nil, err
+	}
+	job.JobID = strutil.GetUUID()
+	job.LastRun = 0
+	job.CtTime = time.Now().UnixMilli()
+	if err := m.js.AddJob(job); nil != err {
+		return nil, err
+	}
+	return m.fillNextRun(&job), nil
+}
+
+// UpdateJob 修改定时任务, 所属用户不能修改
+func (m *JobScheduler) UpdateJob(job service.JobInfo) (*service.JobInfo, error) {
+	old, err := m.QueryJob(job.JobID)
+	if nil != err {
+		return nil, err
+	}
+	job.UserID = old.UserID
+	if err := m.checkJob(&job); nil != err {
+		return nil, err
+	}
+	if err := m.js.UpdateJob(job); nil != err {
+		return nil, err
+	}
+	job.LastRun = old.LastRun
+	job.CtTime = old.CtTime
+	return m.fillNextRun(&job), nil
+}
+
+// DelJob 删除定时任务及执行记录
+func (m *JobScheduler) DelJob(jobID string) error {
+	if _, err := m.QueryJob(jobID); nil != err {
+		return err
+	}
+	return m.js.DelJob(jobID)
+}
+
+// QueryJob 查询定时任务, 不存在返回 ErrorJobNotExist
+func (m *JobScheduler) QueryJob(jobID string) (*service.JobInfo, error) {
+	if job, err := m.js.QueryJob(jobID); nil != err {
+		return nil, err
+	} else if nil == job {
+		return nil, service.ErrorJobNotExist
+	} else {
+		return m.fillNextRun(job), nil
+	}
+}
+
+// ListJobs 列出用户的定时任务, userID为空时列出全部
+func (m *JobScheduler) ListJobs(userID string) (jobs []service.JobInfo, err error) {
+	if len(userID) == 0 {
+		jobs, err = m.js.ListAllJobs()
+	} else {
+		jobs, err = m.js.ListJobs(userID)
+	}
+	for i := 0; i < len(jobs); i++ {
+		m.fillNextRun(&jobs[i])
+	}
+	return jobs, err
+}
+
+// RunJob 立即执行一次
+func (m *JobScheduler) RunJob(jobID string) (*service.JobRunInfo, error) {
+	job, err := m.QueryJob(jobID)
+	if nil != err {
+		return nil, err
+	}
+	return m.runJob(*job, time.Now()), nil
+}
+
+// ListJobRuns 列出执行记录, 按时间倒序, 已发起的任务使用任务记录中的状态
+func (m *JobScheduler) ListJobRuns(jobID string, limit int) ([]service.JobRunInfo, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+	runs, err := m.js.ListRuns(jobID, limit)
+	if nil != err {
+		return nil, err
+	}
+	for i := 0; i < len(runs); i++ {
+		if runs[i].State != service.JobRunState_Started {
+			continue
+		}
+		if task, err := m.ast.QueryTask(runs[i].TaskID); nil == err {
+			runs[i].State = task.State
+			runs[i].Result = task.Result
+		}
+	}
+	return runs, nil
+}
+
+// checkJob 校验任务定义
+func (m *JobScheduler) checkJob(job *service.JobInfo) error {
+	if len(job.UserID) == 0 {
+		return errors.New("userid is empty")
+	}
+	if _, err := ParseCron(job.Cron); nil != err {
+		return err
+	}
+	if _, err := m.ast.GetTaskObject(job.TaskType); nil != err {
+		return err
+	}
+	if len(job.Name) == 0 {
+		job.Name = job.TaskType
+	}
+	return nil
+}
+
+// fillNextRun 计算下次执行时间, 未启用时为0
+func (m *JobScheduler) fillNextRun(job *service.JobInfo) *service.JobInfo {
+	job.NextRun = 0
+	if job.Enabled {
+		if cron, err := ParseCron(job.Cron); nil == err {
+			if next := cron.Next(time.Now()); !next.IsZero() {
+				job.NextRun = next.UnixMilli()
+			}
+		}
+	}
+	return job
+}
+
+// doSchedule 每分钟检查一次需要执行的任务, 停机期间错过的不补执行;
+// 每个实例都会检查, 执行前先在库中认领, 同一次执行只由一个实例发起
+func (m *JobScheduler) doSchedule() {
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		time.Sleep(time.Until(next))
+		jobs, err := m.js.ListAllJobs()
+		if nil != err {
+			logs.Errorln(err)
+			continue
+		}
+		for _, job := range jobs {
+			if !job.Enabled {
+				continue
+			}
+			if cron, err := ParseCron(job.Cron); nil != err {
+				logs.Errorln(job.JobID, err)
+			} else if cron.Match(next) {
+				if claimed, err := m.js.ClaimRun(job.JobID, next.UnixMilli()); nil != err {
+					logs.Errorln(job.JobID, err)
+				} else if claimed {
+					m.runJob(job, next)
+				}
+			}
+		}
+	}
+}
+
+// runJob 发起异步任务并记录执行结果
+func (m *JobScheduler) runJob(job service.JobInfo, t time.Time) *service.JobRunInfo {
+	run := &service.JobRunInfo{
+		RunID:  strutil.GetUUID(),
+		JobID:  job.JobID,
+		State:  service.JobRunState_Started,
+		StTime: t.UnixMilli(),
+	}
+	taskID, err := m.execute(job, t)
+	if nil != err {
+		run.State = service.JobRunState_Failed
+		run.Result = err.Error()
+		logs.Errorf("JobScheduler run %s failed: %s\r\n", job.JobID, err.Error())
+	}
+	run.TaskID = taskID
+	if err := m.js.AddRun(*run, m.maxHistory); nil != err {
+		logs.Errorln(err)
+	}
+	if err := m.js.UpdateLastRun(job.JobID, run.StTime); nil != err {
+		logs.Errorln(err)
+	}
+	m.al.Record(service.AuditInfo{
+		UserID: job.UserID,
+		Action: service.AuditAction_JobRun,
+		Src:    job.JobID,
+		Dst:    taskID,
+		Result: run.Result,
+	})
+	return run
+}
+
+// execute 以所属用户的身份调用异步任务, 与接口发起的任务使用相同的权限校验
+func (m *JobScheduler) execute(job service.JobInfo, t time.Time) (string, error) {
+	executor, err := m.ast.GetTaskObject(job.TaskType)
+	if nil != err {
+		return "", err
+	}
+	access, err := m.um.AskAccess4Service(job.UserID)
+	if nil != err {
+		return "", err
+	}
+	defer m.um.DestroyAccess(access.AccessKey)
+	form := url.Values{}
+	form.Set("func", job.TaskType)
+	for key, val := range job.Params {
+		form.Set(key, expandParam(val, t))
+	}
+	r, err := http.NewRequest(http.MethodPost, "/filetask/v1/asyncexec", strings.NewReader(form.Encode()))
+	if nil != err {
+		return "", err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set(service.AuthHeader_AccessKey, access.AccessKey)
+	if err := r.ParseForm(); nil != err {
+		return "", err
+	}
+	return executor.Execute(r)
+}
+
+// expandParam 替换参数中的时间占位符: {YYYY} {MM} {DD} {hh} {mm}
+func expandParam(val string, t time.Time) string {
+	return strings.NewReplacer(
+		"{YYYY}", fmt.Sprintf("%04d", t.Year()),
+		"{MM}", fmt.Sprintf("%02d", t.Month()),
+		"{DD}", fmt.Sprintf("%02d", t.Day()),
+		"{hh}", fmt.Sprintf("%02d", t.Hour()),
+		"{mm}", fmt.Sprintf("%02d", t.Minute()),
+	).Replace(val)
+}
+
+// mkSqliteDIR 创建sqlite文件存放目录
+func (m *JobScheduler) mkSqliteDIR() {
+	if !fileutil.IsExist("./.datas") {
+		if err := fileutil.MkdirAll("./.datas"); nil != err {
+			logs.Panicln(err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package jobscheduler
+
+import (
+	"fileservice/business/modules/asynctask"
+	"fileservice/business/modules/auditlog"
+	"fileservice/business/modules/filedatas"
+	"fileservice/business/modules/filepermission"
+	"fileservice/business/modules/filequota"
+	"fileservice/business/modules/user4rpc"
+	"fileservice/business/service"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/wup364/pakku"
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/modules/appconfig"
+)
+
+func TestJobRun(t *testing.T) {
+	// 应用配置、模块版本和默认的sqlite文件都在工作目录下, 切换到临时目录使每次运行互不影响
+	wd, err := os.Getwd()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); nil != err {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	app := pakku.NewApplication("jobscheduler-test").EnableCoreModule().BootStart()
+	var conf ipakku.AppConfig
+	app.GetModuleByName(new(appconfig.AppConfig).AsModule().Name, &conf)
+	conf.SetConfig(filedatas.CONFKEY_MOUNT+"./."+filedatas.CONFKEY_MOUNTTYPE, "LOCAL")
+	conf.SetConfig(filedatas.CONFKEY_MOUNT+"./."+filedatas.CONFKEY_MOUNTADDR, t.TempDir())
+	var fd service.FileDatas
+	var pms service.FilePermission
+	var um service.User4RPC
+	app.LoadModule(new(filedatas.FileDatas)).GetModuleByName(new(filedatas.FileDatas).AsModule().Name, &fd)
+	app.LoadModule(new(filepermission.FilePermission)).GetModuleByName(new(filepermission.FilePermission).AsModule().Name, &pms)
+	app.LoadModule(new(user4rpc.User4RPC)).GetModuleByName(new(user4rpc.User4RPC).AsModule().Name, &um)
+	app.LoadModule(new(filequota.FileQuota))
+	app.LoadModule(new(auditlog.AuditLog))
+	app.LoadModule(new(asynctask.AsyncTask))
+	m := new(JobScheduler)
+	app.LoadModule(m)
+
+	// user01 只能读写 /a
+	if err = um.AddUser(&service.UserInfo{UserID: "user01", UserName: "user01", UserPWD: "1", UserType: service.UserType_Normal}); nil != err {
+		t.Fatal(err)
+	}
+	if err = pms.AddFPermission(service.PermissionInfo{Path: "/a", UserID: "user01", Permission: (1 << service.FPM_Visible) + (1 << service.FPM_Read) + (1 << service.FPM_Write)}); nil != err {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a/src.txt", "/b/src.txt"} {
+		if err = fd.DoWrite(path, strings.NewReader("job")); nil != err {
+			t.Fatal(err)
+		}
+	}
+	// 有权限: 以所属用户发起异步任务, 目标路径中的时间占位符被替换
+	allowed, err := m.AddJob(service.JobInfo{
+		UserID:   "user01",
+		Cron:     "0 2 * * *",
+		TaskType: "CopyFile",
+		Params:   map[string]string{"srcPath": "/a/src.txt", "dstPath": "/a/{YYYY}{MM}{DD}.txt"},
+		Enabled:  true,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 5, 6, 2, 0, 0, 0, time.Local)
+	run := m.runJob(*allowed, at)
+	if run.State != service.JobRunState_Started || len(run.TaskID) == 0 {
+		t.Fatal(run)
+	}
+	var task *service.TaskInfo
+	for i := 0; i < 100; i++ {
+		if task, err = m.ast.QueryTask(run.TaskID); nil != err {
+			t.Fatal(err)
+		}
+		if task.State == service.TaskState_Completed || task.State == service.TaskState_Failed {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if task.State != service.TaskState_Completed || task.UserID != "user01" {
+		t.Fatal(task)
+	}
+	if !fd.IsFile("/a/20240506.txt") {
+		t.Fatal("expected /a/20240506.txt to be copied")
+	}
+	// 没有权限: 异步任务拒绝执行, 记录发起失败
+	refused, err := m.AddJob(service.JobInfo{
+		UserID:   "user01",
+		Cron:     "0 2 * * *",
+		TaskType: "CopyFile",
+		Params:   map[string]string{"srcPath": "/b/src.txt", "dstPath": "/a/b.txt"},
+		Enabled:  true,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if run := m.runJob(*refused, at); run.State != service.JobRunState_Failed || run.Result != service.ErrorPermissionInsufficient.Error() || len(run.TaskID) > 0 {
+		t.Fatal(run)
+	}
+	if fd.IsExist("/a/b.txt") {
+		t.Fatal("/a/b.txt should not be copied")
+	}
+	// 执行记录: 已发起的使用任务状态, 并更新上次执行时间
+	runs, err := m.ListJobRuns(allowed.JobID, 0)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].TaskID != run.TaskID || runs[0].State != service.TaskState_Completed || runs[0].StTime != at.UnixMilli() {
+		t.Fatal(runs)
+	}
+	if runs, err = m.ListJobRuns(refused.JobID, 0); nil != err || len(runs) != 1 || runs[0].State != service.JobRunState_Failed {
+		t.Fatal(runs, err)
+	}
+	if job, err := m.QueryJob(allowed.JobID); nil != err || job.LastRun != at.UnixMilli() {
+		t.Fatal(job, err)
+	}
+	// 多实例: 同一次执行只能被认领一次, 已执行过的时间点不能再认领
+	next := at.Add(24 * time.Hour).UnixMilli()
+	if claimed, err := m.js.ClaimRun(allowed.JobID, next); nil != err || !claimed {
+		t.Fatal(claimed, err)
+	}
+	if claimed, err := m.js.ClaimRun(allowed.JobID, next); nil != err || claimed {
+		t.Fatal(claimed, err)
+	}
+	if claimed, err := m.js.ClaimRun(allowed.JobID, at.UnixMilli()); nil != err || claimed {
+		t.Fatal(claimed, err)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 用db存放定时任务和执行记录
+
+package jobscheduler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"time"
+)
+
+// JobStory 定时任务存储
+type JobStory struct {
+	db *sql.DB
+}
+
+// Initial 初始化配置
+func (js *JobStory) Initial(st constants.DBSetting) (err error) {
+	if nil == js.db {
+		js.db, err = sql.Open(st.DriverName, st.DataSourceName)
+		if nil == err {
+			if st.DriverName == "sqlite3" {
+				// database is locked
+				// https://github.com/mattn/go-sqlite3/issues/209
+				js.db.SetMaxOpenConns(1)
+			} else {
+				js.db.SetMaxIdleConns(250)
+				js.db.SetConnMaxLifetime(time.Hour)
+			}
+		}
+	}
+	return err
+}
+
+// Install 初始化 filejobs, filejobruns 表
+func (js *JobStory) Install() (err error) {
+	var tx *sql.Tx
+	if tx, err = js.db.Begin(); err == nil {
+		for _, ddl := range []string{
+			`CREATE TABLE IF NOT EXISTS filejobs(
+				jobid VARCHAR(64) PRIMARY KEY,
+				userid VARCHAR(64) NULL,
+				name VARCHAR(255) NULL,
+				cron VARCHAR(255) NULL,
+				tasktype VARCHAR(64) NULL,
+				params TEXT(4000) NULL,
+				enabled INT DEFAULT 0,
+				lastrun BIGINT DEFAULT 0,
+				cttime BIGINT DEFAULT 0
+			);`,
+			`CREATE INDEX idx_filejobs_userid ON filejobs(userid);`,
+			`CREATE TABLE IF NOT EXISTS filejobruns(
+				runid VARCHAR(64) PRIMARY KEY,
+				jobid VARCHAR(64) NULL,
+				taskid VARCHAR(64) NULL,
+				state VARCHAR(32) NULL,
+				result TEXT(1000) NULL,
+				sttime BIGINT DEFAULT 0
+			);`,
+			`CREATE INDEX idx_filejobruns_jobid ON filejobruns(jobid, sttime);`,
+		} {
+			if _, err = tx.Exec(ddl); nil != err {
+				tx.Rollback()
+				return err
+			}
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// AddJob 添加定时任务
+func (js *JobStory) AddJob(job service.JobInfo) error {
+	params, err := json.Marshal(job.Params)
+	if nil != err {
+		return err
+	}
+	_, err = js.db.Exec("INSERT INTO filejobs(jobid, userid, name, cron, tasktype, params, enabled, lastrun, cttime) values(?,?,?,?,?,?,?,?,?)",
+		job.JobID, job.UserID, job.Name, job.Cron, job.TaskType, string(params), js.bool2Int(job.Enabled), job.LastRun, job.CtTime)
+	return err
+}
+
+// UpdateJob 修改定时任务的定义
+func (js *JobStory) UpdateJob(job service.JobInfo) error {
+	params, err := json.Marshal(job.Params)
+	if nil != err {
+		return err
+	}
+	_, err = js.db.Exec("UPDATE filejobs SET name=?, cron=?, tasktype=?, params=?, enabled=? WHERE jobid=?",
+		job.Name, job.Cron, job.TaskType, string(params), js.bool2Int(job.Enabled), job.JobID)
+	return err
+}
+
+// UpdateLastRun 修改上次执行时间
+func (js *JobStory) UpdateLastRun(jobID string, lastRun int64) error {
+	_, err := js.db.Exec("UPDATE filejobs SET lastrun=? WHERE jobid=?", lastRun, jobID)
+	return err
+}
+
+// ClaimRun 认领一次定时执行, 上次执行时间早于runAt时更新为runAt并返回true;
+// 多个实例共用一个库时只有一个实例能更新成功, 其他实例返回false
+func (js *JobStory) ClaimRun(jobID string, runAt int64) (bool, error) {
+	res, err := js.db.Exec("UPDATE filejobs SET lastrun=? WHERE jobid=? AND lastrun<?", runAt, jobID, runAt)
+	if nil != err {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+// DelJob 删除定时任务和执行记录
+func (js *JobStory) DelJob(jobID string) (err error) {
+	var tx *sql.Tx
+	if tx, err = js.db.Begin(); err == nil {
+		if _, err = tx.Exec("DELETE FROM filejobs WHERE jobid = ?", jobID); nil != err {
+			tx.Rollback()
+			return err
+		}
+		if _, err = tx.Exec("DELETE FROM filejobruns WHERE jobid = ?", jobID); nil != err {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// QueryJob 根据ID查询, 不存在返回nil
+func (js *JobStory) QueryJob(jobID string) (*service.JobInfo, error) {
+	if jobs, err := js.queryJobs("SELECT jobid, userid, name, cron, tasktype, params, enabled, lastrun, cttime FROM filejobs WHERE jobid = ?", jobID); nil != err {
+		return nil, err
+	} else if len(jobs) > 0 {
+		return &jobs[0], nil
+	}
+	return nil, nil
+}
+
+// ListJobs 列出用户的定时任务, 按创建时间排序
+func (js *JobStory) ListJobs(userID string) ([]service.JobInfo, error) {
+	return js.queryJobs("SELECT jobid, userid, name, cron, tasktype, params, enabled, lastrun, cttime FROM filejobs WHERE userid = ? ORDER BY cttime", userID)
+}
+
+// ListAllJobs 列出全部定时任务, 按创建时间排序
+func (js *JobStory) ListAllJobs() ([]service.JobInfo, error) {
+	return js.queryJobs("SELECT jobid, userid, name, cron, tasktype, params, enabled, lastrun, cttime FROM filejobs ORDER BY cttime")
+}
+
+// AddRun 添加执行记录, 只保留最近的keep条
+func (js *JobStory) AddRun(run service.JobRunInfo, keep int) error {
+	if _, err := js.db.Exec("INSERT INTO filejobruns(runid, jobid, taskid, state, result, sttime) values(?,?,?,?,?,?)",
+		run.RunID, run.JobID, run.TaskID, run.State, run.Result, run.StTime); nil != err {
+		return err
+	}
+	if keep > 0 {
+		runs, err := js.ListRuns(run.JobID, keep+1)
+		if nil != err {
+			return err
+		}
+		if len(runs) > keep {
+			_, err = js.db.Exec("DELETE FROM filejobruns WHERE jobid = ? AND sttime <= ?", run.JobID, runs[keep].StTime)
+			return err
+		}
+	}
+	return nil
+}
+
+// ListRuns 列出执行记录, 按执行时间倒序
+func (js *JobStory) ListRuns(jobID string, limit int) ([]service.JobRunInfo, error) {
+	rows, err := js.db.Query("SELECT runid, jobid, taskid, state, result, sttime FROM filejobruns WHERE jobid = ? ORDER BY sttime DESC LIMIT ?", jobID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]service.JobRunInfo, 0)
+	for rows.Next() {
+		run := service.JobRunInfo{}
+		if err := rows.Scan(&run.RunID, &run.JobID, &run.TaskID, &run.State, &run.Result, &run.StTime); err != nil {
+			return nil, err
+		}
+		res = append(res, run)
+	}
+	return res, nil
+}
+
+// queryJobs 查询定时任务列表
+func (js *JobStory) queryJobs(query string, args ...interface{}) ([]service.JobInfo, error) {
+	rows, err := js.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]service.JobInfo, 0)
+	for rows.Next() {
+		var params string
+		var enabled int
+		job := service.JobInfo{}
+		if err := rows.Scan(&job.JobID, &job.UserID, &job.Name, &job.Cron, &job.TaskType, &params, &enabled, &job.LastRun, &job.CtTime); err != nil {
+			return nil, err
+		}
+		if len(params) > 0 {
+			if err := json.Unmarshal([]byte(params), &job.Params); nil != err {
+				return nil, err
+			}
+		}
+		job.Enabled = enabled == 1
+		res = append(res, job)
+	}
+	return res, nil
+}
+
+// bool2Int 布尔值转为数字存储
+func (js *JobStory) bool2Int(val bool) int {
+	if val {
+		return 1
+	}
+	return 0
+}
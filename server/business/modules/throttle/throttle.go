@@ -0,0 +1,240 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 传输限速模块, 全局、用户、传输链接三级令牌桶
+
+package throttle
+
+import (
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/fileutil"
+	"github.com/wup364/pakku/utils/logs"
+)
+
+// Throttle 传输限速模块
+type Throttle struct {
+	ts      *ThrottleStory
+	lock    sync.Mutex
+	limits  map[string]service.ThrottleLimit // scope:target => 限速设置
+	buckets map[string]*TokenBucket          // scope:target:type => 使用中的令牌桶
+	conf    ipakku.AppConfig                 `@autowired:"AppConfig"`
+	tt      service.TransportToken           `@autowired:"TransportToken"`
+}
+
+// AsModule 模块加载器接口实现, 返回模块信息&配置
+func (th *Throttle) AsModule() ipakku.Opts {
+	return ipakku.Opts{
+		Name:        "Throttle",
+		Version:     1.0,
+		Description: "传输限速模块",
+		OnReady: func(mctx ipakku.Loader) {
+			deftDataSource := "./.datas/" + mctx.GetParam(ipakku.PARAMKEY_APPNAME).ToString("app") + ".db?cache=shared"
+			confDataSource := th.conf.GetConfig("throttle.sotre.datasource").ToString(deftDataSource)
+			if confDataSource == deftDataSource {
+				th.mkSqliteDIR() // 创建sqlite文件存放目录
+			}
+			th.ts = new(ThrottleStory)
+			if err := th.ts.Initial(constants.DBSetting{
+				DriverName:     th.conf.GetConfig("throttle.sotre.driver").ToString("sqlite3"),
+				DataSourceName: confDataSource,
+			}); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnSetup: func() {
+			// 执行建库、建表
+			if err := th.ts.Install(); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnInit: func() {
+			th.limits = make(map[string]service.ThrottleLimit)
+			th.buckets = make(map[string]*TokenBucket)
+			limits, err := th.ts.ListLimits()
+			if nil != err {
+				logs.Panicln(err)
+			}
+			for _, limit := range limits {
+				th.limits[limit.Scope+":"+limit.Target] = limit
+			}
+		},
+	}
+}
+
+// ListLimits 列出所有限速设置, 已失效的链接限速会被清理
+func (th *Throttle) ListLimits() []service.ThrottleLimit {
+	th.lock.Lock()
+	defer th.lock.Unlock()
+	res := make([]service.ThrottleLimit, 0, len(th.limits))
+	for key, limit := range th.limits {
+		if limit.Scope == service.ThrottleScope_Link {
+			if token, err := th.tt.QueryToken(limit.Target); nil != err || nil == token {
+				delete(th.limits, key)
+				continue
+			}
+		}
+		res = append(res, limit)
+	}
+	return res
+}
+
+// SetLimit 添加或修改限速设置, 立即作用于进行中的传输; 链接限速只保存在内存中
+func (th *Throttle) SetLimit(limit service.ThrottleLimit) error {
+	switch limit.Scope {
+	case service.ThrottleScope_Global:
+		limit.Target = ""
+	case service.ThrottleScope_User:
+		if len(limit.Target) == 0 {
+			return service.ErrorThrottleTargetIsNil
+		}
+	case service.ThrottleScope_Link:
+		if len(limit.Target) == 0 {
+			return service.ErrorThrottleTargetIsNil
+		}
+		if _, err := th.tt.QueryToken(limit.Target); nil != err {
+			return err
+		}
+	default:
+		return service.ErrorThrottleScopeNotSupport
+	}
+	if limit.Scope != service.ThrottleScope_Link {
+		if err := th.ts.SaveLimit(limit); nil != err {
+			return err
+		}
+	}
+	th.lock.Lock()
+	defer th.lock.Unlock()
+	th.limits[limit.Scope+":"+limit.Target] = limit
+	th.updateBuckets(limit.Scope, limit.Target, limit.ReadRate, limit.WriteRate)
+	return nil
+}
+
+// DelLimit 删除限速设置, 链接限速恢复为申请token时指定的速率
+func (th *Throttle) DelLimit(scope, target string) error {
+	switch scope {
+	case service.ThrottleScope_Global:
+		target = ""
+	case service.ThrottleScope_User, service.ThrottleScope_Link:
+	default:
+		return service.ErrorThrottleScopeNotSupport
+	}
+	if scope != service.ThrottleScope_Link {
+		if err := th.ts.DelLimit(scope, target); nil != err {
+			return err
+		}
+	}
+	th.lock.Lock()
+	defer th.lock.Unlock()
+	delete(th.limits, scope+":"+target)
+	readRate, writeRate := int64(0), int64(0)
+	if scope == service.ThrottleScope_Link {
+		if token, err := th.tt.QueryToken(target); nil == err && nil != token {
+			if token.Type == service.StreamTokenType_Read {
+				readRate = getTokenRate(token)
+			} else {
+				writeRate = getTokenRate(token)
+			}
+		}
+	}
+	th.updateBuckets(scope, target, readRate, writeRate)
+	return nil
+}
+
+// NewReader 包装传输流, 同时受全局、用户、链接限速; Close释放限速桶, 不关闭r
+func (th *Throttle) NewReader(r io.Reader, token *service.StreamToken) io.ReadCloser {
+	th.lock.Lock()
+	defer th.lock.Unlock()
+	tokenType := token.Type
+	keys := []string{
+		th.getBucketKey(service.ThrottleScope_Global, "", tokenType),
+		th.getBucketKey(service.ThrottleScope_User, token.Props[service.StreamTokenProp_UserID], tokenType),
+		th.getBucketKey(service.ThrottleScope_Link, token.Token, tokenType),
+	}
+	rates := []int64{
+		th.getRate(service.ThrottleScope_Global, "", tokenType),
+		th.getRate(service.ThrottleScope_User, token.Props[service.StreamTokenProp_UserID], tokenType),
+		getTokenRate(token),
+	}
+	if limit, ok := th.limits[service.ThrottleScope_Link+":"+token.Token]; ok {
+		rates[2] = getLimitRate(limit, tokenType)
+	}
+	buckets := make([]*TokenBucket, len(keys))
+	for i := 0; i < len(keys); i++ {
+		if tb, ok := th.buckets[keys[i]]; ok {
+			buckets[i] = tb
+		} else {
+			buckets[i] = NewTokenBucket(rates[i])
+			th.buckets[keys[i]] = buckets[i]
+		}
+		buckets[i].refs++
+	}
+	return NewThrottleReader(r, func() {
+		th.lock.Lock()
+		defer th.lock.Unlock()
+		for i := 0; i < len(keys); i++ {
+			if buckets[i].refs--; buckets[i].refs <= 0 {
+				delete(th.buckets, keys[i])
+			}
+		}
+	}, buckets...)
+}
+
+// updateBuckets 更新使用中的令牌桶速率
+func (th *Throttle) updateBuckets(scope, target string, readRate, writeRate int64) {
+	if tb, ok := th.buckets[th.getBucketKey(scope, target, service.StreamTokenType_Read)]; ok {
+		tb.SetRate(readRate)
+	}
+	if tb, ok := th.buckets[th.getBucketKey(scope, target, service.StreamTokenType_Write)]; ok {
+		tb.SetRate(writeRate)
+	}
+}
+
+// getRate 获取限速设置中的速率, 未设置时不限制
+func (th *Throttle) getRate(scope, target string, tokenType service.StreamTokenType) int64 {
+	if limit, ok := th.limits[scope+":"+target]; ok {
+		return getLimitRate(limit, tokenType)
+	}
+	return 0
+}
+
+// getBucketKey 令牌桶的key, 上传和下载分开计算
+func (th *Throttle) getBucketKey(scope, target string, tokenType service.StreamTokenType) string {
+	return scope + ":" + target + ":" + strconv.Itoa(int(tokenType))
+}
+
+// mkSqliteDIR 创建sqlite文件存放目录
+func (th *Throttle) mkSqliteDIR() {
+	if !fileutil.IsExist("./.datas") {
+		if err := fileutil.MkdirAll("./.datas"); nil != err {
+			logs.Panicln(err)
+		}
+	}
+}
+
+// getLimitRate 根据传输类型获取速率
+func getLimitRate(limit service.ThrottleLimit, tokenType service.StreamTokenType) int64 {
+	if tokenType == service.StreamTokenType_Write {
+		return limit.WriteRate
+	}
+	return limit.ReadRate
+}
+
+// getTokenRate 申请token时指定的链接速率
+func getTokenRate(token *service.StreamToken) int64 {
+	if rate, err := strconv.ParseInt(token.Props[service.StreamTokenProp_RateLimit], 10, 64); nil == err {
+		return rate
+	}
+	return 0
+}
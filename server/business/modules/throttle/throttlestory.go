@@ -0,0 +1,108 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 用db存放限速设置
+
+package throttle
+
+import (
+	"database/sql"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"time"
+)
+
+// ThrottleStory 限速设置存储
+type ThrottleStory struct {
+	db *sql.DB
+}
+
+// Initial 初始化配置
+func (ts *ThrottleStory) Initial(st constants.DBSetting) (err error) {
+	if nil == ts.db {
+		ts.db, err = sql.Open(st.DriverName, st.DataSourceName)
+		if nil == err {
+			if st.DriverName == "sqlite3" {
+				// database is locked
+				// https://github.com/mattn/go-sqlite3/issues/209
+				ts.db.SetMaxOpenConns(1)
+			} else {
+				ts.db.SetMaxIdleConns(250)
+				ts.db.SetConnMaxLifetime(time.Hour)
+			}
+		}
+	}
+	return err
+}
+
+// Install 初始化 filethrottles 表
+func (ts *ThrottleStory) Install() (err error) {
+	var tx *sql.Tx
+	if tx, err = ts.db.Begin(); err == nil {
+		for _, ddl := range []string{
+			`CREATE TABLE IF NOT EXISTS filethrottles(
+				scope VARCHAR(16) NOT NULL,
+				target VARCHAR(255) NOT NULL,
+				readrate BIGINT DEFAULT 0,
+				writerate BIGINT DEFAULT 0,
+				PRIMARY KEY(scope, target)
+			);`,
+		} {
+			if _, err = tx.Exec(ddl); nil != err {
+				tx.Rollback()
+				return err
+			}
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// ListLimits 列出所有限速设置
+func (ts *ThrottleStory) ListLimits() ([]service.ThrottleLimit, error) {
+	rows, err := ts.db.Query("SELECT scope, target, readrate, writerate FROM filethrottles")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]service.ThrottleLimit, 0)
+	for rows.Next() {
+		limit := service.ThrottleLimit{}
+		if err := rows.Scan(&limit.Scope, &limit.Target, &limit.ReadRate, &limit.WriteRate); err != nil {
+			return nil, err
+		}
+		res = append(res, limit)
+	}
+	return res, nil
+}
+
+// SaveLimit 保存限速设置, 已存在则覆盖
+func (ts *ThrottleStory) SaveLimit(limit service.ThrottleLimit) (err error) {
+	var tx *sql.Tx
+	if tx, err = ts.db.Begin(); err == nil {
+		if _, err = tx.Exec("DELETE FROM filethrottles WHERE scope=? AND target=?", limit.Scope, limit.Target); nil != err {
+			tx.Rollback()
+			return err
+		}
+		if _, err = tx.Exec("INSERT INTO filethrottles(scope, target, readrate, writerate) values(?,?,?,?)",
+			limit.Scope, limit.Target, limit.ReadRate, limit.WriteRate); nil != err {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// DelLimit 删除限速设置
+func (ts *ThrottleStory) DelLimit(scope, target string) error {
+	_, err := ts.db.Exec("DELETE FROM filethrottles WHERE scope=? AND target=?", scope, target)
+	return err
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 令牌桶限速
+
+package throttle
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// maxChunkSize 限速时单次读取的最大字节数
+const maxChunkSize = 32 * 1024
+
+// TokenBucket 令牌桶, 容量为1秒的速率, 允许透支, 透支部分通过等待偿还
+type TokenBucket struct {
+	lock   sync.Mutex
+	rate   int64 // 字节/秒, <=0为不限制
+	tokens float64
+	last   time.Time
+	refs   int // 使用中的传输数量, 由 Throttle 维护
+}
+
+// NewTokenBucket 创建令牌桶, 初始为空, 避免反复建立连接获得突发流量
+func NewTokenBucket(rate int64) *TokenBucket {
+	return &TokenBucket{rate: rate, last: time.Now()}
+}
+
+// Rate 当前速率
+func (tb *TokenBucket) Rate() int64 {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	return tb.rate
+}
+
+// SetRate 修改速率, 立即生效
+func (tb *TokenBucket) SetRate(rate int64) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	tb.refill(time.Now())
+	if tb.rate = rate; rate <= 0 {
+		tb.tokens = 0 // 不限制时清空透支
+	} else if tb.tokens > float64(rate) {
+		tb.tokens = float64(rate)
+	}
+}
+
+// Take 取出n个令牌, 返回需要等待的时长
+func (tb *TokenBucket) Take(n int) time.Duration {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	if tb.rate <= 0 {
+		return 0
+	}
+	tb.refill(time.Now())
+	if tb.tokens -= float64(n); tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / float64(tb.rate) * float64(time.Second))
+}
+
+// refill 按流逝的时间补充令牌
+func (tb *TokenBucket) refill(now time.Time) {
+	if tb.rate > 0 {
+		tb.tokens += now.Sub(tb.last).Seconds() * float64(tb.rate)
+		if tb.tokens > float64(tb.rate) {
+			tb.tokens = float64(tb.rate)
+		}
+	}
+	tb.last = now
+}
+
+// NewThrottleReader 包装读取流, 读取后按最慢的令牌桶等待
+func NewThrottleReader(r io.Reader, release func(), buckets ...*TokenBucket) io.ReadCloser {
+	return &throttleReader{r: r, release: release, buckets: buckets}
+}
+
+// throttleReader 限速读取
+type throttleReader struct {
+	r       io.Reader
+	release func()
+	once    sync.Once
+	buckets []*TokenBucket
+}
+
+func (tr *throttleReader) Read(p []byte) (n int, err error) {
+	// 速率较低时缩小单次读取, 避免一次透支过多导致传输停顿
+	chunk := maxChunkSize
+	for _, tb := range tr.buckets {
+		if rate := tb.Rate(); rate > 0 && rate < int64(chunk) {
+			chunk = int(rate)
+		}
+	}
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	if n, err = tr.r.Read(p); n > 0 {
+		var wait time.Duration
+		for _, tb := range tr.buckets {
+			if d := tb.Take(n); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return n, err
+}
+
+// Close 释放令牌桶, 不关闭被包装的流
+func (tr *throttleReader) Close() error {
+	if nil != tr.release {
+		tr.once.Do(tr.release)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package throttle
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tb := NewTokenBucket(1000)
+	if d := tb.Take(500); d < 400*time.Millisecond || d > 600*time.Millisecond {
+		t.Fatal("Take from empty bucket", d)
+	}
+	tb.SetRate(0)
+	if d := tb.Take(1 << 20); d != 0 {
+		t.Fatal("unlimited bucket should not wait", d)
+	}
+	tb.SetRate(2000)
+	time.Sleep(1100 * time.Millisecond)
+	// 容量为1秒的速率
+	if d := tb.Take(2000); d != 0 {
+		t.Fatal("full bucket should not wait", d)
+	}
+	if d := tb.Take(1000); d < 400*time.Millisecond {
+		t.Fatal("bucket should not exceed its capacity", d)
+	}
+}
+
+func TestThrottleReader(t *testing.T) {
+	released := 0
+	global, user := NewTokenBucket(0), NewTokenBucket(200*1024)
+	tr := NewThrottleReader(bytes.NewReader(make([]byte, 100*1024)), func() { released++ }, global, user)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, tr)
+	if nil != err || n != 100*1024 {
+		t.Fatal(n, err)
+	}
+	// 以最慢的桶为准
+	if cost := time.Since(start); cost < 400*time.Millisecond || cost > 1500*time.Millisecond {
+		t.Fatal("unexpected cost", cost)
+	}
+	tr.Close()
+	tr.Close()
+	if released != 1 {
+		t.Fatal("release should be called once", released)
+	}
+}
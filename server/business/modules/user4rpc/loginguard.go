@@ -0,0 +1,218 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 登录保护, 按账号和IP统计连续失败次数, 超过次数后临时锁定
+// 计数存放于 AppCache 中, 只存储数值以便兼容本地缓存和redis
+
+package user4rpc
+
+import (
+	"fileservice/business/service"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/logs"
+)
+
+const (
+	loginKeyUser = "user:"
+	loginKeyIP   = "ip:"
+)
+
+// LoginGuardConf 登录保护配置
+type LoginGuardConf struct {
+	MaxUserFailed int64 // 账号连续失败次数上限, 达到后锁定账号
+	MaxIPFailed   int64 // IP连续失败次数上限, 达到后锁定IP
+	LockSeconds   int64 // 锁定时长(秒), 同时也是失败计数的统计窗口
+	DelayMS       int64 // 渐进延迟基数(毫秒), 每多失败一次延迟翻倍
+	MaxDelayMS    int64 // 渐进延迟上限(毫秒)
+}
+
+// NewLoginGuard 登录保护
+func NewLoginGuard(ch ipakku.AppCache, conf LoginGuardConf) *LoginGuard {
+	return &LoginGuard{ch: ch, conf: conf, lock: new(sync.Mutex), elock: new(sync.RWMutex)}
+}
+
+// LoginGuard 登录保护
+type LoginGuard struct {
+	ch        ipakku.AppCache
+	conf      LoginGuardConf
+	lock      *sync.Mutex
+	elock     *sync.RWMutex
+	listeners []service.LoginEventListener
+}
+
+// AddEventListener 监听锁定和延迟事件
+func (lg *LoginGuard) AddEventListener(listener service.LoginEventListener) {
+	lg.elock.Lock()
+	defer lg.elock.Unlock()
+	lg.listeners = append(lg.listeners, listener)
+}
+
+// emit 通知登录保护事件
+func (lg *LoginGuard) emit(event service.LoginEvent) {
+	lg.elock.RLock()
+	defer lg.elock.RUnlock()
+	event.Time = time.Now().UnixMilli()
+	for _, listener := range lg.listeners {
+		listener(event)
+	}
+}
+
+// RegLib 注册计数和锁定缓存库
+func (lg *LoginGuard) RegLib() error {
+	if err := lg.ch.RegLib(service.Cachelib_LoginFailed, lg.conf.LockSeconds); nil != err {
+		return err
+	}
+	return lg.ch.RegLib(service.Cachelib_LoginLocked, lg.conf.LockSeconds)
+}
+
+// Check 登录前检查是否已锁定, 未锁定时按失败次数做渐进延迟
+func (lg *LoginGuard) Check(userID, clientIP string) error {
+	if lg.isLocked(loginKeyUser+userID) || (len(clientIP) > 0 && lg.isLocked(loginKeyIP+clientIP)) {
+		return service.ErrorLoginLocked
+	}
+	failed := lg.getCount(loginKeyUser + userID)
+	if len(clientIP) > 0 {
+		if ipFailed := lg.getCount(loginKeyIP + clientIP); ipFailed > failed {
+			failed = ipFailed
+		}
+	}
+	if delay := lg.getDelay(failed); delay > 0 {
+		lg.emit(service.LoginEvent{Event: service.LoginEvent_Throttled, UserID: userID, ClientIP: clientIP, Failed: failed, DelayMS: delay.Milliseconds()})
+		time.Sleep(delay)
+	}
+	return nil
+}
+
+// OnFailed 登录失败, 累加计数, 达到上限时锁定
+func (lg *LoginGuard) OnFailed(userID, clientIP string) {
+	lg.lock.Lock()
+	defer lg.lock.Unlock()
+	if count := lg.getCount(loginKeyUser+userID) + 1; count >= lg.conf.MaxUserFailed {
+		lg.doLock(loginKeyUser+userID, count)
+	} else {
+		lg.setCount(loginKeyUser+userID, count)
+	}
+	if len(clientIP) > 0 {
+		if count := lg.getCount(loginKeyIP+clientIP) + 1; count >= lg.conf.MaxIPFailed {
+			lg.doLock(loginKeyIP+clientIP, count)
+		} else {
+			lg.setCount(loginKeyIP+clientIP, count)
+		}
+	}
+}
+
+// OnSuccess 登录成功, 清除账号的失败计数, IP计数保留到窗口结束
+func (lg *LoginGuard) OnSuccess(userID, clientIP string) {
+	if err := lg.ch.Del(service.Cachelib_LoginFailed, loginKeyUser+userID); nil != err {
+		logs.Errorln(err)
+	}
+}
+
+// Unlock 解除账号或IP的锁定, 并清除失败计数
+func (lg *LoginGuard) Unlock(userID, clientIP string) error {
+	keys := make([]string, 0)
+	if len(userID) > 0 {
+		keys = append(keys, loginKeyUser+userID)
+	}
+	if len(clientIP) > 0 {
+		keys = append(keys, loginKeyIP+clientIP)
+	}
+	for i := 0; i < len(keys); i++ {
+		if err := lg.ch.Del(service.Cachelib_LoginLocked, keys[i]); nil != err {
+			return err
+		}
+		if err := lg.ch.Del(service.Cachelib_LoginFailed, keys[i]); nil != err {
+			return err
+		}
+		logs.Infof("LoginGuard unlock: %s\r\n", keys[i])
+	}
+	return nil
+}
+
+// ListLocked 列出当前被锁定的账号和IP
+func (lg *LoginGuard) ListLocked() []service.LoginLockDto {
+	res := make([]service.LoginLockDto, 0)
+	for _, key := range lg.ch.Keys(service.Cachelib_LoginLocked) {
+		// redis 返回的key带有库名前缀
+		key = strings.TrimPrefix(key, service.Cachelib_LoginLocked+":")
+		var unlockAt int64
+		if err := lg.ch.Get(service.Cachelib_LoginLocked, key, &unlockAt); nil != err {
+			continue
+		}
+		if strings.HasPrefix(key, loginKeyUser) {
+			res = append(res, service.LoginLockDto{UserID: key[len(loginKeyUser):], UnlockAt: unlockAt})
+		} else if strings.HasPrefix(key, loginKeyIP) {
+			res = append(res, service.LoginLockDto{ClientIP: key[len(loginKeyIP):], UnlockAt: unlockAt})
+		}
+	}
+	return res
+}
+
+// isLocked 是否已锁定
+func (lg *LoginGuard) isLocked(key string) bool {
+	var unlockAt int64
+	if err := lg.ch.Get(service.Cachelib_LoginLocked, key, &unlockAt); nil == err {
+		return unlockAt > time.Now().UnixMilli()
+	}
+	return false
+}
+
+// doLock 锁定并清空计数
+func (lg *LoginGuard) doLock(key string, failed int64) {
+	unlockAt := time.Now().Add(time.Duration(lg.conf.LockSeconds) * time.Second).UnixMilli()
+	if err := lg.ch.Set(service.Cachelib_LoginLocked, key, unlockAt); nil != err {
+		logs.Errorln(err)
+		return
+	}
+	if err := lg.ch.Del(service.Cachelib_LoginFailed, key); nil != err {
+		logs.Errorln(err)
+	}
+	logs.Infof("LoginGuard locked: %s, failed=%d, unlockAt=%s\r\n", key, failed, time.UnixMilli(unlockAt).Format(time.RFC3339))
+	event := service.LoginEvent{Event: service.LoginEvent_Locked, Failed: failed}
+	if strings.HasPrefix(key, loginKeyUser) {
+		event.UserID = key[len(loginKeyUser):]
+	} else {
+		event.ClientIP = key[len(loginKeyIP):]
+	}
+	lg.emit(event)
+}
+
+// getCount 获取失败次数
+func (lg *LoginGuard) getCount(key string) (count int64) {
+	if err := lg.ch.Get(service.Cachelib_LoginFailed, key, &count); nil != err && err != ipakku.ErrNoCacheHit {
+		logs.Errorln(err)
+	}
+	return count
+}
+
+// setCount 设置失败次数
+func (lg *LoginGuard) setCount(key string, count int64) {
+	if err := lg.ch.Set(service.Cachelib_LoginFailed, key, count); nil != err {
+		logs.Errorln(err)
+	}
+}
+
+// getDelay 计算渐进延迟, 第一次失败不延迟
+func (lg *LoginGuard) getDelay(failed int64) time.Duration {
+	if failed <= 1 || lg.conf.DelayMS <= 0 {
+		return 0
+	}
+	delay := lg.conf.DelayMS
+	for i := int64(2); i < failed && delay < lg.conf.MaxDelayMS; i++ {
+		delay = delay * 2
+	}
+	if delay > lg.conf.MaxDelayMS {
+		delay = lg.conf.MaxDelayMS
+	}
+	return time.Duration(delay) * time.Millisecond
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package user4rpc
+
+import (
+	"fileservice/business/service"
+	"testing"
+
+	"github.com/wup364/pakku/modules/appcache/localcache"
+)
+
+func TestLoginGuard(t *testing.T) {
+	ch := new(localcache.CacheManager)
+	ch.Init(nil, "T")
+	lg := NewLoginGuard(ch, LoginGuardConf{MaxUserFailed: 3, MaxIPFailed: 5, LockSeconds: 60, DelayMS: 1, MaxDelayMS: 2})
+	if err := lg.RegLib(); nil != err {
+		t.Fatal(err)
+	}
+	events := make(map[string][]service.LoginEvent)
+	lg.AddEventListener(func(event service.LoginEvent) {
+		events[event.Event] = append(events[event.Event], event)
+	})
+	// 账号连续失败3次后锁定
+	for i := 0; i < 3; i++ {
+		if err := lg.Check("user01", "127.0.0.1"); nil != err {
+			t.Fatal(err)
+		}
+		lg.OnFailed("user01", "127.0.0.1")
+	}
+	if err := lg.Check("user01", "127.0.0.2"); err != service.ErrorLoginLocked {
+		t.Fatal("user01 should be locked")
+	}
+	// IP累计失败5次后锁定, 其他账号也无法从该IP登录
+	lg.OnFailed("user02", "127.0.0.1")
+	lg.OnFailed("user02", "127.0.0.1")
+	if err := lg.Check("user03", "127.0.0.1"); err != service.ErrorLoginLocked {
+		t.Fatal("127.0.0.1 should be locked")
+	}
+	if locks := lg.ListLocked(); len(locks) != 2 {
+		t.Fatal("expected 2 locks, got", locks)
+	}
+	// 锁定和延迟都有事件通知
+	if locked := events[service.LoginEvent_Locked]; len(locked) != 2 || locked[0].UserID != "user01" || locked[1].ClientIP != "127.0.0.1" {
+		t.Fatal("expected user01 and 127.0.0.1 locked events, got", locked)
+	}
+	if throttled := events[service.LoginEvent_Throttled]; len(throttled) != 1 || throttled[0].Failed != 2 || throttled[0].DelayMS != 1 {
+		t.Fatal("expected 1 throttled event, got", throttled)
+	}
+	// 管理员解锁
+	if err := lg.Unlock("user01", "127.0.0.1"); nil != err {
+		t.Fatal(err)
+	}
+	if err := lg.Check("user01", "127.0.0.1"); nil != err {
+		t.Fatal(err)
+	}
+	// 成功登录清除账号计数
+	lg.OnFailed("user02", "")
+	lg.OnSuccess("user02", "")
+	if count := lg.getCount(loginKeyUser + "user02"); count != 0 {
+		t.Fatal("expected user02 count 0, got", count)
+	}
+}
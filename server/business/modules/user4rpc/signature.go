@@ -13,10 +13,12 @@ package user4rpc
 
 import (
 	"fileservice/business/service"
+	"net"
 	"net/http"
 	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/wup364/pakku/ipakku"
 	"github.com/wup364/pakku/utils/logs"
@@ -36,6 +38,8 @@ func NewApiSignature(ch ipakku.AppCache) *Signature {
 // Signature 签名校验
 type Signature struct {
 	validationSign bool
+	trustProxy     bool            // 是否信任代理传递的 X-Forwarded-For, X-Real-IP
+	trustedHops    int             // 可信代理的层数, 取 X-Forwarded-For 从右往左第N个地址
 	ch             ipakku.AppCache `@autowired:"AppCache"`
 }
 
@@ -82,18 +86,29 @@ func (st *Signature) RestfulAPIFilter(w http.ResponseWriter, r *http.Request) bo
 		serviceutil.SendErrorAndStatus(w, http.StatusUnauthorized, err.Error())
 		return false
 	}
+	// 校验账号状态, 过期|需要修改密码
+	if err := st.checkAccessStatus(accessKey, r.URL.Path); nil != err {
+		if err == service.ErrorPwdResetRequired {
+			serviceutil.SendErrorAndStatus(w, http.StatusForbidden, err.Error())
+		} else {
+			serviceutil.SendErrorAndStatus(w, http.StatusUnauthorized, err.Error())
+		}
+		return false
+	}
 	return true // 校验参数合法性-通过
 }
 
 // AskAccess 获取access
 func (st *Signature) AskAccess(user service.UserInfo) (*service.UserAccessDto, error) {
 	access := &service.UserAccess{
-		UserID:    user.UserID,
-		UserType:  user.UserType,
-		UserName:  user.UserName,
-		AccessKey: strutil.GetUUID(),
-		SecretKey: strutil.GetUUID(),
-		Props:     make(map[string]string),
+		UserID:      user.UserID,
+		UserType:    user.UserType,
+		UserName:    user.UserName,
+		AccessKey:   strutil.GetUUID(),
+		SecretKey:   strutil.GetUUID(),
+		ExpiredTime: user.ExpiredTime,
+		PwdReset:    user.PwdReset,
+		Props:       make(map[string]string),
 	}
 	if err := st.ch.Set(service.Cachelib_UserAccessToken, access.AccessKey, access); nil != err {
 		return nil, err
@@ -131,6 +146,46 @@ func (st *Signature) GetAccessKey4Request(r *http.Request) string {
 	return accessKey
 }
 
+// GetClientIP4Request 从http中获取客户端IP
+func (st *Signature) GetClientIP4Request(r *http.Request) string {
+	if st.trustProxy {
+		if xff := st.getForwardedFor(r); len(xff) > 0 {
+			return xff
+		}
+		if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); len(xrip) > 0 {
+			return xrip
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); nil == err {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// getForwardedFor 取 X-Forwarded-For 从右往左第 trustedHops 个地址, 即最外层可信代理看到的客户端地址;
+// 更左边的地址由客户端自己填写, 不可信. 地址数量不足时取最左边的
+func (st *Signature) getForwardedFor(r *http.Request) string {
+	ips := make([]string, 0)
+	for _, xff := range r.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(xff, ",") {
+			if ip = strings.TrimSpace(ip); len(ip) > 0 {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return ""
+	}
+	hops := st.trustedHops
+	if hops < 1 {
+		hops = 1
+	}
+	if hops > len(ips) {
+		hops = len(ips)
+	}
+	return ips[len(ips)-hops]
+}
+
 // DestroyAccess 销毁 useraccess
 func (st *Signature) DestroyAccess(accessKey string) error {
 	if err := st.ch.Del(service.Cachelib_UserAccessToken, accessKey); nil != err {
@@ -154,6 +209,62 @@ func (st *Signature) RefreshAccessKey(accessKey string) error {
 	return service.ErrorAuthentication
 }
 
+// DestroyUserAccess 销毁用户的所有 useraccess
+func (st *Signature) DestroyUserAccess(userID string) error {
+	for _, access := range st.listUserAccess(userID) {
+		if err := st.DestroyAccess(access.AccessKey); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateUserAccess 同步用户状态到已登录的 useraccess
+func (st *Signature) UpdateUserAccess(user service.UserInfo) error {
+	for _, access := range st.listUserAccess(user.UserID) {
+		access.ExpiredTime = user.ExpiredTime
+		access.PwdReset = user.PwdReset
+		if err := st.ch.Set(service.Cachelib_UserAccessToken, access.AccessKey, access); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// listUserAccess 列出用户的所有 useraccess
+func (st *Signature) listUserAccess(userID string) []service.UserAccess {
+	res := make([]service.UserAccess, 0)
+	for _, key := range st.ch.Keys(service.Cachelib_UserAccessToken) {
+		// redis 返回的key带有库名前缀
+		key = strings.TrimPrefix(key, service.Cachelib_UserAccessToken+":")
+		var val service.UserAccess
+		if err := st.ch.Get(service.Cachelib_UserAccessToken, key, &val); nil == err && val.UserID == userID {
+			res = append(res, val)
+		}
+	}
+	return res
+}
+
+// checkAccessStatus 校验账号是否过期, 是否需要先修改密码
+func (st *Signature) checkAccessStatus(accessKey, path string) error {
+	var val service.UserAccess
+	if err := st.ch.Get(service.Cachelib_UserAccessToken, accessKey, &val); nil != err {
+		return service.ErrorAuthentication
+	}
+	if val.ExpiredTime > 0 && val.ExpiredTime <= time.Now().UnixMilli() {
+		st.DestroyAccess(accessKey)
+		return service.ErrorUserExpired
+	}
+	if val.PwdReset {
+		switch strings.ToLower(path) {
+		case "/user/v1/updateuserpwd", "/user/v1/logout", "/user/v1/queryuser":
+		default:
+			return service.ErrorPwdResetRequired
+		}
+	}
+	return nil
+}
+
 // VerificationSignature 验证auth
 func (st *Signature) VerificationSignature(url, signature, accessKey, playload string) error {
 	if access, err := st.GetUserAccess(accessKey); nil == err {
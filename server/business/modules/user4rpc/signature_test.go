@@ -0,0 +1,45 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package user4rpc
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetClientIP4Request(t *testing.T) {
+	cases := []struct {
+		trustProxy bool
+		hops       int
+		xff        []string
+		res        string
+	}{
+		// 不信任代理时忽略头
+		{false, 1, []string{"1.1.1.1"}, "10.0.0.1"},
+		// 客户端伪造的地址在左边, 取最右边由代理追加的地址
+		{true, 1, []string{"6.6.6.6, 1.1.1.1"}, "1.1.1.1"},
+		{true, 2, []string{"6.6.6.6, 1.1.1.1, 172.16.0.2"}, "1.1.1.1"},
+		// 多个头按顺序拼接
+		{true, 2, []string{"6.6.6.6", "1.1.1.1, 172.16.0.2"}, "1.1.1.1"},
+		// 地址数量不足时取最左边的
+		{true, 3, []string{"1.1.1.1, 172.16.0.2"}, "1.1.1.1"},
+		{true, 1, nil, "10.0.0.1"},
+	}
+	for i, c := range cases {
+		st := &Signature{trustProxy: c.trustProxy, trustedHops: c.hops}
+		r := &http.Request{RemoteAddr: "10.0.0.1:1234", Header: http.Header{}}
+		for _, xff := range c.xff {
+			r.Header.Add("X-Forwarded-For", xff)
+		}
+		if ip := st.GetClientIP4Request(r); ip != c.res {
+			t.Fatal(i, ip)
+		}
+	}
+}
@@ -14,6 +14,7 @@ package user4rpc
 import (
 	"fileservice/business/constants"
 	"fileservice/business/service"
+	"time"
 
 	"github.com/wup364/pakku/ipakku"
 	"github.com/wup364/pakku/utils/fileutil"
@@ -23,15 +24,19 @@ import (
 // User4RPC 用户管理模块
 type User4RPC struct {
 	Signature
-	us *UserStory
-	c  ipakku.AppConfig `@autowired:"AppConfig"`
+	us  *UserStory
+	lg  *LoginGuard
+	uh  *UserHome
+	c   ipakku.AppConfig       `@autowired:"AppConfig"`
+	fd  service.FileDatas      `@autowired:"FileDatas"`
+	pms service.FilePermission `@autowired:"FilePermission"`
 }
 
 // AsModule 模块加载器接口实现, 返回模块信息&配置
 func (umg *User4RPC) AsModule() ipakku.Opts {
 	return ipakku.Opts{
 		Name:        "User4RPC",
-		Version:     1.0,
+		Version:     1.1,
 		Description: "用户信息",
 		OnReady: func(mctx ipakku.Loader) {
 			umg.us = &UserStory{}
@@ -43,6 +48,26 @@ func (umg *User4RPC) AsModule() ipakku.Opts {
 					logs.Panicln(err)
 				}
 			}
+			umg.Signature.trustProxy = umg.c.GetConfig("authuser.login.trustproxy").ToBool(false)
+			umg.Signature.trustedHops = int(service.GetInt64Config(umg.c, "authuser.login.trustedhops", 1))
+			// 登录保护, 连续失败后锁定账号|IP
+			umg.lg = NewLoginGuard(umg.ch, LoginGuardConf{
+				MaxUserFailed: service.GetInt64Config(umg.c, "authuser.login.maxuserfailed", 5),
+				MaxIPFailed:   service.GetInt64Config(umg.c, "authuser.login.maxipfailed", 20),
+				LockSeconds:   service.GetInt64Config(umg.c, "authuser.login.lockseconds", 60*15),
+				DelayMS:       service.GetInt64Config(umg.c, "authuser.login.delayms", 500),
+				MaxDelayMS:    service.GetInt64Config(umg.c, "authuser.login.maxdelayms", 8000),
+			})
+			if err := umg.lg.RegLib(); nil != err {
+				logs.Panicln(err)
+			}
+			// 用户主目录
+			umg.uh = NewUserHome(umg.fd, umg.pms, UserHomeConf{
+				Template:   umg.c.GetConfig("authuser.home.template").ToString(""),
+				Permission: service.GetInt64Config(umg.c, "authuser.home.permission", 0),
+				OnDelete:   umg.c.GetConfig("authuser.home.ondelete").ToString(HomeOnDelete_Keep),
+				ArchiveDIR: umg.c.GetConfig("authuser.home.archivedir").ToString(""),
+			})
 			deftDataSource := "./.datas/" + mctx.GetParam(ipakku.PARAMKEY_APPNAME).ToString("app") + ".db?cache=shared"
 			confDataSource := umg.c.GetConfig("authuser.sotre.datasource").ToString(deftDataSource)
 			if confDataSource == deftDataSource {
@@ -60,6 +85,11 @@ func (umg *User4RPC) AsModule() ipakku.Opts {
 				logs.Panicln(err)
 			}
 		},
+		OnUpdate: func(cv float64) {
+			if err := umg.us.Upgrade(cv); nil != err {
+				logs.Panicln(err)
+			}
+		},
 	}
 }
 
@@ -101,9 +131,18 @@ func (umg *User4RPC) Clear() error {
 	return nil
 }
 
-// AddUser 添加用户
+// AddUser 添加用户, 配置了主目录时自动创建并授权
 func (umg *User4RPC) AddUser(user *service.UserInfo) error {
-	return umg.us.AddUser(user)
+	if err := umg.us.AddUser(user); nil != err {
+		return err
+	}
+	if err := umg.uh.Provision(user.UserID); nil != err {
+		if derr := umg.us.DelUser(user.UserID); nil != derr {
+			logs.Errorln(derr)
+		}
+		return err
+	}
+	return nil
 }
 
 // UpdatePWD 修改用户密码
@@ -116,7 +155,14 @@ func (umg *User4RPC) UpdatePWD(userID, pwd string) error {
 		return service.ErrorUserNotExist
 	}
 	userOld.UserPWD = pwd
-	return umg.us.UpdatePWD(userOld)
+	if err = umg.us.UpdatePWD(userOld); nil != err {
+		return err
+	}
+	// 修改密码后取消强制改密标记
+	if userOld.PwdReset {
+		return umg.UpdateUserPwdReset(userID, false)
+	}
+	return nil
 }
 
 // UpdateUserName 修改用户昵称
@@ -135,14 +181,82 @@ func (umg *User4RPC) UpdateUserName(userID, userName string) error {
 	return umg.us.UpdateUser(userOld)
 }
 
-// DelUser 根据userID删除用户
+// UpdateUserDisabled 停用|启用账号, 停用后立即注销会话
+func (umg *User4RPC) UpdateUserDisabled(userID string, disabled bool) error {
+	userOld, err := umg.us.QueryUser(userID)
+	if nil != err {
+		return err
+	}
+	if nil == userOld {
+		return service.ErrorUserNotExist
+	}
+	userOld.Disabled = disabled
+	if err = umg.us.UpdateStatus(userOld); nil != err {
+		return err
+	}
+	if disabled {
+		return umg.DestroyUserAccess(userID)
+	}
+	return nil
+}
+
+// UpdateUserExpiredTime 设置账号过期时间(毫秒), 0为永不过期
+func (umg *User4RPC) UpdateUserExpiredTime(userID string, expiredTime int64) error {
+	if expiredTime < 0 {
+		expiredTime = 0
+	}
+	userOld, err := umg.us.QueryUser(userID)
+	if nil != err {
+		return err
+	}
+	if nil == userOld {
+		return service.ErrorUserNotExist
+	}
+	userOld.ExpiredTime = expiredTime
+	if err = umg.us.UpdateStatus(userOld); nil != err {
+		return err
+	}
+	return umg.UpdateUserAccess(*userOld)
+}
+
+// UpdateUserPwdReset 设置下次登录时必须修改密码
+func (umg *User4RPC) UpdateUserPwdReset(userID string, pwdReset bool) error {
+	userOld, err := umg.us.QueryUser(userID)
+	if nil != err {
+		return err
+	}
+	if nil == userOld {
+		return service.ErrorUserNotExist
+	}
+	userOld.PwdReset = pwdReset
+	if err = umg.us.UpdateStatus(userOld); nil != err {
+		return err
+	}
+	return umg.UpdateUserAccess(*userOld)
+}
+
+// DelUser 根据userID删除用户, 按配置回收主目录
+// 先回收主目录再删除用户, 回收失败时保留用户以便重试, 回收可以重复执行
 func (umg *User4RPC) DelUser(userID string) error {
-	return umg.us.DelUser(userID)
+	user, err := umg.us.QueryUser(userID)
+	if nil != err {
+		return err
+	}
+	if nil == user {
+		return service.ErrorUserNotExist
+	}
+	if err = umg.uh.Release(userID); nil != err {
+		return err
+	}
+	if err = umg.us.DelUser(userID); nil != err {
+		return err
+	}
+	return umg.DestroyUserAccess(userID)
 }
 
-// CheckPwd 校验密码是否一致
+// CheckPwd 校验密码是否一致, 连续失败会锁定账号
 func (umg *User4RPC) CheckPwd(userID, pwd string) bool {
-	return umg.us.CheckPwd(userID, pwd)
+	return nil == umg.checkPwd(userID, pwd, "")
 }
 
 // GetAuthFilterFunc 获取过滤器实现
@@ -151,17 +265,66 @@ func (umg *User4RPC) GetAuthFilterFunc() ipakku.FilterFunc {
 }
 
 // AskAccess 获取access
-func (umg *User4RPC) AskAccess(userID, pwd string) (*service.UserAccessDto, error) {
-	if !umg.CheckPwd(userID, pwd) {
-		return nil, service.ErrorAuthentication
+func (umg *User4RPC) AskAccess(userID, pwd, clientIP string) (*service.UserAccessDto, error) {
+	if err := umg.checkPwd(userID, pwd, clientIP); nil != err {
+		return nil, err
 	}
+	return umg.askAccess(userID)
+}
+
+// AskAccess4Service 内部服务以用户身份执行操作时获取access, 不校验密码, 使用完毕后需销毁
+func (umg *User4RPC) AskAccess4Service(userID string) (*service.UserAccessDto, error) {
+	return umg.askAccess(userID)
+}
+
+// askAccess 检查账号状态后获取access
+func (umg *User4RPC) askAccess(userID string) (*service.UserAccessDto, error) {
 	if user, err := umg.us.QueryUser(userID); nil != err {
 		return nil, err
+	} else if nil == user {
+		return nil, service.ErrorUserNotExist
+	} else if user.Disabled {
+		return nil, service.ErrorUserDisabled
+	} else if user.ExpiredTime > 0 && user.ExpiredTime <= time.Now().UnixMilli() {
+		return nil, service.ErrorUserExpired
 	} else {
 		return umg.Signature.AskAccess(*user)
 	}
 }
 
+// ListLoginLocks 列出被锁定的账号和IP
+func (umg *User4RPC) ListLoginLocks() []service.LoginLockDto {
+	return umg.lg.ListLocked()
+}
+
+// UnlockLogin 解除账号或IP的登录锁定
+func (umg *User4RPC) UnlockLogin(userID, clientIP string) error {
+	return umg.lg.Unlock(userID, clientIP)
+}
+
+// GetHomePath 获取用户主目录, 未配置时返回空
+func (umg *User4RPC) GetHomePath(userID string) string {
+	return umg.uh.GetHomePath(userID)
+}
+
+// AddLoginEventListener 监听登录锁定和延迟
+func (umg *User4RPC) AddLoginEventListener(listener service.LoginEventListener) {
+	umg.lg.AddEventListener(listener)
+}
+
+// checkPwd 校验密码, 统计账号和IP的失败次数
+func (umg *User4RPC) checkPwd(userID, pwd, clientIP string) error {
+	if err := umg.lg.Check(userID, clientIP); nil != err {
+		return err
+	}
+	if !umg.us.CheckPwd(userID, pwd) {
+		umg.lg.OnFailed(userID, clientIP)
+		return service.ErrorAuthentication
+	}
+	umg.lg.OnSuccess(userID, clientIP)
+	return nil
+}
+
 // mkSqliteDIR 创建sqlite文件存放目录
 func (umg *User4RPC) mkSqliteDIR() {
 	if !fileutil.IsExist("./.datas") {
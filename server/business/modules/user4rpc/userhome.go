@@ -0,0 +1,139 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 用户主目录, 新增用户时自动创建并授权
+
+package user4rpc
+
+import (
+	"fileservice/business/service"
+	"strings"
+	"time"
+
+	"github.com/wup364/pakku/utils/logs"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+const (
+	// HomeOnDelete_Keep 删除用户时保留主目录
+	HomeOnDelete_Keep = "keep"
+	// HomeOnDelete_Archive 删除用户时归档主目录
+	HomeOnDelete_Archive = "archive"
+	// HomeOnDelete_Delete 删除用户时删除主目录
+	HomeOnDelete_Delete = "delete"
+)
+
+// UserHomeConf 用户主目录配置
+type UserHomeConf struct {
+	Template   string // 主目录模板, 如: /home/{userID}, 为空时不创建
+	Permission int64  // 主目录授予的权限值
+	OnDelete   string // 删除用户时的处理方式: keep|archive|delete
+	ArchiveDIR string // 归档目录, 为空时归档到主目录的上级目录
+}
+
+// NewUserHome NewUserHome
+func NewUserHome(fd service.FileDatas, pms service.FilePermission, conf UserHomeConf) *UserHome {
+	if conf.Permission <= 0 {
+		conf.Permission = (1 << service.FPM_Visible) + (1 << service.FPM_Read) + (1 << service.FPM_Write)
+	}
+	if len(conf.OnDelete) == 0 {
+		conf.OnDelete = HomeOnDelete_Keep
+	}
+	return &UserHome{fd: fd, pms: pms, conf: conf}
+}
+
+// UserHome 用户主目录
+type UserHome struct {
+	fd   service.FileDatas
+	pms  service.FilePermission
+	conf UserHomeConf
+}
+
+// GetHomePath 获取用户主目录, 未配置时返回空
+func (uh *UserHome) GetHomePath(userID string) string {
+	if len(uh.conf.Template) == 0 || len(userID) == 0 {
+		return ""
+	}
+	// userID 不能跳出模板目录
+	if strings.ContainsAny(userID, "/\\") || strings.Contains(userID, "..") {
+		return ""
+	}
+	return strutil.Parse2UnixPath(strings.ReplaceAll(uh.conf.Template, "{userID}", userID))
+}
+
+// Provision 创建用户主目录并授权
+func (uh *UserHome) Provision(userID string) error {
+	if len(uh.conf.Template) == 0 {
+		return nil
+	}
+	home := uh.GetHomePath(userID)
+	if len(home) == 0 {
+		return service.ErrorUserHomeIllegal
+	}
+	if !uh.fd.IsDir(home) {
+		if err := uh.fd.DoMkDir(home); nil != err {
+			return err
+		}
+	}
+	if pmsList, err := uh.pms.ListUserFPermissions(userID); nil != err {
+		return err
+	} else {
+		for _, val := range pmsList {
+			if val.Path == home {
+				return nil
+			}
+		}
+	}
+	logs.Infof("UserHome provision: userID=%s, home=%s\r\n", userID, home)
+	return uh.pms.AddFPermission(service.PermissionInfo{
+		Path:       home,
+		UserID:     userID,
+		Permission: uh.conf.Permission,
+	})
+}
+
+// Release 删除用户时回收主目录的授权, 并按配置归档或删除主目录
+func (uh *UserHome) Release(userID string) error {
+	home := uh.GetHomePath(userID)
+	if len(home) == 0 {
+		return nil
+	}
+	if pmsList, err := uh.pms.ListUserFPermissions(userID); nil != err {
+		return err
+	} else {
+		for _, val := range pmsList {
+			if val.Path == home {
+				if err = uh.pms.DelFPermission(val.PermissionID); nil != err {
+					return err
+				}
+			}
+		}
+	}
+	if !uh.fd.IsDir(home) {
+		return nil
+	}
+	switch uh.conf.OnDelete {
+	case HomeOnDelete_Archive:
+		archiveDIR := uh.conf.ArchiveDIR
+		if len(archiveDIR) == 0 {
+			archiveDIR = strutil.GetPathParent(home)
+		} else if !uh.fd.IsDir(archiveDIR) {
+			if err := uh.fd.DoMkDir(archiveDIR); nil != err {
+				return err
+			}
+		}
+		dst := strutil.Parse2UnixPath(archiveDIR + "/" + strutil.GetPathName(home) + "." + time.Now().Format("20060102150405"))
+		logs.Infof("UserHome archive: userID=%s, home=%s, dst=%s\r\n", userID, home, dst)
+		return uh.fd.DoMove(home, dst, false)
+	case HomeOnDelete_Delete:
+		logs.Infof("UserHome delete: userID=%s, home=%s\r\n", userID, home)
+		return uh.fd.DoDelete(home)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package user4rpc
+
+import (
+	"fileservice/business/modules/filedatas"
+	"fileservice/business/modules/filepermission"
+	"fileservice/business/service"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/wup364/pakku"
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/modules/appconfig"
+)
+
+func TestUserHomePath(t *testing.T) {
+	uh := NewUserHome(nil, nil, UserHomeConf{Template: "/home/u_{userID}/files"})
+	cases := map[string]string{
+		"user01": "/home/u_user01/files",
+		"a/b":    "",
+		"..":     "",
+		"":       "",
+	}
+	for userID, home := range cases {
+		if res := uh.GetHomePath(userID); res != home {
+			t.Fatalf("%s: expected home '%s', got '%s'", userID, home, res)
+		}
+	}
+	// 未配置主目录时为空
+	if res := NewUserHome(nil, nil, UserHomeConf{}).GetHomePath("user01"); len(res) > 0 {
+		t.Fatal("expected no home, got", res)
+	}
+}
+
+func TestUserHomeProvision(t *testing.T) {
+	// 应用配置和模块版本记录在工作目录的 .conf 中, 切换到临时目录使每次运行都会执行建表
+	wd, err := os.Getwd()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); nil != err {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	app := pakku.NewApplication("userhome-test").EnableCoreModule().BootStart()
+	var conf ipakku.AppConfig
+	app.GetModuleByName(new(appconfig.AppConfig).AsModule().Name, &conf)
+	conf.SetConfig(filedatas.CONFKEY_MOUNT+"./."+filedatas.CONFKEY_MOUNTTYPE, "LOCAL")
+	conf.SetConfig(filedatas.CONFKEY_MOUNT+"./."+filedatas.CONFKEY_MOUNTADDR, t.TempDir())
+	conf.SetConfig(filedatas.CONFKEY_SOTREDATASOURCE, filepath.Join(t.TempDir(), "filedatas.db"))
+	conf.SetConfig("filepermission.sotre.datasource", filepath.Join(t.TempDir(), "filepermission.db"))
+	var fd service.FileDatas
+	var pms service.FilePermission
+	app.LoadModule(new(filedatas.FileDatas)).GetModuleByName(new(filedatas.FileDatas).AsModule().Name, &fd)
+	app.LoadModule(new(filepermission.FilePermission)).GetModuleByName(new(filepermission.FilePermission).AsModule().Name, &pms)
+	hasGrant := func(userID, home string) bool {
+		list, err := pms.ListUserFPermissions(userID)
+		if nil != err {
+			t.Fatal(err)
+		}
+		for _, val := range list {
+			if val.Path == home {
+				return true
+			}
+		}
+		return false
+	}
+	uh := NewUserHome(fd, pms, UserHomeConf{Template: "/home/{userID}", OnDelete: HomeOnDelete_Delete})
+	// 创建主目录并授权, 重复执行不会重复授权
+	for i := 0; i < 2; i++ {
+		if err := uh.Provision("user01"); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if !fd.IsDir("/home/user01") || !hasGrant("user01", "/home/user01") {
+		t.Fatal("home and grant should be created")
+	}
+	if list, _ := pms.ListUserFPermissions("user01"); len(list) != 1 {
+		t.Fatal("expected 1 grant, got", list)
+	}
+	if !pms.HashPermission("user01", "/home/user01/a.txt", service.FPM_Write) {
+		t.Fatal("user01 should be able to write in home")
+	}
+	// 删除用户时回收授权并删除主目录, 重复执行不报错
+	for i := 0; i < 2; i++ {
+		if err := uh.Release("user01"); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if fd.IsExist("/home/user01") || hasGrant("user01", "/home/user01") {
+		t.Fatal("home and grant should be removed")
+	}
+	// 非法的用户ID不能创建主目录
+	if err := uh.Provision("../user02"); err != service.ErrorUserHomeIllegal {
+		t.Fatal("expected illegal home error, got", err)
+	}
+}
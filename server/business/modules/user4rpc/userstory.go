@@ -54,7 +54,10 @@ func (us *UserStory) Install() (err error) {
 				userpwd varchar(255) default '',
 				usertype varchar(255) default '',
 				username varchar(255) default '',
-				cttime date null
+				cttime date null,
+				disabled integer default 0,
+				expiredtime bigint default 0,
+				pwdreset integer default 0
 			);`); nil == err {
 			//
 			err = tx.Commit()
@@ -65,9 +68,32 @@ func (us *UserStory) Install() (err error) {
 	return err
 }
 
+// Upgrade 升级 users 表结构
+func (us *UserStory) Upgrade(cv float64) (err error) {
+	if cv >= 1.1 {
+		return nil
+	}
+	// 1.1 增加 停用|过期|强制改密 字段
+	var tx *sql.Tx
+	if tx, err = us.db.Begin(); err == nil {
+		for _, ddl := range []string{
+			"ALTER TABLE users ADD COLUMN disabled integer default 0",
+			"ALTER TABLE users ADD COLUMN expiredtime bigint default 0",
+			"ALTER TABLE users ADD COLUMN pwdreset integer default 0",
+		} {
+			if _, err = tx.Exec(ddl); nil != err {
+				tx.Rollback()
+				return err
+			}
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
 // ListAllUsers 列出所有用户数据, 无分页
 func (us *UserStory) ListAllUsers() ([]service.UserInfo, error) {
-	rows, err := us.db.Query("SELECT userid,username,usertype,cttime FROM users")
+	rows, err := us.db.Query("SELECT userid,username,usertype,cttime,disabled,expiredtime,pwdreset FROM users")
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +102,7 @@ func (us *UserStory) ListAllUsers() ([]service.UserInfo, error) {
 	res := make([]service.UserInfo, 0)
 	for rows.Next() {
 		user := service.UserInfo{}
-		if err := rows.Scan(&user.UserID, &user.UserName, &user.UserType, &user.CtTime); err != nil {
+		if err := rows.Scan(&user.UserID, &user.UserName, &user.UserType, &user.CtTime, &user.Disabled, &user.ExpiredTime, &user.PwdReset); err != nil {
 			return nil, err
 		}
 		res = append(res, user)
@@ -86,7 +112,7 @@ func (us *UserStory) ListAllUsers() ([]service.UserInfo, error) {
 
 // QueryUser 根据用户ID查询详细信息
 func (us *UserStory) QueryUser(userID string) (*service.UserInfo, error) {
-	rows, err := us.db.Query("SELECT userid,username,usertype,cttime FROM users where userid='" + userID + "'")
+	rows, err := us.db.Query("SELECT userid,username,usertype,cttime,disabled,expiredtime,pwdreset FROM users where userid='" + userID + "'")
 	if err != nil {
 		return nil, err
 	}
@@ -94,7 +120,7 @@ func (us *UserStory) QueryUser(userID string) (*service.UserInfo, error) {
 	//
 	if rows.Next() {
 		user := service.UserInfo{}
-		if err := rows.Scan(&user.UserID, &user.UserName, &user.UserType, &user.CtTime); err != nil {
+		if err := rows.Scan(&user.UserID, &user.UserName, &user.UserType, &user.CtTime, &user.Disabled, &user.ExpiredTime, &user.PwdReset); err != nil {
 			return nil, err
 		}
 		return &user, nil
@@ -116,12 +142,13 @@ func (us *UserStory) AddUser(user *service.UserInfo) (err error) {
 		return err
 	}
 	//
-	stmt, err := ts.Prepare("INSERT INTO users(userid,username,usertype,userpwd,cttime) values(?,?,?,?,?)")
+	stmt, err := ts.Prepare("INSERT INTO users(userid,username,usertype,userpwd,cttime,disabled,expiredtime,pwdreset) values(?,?,?,?,?,?,?,?)")
 	if err != nil {
+		ts.Rollback()
 		return err
 	}
 	//
-	if _, err = stmt.Exec(user.UserID, user.UserName, user.UserType, strutil.GetMD5(user.UserPWD), time.Now()); err == nil {
+	if _, err = stmt.Exec(user.UserID, user.UserName, user.UserType, strutil.GetMD5(user.UserPWD), time.Now(), user.Disabled, user.ExpiredTime, user.PwdReset); err == nil {
 		err = ts.Commit()
 	} else {
 		ts.Rollback()
@@ -142,6 +169,7 @@ func (us *UserStory) UpdateUser(user *service.UserInfo) (err error) {
 	//
 	var stmt *sql.Stmt
 	if stmt, err = ts.Prepare("UPDATE users SET username=?, usertype=? WHERE userid=?"); err != nil {
+		ts.Rollback()
 		return err
 	}
 	//
@@ -153,6 +181,31 @@ func (us *UserStory) UpdateUser(user *service.UserInfo) (err error) {
 	return err
 }
 
+// UpdateStatus 修改用户状态(停用|过期时间|强制改密)
+func (us *UserStory) UpdateStatus(user *service.UserInfo) (err error) {
+	if len(user.UserID) == 0 {
+		return service.ErrorUserIDIsNil
+	}
+	// 开启事务
+	var ts *sql.Tx
+	if ts, err = us.db.Begin(); err != nil {
+		return err
+	}
+	//
+	var stmt *sql.Stmt
+	if stmt, err = ts.Prepare("UPDATE users SET disabled=?, expiredtime=?, pwdreset=? WHERE userid=?"); err != nil {
+		ts.Rollback()
+		return err
+	}
+	//
+	if _, err = stmt.Exec(user.Disabled, user.ExpiredTime, user.PwdReset, user.UserID); err == nil {
+		err = ts.Commit()
+	} else {
+		ts.Rollback()
+	}
+	return err
+}
+
 // DelUser 根据userId删除用户
 func (us *UserStory) DelUser(userID string) (err error) {
 	if len(userID) == 0 {
@@ -166,6 +219,7 @@ func (us *UserStory) DelUser(userID string) (err error) {
 	//
 	var stmt *sql.Stmt
 	if stmt, err = ts.Prepare("DELETE FROM users WHERE userid = ?"); err != nil {
+		ts.Rollback()
 		return err
 	}
 	//
@@ -205,6 +259,7 @@ func (us *UserStory) UpdatePWD(user *service.UserInfo) (err error) {
 	//
 	var stmt *sql.Stmt
 	if stmt, err = ts.Prepare("UPDATE users SET userpwd=? WHERE userid=?"); err != nil {
+		ts.Rollback()
 		return err
 	}
 	//
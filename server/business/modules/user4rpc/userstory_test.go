@@ -0,0 +1,83 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package user4rpc
+
+import (
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestUserStoryUpgrade(t *testing.T) {
+	us := &UserStory{}
+	if err := us.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "users.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	// 1.0 版本的表结构
+	if _, err := us.db.Exec(`create table users(
+		userid varchar(64) primary key,
+		userpwd varchar(255) default '',
+		usertype varchar(255) default '',
+		username varchar(255) default '',
+		cttime date null
+	);`); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := us.db.Exec("INSERT INTO users(userid,username,usertype,userpwd,cttime) values('old','old',1,'',CURRENT_TIMESTAMP)"); nil != err {
+		t.Fatal(err)
+	}
+	if err := us.Upgrade(1.0); nil != err {
+		t.Fatal(err)
+	}
+	if user, err := us.QueryUser("old"); nil != err || nil == user || user.Disabled || user.ExpiredTime != 0 || user.PwdReset {
+		t.Fatal(user, err)
+	}
+	// 修改状态
+	if err := us.AddUser(&service.UserInfo{UserID: "user01", UserName: "user01", UserPWD: "1", PwdReset: true}); nil != err {
+		t.Fatal(err)
+	}
+	if err := us.UpdateStatus(&service.UserInfo{UserID: "user01", Disabled: true, ExpiredTime: 1000}); nil != err {
+		t.Fatal(err)
+	}
+	if user, err := us.QueryUser("user01"); nil != err || !user.Disabled || user.ExpiredTime != 1000 || user.PwdReset {
+		t.Fatal(user, err)
+	}
+}
+
+func TestUserStoryRollback(t *testing.T) {
+	us := &UserStory{}
+	if err := us.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "users.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	// 表不存在时 Prepare 失败, 事务需要回滚, 否则sqlite唯一的连接被占用, 后续操作会一直等待
+	if err := us.UpdateStatus(&service.UserInfo{UserID: "user01", Disabled: true}); nil == err {
+		t.Fatal("expected prepare error")
+	}
+	done := make(chan error, 1)
+	go func() { done <- us.Install() }()
+	select {
+	case err := <-done:
+		if nil != err {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("transaction was not rolled back")
+	}
+}
@@ -0,0 +1,479 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Webhook模块, 文件变化和异步任务结束时向注册的地址推送签名的通知, 失败按指数退避重试
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wup364/pakku/ipakku"
+	"github.com/wup364/pakku/utils/fileutil"
+	"github.com/wup364/pakku/utils/logs"
+	"github.com/wup364/pakku/utils/strutil"
+)
+
+const (
+	// Header_Event 事件名
+	Header_Event = "X-Webhook-Event"
+	// Header_Delivery 投递ID, 重试时不变
+	Header_Delivery = "X-Webhook-Delivery"
+	// Header_Timestamp 发送时间, 秒
+	Header_Timestamp = "X-Webhook-Timestamp"
+	// Header_Signature 签名, sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+	Header_Signature = "X-Webhook-Signature"
+	// maxRetryShift 重试等待时间最多翻倍的次数, 避免移位溢出
+	maxRetryShift = 16
+	// hookCacheTTL Webhook列表缓存时长, 本实例修改时立即失效, 多实例共用数据库时其他实例的修改在缓存过期后生效
+	hookCacheTTL = time.Minute
+)
+
+// deliveryJob 投递队列中的一次投递
+type deliveryJob struct {
+	hook service.WebhookInfo
+	dl   service.WebhookDelivery
+}
+
+// Webhook Webhook模块
+type Webhook struct {
+	ws         *WebhookStory
+	events     chan service.WebhookPayload
+	jobs       chan deliveryJob
+	client     *http.Client
+	workers    int
+	maxRetries int
+	retryDelay time.Duration
+	maxDelay   time.Duration
+	maxHistory int
+	hlock      sync.Mutex
+	hooks      []service.WebhookInfo
+	hooksAt    time.Time
+	conf       ipakku.AppConfig  `@autowired:"AppConfig"`
+	fm         service.FileDatas `@autowired:"FileDatas"`
+	ast        service.AsyncTask `@autowired:"AsyncTask"`
+}
+
+// AsModule 模块加载器接口实现, 返回模块信息&配置
+func (m *Webhook) AsModule() ipakku.Opts {
+	return ipakku.Opts{
+		Name:        "Webhook",
+		Version:     1.0,
+		Description: "Webhook模块",
+		OnReady: func(mctx ipakku.Loader) {
+			deftDataSource := "./.datas/" + mctx.GetParam(ipakku.PARAMKEY_APPNAME).ToString("app") + ".db?cache=shared"
+			confDataSource := m.conf.GetConfig("webhook.sotre.datasource").ToString(deftDataSource)
+			if confDataSource == deftDataSource {
+				m.mkSqliteDIR() // 创建sqlite文件存放目录
+			}
+			m.ws = new(WebhookStory)
+			if err := m.ws.Initial(constants.DBSetting{
+				DriverName:     m.conf.GetConfig("webhook.sotre.driver").ToString("sqlite3"),
+				DataSourceName: confDataSource,
+			}); nil != err {
+				logs.Panicln(err)
+			}
+			m.maxRetries = int(service.GetInt64Config(m.conf, "webhook.maxretries", 5))
+			m.retryDelay = time.Duration(service.GetInt64Config(m.conf, "webhook.retrydelayms", 1000)) * time.Millisecond
+			m.maxDelay = time.Duration(service.GetInt64Config(m.conf, "webhook.maxretrydelayms", 10*60*1000)) * time.Millisecond
+			m.workers = int(service.GetInt64Config(m.conf, "webhook.workers", 4))
+			m.maxHistory = int(service.GetInt64Config(m.conf, "webhook.maxhistory", 100))
+			m.client = &http.Client{
+				Timeout: time.Duration(service.GetInt64Config(m.conf, "webhook.timeoutms", 10000)) * time.Millisecond,
+			}
+		},
+		OnSetup: func() {
+			// 执行建库、建表
+			if err := m.ws.Install(); nil != err {
+				logs.Panicln(err)
+			}
+		},
+		OnInit: func() {
+			m.start()
+			m.fm.AddEventListener(m.onFileEvent)
+			m.ast.AddTaskListener(m.onTaskEvent)
+			go m.resumeDeliveries()
+		},
+	}
+}
+
+// AddHook 添加Webhook, 未指定密钥时自动生成
+func (m *Webhook) AddHook(hook service.WebhookInfo) (*service.WebhookInfo, error) {
+	if err := m.checkHook(&hook); nil != err {
+		return nil, err
+	}
+	if len(hook.Secret) == 0 {
+		hook.Secret = strutil.GetUUID()
+	}
+	hook.HookID = strutil.GetUUID()
+	hook.CtTime = time.Now().UnixMilli()
+	if err := m.ws.AddHook(hook); nil != err {
+		return nil, err
+	}
+	m.clearHooks()
+	return &hook, nil
+}
+
+// UpdateHook 修改Webhook, 密钥为空时保持不变
+func (m *Webhook) UpdateHook(hook service.WebhookInfo) (*service.WebhookInfo, error) {
+	old, err := m.QueryHook(hook.HookID)
+	if nil != err {
+		return nil, err
+	}
+	if err := m.checkHook(&hook); nil != err {
+		return nil, err
+	}
+	if len(hook.Secret) == 0 {
+		hook.Secret = old.Secret
+	}
+	if err := m.ws.UpdateHook(hook); nil != err {
+		return nil, err
+	}
+	m.clearHooks()
+	hook.CtTime = old.CtTime
+	return &hook, nil
+}
+
+// DelHook 删除Webhook及投递记录
+func (m *Webhook) DelHook(hookID string) error {
+	if _, err := m.QueryHook(hookID); nil != err {
+		return err
+	}
+	if err := m.ws.DelHook(hookID); nil != err {
+		return err
+	}
+	m.clearHooks()
+	return nil
+}
+
+// QueryHook 查询Webhook, 不存在返回 ErrorWebhookNotExist
+func (m *Webhook) QueryHook(hookID string) (*service.WebhookInfo, error) {
+	if hook, err := m.ws.QueryHook(hookID); nil != err {
+		return nil, err
+	} else if nil == hook {
+		return nil, service.ErrorWebhookNotExist
+	} else {
+		return hook, nil
+	}
+}
+
+// ListHooks 列出全部Webhook
+func (m *Webhook) ListHooks() ([]service.WebhookInfo, error) {
+	return m.ws.ListHooks()
+}
+
+// getHooks 获取用于匹配事件的Webhook列表, 缓存 hookCacheTTL
+func (m *Webhook) getHooks() ([]service.WebhookInfo, error) {
+	m.hlock.Lock()
+	defer m.hlock.Unlock()
+	if nil != m.hooks && time.Since(m.hooksAt) < hookCacheTTL {
+		return m.hooks, nil
+	}
+	hooks, err := m.ws.ListHooks()
+	if nil != err {
+		return nil, err
+	}
+	m.hooks, m.hooksAt = hooks, time.Now()
+	return hooks, nil
+}
+
+// clearHooks 新增|修改|删除Webhook后清除缓存
+func (m *Webhook) clearHooks() {
+	m.hlock.Lock()
+	defer m.hlock.Unlock()
+	m.hooks = nil
+}
+
+// ListDeliveries 列出投递记录, 按时间倒序
+func (m *Webhook) ListDeliveries(hookID string, limit int) ([]service.WebhookDelivery, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+	return m.ws.ListDeliveries(hookID, limit)
+}
+
+// checkHook 校验Webhook定义
+func (m *Webhook) checkHook(hook *service.WebhookInfo) error {
+	if u, err := url.Parse(hook.URL); nil != err {
+		return err
+	} else if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return errors.New("url must be an absolute http(s) address")
+	}
+	for _, event := range hook.Events {
+		if !m.isSupportEvent(event) {
+			return errors.New("unsupported event: " + event)
+		}
+	}
+	if len(hook.PathPrefix) > 0 {
+		hook.PathPrefix = strutil.Parse2UnixPath(hook.PathPrefix)
+	}
+	return nil
+}
+
+// isSupportEvent 是否是支持订阅的事件
+func (m *Webhook) isSupportEvent(event string) bool {
+	for _, val := range service.WebhookEvents {
+		if val == event {
+			return true
+		}
+	}
+	return false
+}
+
+// start 启动事件分发和固定数量的投递协程
+func (m *Webhook) start() {
+	m.events = make(chan service.WebhookPayload, 1024)
+	m.jobs = make(chan deliveryJob, 1024)
+	workers := m.workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go m.doDeliver()
+	}
+	go m.doDispatch()
+}
+
+// onFileEvent 文件变化回调, 不阻塞文件操作, 队列满时记为失败的投递
+func (m *Webhook) onFileEvent(event service.FileEvent) {
+	m.publish(service.WebhookPayload{
+		Event: event.Event,
+		Path:  event.Path,
+		Dst:   event.Dst,
+		Time:  event.Time,
+	})
+}
+
+// onTaskEvent 任务结束回调, 路径取任务参数中的 srcPath|path, dstPath
+func (m *Webhook) onTaskEvent(task service.TaskInfo) {
+	payload := service.WebhookPayload{
+		Event:    service.WebhookEvent_TaskCompleted,
+		Path:     task.Params["srcPath"],
+		Dst:      task.Params["dstPath"],
+		Time:     task.EndTime,
+		TaskID:   task.TaskID,
+		TaskType: task.TaskType,
+		UserID:   task.UserID,
+		State:    task.State,
+		Result:   task.Result,
+	}
+	if len(payload.Path) == 0 {
+		payload.Path = task.Params["path"]
+	}
+	m.publish(payload)
+}
+
+// publish 放入分发队列, 队列满时不阻塞等待, 为每个匹配的Webhook记录一条失败的投递, 可以在投递记录中查到
+func (m *Webhook) publish(payload service.WebhookPayload) {
+	select {
+	case m.events <- payload:
+	default:
+		logs.Errorf("Webhook queue is full, event dropped: %s %s\r\n", payload.Event, payload.Path)
+		m.dispatch(payload, func(dl service.WebhookDelivery) service.WebhookDelivery {
+			dl.State = service.DeliveryState_Failed
+			dl.Error = "webhook queue is full, event dropped"
+			dl.LastTime = dl.CtTime
+			return dl
+		}, nil)
+	}
+}
+
+// doDispatch 从分发队列取出事件, 每个匹配的Webhook先保存一条投递记录再异步投递
+func (m *Webhook) doDispatch() {
+	for payload := range m.events {
+		m.dispatch(payload, nil, m.deliver)
+	}
+}
+
+// dispatch 按事件和路径匹配Webhook, 每个匹配的Webhook生成一条投递记录, prepare可以在保存前修改记录,
+// 保存成功后调用send
+func (m *Webhook) dispatch(payload service.WebhookPayload, prepare func(service.WebhookDelivery) service.WebhookDelivery, send func(service.WebhookInfo, service.WebhookDelivery)) {
+	hooks, err := m.getHooks()
+	if nil != err {
+		logs.Errorln(err)
+		return
+	}
+	for _, hook := range hooks {
+		if !hook.Enabled || !m.isMatch(hook, payload) {
+			continue
+		}
+		payload.DeliveryID = strutil.GetUUID()
+		body, err := json.Marshal(payload)
+		if nil != err {
+			logs.Errorln(err)
+			continue
+		}
+		dl := service.WebhookDelivery{
+			DeliveryID: payload.DeliveryID,
+			HookID:     hook.HookID,
+			Event:      payload.Event,
+			Payload:    string(body),
+			State:      service.DeliveryState_Pending,
+			CtTime:     time.Now().UnixMilli(),
+		}
+		if nil != prepare {
+			dl = prepare(dl)
+		}
+		if err := m.ws.AddDelivery(dl, m.maxHistory); nil != err {
+			logs.Errorln(err)
+			continue
+		}
+		if nil != send {
+			send(hook, dl)
+		}
+	}
+}
+
+// isMatch 事件为空时订阅全部; 源路径或目标路径在前缀下时匹配
+func (m *Webhook) isMatch(hook service.WebhookInfo, payload service.WebhookPayload) bool {
+	if len(hook.Events) > 0 {
+		matched := false
+		for _, event := range hook.Events {
+			if event == payload.Event {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return isUnderPath(hook.PathPrefix, payload.Path) || isUnderPath(hook.PathPrefix, payload.Dst)
+}
+
+// isUnderPath path等于prefix或在prefix之下, prefix为空或为/时全部匹配
+func isUnderPath(prefix, path string) bool {
+	if len(prefix) == 0 || prefix == "/" {
+		return true
+	}
+	if len(path) == 0 {
+		return false
+	}
+	path = strutil.Parse2UnixPath(path)
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// resumeDeliveries 重启后继续投递未完成的记录, Webhook已删除或停用的标记为失败
+func (m *Webhook) resumeDeliveries() {
+	dls, err := m.ws.ListPendingDeliveries()
+	if nil != err {
+		logs.Errorln(err)
+		return
+	}
+	for _, dl := range dls {
+		if hook, err := m.ws.QueryHook(dl.HookID); nil != err {
+			logs.Errorln(err)
+		} else if nil == hook || !hook.Enabled {
+			dl.State = service.DeliveryState_Failed
+			dl.Error = "webhook is deleted or disabled"
+			if err := m.ws.UpdateDelivery(dl); nil != err {
+				logs.Errorln(err)
+			}
+		} else {
+			m.deliver(*hook, dl)
+		}
+	}
+}
+
+// deliver 放入投递队列, 队列满时等待
+func (m *Webhook) deliver(hook service.WebhookInfo, dl service.WebhookDelivery) {
+	m.jobs <- deliveryJob{hook: hook, dl: dl}
+}
+
+// doDeliver 从队列中取出投递并发送一次, 接收方返回2xx为成功, 否则等待 retryBackoff 后重新放入队列, 每次尝试都更新投递记录
+func (m *Webhook) doDeliver() {
+	for job := range m.jobs {
+		dl := job.dl
+		dl.HTTPStatus, dl.Error = m.post(job.hook, dl)
+		dl.Attempts++
+		dl.LastTime = time.Now().UnixMilli()
+		if len(dl.Error) == 0 {
+			dl.State = service.DeliveryState_Success
+		} else if dl.Attempts > m.maxRetries {
+			dl.State = service.DeliveryState_Failed
+		}
+		if err := m.ws.UpdateDelivery(dl); nil != err {
+			logs.Errorln(err)
+		}
+		if dl.State == service.DeliveryState_Pending {
+			hook := job.hook
+			time.AfterFunc(m.retryBackoff(dl.Attempts), func() { m.deliver(hook, dl) })
+		}
+	}
+}
+
+// retryBackoff 第n次失败后的等待时间 retryDelay*2^(n-1), 翻倍次数不超过 maxRetryShift, 时长不超过 maxDelay
+func (m *Webhook) retryBackoff(attempts int) time.Duration {
+	shift := attempts - 1
+	if shift < 0 {
+		shift = 0
+	} else if shift > maxRetryShift {
+		shift = maxRetryShift
+	}
+	delay := m.retryDelay << uint(shift)
+	if m.maxDelay > 0 && (delay > m.maxDelay || delay < 0) {
+		delay = m.maxDelay
+	}
+	return delay
+}
+
+// post 发送一次, 返回响应码和错误信息
+func (m *Webhook) post(hook service.WebhookInfo, dl service.WebhookDelivery) (int, string) {
+	r, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader([]byte(dl.Payload)))
+	if nil != err {
+		return 0, err.Error()
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(Header_Event, dl.Event)
+	r.Header.Set(Header_Delivery, dl.DeliveryID)
+	r.Header.Set(Header_Timestamp, timestamp)
+	r.Header.Set(Header_Signature, Sign(hook.Secret, timestamp, []byte(dl.Payload)))
+	resp, err := m.client.Do(r)
+	if nil != err {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Sprintf("unexpected status: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, ""
+}
+
+// Sign 计算签名: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// mkSqliteDIR 创建sqlite文件存放目录
+func (m *Webhook) mkSqliteDIR() {
+	if !fileutil.IsExist("./.datas") {
+		if err := fileutil.MkdirAll("./.datas"); nil != err {
+			logs.Panicln(err)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package webhook
+
+import (
+	"encoding/json"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestWebhookDeliver(t *testing.T) {
+	ws := new(WebhookStory)
+	if err := ws.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "webhook.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if err := ws.Install(); nil != err {
+		t.Fatal(err)
+	}
+	m := &Webhook{
+		ws:         ws,
+		client:     &http.Client{Timeout: time.Second},
+		maxRetries: 2,
+		retryDelay: 10 * time.Millisecond,
+	}
+	m.start()
+	// 第一次返回500, 之后返回200
+	var lock sync.Mutex
+	var received []service.WebhookPayload
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if sign := Sign("secret01", r.Header.Get(Header_Timestamp), body); sign != r.Header.Get(Header_Signature) {
+			t.Errorf("signature mismatch: %s", r.Header.Get(Header_Signature))
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		payload := service.WebhookPayload{}
+		if err := json.Unmarshal(body, &payload); nil != err {
+			t.Error(err)
+		}
+		if payload.DeliveryID != r.Header.Get(Header_Delivery) || payload.Event != r.Header.Get(Header_Event) {
+			t.Errorf("header mismatch: %v", payload)
+		}
+		received = append(received, payload)
+	}))
+	defer srv.Close()
+
+	if _, err := m.AddHook(service.WebhookInfo{URL: "ftp://127.0.0.1/"}); nil == err {
+		t.Fatal("expect url error")
+	}
+	if _, err := m.AddHook(service.WebhookInfo{URL: srv.URL, Events: []string{"renamed"}}); nil == err {
+		t.Fatal("expect event error")
+	}
+	hook, err := m.AddHook(service.WebhookInfo{
+		URL:        srv.URL,
+		Secret:     "secret01",
+		Events:     []string{service.FileEvent_Created, service.WebhookEvent_TaskCompleted},
+		PathPrefix: "/incoming/",
+		Enabled:    true,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	// 不匹配: 路径前缀、事件类型
+	m.onFileEvent(service.FileEvent{Event: service.FileEvent_Created, Path: "/incomingx/a.txt"})
+	m.onFileEvent(service.FileEvent{Event: service.FileEvent_Deleted, Path: "/incoming/a.txt"})
+	// 匹配
+	m.onFileEvent(service.FileEvent{Event: service.FileEvent_Created, Path: "/incoming/a.txt"})
+	m.onTaskEvent(service.TaskInfo{
+		TaskID:   "task01",
+		TaskType: "MoveFile",
+		State:    service.TaskState_Completed,
+		Params:   map[string]string{"srcPath": "/tmp/b.txt", "dstPath": "/incoming"},
+	})
+
+	var dls []service.WebhookDelivery
+	for i := 0; i < 100; i++ {
+		if dls, err = m.ListDeliveries(hook.HookID, 0); nil != err {
+			t.Fatal(err)
+		}
+		if len(dls) == 2 && dls[0].State != service.DeliveryState_Pending && dls[1].State != service.DeliveryState_Pending {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(dls) != 2 {
+		t.Fatal(dls)
+	}
+	attempts := 0
+	for _, dl := range dls {
+		if dl.State != service.DeliveryState_Success || dl.HTTPStatus != http.StatusOK {
+			t.Fatal(dl)
+		}
+		attempts += dl.Attempts
+	}
+	// 其中一条重试了一次
+	if attempts != 3 {
+		t.Fatal(dls)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 2 {
+		t.Fatal(received)
+	}
+	for _, payload := range received {
+		if payload.Event == service.WebhookEvent_TaskCompleted && (payload.TaskID != "task01" || payload.Dst != "/incoming") {
+			t.Fatal(payload)
+		}
+	}
+}
+
+func TestWebhookRetryExhausted(t *testing.T) {
+	ws := new(WebhookStory)
+	if err := ws.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "webhook.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if err := ws.Install(); nil != err {
+		t.Fatal(err)
+	}
+	m := &Webhook{
+		ws:         ws,
+		client:     &http.Client{Timeout: time.Second},
+		maxRetries: 2,
+		retryDelay: 10 * time.Millisecond,
+	}
+	m.start()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	hook := service.WebhookInfo{HookID: "hook01", URL: srv.URL, Secret: "s", Enabled: true}
+	dl := service.WebhookDelivery{DeliveryID: "dl01", HookID: hook.HookID, Event: service.FileEvent_Deleted, Payload: "{}", State: service.DeliveryState_Pending}
+	if err := ws.AddDelivery(dl, 10); nil != err {
+		t.Fatal(err)
+	}
+	m.deliver(hook, dl)
+	var dls []service.WebhookDelivery
+	var err error
+	for i := 0; i < 100; i++ {
+		if dls, err = ws.ListDeliveries(hook.HookID, 10); nil != err {
+			t.Fatal(err)
+		}
+		if len(dls) == 1 && dls[0].State != service.DeliveryState_Pending {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(dls) != 1 || dls[0].State != service.DeliveryState_Failed || dls[0].Attempts != 3 || dls[0].HTTPStatus != http.StatusBadGateway {
+		t.Fatal(dls)
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	ws := new(WebhookStory)
+	if err := ws.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "webhook.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if err := ws.Install(); nil != err {
+		t.Fatal(err)
+	}
+	// 没有分发协程的无缓冲队列, 事件总是放不进去
+	m := &Webhook{ws: ws, events: make(chan service.WebhookPayload)}
+	hook, err := m.AddHook(service.WebhookInfo{URL: "http://127.0.0.1/", Enabled: true})
+	if nil != err {
+		t.Fatal(err)
+	}
+	m.onFileEvent(service.FileEvent{Event: service.FileEvent_Created, Path: "/a.txt"})
+	dls, err := m.ListDeliveries(hook.HookID, 0)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 || dls[0].State != service.DeliveryState_Failed || len(dls[0].Error) == 0 || dls[0].Attempts != 0 {
+		t.Fatal(dls)
+	}
+}
+
+func TestWebhookRetryBackoff(t *testing.T) {
+	m := &Webhook{retryDelay: time.Second, maxDelay: time.Minute}
+	for attempts, delay := range map[int]time.Duration{0: time.Second, 1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 7: time.Minute, 1000: time.Minute} {
+		if res := m.retryBackoff(attempts); res != delay {
+			t.Fatalf("attempts %d: expected %s, got %s", attempts, delay, res)
+		}
+	}
+	// 未设置上限时只限制翻倍次数, 不会溢出
+	m.maxDelay = 0
+	if res := m.retryBackoff(1000); res != time.Second<<maxRetryShift {
+		t.Fatal(res)
+	}
+}
+
+func TestWebhookHookCache(t *testing.T) {
+	ws := new(WebhookStory)
+	if err := ws.Initial(constants.DBSetting{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "webhook.db"),
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if err := ws.Install(); nil != err {
+		t.Fatal(err)
+	}
+	m := &Webhook{ws: ws}
+	if hooks, err := m.getHooks(); nil != err || len(hooks) != 0 {
+		t.Fatal(hooks, err)
+	}
+	// 绕过模块直接写库时使用缓存
+	if err := ws.AddHook(service.WebhookInfo{HookID: "hook01", URL: "http://127.0.0.1/", Enabled: true}); nil != err {
+		t.Fatal(err)
+	}
+	if hooks, _ := m.getHooks(); len(hooks) != 0 {
+		t.Fatal("expected cached hooks, got", hooks)
+	}
+	// 通过模块新增|修改|删除后缓存失效
+	hook, err := m.AddHook(service.WebhookInfo{URL: "http://127.0.0.1/", Enabled: true})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if hooks, _ := m.getHooks(); len(hooks) != 2 {
+		t.Fatal("expected 2 hooks, got", hooks)
+	}
+	hook.Enabled = false
+	if _, err = m.UpdateHook(*hook); nil != err {
+		t.Fatal(err)
+	}
+	hooks, _ := m.getHooks()
+	for _, val := range hooks {
+		if val.HookID == hook.HookID && val.Enabled {
+			t.Fatal("expected disabled hook, got", val)
+		}
+	}
+	if err = m.DelHook(hook.HookID); nil != err {
+		t.Fatal(err)
+	}
+	if hooks, _ := m.getHooks(); len(hooks) != 1 {
+		t.Fatal("expected 1 hook, got", hooks)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 用db存放Webhook定义和投递记录
+
+package webhook
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fileservice/business/constants"
+	"fileservice/business/service"
+	"time"
+)
+
+// WebhookStory Webhook存储
+type WebhookStory struct {
+	db *sql.DB
+}
+
+// Initial 初始化配置
+func (ws *WebhookStory) Initial(st constants.DBSetting) (err error) {
+	if nil == ws.db {
+		ws.db, err = sql.Open(st.DriverName, st.DataSourceName)
+		if nil == err {
+			if st.DriverName == "sqlite3" {
+				// database is locked
+				// https://github.com/mattn/go-sqlite3/issues/209
+				ws.db.SetMaxOpenConns(1)
+			} else {
+				ws.db.SetMaxIdleConns(250)
+				ws.db.SetConnMaxLifetime(time.Hour)
+			}
+		}
+	}
+	return err
+}
+
+// Install 初始化 filewebhooks, filewebhookdeliveries 表
+func (ws *WebhookStory) Install() (err error) {
+	var tx *sql.Tx
+	if tx, err = ws.db.Begin(); err == nil {
+		for _, ddl := range []string{
+			`CREATE TABLE IF NOT EXISTS filewebhooks(
+				hookid VARCHAR(64) PRIMARY KEY,
+				url VARCHAR(2000) NULL,
+				secret VARCHAR(255) NULL,
+				events VARCHAR(255) NULL,
+				pathprefix VARCHAR(1000) NULL,
+				enabled INT DEFAULT 0,
+				cttime BIGINT DEFAULT 0
+			);`,
+			`CREATE TABLE IF NOT EXISTS filewebhookdeliveries(
+				deliveryid VARCHAR(64) PRIMARY KEY,
+				hookid VARCHAR(64) NULL,
+				event VARCHAR(64) NULL,
+				payload TEXT(4000) NULL,
+				state VARCHAR(32) NULL,
+				attempts INT DEFAULT 0,
+				httpstatus INT DEFAULT 0,
+				error TEXT(1000) NULL,
+				cttime BIGINT DEFAULT 0,
+				lasttime BIGINT DEFAULT 0
+			);`,
+			`CREATE INDEX idx_filewebhookdeliveries_hookid ON filewebhookdeliveries(hookid, cttime);`,
+		} {
+			if _, err = tx.Exec(ddl); nil != err {
+				tx.Rollback()
+				return err
+			}
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// AddHook 添加Webhook
+func (ws *WebhookStory) AddHook(hook service.WebhookInfo) error {
+	events, err := json.Marshal(hook.Events)
+	if nil != err {
+		return err
+	}
+	_, err = ws.db.Exec("INSERT INTO filewebhooks(hookid, url, secret, events, pathprefix, enabled, cttime) values(?,?,?,?,?,?,?)",
+		hook.HookID, hook.URL, hook.Secret, string(events), hook.PathPrefix, ws.bool2Int(hook.Enabled), hook.CtTime)
+	return err
+}
+
+// UpdateHook 修改Webhook
+func (ws *WebhookStory) UpdateHook(hook service.WebhookInfo) error {
+	events, err := json.Marshal(hook.Events)
+	if nil != err {
+		return err
+	}
+	_, err = ws.db.Exec("UPDATE filewebhooks SET url=?, secret=?, events=?, pathprefix=?, enabled=? WHERE hookid=?",
+		hook.URL, hook.Secret, string(events), hook.PathPrefix, ws.bool2Int(hook.Enabled), hook.HookID)
+	return err
+}
+
+// DelHook 删除Webhook和投递记录
+func (ws *WebhookStory) DelHook(hookID string) (err error) {
+	var tx *sql.Tx
+	if tx, err = ws.db.Begin(); err == nil {
+		if _, err = tx.Exec("DELETE FROM filewebhooks WHERE hookid = ?", hookID); nil != err {
+			tx.Rollback()
+			return err
+		}
+		if _, err = tx.Exec("DELETE FROM filewebhookdeliveries WHERE hookid = ?", hookID); nil != err {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+	}
+	return err
+}
+
+// QueryHook 根据ID查询, 不存在返回nil
+func (ws *WebhookStory) QueryHook(hookID string) (*service.WebhookInfo, error) {
+	if hooks, err := ws.queryHooks("SELECT hookid, url, secret, events, pathprefix, enabled, cttime FROM filewebhooks WHERE hookid = ?", hookID); nil != err {
+		return nil, err
+	} else if len(hooks) > 0 {
+		return &hooks[0], nil
+	}
+	return nil, nil
+}
+
+// ListHooks 列出全部Webhook, 按创建时间排序
+func (ws *WebhookStory) ListHooks() ([]service.WebhookInfo, error) {
+	return ws.queryHooks("SELECT hookid, url, secret, events, pathprefix, enabled, cttime FROM filewebhooks ORDER BY cttime")
+}
+
+// AddDelivery 添加投递记录, 只保留最近的keep条
+func (ws *WebhookStory) AddDelivery(dl service.WebhookDelivery, keep int) error {
+	if _, err := ws.db.Exec("INSERT INTO filewebhookdeliveries(deliveryid, hookid, event, payload, state, attempts, httpstatus, error, cttime, lasttime) values(?,?,?,?,?,?,?,?,?,?)",
+		dl.DeliveryID, dl.HookID, dl.Event, dl.Payload, dl.State, dl.Attempts, dl.HTTPStatus, dl.Error, dl.CtTime, dl.LastTime); nil != err {
+		return err
+	}
+	if keep > 0 {
+		dls, err := ws.ListDeliveries(dl.HookID, keep+1)
+		if nil != err {
+			return err
+		}
+		if len(dls) > keep {
+			_, err = ws.db.Exec("DELETE FROM filewebhookdeliveries WHERE hookid = ? AND cttime <= ?", dl.HookID, dls[keep].CtTime)
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateDelivery 修改投递状态
+func (ws *WebhookStory) UpdateDelivery(dl service.WebhookDelivery) error {
+	_, err := ws.db.Exec("UPDATE filewebhookdeliveries SET state=?, attempts=?, httpstatus=?, error=?, lasttime=? WHERE deliveryid=?",
+		dl.State, dl.Attempts, dl.HTTPStatus, dl.Error, dl.LastTime, dl.DeliveryID)
+	return err
+}
+
+// ListDeliveries 列出投递记录, 按时间倒序
+func (ws *WebhookStory) ListDeliveries(hookID string, limit int) ([]service.WebhookDelivery, error) {
+	return ws.queryDeliveries("SELECT deliveryid, hookid, event, payload, state, attempts, httpstatus, error, cttime, lasttime FROM filewebhookdeliveries WHERE hookid = ? ORDER BY cttime DESC LIMIT ?", hookID, limit)
+}
+
+// ListPendingDeliveries 列出未投递完成的记录, 用于重启后继续投递
+func (ws *WebhookStory) ListPendingDeliveries() ([]service.WebhookDelivery, error) {
+	return ws.queryDeliveries("SELECT deliveryid, hookid, event, payload, state, attempts, httpstatus, error, cttime, lasttime FROM filewebhookdeliveries WHERE state = ? ORDER BY cttime", service.DeliveryState_Pending)
+}
+
+// queryDeliveries 查询投递记录
+func (ws *WebhookStory) queryDeliveries(query string, args ...interface{}) ([]service.WebhookDelivery, error) {
+	rows, err := ws.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]service.WebhookDelivery, 0)
+	for rows.Next() {
+		dl := service.WebhookDelivery{}
+		if err := rows.Scan(&dl.DeliveryID, &dl.HookID, &dl.Event, &dl.Payload, &dl.State, &dl.Attempts, &dl.HTTPStatus, &dl.Error, &dl.CtTime, &dl.LastTime); err != nil {
+			return nil, err
+		}
+		res = append(res, dl)
+	}
+	return res, nil
+}
+
+// queryHooks 查询Webhook列表
+func (ws *WebhookStory) queryHooks(query string, args ...interface{}) ([]service.WebhookInfo, error) {
+	rows, err := ws.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	//
+	res := make([]service.WebhookInfo, 0)
+	for rows.Next() {
+		var events string
+		var enabled int
+		hook := service.WebhookInfo{}
+		if err := rows.Scan(&hook.HookID, &hook.URL, &hook.Secret, &events, &hook.PathPrefix, &enabled, &hook.CtTime); err != nil {
+			return nil, err
+		}
+		if len(events) > 0 {
+			if err := json.Unmarshal([]byte(events), &hook.Events); nil != err {
+				return nil, err
+			}
+		}
+		hook.Enabled = enabled == 1
+		res = append(res, hook)
+	}
+	return res, nil
+}
+
+// bool2Int 布尔值转为数字存储
+func (ws *WebhookStory) bool2Int(val bool) int {
+	if val {
+		return 1
+	}
+	return 0
+}
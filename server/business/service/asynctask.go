@@ -21,12 +21,57 @@ const (
 	CacheLib_AsyncTaskToken_Exp = 30
 )
 
+const (
+	// TaskState_Queued 排队中, 等待执行名额
+	TaskState_Queued = "queued"
+	// TaskState_Running 执行中
+	TaskState_Running = "running"
+	// TaskState_Paused 已暂停
+	TaskState_Paused = "paused"
+	// TaskState_Completed 执行成功
+	TaskState_Completed = "completed"
+	// TaskState_Failed 执行失败
+	TaskState_Failed = "failed"
+	// TaskState_Discontinued 已中断
+	TaskState_Discontinued = "discontinued"
+)
+
+const (
+	// TaskEvent_Progress 进度变化
+	TaskEvent_Progress = "progress"
+	// TaskEvent_Conflict 遇到错误或冲突, 等待用户选择
+	TaskEvent_Conflict = "conflict"
+	// TaskEvent_Complete 任务结束
+	TaskEvent_Complete = "complete"
+)
+
+const (
+	// BatchOp_Info 查询信息, BatchFile 的操作类型
+	BatchOp_Info = "info"
+	// BatchOp_Delete 删除
+	BatchOp_Delete = "delete"
+	// BatchOp_Rename 重命名
+	BatchOp_Rename = "rename"
+	// BatchOp_Copy 复制到目标文件夹
+	BatchOp_Copy = "copy"
+	// BatchOp_Move 移动到目标文件夹
+	BatchOp_Move = "move"
+)
+
 // AsyncTask 接口
 type AsyncTask interface {
 	GetTaskObject(name string) (AsyncTaskExec, error)
 	AddTaskObject(task AsyncTaskExecI)
+	ListTasks(userID string) ([]TaskInfo, error)      // 列出用户的任务, 按开始时间倒序
+	QueryTask(taskID string) (*TaskInfo, error)       // 查询任务, 不存在返回 ErrorTaskNotExist
+	ClearTasks(userID string) (int64, error)          // 清除用户已结束的任务, 返回清除条数
+	Subscribe(token string) (<-chan struct{}, func()) // 订阅任务变化通知, 使用完毕后调用返回的取消函数
+	AddTaskListener(listener TaskListener)            // 监听任务结束, 回调中不能有耗时操作
 }
 
+// TaskListener 任务结束回调, 参数为结束后的任务记录
+type TaskListener func(task TaskInfo)
+
 // AsyncTaskExec 异步执行器调用接口
 type AsyncTaskExec interface {
 	Execute(r *http.Request) (string, error)       // 动作执行, 返回一个tooken
@@ -40,6 +85,60 @@ type AsyncTaskExecI interface {
 	AsyncTaskExec
 }
 
+// AsyncTaskWatchI 支持推送进度的任务实现此接口
+type AsyncTaskWatchI interface {
+	Snapshot(token string) (string, interface{}, error) // 返回事件类型和当前状态
+}
+
+// AsyncTaskResumeI 进程重启后可以继续执行的任务实现此接口, 未实现的任务会被标记为失败
+type AsyncTaskResumeI interface {
+	Resume(task TaskInfo) error
+}
+
+// TaskInfo 任务记录, Params 为发起任务时的参数
+type TaskInfo struct {
+	TaskID   string
+	UserID   string
+	TaskType string
+	Params   map[string]string
+	State    string
+	Result   string // 失败原因
+	StTime   int64  // 开始时间, 毫秒
+	EndTime  int64  // 结束时间, 毫秒
+}
+
+// ToDto 转传输对象
+func (ti *TaskInfo) ToDto() *TaskInfoDto {
+	return &TaskInfoDto{
+		TaskID:   ti.TaskID,
+		UserID:   ti.UserID,
+		TaskType: ti.TaskType,
+		Params:   ti.Params,
+		State:    ti.State,
+		Result:   ti.Result,
+		StTime:   ti.StTime,
+		EndTime:  ti.EndTime,
+	}
+}
+
+// TaskInfoDto 任务记录传输对象
+type TaskInfoDto struct {
+	TaskID   string            `json:"taskID"`
+	UserID   string            `json:"userID"`
+	TaskType string            `json:"taskType"`
+	Params   map[string]string `json:"params"`
+	State    string            `json:"state"`
+	Result   string            `json:"result"`
+	StTime   int64             `json:"stTime"`
+	EndTime  int64             `json:"endTime"`
+}
+
+// ErrorTaskNotExist 任务不存在
+var ErrorTaskNotExist = errors.New("task does not exist")
+
+// ErrorTaskInterrupted 任务因进程重启而中断
+var ErrorTaskInterrupted = errors.New("task interrupted by restart")
+
 // ErrorDiscontinue ErrorDiscontinue
 var ErrorDiscontinue = errors.New("discontine")
 
@@ -63,3 +162,15 @@ var ErrorNewNameIsEmpty = errors.New("new name cannot be empty")
 
 // ErrorPermissionInsufficient 权限不足
 var ErrorPermissionInsufficient = errors.New("权限不足")
+
+// ErrorURLNotSupport 只支持 http|https 地址
+var ErrorURLNotSupport = errors.New("only http and https urls are supported")
+
+// ErrorHostNotAllowed 远程主机不在允许的范围内
+var ErrorHostNotAllowed = errors.New("the host is not allowed")
+
+// ErrorFetchTooLarge 远程文件超出允许的大小
+var ErrorFetchTooLarge = errors.New("the remote file exceeds the size limit")
+
+// ErrorFetchNotResumable 远程服务器不支持续传或文件已变化
+var ErrorFetchNotResumable = errors.New("the remote file cannot be resumed")
@@ -0,0 +1,121 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 审计日志接口
+
+package service
+
+import (
+	"errors"
+	"net/http"
+)
+
+const (
+	AuditAction_Login          = "user.login"
+	AuditAction_Logout         = "user.logout"
+	AuditAction_UserAdd        = "user.add"
+	AuditAction_UserDelete     = "user.delete"
+	AuditAction_UserRename     = "user.rename"
+	AuditAction_UserPassword   = "user.password"
+	AuditAction_UserDisable    = "user.disable"
+	AuditAction_UserExpire     = "user.expire"
+	AuditAction_UserPwdReset   = "user.pwdreset"
+	AuditAction_UserUnlock     = "user.unlock"
+	AuditAction_LoginLocked    = "login.locked"
+	AuditAction_LoginThrottled = "login.throttled"
+	AuditAction_FileDelete     = "file.delete"
+	AuditAction_FileRename     = "file.rename"
+	AuditAction_FileMkdir      = "file.mkdir"
+	AuditAction_FileUpload     = "file.upload"
+	AuditAction_FileDownload   = "file.download"
+	AuditAction_FileCopy       = "file.copy"
+	AuditAction_FileMove       = "file.move"
+	AuditAction_FileFetch      = "file.fetch"
+	AuditAction_TaskExec       = "task.exec"
+	AuditAction_TaskOperation  = "task.operation"
+	AuditAction_JobAdd         = "job.add"
+	AuditAction_JobEdit        = "job.update"
+	AuditAction_JobDel         = "job.delete"
+	AuditAction_JobRun         = "job.run"
+	AuditAction_WebhookAdd     = "webhook.add"
+	AuditAction_WebhookEdit    = "webhook.update"
+	AuditAction_WebhookDel     = "webhook.delete"
+	AuditAction_PermissionAdd  = "permission.add"
+	AuditAction_PermissionEdit = "permission.update"
+	AuditAction_PermissionDel  = "permission.delete"
+	AuditAction_QuotaAdd       = "quota.add"
+	AuditAction_QuotaEdit      = "quota.update"
+	AuditAction_QuotaDel       = "quota.delete"
+	AuditAction_QuotaRecount   = "quota.recount"
+	AuditAction_ThrottleSet    = "throttle.set"
+	AuditAction_ThrottleDel    = "throttle.delete"
+	AuditAction_SignKeyRotate  = "signkey.rotate"
+	// AuditResult_Success 操作成功
+	AuditResult_Success = "success"
+)
+
+// ErrorAuditQueryRange 查询时间范围错误
+var ErrorAuditQueryRange = errors.New("the end time must be greater than the start time")
+
+// AuditLog 审计日志
+type AuditLog interface {
+	Record(info AuditInfo)                                                      // 记录一条日志
+	Record4Request(r *http.Request, userID, action, src, dst string, err error) // 记录一条请求日志, userID为空时从会话中获取
+	QueryAudits(query AuditQuery) ([]AuditInfo, error)                          // 按条件查询日志, 按时间倒序
+	Cleanup(before int64) (int64, error)                                        // 删除某个时间(毫秒)之前的日志
+}
+
+// AuditInfo 审计日志表存储的结构
+type AuditInfo struct {
+	AuditID  string // 日志ID
+	UserID   string // 用户ID
+	ClientIP string // 客户端IP
+	Action   string // 动作
+	Src      string // 源路径|操作对象
+	Dst      string // 目标路径
+	Result   string // 结果, 成功为success, 失败为错误信息
+	CtTime   int64  // 时间(毫秒)
+}
+
+// AuditQuery 审计日志查询条件, 为空则不作为条件
+type AuditQuery struct {
+	UserID string // 用户ID
+	Path   string // 源路径或目标路径前缀
+	Action string // 动作
+	Start  int64  // 开始时间(毫秒)
+	End    int64  // 结束时间(毫秒)
+	Limit  int    // 条数
+	Offset int    // 偏移
+}
+
+// AuditInfoDto AuditInfo传输对象
+type AuditInfoDto struct {
+	AuditID  string `json:"auditID"`
+	UserID   string `json:"userID"`
+	ClientIP string `json:"clientIP"`
+	Action   string `json:"action"`
+	Src      string `json:"src"`
+	Dst      string `json:"dst"`
+	Result   string `json:"result"`
+	CtTime   int64  `json:"ctTime"`
+}
+
+// ToDto 转传输对象
+func (ai *AuditInfo) ToDto() *AuditInfoDto {
+	return &AuditInfoDto{
+		AuditID:  ai.AuditID,
+		UserID:   ai.UserID,
+		ClientIP: ai.ClientIP,
+		Action:   ai.Action,
+		Src:      ai.Src,
+		Dst:      ai.Dst,
+		Result:   ai.Result,
+		CtTime:   ai.CtTime,
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 文件变更日志接口
+
+package service
+
+// ChangeJournal 文件变更日志, 记录每次通过 FileDatas 的修改, 序号单调递增, 供同步客户端增量拉取
+type ChangeJournal interface {
+	LastSeq() int64                                                                    // 当前最大序号
+	ListChanges(since int64, limit int) (changes []ChangeInfo, resync bool, err error) // 返回序号大于since的变更, since之后的记录已被清理时resync为true
+}
+
+// ChangeInfo 一条变更记录, Event 取值同 FileEvent
+type ChangeInfo struct {
+	Seq    int64
+	Event  string
+	Path   string
+	Dst    string // 移动|重命名的新路径
+	CtTime int64  // 毫秒
+}
+
+// ToDto 转传输对象
+func (ch *ChangeInfo) ToDto() *ChangeInfoDto {
+	return &ChangeInfoDto{
+		Seq:    ch.Seq,
+		Event:  ch.Event,
+		Path:   ch.Path,
+		Dst:    ch.Dst,
+		CtTime: ch.CtTime,
+	}
+}
+
+// ChangeInfoDto 变更记录传输对象
+type ChangeInfoDto struct {
+	Seq    int64  `json:"seq"`
+	Event  string `json:"event"`
+	Path   string `json:"path"`
+	Dst    string `json:"dst,omitempty"`
+	CtTime int64  `json:"ctTime"`
+}
+
+// ChangeFeedDto 增量拉取结果, 下次以Cursor作为since继续拉取; Resync为true时需要重新全量同步
+type ChangeFeedDto struct {
+	Changes []*ChangeInfoDto `json:"changes"`
+	Cursor  int64            `json:"cursor"`
+	HasMore bool             `json:"hasMore"`
+	Resync  bool             `json:"resync"`
+}
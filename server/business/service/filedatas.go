@@ -13,6 +13,7 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 )
 
@@ -35,9 +36,57 @@ type FileDatas interface {
 	DoMove(src, dst string, replace bool) error
 
 	DoWrite(src string, ioReader io.Reader) error
+	DoWriteWithPolicy(src string, ioReader io.Reader, policy string) (string, error)                               // 按冲突策略写入, 返回最终路径
+	DoWriteWithCheck(src string, ioReader io.Reader, policy string, check func(node *FNode) error) (string, error) // 同 DoWriteWithPolicy, 提交前用目标当前的信息调用check, 返回错误时放弃写入
 	DoRead(src string, offset int64) (io.ReadCloser, error)
+	DoReadRange(src string, offset, length int64) (io.ReadCloser, error) // 从offset开始读取length个字节, length<0时读取到结尾
+	DoHash(src string) (*FNode, error)                                   // 获取文件信息并计算内容摘要, 缓存有效时不重复计算
+
+	AddEventListener(listener FileEventListener) // 监听文件变化, 在操作成功后同步回调, 回调中不能有耗时操作
+}
+
+const (
+	// WriteConflict_Overwrite 写入目标已存在时覆盖
+	WriteConflict_Overwrite = "overwrite"
+	// WriteConflict_Fail 写入目标已存在时失败
+	WriteConflict_Fail = "fail"
+	// WriteConflict_Rename 写入目标已存在时改名为 name (n).ext
+	WriteConflict_Rename = "rename"
+)
+
+// ErrorFileExist 写入目标已存在
+var ErrorFileExist = errors.New("file already exists")
+
+// ErrorConflictPolicyNotSupport 不支持的冲突策略
+var ErrorConflictPolicyNotSupport = errors.New("the conflict policy is not supported")
+
+// IsWriteConflictPolicy 是否是支持的冲突策略
+func IsWriteConflictPolicy(policy string) bool {
+	return policy == WriteConflict_Overwrite || policy == WriteConflict_Fail || policy == WriteConflict_Rename
+}
+
+const (
+	// FileEvent_Created 新建文件|文件夹, 复制的目标
+	FileEvent_Created = "created"
+	// FileEvent_Modified 覆盖写入文件
+	FileEvent_Modified = "modified"
+	// FileEvent_Deleted 删除文件|文件夹
+	FileEvent_Deleted = "deleted"
+	// FileEvent_Moved 移动|重命名, Dst为新路径
+	FileEvent_Moved = "moved"
+)
+
+// FileEvent 文件变化事件
+type FileEvent struct {
+	Event string
+	Path  string
+	Dst   string
+	Time  int64 // 毫秒
 }
 
+// FileEventListener 文件变化回调
+type FileEventListener func(event FileEvent)
+
 // FNode 文件|夹基础属性(filedatas)
 type FNode struct {
 	Path   string
@@ -45,6 +94,7 @@ type FNode struct {
 	IsFile bool
 	IsDir  bool
 	Size   int64
+	Hash   string // 内容摘要(十六进制), 未计算时为空
 }
 
 // ToDto 转传输对象
@@ -55,6 +105,7 @@ func (f *FNode) ToDto() FNodeDto {
 		IsFile: f.IsFile,
 		IsDir:  f.IsDir,
 		Size:   f.Size,
+		Hash:   f.Hash,
 	}
 }
 
@@ -65,6 +116,7 @@ type FNodeDto struct {
 	IsFile bool   `json:"isFile"`
 	IsDir  bool   `json:"isDir"`
 	Size   int64  `json:"size"`
+	Hash   string `json:"hash,omitempty"`
 }
 
 // ToJSON 转传JSON
@@ -0,0 +1,122 @@
+// Copyright (C) 2021 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 存储配额接口
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// QuotaType_User 用户配额, 统计该用户主目录下的数据量, 需要配置用户主目录
+	QuotaType_User = "user"
+	// QuotaType_Path 路径配额, 统计该路径(挂载点|文件夹)下的数据量
+	QuotaType_Path = "path"
+)
+
+// ErrorQuotaExceeded 超出存储配额
+var ErrorQuotaExceeded = errors.New("storage quota exceeded")
+
+// ErrorQuotaIDIsNil ErrorQuotaIDIsNil
+var ErrorQuotaIDIsNil = errors.New("the quotaid is empty")
+
+// ErrorQuotaNotExist ErrorQuotaNotExist
+var ErrorQuotaNotExist = errors.New("the quota does not exist")
+
+// ErrorQuotaTargetIsNil ErrorQuotaTargetIsNil
+var ErrorQuotaTargetIsNil = errors.New("the quota target is empty")
+
+// ErrorQuotaTypeNotSupport ErrorQuotaTypeNotSupport
+var ErrorQuotaTypeNotSupport = errors.New("the quota type is not supported")
+
+// QuotaInfo 配额表存储的结构
+type QuotaInfo struct {
+	QuotaID   string    // 配额ID
+	QuotaType string    // 配额类型 user|path
+	Target    string    // 用户ID或路径
+	MaxSize   int64     // 配额上限(字节)
+	UsedSize  int64     // 已使用(字节)
+	CtTime    time.Time // 插入时间
+}
+
+// QuotaInfoDto QuotaInfo传输对象
+type QuotaInfoDto struct {
+	QuotaID   string    `json:"quotaID"`
+	QuotaType string    `json:"quotaType"`
+	Target    string    `json:"target"`
+	MaxSize   int64     `json:"maxSize"`
+	UsedSize  int64     `json:"usedSize"`
+	CtTime    time.Time `json:"ctTime"`
+}
+
+// QuotaUsageDto 用户在路径下的配额使用情况
+type QuotaUsageDto struct {
+	Remaining int64           `json:"remaining"` // 剩余可写入空间, -1为不限制
+	Quotas    []*QuotaInfoDto `json:"quotas"`
+}
+
+// Clone Clone
+func (qi *QuotaInfo) Clone(val interface{}) error {
+	if qit, ok := val.(*QuotaInfo); ok {
+		qit.QuotaID = qi.QuotaID
+		qit.QuotaType = qi.QuotaType
+		qit.Target = qi.Target
+		qit.MaxSize = qi.MaxSize
+		qit.UsedSize = qi.UsedSize
+		qit.CtTime = qi.CtTime
+		return nil
+	}
+	return fmt.Errorf("can't support clone %T ", val)
+}
+
+// ToDto 转传输对象
+func (qi *QuotaInfo) ToDto() *QuotaInfoDto {
+	return &QuotaInfoDto{
+		QuotaID:   qi.QuotaID,
+		QuotaType: qi.QuotaType,
+		Target:    qi.Target,
+		MaxSize:   qi.MaxSize,
+		UsedSize:  qi.UsedSize,
+		CtTime:    qi.CtTime,
+	}
+}
+
+// FileQuota 存储配额管理接口
+type FileQuota interface {
+	FileQuotaCheck
+	ListQuotas() ([]QuotaInfo, error)              // 列出所有配额, 无分页
+	QueryQuota(quotaID string) (*QuotaInfo, error) // 根据配额ID查询详细信息
+	AddQuota(quota QuotaInfo) error                // 添加配额, 路径配额会统计当前已用空间
+	UpdateQuota(quota QuotaInfo) error             // 修改配额上限
+	DelQuota(quotaID string) error                 // 根据配额ID删除配额
+	RecountQuota(quotaID string) error             // 重新统计配额的已用空间
+	ListUsage(path string) []QuotaInfo             // 列出在路径下写入时受限的配额
+	GetRemaining(path string) int64                // 路径下剩余可写入的空间, -1为不限制
+}
+
+// FileQuotaCheck 存储配额校验&统计
+type FileQuotaCheck interface {
+	HasQuotas() bool                                                   // 是否配置了配额
+	GetPathSize(path string) int64                                     // 计算文件|文件夹大小
+	Reserve(path string, size, credit int64) (QuotaReservation, error) // 预占在路径下写入size字节的空间, credit为覆盖写入时被替换的旧文件大小
+	CheckMove(src, dst string, size int64) error                       // 从src移动size字节到dst是否超出配额
+	AddUsage(path string, delta int64)                                 // 写入|删除后更新用量
+	MoveUsage(src, dst string, size int64)                             // 移动后更新路径配额用量
+}
+
+// QuotaReservation 写入前预占的配额, 并发写入时不会同时用掉同一份剩余空间
+type QuotaReservation interface {
+	Ensure(size int64) error // 确保预占的空间足够写入size字节, 不够时追加预占
+	Commit(delta int64)      // 写入完成, 按实际的用量变化结算并释放预占
+	Release()                // 放弃写入, 释放预占
+}
@@ -15,6 +15,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 )
 
 const (
@@ -22,11 +23,45 @@ const (
 	StreamTokenType_Write    = 1
 	CacheLib_StreamToken     = "FileStransport:Token"
 	CacheLib_StreamToken_Exp = 60 * 30
+	// StreamTokenProp_UserID 申请token的用户
+	StreamTokenProp_UserID = "userID"
+	// StreamTokenProp_RateLimit 传输链接限速(字节/秒), 为空或<=0时不限制
+	StreamTokenProp_RateLimit = "rateLimit"
+	// StreamTokenProp_MaxUses 最大使用次数, 每次下载|上传请求计一次, 只在单个实例内严格生效, 多实例共用缓存时可能略有超出
+	StreamTokenProp_MaxUses = "maxUses"
+	// StreamTokenProp_Uses 已使用次数
+	StreamTokenProp_Uses = "uses"
+	// StreamTokenProp_BindIP 只允许该IP使用
+	StreamTokenProp_BindIP = "bindIP"
+	// StreamTokenProp_ExpiresAt 绝对过期时间(毫秒), 刷新不会延长
+	StreamTokenProp_ExpiresAt = "expiresAt"
+	// StreamTokenProp_MaxSize 上传token允许写入的最大字节数
+	StreamTokenProp_MaxSize = "maxSize"
+	// StreamTokenProp_Conflict 上传目标已存在时的处理策略, 为空时覆盖
+	StreamTokenProp_Conflict = "conflict"
 )
 
 // ErrInvalidToken 无效的token
 var ErrInvalidToken = errors.New("invalid token")
 
+// ErrorUploadTooLarge 超出上传token声明的大小
+var ErrorUploadTooLarge = errors.New("upload size exceeds the limit of the token")
+
+// ErrorChecksumMismatch 上传内容与校验值不一致
+var ErrorChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumDto 上传内容的摘要, 十六进制
+type ChecksumDto struct {
+	MD5    string `json:"md5"`
+	SHA256 string `json:"sha256"`
+}
+
+// WriteResultDto 上传结果, Path为最终写入的路径
+type WriteResultDto struct {
+	Path string `json:"path"`
+	ChecksumDto
+}
+
 // StreamTokenType token类型
 type StreamTokenType int
 
@@ -35,6 +70,10 @@ type TransportToken interface {
 	AskWriteToken(src string, props map[string]string) (*StreamToken, error)
 	AskReadToken(src string, props map[string]string) (*StreamToken, error)
 	QueryToken(token string) (*StreamToken, error)
+	UseToken(token, clientIP string, count bool) (*StreamToken, error) // 校验绑定IP和使用次数, count为true时使用次数+1
+	SignURL(src, userID string, expiresAt int64) (url.Values, error)   // 生成签名下载地址参数, expiresAt<=0时使用默认有效期
+	VerifyURL(query url.Values) (*StreamToken, error)                  // 校验签名下载地址, 不查询缓存
+	RotateSignKey() (string, error)                                    // 轮换签名密钥, 返回新密钥ID
 	RefreshToken(token string) (st *StreamToken, err error)
 	DestroyToken(token string, override bool) (err error)
 }
@@ -47,6 +86,7 @@ type StreamToken struct {
 	CTime    int64
 	MTime    int64
 	Type     StreamTokenType
+	Props    map[string]string
 }
 
 // StreamTokenDto token信息
@@ -66,6 +106,7 @@ func (ua *StreamToken) Clone(val interface{}) error {
 		st.CTime = ua.CTime
 		st.MTime = ua.MTime
 		st.Type = ua.Type
+		st.Props = ua.Props
 		return nil
 	}
 	return fmt.Errorf("can't support clone %T ", val)
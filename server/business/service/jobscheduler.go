@@ -0,0 +1,111 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 定时任务接口
+
+package service
+
+import "errors"
+
+const (
+	// JobRunState_Started 已发起异步任务, 最终状态以任务记录为准
+	JobRunState_Started = "started"
+	// JobRunState_Failed 发起失败
+	JobRunState_Failed = "failed"
+)
+
+// ErrorJobNotExist 定时任务不存在
+var ErrorJobNotExist = errors.New("job does not exist")
+
+// JobScheduler 定时任务, 按cron表达式以所属用户的身份执行 AsyncTask 中的任务
+type JobScheduler interface {
+	AddJob(job JobInfo) (*JobInfo, error)                      // 添加定时任务, 返回补全ID和下次执行时间后的任务
+	UpdateJob(job JobInfo) (*JobInfo, error)                   // 修改定时任务
+	DelJob(jobID string) error                                 // 删除定时任务及执行记录
+	QueryJob(jobID string) (*JobInfo, error)                   // 查询定时任务, 不存在返回 ErrorJobNotExist
+	ListJobs(userID string) ([]JobInfo, error)                 // 列出用户的定时任务, userID为空时列出全部
+	RunJob(jobID string) (*JobRunInfo, error)                  // 立即执行一次
+	ListJobRuns(jobID string, limit int) ([]JobRunInfo, error) // 列出执行记录, 按时间倒序
+}
+
+// JobInfo 定时任务定义, Params 中的 {YYYY} {MM} {DD} {hh} {mm} 在执行时替换为当前时间
+type JobInfo struct {
+	JobID    string
+	UserID   string // 所属用户, 以该用户的权限执行
+	Name     string
+	Cron     string // cron表达式: 分 时 日 月 周
+	TaskType string // AsyncTask 中的任务名, 如 CopyFile
+	Params   map[string]string
+	Enabled  bool
+	LastRun  int64 // 上次执行时间, 毫秒
+	NextRun  int64 // 下次执行时间, 毫秒
+	CtTime   int64 // 创建时间, 毫秒
+}
+
+// ToDto 转传输对象
+func (job *JobInfo) ToDto() *JobInfoDto {
+	return &JobInfoDto{
+		JobID:    job.JobID,
+		UserID:   job.UserID,
+		Name:     job.Name,
+		Cron:     job.Cron,
+		TaskType: job.TaskType,
+		Params:   job.Params,
+		Enabled:  job.Enabled,
+		LastRun:  job.LastRun,
+		NextRun:  job.NextRun,
+		CtTime:   job.CtTime,
+	}
+}
+
+// JobInfoDto 定时任务传输对象
+type JobInfoDto struct {
+	JobID    string            `json:"jobID"`
+	UserID   string            `json:"userID"`
+	Name     string            `json:"name"`
+	Cron     string            `json:"cron"`
+	TaskType string            `json:"taskType"`
+	Params   map[string]string `json:"params"`
+	Enabled  bool              `json:"enabled"`
+	LastRun  int64             `json:"lastRun"`
+	NextRun  int64             `json:"nextRun"`
+	CtTime   int64             `json:"ctTime"`
+}
+
+// JobRunInfo 定时任务执行记录
+type JobRunInfo struct {
+	RunID  string
+	JobID  string
+	TaskID string // 发起的异步任务ID
+	State  string // 发起状态, 任务记录存在时为任务的状态
+	Result string // 失败原因
+	StTime int64  // 执行时间, 毫秒
+}
+
+// ToDto 转传输对象
+func (run *JobRunInfo) ToDto() *JobRunInfoDto {
+	return &JobRunInfoDto{
+		RunID:  run.RunID,
+		JobID:  run.JobID,
+		TaskID: run.TaskID,
+		State:  run.State,
+		Result: run.Result,
+		StTime: run.StTime,
+	}
+}
+
+// JobRunInfoDto 执行记录传输对象
+type JobRunInfoDto struct {
+	RunID  string `json:"runID"`
+	JobID  string `json:"jobID"`
+	TaskID string `json:"taskID"`
+	State  string `json:"state"`
+	Result string `json:"result"`
+	StTime int64  `json:"stTime"`
+}
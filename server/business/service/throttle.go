@@ -0,0 +1,66 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// 传输限速接口
+
+package service
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	// ThrottleScope_Global 全局限速, target为空
+	ThrottleScope_Global = "global"
+	// ThrottleScope_User 用户限速, target为用户ID
+	ThrottleScope_User = "user"
+	// ThrottleScope_Link 传输链接限速, target为传输token, 不持久化, 随token失效
+	ThrottleScope_Link = "link"
+)
+
+// ErrorThrottleScopeNotSupport ErrorThrottleScopeNotSupport
+var ErrorThrottleScopeNotSupport = errors.New("the throttle scope is not supported")
+
+// ErrorThrottleTargetIsNil ErrorThrottleTargetIsNil
+var ErrorThrottleTargetIsNil = errors.New("the throttle target is empty")
+
+// ThrottleLimit 限速设置, 速率单位为字节/秒, <=0为不限制
+type ThrottleLimit struct {
+	Scope     string // 范围 global|user|link
+	Target    string // 用户ID或传输token
+	ReadRate  int64  // 下载速率
+	WriteRate int64  // 上传速率
+}
+
+// ThrottleLimitDto ThrottleLimit传输对象
+type ThrottleLimitDto struct {
+	Scope     string `json:"scope"`
+	Target    string `json:"target"`
+	ReadRate  int64  `json:"readRate"`
+	WriteRate int64  `json:"writeRate"`
+}
+
+// ToDto 转传输对象
+func (tl *ThrottleLimit) ToDto() *ThrottleLimitDto {
+	return &ThrottleLimitDto{
+		Scope:     tl.Scope,
+		Target:    tl.Target,
+		ReadRate:  tl.ReadRate,
+		WriteRate: tl.WriteRate,
+	}
+}
+
+// Throttle 传输限速接口
+type Throttle interface {
+	ListLimits() []ThrottleLimit                             // 列出所有限速设置
+	SetLimit(limit ThrottleLimit) error                      // 添加或修改限速设置, 立即作用于进行中的传输
+	DelLimit(scope, target string) error                     // 删除限速设置
+	NewReader(r io.Reader, token *StreamToken) io.ReadCloser // 包装传输流, 同时受全局、用户、链接限速; Close释放限速桶, 不关闭r
+}
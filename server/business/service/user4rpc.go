@@ -30,6 +30,14 @@ const (
 	AuthHeader_Sign = "X-Sign"
 	// Cachelib_UserAccessToken 用户access信息缓存库
 	Cachelib_UserAccessToken = "User4RPC:AccessToken"
+	// Cachelib_LoginFailed 登录失败次数缓存库
+	Cachelib_LoginFailed = "User4RPC:LoginFailed"
+	// Cachelib_LoginLocked 登录锁定记录缓存库
+	Cachelib_LoginLocked = "User4RPC:LoginLocked"
+	// LoginEvent_Locked 账号或IP被锁定
+	LoginEvent_Locked = "locked"
+	// LoginEvent_Throttled 登录被渐进延迟
+	LoginEvent_Throttled = "throttled"
 )
 
 // ErrorUserIDIsNil ErrorUserIDIsNil
@@ -47,6 +55,21 @@ var ErrorAuthentication = errors.New("authentication failed")
 // ErrorSignature 签名错误
 var ErrorSignature = errors.New("request content signature error")
 
+// ErrorUserDisabled 账号已停用
+var ErrorUserDisabled = errors.New("the account is disabled")
+
+// ErrorUserExpired 账号已过期
+var ErrorUserExpired = errors.New("the account has expired")
+
+// ErrorPwdResetRequired 需要先修改密码
+var ErrorPwdResetRequired = errors.New("password must be changed before continuing")
+
+// ErrorUserHomeIllegal 用户ID无法生成合法的主目录
+var ErrorUserHomeIllegal = errors.New("the userid cannot be used as home directory")
+
+// ErrorLoginLocked 登录失败次数过多, 暂时锁定
+var ErrorLoginLocked = errors.New("too many failed login attempts, please try again later")
+
 // User4RPC 用户管理接口
 type User4RPC interface {
 	UserManage
@@ -56,56 +79,92 @@ type User4RPC interface {
 // UserManage access接口
 type UserManage interface {
 	Clear() error
-	AddUser(user *UserInfo) error                  // 添加用户
-	DelUser(userID string) error                   // 根据userID删除用户
-	CheckPwd(userID, pwd string) bool              // 校验密码是否一致
-	UpdatePWD(userID, pwd string) error            // 修改用户密码
-	ListAllUsers() ([]UserInfoDto, error)          // 列出所有用户数据, 无分页
-	QueryUser(userID string) (*UserInfoDto, error) // 根据用户ID查询详细信息
-	UpdateUserName(userID, userName string) error  // 修改用户名字
+	AddUser(user *UserInfo) error                                 // 添加用户
+	DelUser(userID string) error                                  // 根据userID删除用户
+	CheckPwd(userID, pwd string) bool                             // 校验密码是否一致
+	UpdatePWD(userID, pwd string) error                           // 修改用户密码
+	ListAllUsers() ([]UserInfoDto, error)                         // 列出所有用户数据, 无分页
+	QueryUser(userID string) (*UserInfoDto, error)                // 根据用户ID查询详细信息
+	UpdateUserName(userID, userName string) error                 // 修改用户名字
+	UpdateUserDisabled(userID string, disabled bool) error        // 停用|启用账号, 停用后立即注销会话
+	UpdateUserExpiredTime(userID string, expiredTime int64) error // 设置账号过期时间(毫秒), 0为永不过期
+	UpdateUserPwdReset(userID string, pwdReset bool) error        // 设置下次登录时必须修改密码
 }
 
 // UserAuth4Rpc access接口
 type UserAuth4Rpc interface {
 	// GetAuthFilterFunc 获取过滤器实现
 	GetAuthFilterFunc() ipakku.FilterFunc
-	// AskAccess 获取access
-	AskAccess(userID, pwd string) (*UserAccessDto, error)
+	// AskAccess 获取access, clientIP 用于统计登录失败次数
+	AskAccess(userID, pwd, clientIP string) (*UserAccessDto, error)
+	// AskAccess4Service 内部服务以用户身份执行操作时获取access, 不校验密码, 使用完毕后需销毁
+	AskAccess4Service(userID string) (*UserAccessDto, error)
 	// GetSecretKey 获取 userAccess
 	GetUserAccess(accessKey string) (*UserAccessDto, error)
 	// GetAccessKey4Request 从http中获取accesskey
 	GetAccessKey4Request(r *http.Request) string
+	// GetClientIP4Request 从http中获取客户端IP
+	GetClientIP4Request(r *http.Request) string
 	// RefreshAccessKey 刷新 access
 	RefreshAccessKey(accessKey string) error
 	// DestroyAccess 销毁 access
 	DestroyAccess(accessKey string) error
+	// ListLoginLocks 列出被锁定的账号和IP
+	ListLoginLocks() []LoginLockDto
+	// UnlockLogin 解除账号或IP的登录锁定
+	UnlockLogin(userID, clientIP string) error
+	// GetHomePath 获取用户主目录, 未配置时返回空
+	GetHomePath(userID string) string
+	// AddLoginEventListener 监听登录锁定和延迟, 同步回调, 回调中不能有耗时操作
+	AddLoginEventListener(listener LoginEventListener)
 }
 
+// LoginEvent 登录保护事件, 锁定时 UserID 和 ClientIP 只有被锁定的一方有值
+type LoginEvent struct {
+	Event    string // 事件类型
+	UserID   string // 账号
+	ClientIP string // 客户端IP
+	Failed   int64  // 连续失败次数
+	DelayMS  int64  // 延迟时长(毫秒), 锁定时为0
+	Time     int64  // 发生时间(毫秒)
+}
+
+// LoginEventListener 登录保护事件监听
+type LoginEventListener func(event LoginEvent)
+
 // UserInfo 用户表存储的结构
 type UserInfo struct {
-	UserType int
-	UserID   string
-	UserName string
-	UserPWD  string
-	CtTime   time.Time
+	UserType    int
+	UserID      string
+	UserName    string
+	UserPWD     string
+	CtTime      time.Time
+	Disabled    bool  // 是否停用
+	ExpiredTime int64 // 过期时间(毫秒), 0为永不过期
+	PwdReset    bool  // 下次登录时必须修改密码
 }
 
 // UserAccess access内容
 type UserAccess struct {
-	UserID    string
-	UserType  int
-	UserName  string
-	AccessKey string
-	SecretKey string
-	Props     map[string]string
+	UserID      string
+	UserType    int
+	UserName    string
+	AccessKey   string
+	SecretKey   string
+	ExpiredTime int64
+	PwdReset    bool
+	Props       map[string]string
 }
 
 // UserInfoDto UserInfo传输对象
 type UserInfoDto struct {
-	UserType int       `json:"userType"`
-	UserID   string    `json:"userID"`
-	UserName string    `json:"userName"`
-	CtTime   time.Time `json:"ctTime"`
+	UserType    int       `json:"userType"`
+	UserID      string    `json:"userID"`
+	UserName    string    `json:"userName"`
+	CtTime      time.Time `json:"ctTime"`
+	Disabled    bool      `json:"disabled"`
+	ExpiredTime int64     `json:"expiredTime"`
+	PwdReset    bool      `json:"pwdReset"`
 }
 
 // UserAccessDto UserAccess传输对象
@@ -115,6 +174,14 @@ type UserAccessDto struct {
 	UserName  string `json:"userName"`
 	AccessKey string `json:"accessKey"`
 	SecretKey string `json:"secretKey"`
+	PwdReset  bool   `json:"pwdReset"`
+}
+
+// LoginLockDto 登录锁定记录
+type LoginLockDto struct {
+	UserID   string `json:"userID"`   // 被锁定的账号, 为空时表示锁定的是IP
+	ClientIP string `json:"clientIP"` // 被锁定的IP, 为空时表示锁定的是账号
+	UnlockAt int64  `json:"unlockAt"` // 自动解锁时间
 }
 
 // Clone Clone
@@ -125,6 +192,8 @@ func (ua *UserAccess) Clone(val interface{}) error {
 		uat.UserName = ua.UserName
 		uat.AccessKey = ua.AccessKey
 		uat.SecretKey = ua.SecretKey
+		uat.ExpiredTime = ua.ExpiredTime
+		uat.PwdReset = ua.PwdReset
 		uat.Props = ua.Props
 		return nil
 	}
@@ -139,16 +208,20 @@ func (ua *UserAccess) ToDto() *UserAccessDto {
 		UserName:  ua.UserName,
 		AccessKey: ua.AccessKey,
 		SecretKey: ua.SecretKey,
+		PwdReset:  ua.PwdReset,
 	}
 }
 
 // ToDto 转传输对象
 func (ui *UserInfo) ToDto() *UserInfoDto {
 	return &UserInfoDto{
-		UserType: ui.UserType,
-		UserID:   ui.UserID,
-		UserName: ui.UserName,
-		CtTime:   ui.CtTime,
+		UserType:    ui.UserType,
+		UserID:      ui.UserID,
+		UserName:    ui.UserName,
+		CtTime:      ui.CtTime,
+		Disabled:    ui.Disabled,
+		ExpiredTime: ui.ExpiredTime,
+		PwdReset:    ui.PwdReset,
 	}
 }
 
@@ -0,0 +1,134 @@
+// Copyright (C) 2020 WuPeng <wup364@outlook.com>.
+// Use of this source code is governed by an MIT-style.
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction,
+// including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software,
+// and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Webhook接口
+
+package service
+
+import "errors"
+
+const (
+	// WebhookEvent_TaskCompleted 异步任务结束(成功、失败、取消)
+	WebhookEvent_TaskCompleted = "task-completed"
+	// DeliveryState_Pending 投递中, 包括等待重试
+	DeliveryState_Pending = "pending"
+	// DeliveryState_Success 投递成功, 接收方返回2xx
+	DeliveryState_Success = "success"
+	// DeliveryState_Failed 重试次数用完仍失败
+	DeliveryState_Failed = "failed"
+)
+
+// ErrorWebhookNotExist Webhook不存在
+var ErrorWebhookNotExist = errors.New("webhook does not exist")
+
+// WebhookEvents 支持订阅的事件
+var WebhookEvents = []string{FileEvent_Created, FileEvent_Modified, FileEvent_Deleted, FileEvent_Moved, WebhookEvent_TaskCompleted}
+
+// Webhook 文件变化和异步任务结束时向注册的地址推送签名的通知
+type Webhook interface {
+	AddHook(hook WebhookInfo) (*WebhookInfo, error)                     // 添加Webhook, 未指定密钥时自动生成
+	UpdateHook(hook WebhookInfo) (*WebhookInfo, error)                  // 修改Webhook, 密钥为空时保持不变
+	DelHook(hookID string) error                                        // 删除Webhook及投递记录
+	QueryHook(hookID string) (*WebhookInfo, error)                      // 查询Webhook, 不存在返回 ErrorWebhookNotExist
+	ListHooks() ([]WebhookInfo, error)                                  // 列出全部Webhook
+	ListDeliveries(hookID string, limit int) ([]WebhookDelivery, error) // 列出投递记录, 按时间倒序
+}
+
+// WebhookInfo Webhook定义
+type WebhookInfo struct {
+	HookID     string
+	URL        string
+	Secret     string   // 签名密钥, HMAC-SHA256
+	Events     []string // 订阅的事件, 为空时订阅全部
+	PathPrefix string   // 路径前缀, 源路径或目标路径匹配时推送, 为空时不限制
+	Enabled    bool
+	CtTime     int64 // 创建时间, 毫秒
+}
+
+// ToDto 转传输对象
+func (hook *WebhookInfo) ToDto() *WebhookInfoDto {
+	return &WebhookInfoDto{
+		HookID:     hook.HookID,
+		URL:        hook.URL,
+		Secret:     hook.Secret,
+		Events:     hook.Events,
+		PathPrefix: hook.PathPrefix,
+		Enabled:    hook.Enabled,
+		CtTime:     hook.CtTime,
+	}
+}
+
+// WebhookInfoDto Webhook传输对象
+type WebhookInfoDto struct {
+	HookID     string   `json:"hookID"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	Events     []string `json:"events"`
+	PathPrefix string   `json:"pathPrefix"`
+	Enabled    bool     `json:"enabled"`
+	CtTime     int64    `json:"ctTime"`
+}
+
+// WebhookPayload 推送的内容, 文件事件没有任务字段
+type WebhookPayload struct {
+	DeliveryID string `json:"deliveryID"`
+	Event      string `json:"event"`
+	Path       string `json:"path"`
+	Dst        string `json:"dst,omitempty"`
+	Time       int64  `json:"time"`
+	TaskID     string `json:"taskID,omitempty"`
+	TaskType   string `json:"taskType,omitempty"`
+	UserID     string `json:"userID,omitempty"`
+	State      string `json:"state,omitempty"`
+	Result     string `json:"result,omitempty"`
+}
+
+// WebhookDelivery 投递记录
+type WebhookDelivery struct {
+	DeliveryID string
+	HookID     string
+	Event      string
+	Payload    string // 推送的json
+	State      string
+	Attempts   int    // 已尝试次数
+	HTTPStatus int    // 最后一次的响应码, 未响应为0
+	Error      string // 最后一次的错误信息
+	CtTime     int64  // 创建时间, 毫秒
+	LastTime   int64  // 最后一次尝试时间, 毫秒
+}
+
+// ToDto 转传输对象
+func (dl *WebhookDelivery) ToDto() *WebhookDeliveryDto {
+	return &WebhookDeliveryDto{
+		DeliveryID: dl.DeliveryID,
+		HookID:     dl.HookID,
+		Event:      dl.Event,
+		Payload:    dl.Payload,
+		State:      dl.State,
+		Attempts:   dl.Attempts,
+		HTTPStatus: dl.HTTPStatus,
+		Error:      dl.Error,
+		CtTime:     dl.CtTime,
+		LastTime:   dl.LastTime,
+	}
+}
+
+// WebhookDeliveryDto 投递记录传输对象
+type WebhookDeliveryDto struct {
+	DeliveryID string `json:"deliveryID"`
+	HookID     string `json:"hookID"`
+	Event      string `json:"event"`
+	Payload    string `json:"payload"`
+	State      string `json:"state"`
+	Attempts   int    `json:"attempts"`
+	HTTPStatus int    `json:"httpStatus"`
+	Error      string `json:"error"`
+	CtTime     int64  `json:"ctTime"`
+	LastTime   int64  `json:"lastTime"`
+}
@@ -13,12 +13,18 @@ package pakkuconf
 import (
 	"fileservice/business/controller"
 	"fileservice/business/modules/asynctask"
+	"fileservice/business/modules/auditlog"
 	"fileservice/business/modules/bootstart"
+	"fileservice/business/modules/changejournal"
 	"fileservice/business/modules/filedatas"
 	"fileservice/business/modules/filepermission"
+	"fileservice/business/modules/filequota"
 	"fileservice/business/modules/filetransport"
 	"fileservice/business/modules/htmlpage"
+	"fileservice/business/modules/jobscheduler"
+	"fileservice/business/modules/throttle"
 	"fileservice/business/modules/user4rpc"
+	"fileservice/business/modules/webhook"
 	"fileservice/pakkusys"
 
 	"github.com/wup364/pakku/ipakku"
@@ -27,11 +33,17 @@ import (
 // RegisterModules 注册需要加载的模块
 func RegisterModules() []ipakku.Module {
 	return []ipakku.Module{
-		new(user4rpc.User4RPC),
 		new(filedatas.FileDatas),
 		new(filetransport.TransportToken),
 		new(filepermission.FilePermission),
+		new(throttle.Throttle),
+		new(user4rpc.User4RPC),
+		new(filequota.FileQuota),
+		new(auditlog.AuditLog),
 		new(asynctask.AsyncTask),
+		new(jobscheduler.JobScheduler),
+		new(webhook.Webhook),
+		new(changejournal.ChangeJournal),
 		new(htmlpage.HTMLPage),
 		new(bootstart.BootStart),
 	}
@@ -43,7 +55,12 @@ func RegisterController() []ipakku.Controller {
 		new(controller.UserCtrl),
 		new(controller.FileOptsCtrl),
 		new(controller.AsyncTaskCtrl),
+		new(controller.JobCtrl),
+		new(controller.WebhookCtrl),
 		new(controller.FilePermissionCtrl),
+		new(controller.FileQuotaCtrl),
+		new(controller.ThrottleCtrl),
+		new(controller.AuditCtrl),
 		new(controller.TransportCtrl),
 		new(controller.Preview),
 	}